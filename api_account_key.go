@@ -0,0 +1,299 @@
+package weshnet
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/secretstore"
+	"berty.tech/weshnet/v2/pkg/tyber"
+)
+
+// AccountKeyRotate replaces the account key by a new one, the succession
+// statement signed by the previous key is announced on the account group and
+// on the contact groups, the contact groups derived from the new key are
+// then registered. The rotation is refused while contact requests are
+// pending.
+func (s *service) AccountKeyRotate(ctx context.Context, _ *protocoltypes.AccountKeyRotate_Request) (_ *protocoltypes.AccountKeyRotate_Reply, err error) {
+	ctx, _, endSection := tyber.Section(ctx, s.logger, "Rotating account key")
+	defer func() { endSection(err, "") }()
+
+	accountGroup := s.getAccountGroup()
+	if accountGroup == nil {
+		return nil, errcode.ErrCode_ErrGroupMissing
+	}
+
+	// a pending contact request references the previous key and could not be
+	// completed once it is replaced
+	if pending := accountGroup.MetadataStore().ListContactsByStatus(
+		protocoltypes.ContactState_ContactStateToRequest,
+		protocoltypes.ContactState_ContactStateReceived,
+	); len(pending) > 0 {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("%d contact requests are pending", len(pending)))
+	}
+
+	// the contact groups derived from the previous key are used to announce
+	// the new one
+	contacts := accountGroup.MetadataStore().ListContactsByStatus(protocoltypes.ContactState_ContactStateAdded)
+	previousGroups := make([]*protocoltypes.Group, len(contacts))
+	for i, contact := range contacts {
+		contactPK, err := contact.GetPubKey()
+		if err != nil {
+			return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+		}
+
+		if previousGroups[i], err = s.getContactGroup(contactPK); err != nil {
+			return nil, err
+		}
+	}
+
+	// the contact groups of every contact remain readable after the rotation
+	previousContactGroupPKs := [][]byte(nil)
+	for _, contact := range accountGroup.MetadataStore().ListContactsByStatus(
+		protocoltypes.ContactState_ContactStateAdded,
+		protocoltypes.ContactState_ContactStateRemoved,
+		protocoltypes.ContactState_ContactStateDiscarded,
+		protocoltypes.ContactState_ContactStateBlocked,
+	) {
+		contactPK, err := contact.GetPubKey()
+		if err != nil {
+			return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+		}
+
+		group, err := s.getContactGroup(contactPK)
+		if err != nil {
+			return nil, err
+		}
+
+		previousContactGroupPKs = append(previousContactGroupPKs, group.PublicKey)
+	}
+
+	succession, err := s.secretStore.RotateAccountKey(ctx)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrInternal.Wrap(err)
+	}
+
+	for _, group := range previousGroups {
+		if err := s.announceAccountKeyRotation(ctx, group, succession); err != nil {
+			s.logger.Error("unable to announce account key rotation to contact", zap.Error(err))
+		}
+	}
+
+	if _, err := accountGroup.MetadataStore().AccountKeyRotated(ctx, succession, previousContactGroupPKs); err != nil {
+		return nil, errcode.ErrCode_ErrOrbitDBAppend.Wrap(err)
+	}
+
+	for _, contact := range contacts {
+		contactPK, err := contact.GetPubKey()
+		if err != nil {
+			return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+		}
+
+		group, err := s.getContactGroup(contactPK)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.secretStore.PutGroup(ctx, group); err != nil {
+			return nil, err
+		}
+	}
+
+	// the account group context still holds the previous key, reopen it
+	accountGroupPK, err := accountGroup.Group().GetPubKey()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	if err := s.deactivateGroup(accountGroupPK); err != nil {
+		return nil, errcode.ErrCode_ErrGroupDeactivate.Wrap(err)
+	}
+
+	if err := s.activateGroup(ctx, accountGroupPK, true); err != nil {
+		return nil, errcode.ErrCode_ErrGroupActivate.Wrap(err)
+	}
+
+	return &protocoltypes.AccountKeyRotate_Reply{AccountPk: succession.AccountPk}, nil
+}
+
+// AccountMnemonicShow returns the BIP39 mnemonic from which the account keys
+// have been derived, each disclosure is logged
+func (s *service) AccountMnemonicShow(ctx context.Context, _ *protocoltypes.AccountMnemonicShow_Request) (_ *protocoltypes.AccountMnemonicShow_Reply, err error) {
+	ctx, _, endSection := tyber.Section(ctx, s.logger, "Showing account mnemonic")
+	defer func() { endSection(err, "") }()
+
+	mnemonic, err := s.secretStore.GetAccountMnemonic(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the mnemonic gives full access to the account
+	s.logger.Warn("Account mnemonic disclosed", tyber.FormatStepLogFields(ctx, []tyber.Detail{})...)
+
+	return &protocoltypes.AccountMnemonicShow_Reply{Mnemonic: mnemonic}, nil
+}
+
+// AccountMnemonicRestore replaces the account of the service by the one
+// derived from a BIP39 mnemonic, its data is then synchronized from the
+// other devices of the account
+func (s *service) AccountMnemonicRestore(ctx context.Context, req *protocoltypes.AccountMnemonicRestore_Request) (_ *protocoltypes.AccountMnemonicRestore_Reply, err error) {
+	ctx, _, endSection := tyber.Section(ctx, s.logger, "Restoring account from mnemonic")
+	defer func() { endSection(err, "") }()
+
+	accountGroup := s.getAccountGroup()
+	if accountGroup == nil {
+		return nil, errcode.ErrCode_ErrGroupMissing
+	}
+
+	// the contacts and the groups of the current account would be lost
+	m := accountGroup.MetadataStore()
+	if len(m.ListContacts()) > 0 || len(m.ListMultiMemberGroups()) > 0 {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("the current account has contacts or groups and can't be replaced"))
+	}
+
+	previousGroupPK, err := accountGroup.Group().GetPubKey()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	if err := s.secretStore.RestoreAccountMnemonic(ctx, req.Mnemonic); err != nil {
+		return nil, err
+	}
+
+	group, memberDevice, err := s.secretStore.GetGroupForAccount()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrInternal.Wrap(err)
+	}
+
+	groupPK, err := group.GetPubKey()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	accountPK, err := memberDevice.Member().Raw()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	if err := s.secretStore.PutGroup(ctx, group); err != nil {
+		return nil, errcode.ErrCode_ErrInternal.Wrap(err)
+	}
+
+	if err := s.deactivateGroup(previousGroupPK); err != nil {
+		return nil, errcode.ErrCode_ErrGroupDeactivate.Wrap(err)
+	}
+
+	if err := s.activateGroup(ctx, groupPK, true); err != nil {
+		return nil, errcode.ErrCode_ErrGroupActivate.Wrap(err)
+	}
+
+	return &protocoltypes.AccountMnemonicRestore_Reply{
+		AccountPk:      accountPK,
+		AccountGroupPk: group.PublicKey,
+	}, nil
+}
+
+// announceAccountKeyRotation sends the succession statement on a contact
+// group, the group is activated if needed
+func (s *service) announceAccountKeyRotation(ctx context.Context, group *protocoltypes.Group, succession *protocoltypes.AccountKeySuccession) error {
+	groupPK, err := group.GetPubKey()
+	if err != nil {
+		return errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	gc, err := s.GetContextGroupForID(group.PublicKey)
+	if err != nil {
+		if err := s.activateGroup(ctx, groupPK, false); err != nil {
+			return errcode.ErrCode_ErrGroupActivate.Wrap(err)
+		}
+
+		if gc, err = s.GetContextGroupForID(group.PublicKey); err != nil {
+			return errcode.ErrCode_ErrGroupMissing.Wrap(err)
+		}
+	}
+
+	if _, err := gc.MetadataStore().ContactAccountKeyRotated(ctx, succession); err != nil {
+		return errcode.ErrCode_ErrOrbitDBAppend.Wrap(err)
+	}
+
+	return nil
+}
+
+// watchContactAccountKeyRotation follows the account key successions
+// announced on a contact group
+func (s *service) watchContactAccountKeyRotation(gc *GroupContext) {
+	sub, err := gc.MetadataStore().EventBus().Subscribe(new(*protocoltypes.GroupMetadataEvent),
+		eventbus.Name("weshnet/account-key/contact-watcher"))
+	if err != nil {
+		s.logger.Error("unable to subscribe to group metadata event", zap.Error(err))
+		return
+	}
+
+	go func() {
+		defer sub.Close()
+
+		for {
+			var e any
+			select {
+			case e = <-sub.Out():
+			case <-gc.ctx.Done():
+				return
+			case <-s.ctx.Done():
+				return
+			}
+
+			evt := e.(*protocoltypes.GroupMetadataEvent)
+			if evt.Metadata.EventType != protocoltypes.EventType_EventTypeContactAccountKeyRotated {
+				continue
+			}
+
+			if err := s.handleContactAccountKeyRotated(gc.ctx, gc.Group(), evt); err != nil {
+				s.logger.Error("unable to handle contact account key rotation", zap.Error(err))
+			}
+		}
+	}()
+}
+
+// handleContactAccountKeyRotated moves the contact of the group to its new
+// account key on the account group and registers the contact group derived
+// from it
+func (s *service) handleContactAccountKeyRotated(ctx context.Context, group *protocoltypes.Group, evt *protocoltypes.GroupMetadataEvent) error {
+	e := &protocoltypes.AccountKeyRotated{}
+	if err := proto.Unmarshal(evt.Event, e); err != nil {
+		return errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	_, next, err := secretstore.VerifyAccountKeySuccession(e.Succession)
+	if err != nil {
+		return err
+	}
+
+	accountGroup := s.getAccountGroup()
+	if accountGroup == nil {
+		return errcode.ErrCode_ErrGroupMissing
+	}
+
+	// only the contact of the group can announce its succession, ours is
+	// ignored as well as the ones already handled
+	contact := accountGroup.MetadataStore().GetContactFromGroupPK(group.PublicKey)
+	if contact == nil || !bytes.Equal(contact.Pk, e.Succession.PreviousAccountPk) {
+		return nil
+	}
+
+	if _, err := accountGroup.MetadataStore().AccountKeyRotated(ctx, e.Succession, nil); err != nil {
+		return errcode.ErrCode_ErrOrbitDBAppend.Wrap(err)
+	}
+
+	contactGroup, err := s.getContactGroup(next)
+	if err != nil {
+		return err
+	}
+
+	return s.secretStore.PutGroup(ctx, contactGroup)
+}
@@ -0,0 +1,326 @@
+package weshnet
+
+import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/testutil"
+)
+
+func TestAccountKeyRotateContact(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+
+	logger, cleanup := testutil.Logger(t)
+	defer cleanup()
+
+	opts := TestingOpts{
+		Mocknet: mocknet.New(),
+		Logger:  logger,
+	}
+
+	pts, cleanup := NewTestingProtocolWithMockedPeers(ctx, t, &opts, nil, 2)
+	defer cleanup()
+
+	config0, err := pts[0].Client.ServiceGetConfiguration(ctx, &protocoltypes.ServiceGetConfiguration_Request{})
+	require.NoError(t, err)
+
+	config1, err := pts[1].Client.ServiceGetConfiguration(ctx, &protocoltypes.ServiceGetConfiguration_Request{})
+	require.NoError(t, err)
+
+	// pts[1] adds pts[0] as a contact
+	_, err = pts[0].Client.ContactRequestEnable(ctx, &protocoltypes.ContactRequestEnable_Request{})
+	require.NoError(t, err)
+
+	ref0, err := pts[0].Client.ContactRequestResetReference(ctx, &protocoltypes.ContactRequestResetReference_Request{})
+	require.NoError(t, err)
+
+	subCtx, subCancel := context.WithCancel(ctx)
+	defer subCancel()
+
+	subMeta0, err := pts[0].Client.GroupMetadataList(subCtx, &protocoltypes.GroupMetadataList_Request{
+		GroupPk: config0.AccountGroupPk,
+	})
+	require.NoError(t, err)
+
+	_, err = pts[1].Client.ContactRequestSend(ctx, &protocoltypes.ContactRequestSend_Request{
+		Contact: &protocoltypes.ShareableContact{
+			Pk:                   config0.AccountPk,
+			PublicRendezvousSeed: ref0.PublicRendezvousSeed,
+		},
+	})
+	require.NoError(t, err)
+
+	found := false
+	for !found {
+		evt, err := subMeta0.Recv()
+		if err == io.EOF || subMeta0.Context().Err() != nil {
+			break
+		}
+		require.NoError(t, err)
+
+		if evt.Metadata.EventType != protocoltypes.EventType_EventTypeAccountContactRequestIncomingReceived {
+			continue
+		}
+
+		req := &protocoltypes.AccountContactRequestIncomingReceived{}
+		require.NoError(t, proto.Unmarshal(evt.Event, req))
+		found = bytes.Equal(config1.AccountPk, req.ContactPk)
+	}
+	subCancel()
+	require.True(t, found)
+
+	_, err = pts[0].Client.ContactRequestAccept(ctx, &protocoltypes.ContactRequestAccept_Request{
+		ContactPk: config1.AccountPk,
+	})
+	require.NoError(t, err)
+
+	grpInfo, err := pts[1].Client.GroupInfo(ctx, &protocoltypes.GroupInfo_Request{
+		ContactPk: config0.AccountPk,
+	})
+	require.NoError(t, err)
+
+	for _, pt := range pts {
+		_, err = pt.Client.ActivateGroup(ctx, &protocoltypes.ActivateGroup_Request{
+			GroupPk: grpInfo.Group.PublicKey,
+		})
+		require.NoError(t, err)
+	}
+
+	svc0, svc1 := pts[0].Service.(*service), pts[1].Service.(*service)
+
+	contactGroup0, err := svc0.GetContextGroupForID(grpInfo.Group.PublicKey)
+	require.NoError(t, err)
+	device0 := contactGroup0.DevicePubKey()
+
+	contactGroup1, err := svc1.GetContextGroupForID(grpInfo.Group.PublicKey)
+	require.NoError(t, err)
+
+	// pts[0] must be known as a member of the contact group before rotating
+	require.Eventually(t, func() bool {
+		member, err := contactGroup1.MetadataStore().GetMemberByDevice(device0)
+		return err == nil && member != nil
+	}, time.Second*10, time.Millisecond*100)
+
+	rotated, err := pts[0].Client.AccountKeyRotate(ctx, &protocoltypes.AccountKeyRotate_Request{})
+	require.NoError(t, err)
+	require.NotEqual(t, config0.AccountPk, rotated.AccountPk)
+
+	contactKeys := func() [][]byte {
+		contacts := svc1.getAccountGroup().MetadataStore().ListContactsByStatus(protocoltypes.ContactState_ContactStateAdded)
+
+		keys := make([][]byte, len(contacts))
+		for i, contact := range contacts {
+			keys[i] = contact.Pk
+		}
+
+		return keys
+	}
+
+	contactGroupMember := func() []byte {
+		member, err := contactGroup1.MetadataStore().GetMemberByDevice(device0)
+		if err != nil {
+			return nil
+		}
+
+		memberBytes, err := member.Raw()
+		require.NoError(t, err)
+
+		return memberBytes
+	}
+
+	// the contact is moved to its new key on the account group, and its
+	// devices on the contact group
+	require.Eventually(t, func() bool {
+		keys := contactKeys()
+		return len(keys) == 1 && bytes.Equal(keys[0], rotated.AccountPk)
+	}, time.Second*20, time.Millisecond*100)
+
+	require.Eventually(t, func() bool {
+		return bytes.Equal(contactGroupMember(), rotated.AccountPk)
+	}, time.Second*20, time.Millisecond*100)
+
+	// the successions are kept when the groups are indexed again
+	_, err = svc1.getAccountGroup().MetadataStore().ContactRequestDisable(ctx)
+	require.NoError(t, err)
+
+	_, err = contactGroup1.MetadataStore().SendAppMetadata(ctx, []byte("reindex"))
+	require.NoError(t, err)
+
+	require.Equal(t, [][]byte{rotated.AccountPk}, contactKeys())
+	require.Equal(t, rotated.AccountPk, contactGroupMember())
+}
+
+func TestAccountKeyRotateKeepsContactHistory(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+
+	logger, cleanup := testutil.Logger(t)
+	defer cleanup()
+
+	opts := TestingOpts{
+		Mocknet: mocknet.New(),
+		Logger:  logger,
+	}
+
+	pts, cleanup := NewTestingProtocolWithMockedPeers(ctx, t, &opts, nil, 2)
+	defer cleanup()
+
+	group := addTestContact(ctx, t, pts)
+
+	groupPK, err := group.GetPubKey()
+	require.NoError(t, err)
+
+	svc0 := pts[0].Service.(*service)
+
+	contactGroup1, err := pts[1].Service.(*service).GetContextGroupForID(group.PublicKey)
+	require.NoError(t, err)
+	device1 := contactGroup1.DevicePubKey()
+
+	payload := []byte("sent before the rotation")
+	_, err = pts[1].Client.AppMessageSend(ctx, &protocoltypes.AppMessageSend_Request{
+		GroupPk: group.PublicKey,
+		Payload: payload,
+	})
+	require.NoError(t, err)
+
+	listMessages := func() [][]byte {
+		ml, err := pts[0].Client.GroupMessageList(ctx, &protocoltypes.GroupMessageList_Request{
+			GroupPk:  group.PublicKey,
+			UntilNow: true,
+		})
+		require.NoError(t, err)
+
+		messages := [][]byte(nil)
+		for {
+			evt, err := ml.Recv()
+			if err == io.EOF {
+				return messages
+			}
+			require.NoError(t, err)
+
+			messages = append(messages, evt.Message)
+		}
+	}
+
+	require.Eventually(t, func() bool {
+		messages := listMessages()
+		return len(messages) == 1 && bytes.Equal(messages[0], payload)
+	}, time.Second*20, time.Millisecond*100)
+
+	_, err = pts[0].Client.AccountKeyRotate(ctx, &protocoltypes.AccountKeyRotate_Request{})
+	require.NoError(t, err)
+
+	// the contact group derived from the previous key is still joined
+	require.Eventually(t, func() bool {
+		for _, pk := range svc0.getAccountGroup().MetadataStore().ListPreviousContactGroupPKs() {
+			if bytes.Equal(pk, group.PublicKey) {
+				return true
+			}
+		}
+
+		return false
+	}, time.Second*10, time.Millisecond*100)
+
+	report, err := svc0.garbageCollectSecretStore(ctx)
+	require.NoError(t, err)
+	require.Zero(t, report.Groups)
+	require.True(t, svc0.secretStore.IsChainKeyKnownForDevice(ctx, groupPK, device1))
+
+	messages := listMessages()
+	require.Len(t, messages, 1)
+	require.Equal(t, payload, messages[0])
+}
+
+func TestAccountKeyRotatePendingContactRequests(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+
+	logger, cleanup := testutil.Logger(t)
+	defer cleanup()
+
+	opts := TestingOpts{
+		Mocknet: mocknet.New(),
+		Logger:  logger,
+	}
+
+	pts, cleanup := NewTestingProtocolWithMockedPeers(ctx, t, &opts, nil, 2)
+	defer cleanup()
+
+	config0, err := pts[0].Client.ServiceGetConfiguration(ctx, &protocoltypes.ServiceGetConfiguration_Request{})
+	require.NoError(t, err)
+
+	config1, err := pts[1].Client.ServiceGetConfiguration(ctx, &protocoltypes.ServiceGetConfiguration_Request{})
+	require.NoError(t, err)
+
+	svc0, svc1 := pts[0].Service.(*service), pts[1].Service.(*service)
+
+	// an outgoing request still to be sent
+	contactPK, _, err := crypto.GenerateEd25519Key(crand.Reader)
+	require.NoError(t, err)
+
+	contactPKBytes, err := contactPK.Raw()
+	require.NoError(t, err)
+
+	seed := make([]byte, protocoltypes.RendezvousSeedLength)
+	_, err = crand.Read(seed)
+	require.NoError(t, err)
+
+	_, err = svc1.getAccountGroup().MetadataStore().ContactRequestOutgoingEnqueue(ctx, &protocoltypes.ShareableContact{
+		Pk:                   contactPKBytes,
+		PublicRendezvousSeed: seed,
+	}, nil)
+	require.NoError(t, err)
+
+	_, err = svc1.AccountKeyRotate(ctx, &protocoltypes.AccountKeyRotate_Request{})
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrInvalidInput))
+
+	// an incoming request not accepted yet
+	_, err = pts[0].Client.ContactRequestEnable(ctx, &protocoltypes.ContactRequestEnable_Request{})
+	require.NoError(t, err)
+
+	ref0, err := pts[0].Client.ContactRequestResetReference(ctx, &protocoltypes.ContactRequestResetReference_Request{})
+	require.NoError(t, err)
+
+	_, err = pts[1].Client.ContactRequestSend(ctx, &protocoltypes.ContactRequestSend_Request{
+		Contact: &protocoltypes.ShareableContact{
+			Pk:                   config0.AccountPk,
+			PublicRendezvousSeed: ref0.PublicRendezvousSeed,
+		},
+	})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(svc0.getAccountGroup().MetadataStore().ListContactsByStatus(protocoltypes.ContactState_ContactStateReceived)) == 1
+	}, time.Second*20, time.Millisecond*100)
+
+	_, err = svc0.AccountKeyRotate(ctx, &protocoltypes.AccountKeyRotate_Request{})
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrInvalidInput))
+
+	// the rotation is possible once the request has been answered
+	_, err = pts[0].Client.ContactRequestDiscard(ctx, &protocoltypes.ContactRequestDiscard_Request{
+		ContactPk: config1.AccountPk,
+	})
+	require.NoError(t, err)
+
+	rotated, err := svc0.AccountKeyRotate(ctx, &protocoltypes.AccountKeyRotate_Request{})
+	require.NoError(t, err)
+	require.NotEqual(t, config0.AccountPk, rotated.AccountPk)
+}
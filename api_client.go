@@ -93,5 +93,6 @@ func (s *service) ServiceGetConfiguration(ctx context.Context, _ *protocoltypes.
 		AccountGroupPk: accountGroup.Group().PublicKey,
 		PeerId:         key.ID().String(),
 		Listeners:      listeners,
+		MeteredPolicy:  s.meteredPolicyConfiguration(),
 	}, nil
 }
@@ -0,0 +1,156 @@
+package weshnet
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/testutil"
+)
+
+// addTestContact makes pts[1] send a contact request to pts[0] which accepts
+// it, the contact group is then activated on both sides and their devices
+// are known to each other
+func addTestContact(ctx context.Context, t *testing.T, pts []*TestingProtocol) *protocoltypes.Group {
+	t.Helper()
+
+	config0, err := pts[0].Client.ServiceGetConfiguration(ctx, &protocoltypes.ServiceGetConfiguration_Request{})
+	require.NoError(t, err)
+
+	config1, err := pts[1].Client.ServiceGetConfiguration(ctx, &protocoltypes.ServiceGetConfiguration_Request{})
+	require.NoError(t, err)
+
+	_, err = pts[0].Client.ContactRequestEnable(ctx, &protocoltypes.ContactRequestEnable_Request{})
+	require.NoError(t, err)
+
+	ref0, err := pts[0].Client.ContactRequestResetReference(ctx, &protocoltypes.ContactRequestResetReference_Request{})
+	require.NoError(t, err)
+
+	subCtx, subCancel := context.WithCancel(ctx)
+	defer subCancel()
+
+	subMeta0, err := pts[0].Client.GroupMetadataList(subCtx, &protocoltypes.GroupMetadataList_Request{
+		GroupPk: config0.AccountGroupPk,
+	})
+	require.NoError(t, err)
+
+	_, err = pts[1].Client.ContactRequestSend(ctx, &protocoltypes.ContactRequestSend_Request{
+		Contact: &protocoltypes.ShareableContact{
+			Pk:                   config0.AccountPk,
+			PublicRendezvousSeed: ref0.PublicRendezvousSeed,
+		},
+	})
+	require.NoError(t, err)
+
+	found := false
+	for !found {
+		evt, err := subMeta0.Recv()
+		if err == io.EOF || subMeta0.Context().Err() != nil {
+			break
+		}
+		require.NoError(t, err)
+
+		if evt.Metadata.EventType != protocoltypes.EventType_EventTypeAccountContactRequestIncomingReceived {
+			continue
+		}
+
+		req := &protocoltypes.AccountContactRequestIncomingReceived{}
+		require.NoError(t, proto.Unmarshal(evt.Event, req))
+		found = bytes.Equal(config1.AccountPk, req.ContactPk)
+	}
+	subCancel()
+	require.True(t, found)
+
+	_, err = pts[0].Client.ContactRequestAccept(ctx, &protocoltypes.ContactRequestAccept_Request{
+		ContactPk: config1.AccountPk,
+	})
+	require.NoError(t, err)
+
+	grpInfo, err := pts[1].Client.GroupInfo(ctx, &protocoltypes.GroupInfo_Request{
+		ContactPk: config0.AccountPk,
+	})
+	require.NoError(t, err)
+
+	for _, pt := range pts {
+		_, err = pt.Client.ActivateGroup(ctx, &protocoltypes.ActivateGroup_Request{
+			GroupPk: grpInfo.Group.PublicKey,
+		})
+		require.NoError(t, err)
+	}
+
+	groupPK, err := grpInfo.Group.GetPubKey()
+	require.NoError(t, err)
+
+	devices := make([]crypto.PubKey, len(pts))
+	for i, pt := range pts {
+		gc, err := pt.Service.(*service).GetContextGroupForID(grpInfo.Group.PublicKey)
+		require.NoError(t, err)
+
+		devices[i] = gc.DevicePubKey()
+	}
+
+	// the chain keys of the other device are exchanged
+	require.Eventually(t, func() bool {
+		return pts[0].Service.(*service).secretStore.IsChainKeyKnownForDevice(ctx, groupPK, devices[1]) &&
+			pts[1].Service.(*service).secretStore.IsChainKeyKnownForDevice(ctx, groupPK, devices[0])
+	}, time.Second*20, time.Millisecond*100)
+
+	return grpInfo.Group
+}
+
+func TestContactBlockKeepsGroupKeys(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+
+	logger, cleanup := testutil.Logger(t)
+	defer cleanup()
+
+	opts := TestingOpts{
+		Mocknet: mocknet.New(),
+		Logger:  logger,
+	}
+
+	pts, cleanup := NewTestingProtocolWithMockedPeers(ctx, t, &opts, nil, 2)
+	defer cleanup()
+
+	group := addTestContact(ctx, t, pts)
+
+	groupPK, err := group.GetPubKey()
+	require.NoError(t, err)
+
+	svc0 := pts[0].Service.(*service)
+
+	contactGroup1, err := pts[1].Service.(*service).GetContextGroupForID(group.PublicKey)
+	require.NoError(t, err)
+	device1 := contactGroup1.DevicePubKey()
+
+	config1, err := pts[1].Client.ServiceGetConfiguration(ctx, &protocoltypes.ServiceGetConfiguration_Request{})
+	require.NoError(t, err)
+
+	_, err = pts[0].Client.ContactBlock(ctx, &protocoltypes.ContactBlock_Request{ContactPk: config1.AccountPk})
+	require.NoError(t, err)
+
+	report, err := svc0.garbageCollectSecretStore(ctx)
+	require.NoError(t, err)
+	require.Zero(t, report.Groups)
+	require.True(t, svc0.secretStore.IsChainKeyKnownForDevice(ctx, groupPK, device1))
+
+	// the history is still readable once the contact is unblocked
+	_, err = pts[0].Client.ContactUnblock(ctx, &protocoltypes.ContactUnblock_Request{ContactPk: config1.AccountPk})
+	require.NoError(t, err)
+
+	report, err = svc0.garbageCollectSecretStore(ctx)
+	require.NoError(t, err)
+	require.Zero(t, report.Groups)
+	require.True(t, svc0.secretStore.IsChainKeyKnownForDevice(ctx, groupPK, device1))
+}
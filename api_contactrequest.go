@@ -19,16 +19,16 @@ func (s *service) ContactRequestReference(context.Context, *protocoltypes.Contac
 	}
 
 	enabled, shareableContact := accountGroup.MetadataStore().GetIncomingContactRequestsStatus()
-	rdvSeed := []byte(nil)
+	reply := &protocoltypes.ContactRequestReference_Reply{
+		Enabled: enabled,
+	}
 
 	if shareableContact != nil {
-		rdvSeed = shareableContact.PublicRendezvousSeed
+		reply.PublicRendezvousSeed = shareableContact.PublicRendezvousSeed
+		reply.RendezvousRotationIntervalSeconds = shareableContact.RendezvousRotationIntervalSeconds
 	}
 
-	return &protocoltypes.ContactRequestReference_Reply{
-		PublicRendezvousSeed: rdvSeed,
-		Enabled:              enabled,
-	}, nil
+	return reply, nil
 }
 
 // ContactRequestDisable disables incoming contact requests
@@ -17,6 +17,7 @@ import (
 	"berty.tech/weshnet/v2/internal/sysutil"
 	"berty.tech/weshnet/v2/pkg/errcode"
 	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/tyber"
 )
 
 func (s *service) DebugListGroups(_ *protocoltypes.DebugListGroups_Request, srv protocoltypes.ProtocolService_DebugListGroupsServer) error {
@@ -176,6 +177,22 @@ func (s *service) DebugGroup(ctx context.Context, request *protocoltypes.DebugGr
 	return rep, nil
 }
 
+func (s *service) DebugSecretStoreGC(ctx context.Context, _ *protocoltypes.DebugSecretStoreGC_Request) (_ *protocoltypes.DebugSecretStoreGC_Reply, err error) {
+	ctx, _, endSection := tyber.Section(ctx, s.logger, "Garbage collecting secret store")
+	defer func() { endSection(err, "") }()
+
+	report, err := s.garbageCollectSecretStore(ctx)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrInternal.Wrap(err)
+	}
+
+	return &protocoltypes.DebugSecretStoreGC_Reply{
+		Groups:  int64(report.Groups),
+		Devices: int64(report.Devices),
+		Entries: int64(report.Entries),
+	}, nil
+}
+
 func (s *service) SystemInfo(ctx context.Context, _ *protocoltypes.SystemInfo_Request) (*protocoltypes.SystemInfo_Reply, error) {
 	reply := protocoltypes.SystemInfo_Reply{}
 
@@ -221,6 +238,21 @@ func (s *service) SystemInfo(ctx context.Context, _ *protocoltypes.SystemInfo_Re
 	}
 	// FIXME: compute more stores
 
+	// clock skew
+	if s.odb != nil && s.odb.rotationInterval != nil {
+		clockSkew := s.odb.rotationInterval.ClockSkew()
+		offset, peers := clockSkew.Offset()
+		reply.ClockSkew = &protocoltypes.SystemInfo_ClockSkew{
+			OffsetMs: offset.Milliseconds(),
+			Peers:    int64(peers),
+			Detected: clockSkew.Detected(),
+		}
+
+		if reply.ClockSkew.Detected {
+			errs = multierr.Append(errs, fmt.Errorf("local clock seems to be skewed by %s compared to %d peer(s)", offset, peers))
+		}
+	}
+
 	// warns
 	if errs != nil {
 		reply.Warns = []string{}
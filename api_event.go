@@ -60,6 +60,9 @@ func (s *service) GroupMetadataList(req *protocoltypes.GroupMetadataList_Request
 		}
 		defer sub.Close()
 		newEvents = sub.Out()
+
+		// the group stays active while it has live subscribers
+		defer s.trackGroupSubscriber(cg)()
 	}
 
 	// Subscribe to previous metadata events and stream them if requested
@@ -147,6 +150,9 @@ func (s *service) GroupMessageList(req *protocoltypes.GroupMessageList_Request,
 		}
 		defer messageStoreSub.Close()
 		newEvents = messageStoreSub.Out()
+
+		// the group stays active while it has live subscribers
+		defer s.trackGroupSubscriber(cg)()
 	}
 
 	// Subscribe to previous message events and stream them if requested
@@ -195,7 +201,7 @@ func (s *service) GroupMessageList(req *protocoltypes.GroupMessageList_Request,
 		}
 
 		msg := event.(*protocoltypes.GroupMessageEvent)
-		if msg.EventContext == nil {
+		if msg.EventContext == nil || (msg.Hidden && !req.IncludeHidden) {
 			continue
 		}
 
@@ -5,6 +5,9 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/crypto"
 	peer "github.com/libp2p/go-libp2p/core/peer"
@@ -15,6 +18,7 @@ import (
 	"berty.tech/weshnet/v2/pkg/errcode"
 	"berty.tech/weshnet/v2/pkg/logutil"
 	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/tyber"
 )
 
 func (s *service) GroupInfo(ctx context.Context, req *protocoltypes.GroupInfo_Request) (*protocoltypes.GroupInfo_Reply, error) {
@@ -63,11 +67,24 @@ func (s *service) GroupInfo(ctx context.Context, req *protocoltypes.GroupInfo_Re
 		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
 	}
 
-	return &protocoltypes.GroupInfo_Reply{
+	reply := &protocoltypes.GroupInfo_Reply{
 		Group:    g,
 		MemberPk: member,
 		DevicePk: device,
-	}, nil
+	}
+
+	if gc, err := s.GetContextGroupForID(g.PublicKey); err == nil {
+		reply.RendezvousRotationIntervalSeconds = int64(gc.MetadataStore().GetRendezvousRotationInterval() / time.Second)
+		reply.HistorySharingEnabled = gc.MetadataStore().IsHistorySharingEnabled()
+		reply.Permissions = gc.MetadataStore().GetPermissions()
+		if reply.Role, err = gc.MetadataStore().GetMemberRole(memberDevice.Member()); err != nil {
+			return nil, err
+		}
+		reply.MetadataHeadsCids = storeHeadsCIDs(gc.metadataStore)
+		reply.MessagesHeadsCids = storeHeadsCIDs(gc.messageStore)
+	}
+
+	return reply, nil
 }
 
 func (s *service) ActivateGroup(ctx context.Context, req *protocoltypes.ActivateGroup_Request) (*protocoltypes.ActivateGroup_Reply, error) {
@@ -97,6 +114,128 @@ func (s *service) DeactivateGroup(_ context.Context, req *protocoltypes.Deactiva
 	return &protocoltypes.DeactivateGroup_Reply{}, nil
 }
 
+// GroupRendezvousRotationIntervalUpdate sets the rendezvous rotation interval used by all the members of a group
+func (s *service) GroupRendezvousRotationIntervalUpdate(ctx context.Context, req *protocoltypes.GroupRendezvousRotationIntervalUpdate_Request) (_ *protocoltypes.GroupRendezvousRotationIntervalUpdate_Reply, err error) {
+	ctx, _, endSection := tyber.Section(ctx, s.logger, fmt.Sprintf("Updating rendezvous rotation interval of group %s", base64.RawURLEncoding.EncodeToString(req.GroupPk)))
+	defer func() { endSection(err, "") }()
+
+	gc, err := s.GetContextGroupForID(req.GroupPk)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrGroupMissing.Wrap(err)
+	}
+
+	if _, err := gc.MetadataStore().SendRendezvousRotationInterval(ctx, time.Duration(req.IntervalSeconds)*time.Second); err != nil {
+		if errcode.Is(err, errcode.ErrCode_ErrInvalidInput) {
+			return nil, err
+		}
+
+		return nil, errcode.ErrCode_ErrOrbitDBAppend.Wrap(err)
+	}
+
+	return &protocoltypes.GroupRendezvousRotationIntervalUpdate_Reply{}, nil
+}
+
+// GroupSyncBundleExport exports the entries of a group missing to a peer in a signed bundle, to be transferred offline
+func (s *service) GroupSyncBundleExport(req *protocoltypes.GroupSyncBundleExport_Request, server protocoltypes.ProtocolService_GroupSyncBundleExportServer) (err error) {
+	ctx, _, endSection := tyber.Section(server.Context(), s.logger, fmt.Sprintf("Exporting sync bundle of group %s", base64.RawURLEncoding.EncodeToString(req.GroupPk)))
+	defer func() { endSection(err, "") }()
+
+	gc, err := s.GetContextGroupForID(req.GroupPk)
+	if err != nil {
+		return errcode.ErrCode_ErrGroupMissing.Wrap(err)
+	}
+
+	metaHeads, err := parseCIDs(req.MetadataHeadsCids)
+	if err != nil {
+		return errcode.ErrCode_ErrInvalidInput.Wrap(err)
+	}
+
+	messageHeads, err := parseCIDs(req.MessagesHeadsCids)
+	if err != nil {
+		return errcode.ErrCode_ErrInvalidInput.Wrap(err)
+	}
+
+	r, w := io.Pipe()
+
+	var exportErr error
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+
+	go func() {
+		defer func() { _ = r.Close() }()
+		defer wg.Done()
+
+		for {
+			contents := make([]byte, 4096)
+			l, err := r.Read(contents)
+
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				exportErr = errcode.ErrCode_ErrStreamRead.Wrap(err)
+				break
+			}
+
+			if err := server.Send(&protocoltypes.GroupSyncBundleExport_Reply{BundleData: contents[:l]}); err != nil {
+				exportErr = errcode.ErrCode_ErrStreamWrite.Wrap(err)
+				break
+			}
+		}
+	}()
+
+	if err := s.exportGroupSyncBundle(ctx, gc, metaHeads, messageHeads, w); err != nil {
+		_ = w.CloseWithError(err)
+		wg.Wait()
+		return errcode.ErrCode_ErrInternal.Wrap(err)
+	}
+	_ = w.Close()
+
+	wg.Wait()
+
+	if exportErr != nil {
+		return exportErr
+	}
+
+	return nil
+}
+
+// GroupSyncBundleImport imports a bundle created by GroupSyncBundleExport
+func (s *service) GroupSyncBundleImport(server protocoltypes.ProtocolService_GroupSyncBundleImportServer) (err error) {
+	ctx, _, endSection := tyber.Section(server.Context(), s.logger, "Importing group sync bundle")
+	defer func() { endSection(err, "") }()
+
+	r, w := io.Pipe()
+	defer func() { _ = r.Close() }()
+
+	go func() {
+		for {
+			req, err := server.Recv()
+			if err == io.EOF {
+				_ = w.Close()
+				return
+			} else if err != nil {
+				_ = w.CloseWithError(errcode.ErrCode_ErrStreamRead.Wrap(err))
+				return
+			}
+
+			if _, err := w.Write(req.BundleData); err != nil {
+				// the import has been aborted
+				return
+			}
+		}
+	}()
+
+	g, entries, err := s.importGroupSyncBundle(ctx, r)
+	if err != nil {
+		return err
+	}
+
+	return server.SendAndClose(&protocoltypes.GroupSyncBundleImport_Reply{
+		GroupPk: g.PublicKey,
+		Entries: entries,
+	})
+}
+
 func (s *service) GroupDeviceStatus(req *protocoltypes.GroupDeviceStatus_Request, srv protocoltypes.ProtocolService_GroupDeviceStatusServer) error {
 	ctx := srv.Context()
 	gkey := hex.EncodeToString(req.GroupPk)
@@ -217,3 +356,30 @@ func (s *service) craftDeviceReconnectedMessage(peer peer.ID) *protocoltypes.Gro
 		PeerId: peer.String(),
 	}
 }
+
+func (s *service) GroupBandwidthMetrics(_ context.Context, req *protocoltypes.GroupBandwidthMetrics_Request) (*protocoltypes.GroupBandwidthMetrics_Reply, error) {
+	return &protocoltypes.GroupBandwidthMetrics_Reply{
+		Groups: s.groupBandwidth.metrics(req.GroupPk),
+	}, nil
+}
+
+func (s *service) GroupBandwidthQuotaSet(ctx context.Context, req *protocoltypes.GroupBandwidthQuotaSet_Request) (_ *protocoltypes.GroupBandwidthQuotaSet_Reply, err error) {
+	ctx, _, endSection := tyber.Section(ctx, s.logger, "Setting group bandwidth quota")
+	defer func() { endSection(err, "") }()
+
+	pk, err := crypto.UnmarshalEd25519PublicKey(req.GroupPk)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(err)
+	}
+
+	g, err := s.getGroupForPK(ctx, pk)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrGroupUnknown.Wrap(err)
+	}
+
+	if err := s.groupBandwidth.setQuota(ctx, g, req.Quota); err != nil {
+		return nil, err
+	}
+
+	return &protocoltypes.GroupBandwidthQuotaSet_Reply{}, nil
+}
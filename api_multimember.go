@@ -2,8 +2,10 @@ package weshnet
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 
+	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p/core/crypto"
 
 	"berty.tech/weshnet/v2/pkg/errcode"
@@ -12,7 +14,7 @@ import (
 )
 
 // MultiMemberGroupCreate creates a new MultiMember group
-func (s *service) MultiMemberGroupCreate(ctx context.Context, _ *protocoltypes.MultiMemberGroupCreate_Request) (_ *protocoltypes.MultiMemberGroupCreate_Reply, err error) {
+func (s *service) MultiMemberGroupCreate(ctx context.Context, req *protocoltypes.MultiMemberGroupCreate_Request) (_ *protocoltypes.MultiMemberGroupCreate_Reply, err error) {
 	ctx, _, endSection := tyber.Section(ctx, s.logger, "Creating MultiMember group")
 	defer func() { endSection(err, "") }()
 
@@ -21,6 +23,8 @@ func (s *service) MultiMemberGroupCreate(ctx context.Context, _ *protocoltypes.M
 		return nil, errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(err)
 	}
 
+	group.Keying = req.GetKeying()
+
 	accountGroup := s.getAccountGroup()
 	if accountGroup == nil {
 		return nil, errcode.ErrCode_ErrGroupMissing
@@ -96,6 +100,8 @@ func (s *service) MultiMemberGroupLeave(ctx context.Context, req *protocoltypes.
 		return nil, errcode.ErrCode_ErrOrbitDBAppend.Wrap(err)
 	}
 
+	s.garbageCollectSecretStoreAsync()
+
 	return &protocoltypes.MultiMemberGroupLeave_Reply{}, nil
 }
 
@@ -115,8 +121,50 @@ func (s *service) MultiMemberGroupAliasResolverDisclose(ctx context.Context, req
 }
 
 // MultiMemberGroupAdminRoleGrant grants admin role to another member of the group
-func (s *service) MultiMemberGroupAdminRoleGrant(context.Context, *protocoltypes.MultiMemberGroupAdminRoleGrant_Request) (*protocoltypes.MultiMemberGroupAdminRoleGrant_Reply, error) {
-	return nil, errcode.ErrCode_ErrNotImplemented
+func (s *service) MultiMemberGroupAdminRoleGrant(ctx context.Context, req *protocoltypes.MultiMemberGroupAdminRoleGrant_Request) (_ *protocoltypes.MultiMemberGroupAdminRoleGrant_Reply, err error) {
+	ctx, _, endSection := tyber.Section(ctx, s.logger, fmt.Sprintf("Granting admin role on group %s", base64.RawURLEncoding.EncodeToString(req.GroupPk)))
+	defer func() { endSection(err, "") }()
+
+	memberPK, err := crypto.UnmarshalEd25519PublicKey(req.MemberPk)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	cg, err := s.GetContextGroupForID(req.GroupPk)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrGroupMemberUnknownGroupID.Wrap(err)
+	}
+
+	if _, err := cg.MetadataStore().GrantAdminRole(ctx, memberPK); err != nil {
+		if errcode.Is(err, errcode.ErrCode_ErrGroupInvalidType) || errcode.Is(err, errcode.ErrCode_ErrGroupPermissionDenied) {
+			return nil, err
+		}
+
+		return nil, errcode.ErrCode_ErrOrbitDBAppend.Wrap(err)
+	}
+
+	return &protocoltypes.MultiMemberGroupAdminRoleGrant_Reply{}, nil
+}
+
+// MultiMemberGroupPermissionsUpdate sets the role required to send each kind of entry on the group
+func (s *service) MultiMemberGroupPermissionsUpdate(ctx context.Context, req *protocoltypes.MultiMemberGroupPermissionsUpdate_Request) (_ *protocoltypes.MultiMemberGroupPermissionsUpdate_Reply, err error) {
+	ctx, _, endSection := tyber.Section(ctx, s.logger, fmt.Sprintf("Updating permissions of group %s", base64.RawURLEncoding.EncodeToString(req.GroupPk)))
+	defer func() { endSection(err, "") }()
+
+	cg, err := s.GetContextGroupForID(req.GroupPk)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrGroupMemberUnknownGroupID.Wrap(err)
+	}
+
+	if _, err := cg.MetadataStore().SendPermissionsUpdated(ctx, req.Permissions); err != nil {
+		if errcode.Is(err, errcode.ErrCode_ErrGroupInvalidType) || errcode.Is(err, errcode.ErrCode_ErrInvalidInput) || errcode.Is(err, errcode.ErrCode_ErrGroupPermissionDenied) {
+			return nil, err
+		}
+
+		return nil, errcode.ErrCode_ErrOrbitDBAppend.Wrap(err)
+	}
+
+	return &protocoltypes.MultiMemberGroupPermissionsUpdate_Reply{}, nil
 }
 
 // MultiMemberGroupInvitationCreate creates a group invitation
@@ -130,3 +178,102 @@ func (s *service) MultiMemberGroupInvitationCreate(_ context.Context, req *proto
 		Group: cg.Group(),
 	}, nil
 }
+
+// MultiMemberGroupMessageHide hides a message of the group for every member
+func (s *service) MultiMemberGroupMessageHide(ctx context.Context, req *protocoltypes.MultiMemberGroupMessageHide_Request) (_ *protocoltypes.MultiMemberGroupMessageHide_Reply, err error) {
+	ctx, _, endSection := tyber.Section(ctx, s.logger, fmt.Sprintf("Hiding message of group %s", base64.RawURLEncoding.EncodeToString(req.GroupPk)))
+	defer func() { endSection(err, "") }()
+
+	_, messageCID, err := cid.CidFromBytes(req.MessageCid)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	cg, err := s.GetContextGroupForID(req.GroupPk)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrGroupMemberUnknownGroupID.Wrap(err)
+	}
+
+	if _, err := cg.MessageStore().GetMessageByCID(messageCID); err != nil {
+		return nil, err
+	}
+
+	op, err := cg.MetadataStore().HideMessage(ctx, messageCID, req.Reason)
+	if err != nil {
+		if errcode.Is(err, errcode.ErrCode_ErrGroupInvalidType) || errcode.Is(err, errcode.ErrCode_ErrGroupPermissionDenied) {
+			return nil, err
+		}
+
+		return nil, errcode.ErrCode_ErrOrbitDBAppend.Wrap(err)
+	}
+
+	return &protocoltypes.MultiMemberGroupMessageHide_Reply{Cid: op.GetEntry().GetHash().Bytes()}, nil
+}
+
+// MultiMemberGroupKeyUpdate refreshes the keys of the ratchet tree of a group using the TreeKEM keying, devices can be removed from it by an admin
+func (s *service) MultiMemberGroupKeyUpdate(ctx context.Context, req *protocoltypes.MultiMemberGroupKeyUpdate_Request) (_ *protocoltypes.MultiMemberGroupKeyUpdate_Reply, err error) {
+	ctx, _, endSection := tyber.Section(ctx, s.logger, fmt.Sprintf("Updating ratchet tree keys of group %s", base64.RawURLEncoding.EncodeToString(req.GroupPk)))
+	defer func() { endSection(err, "") }()
+
+	cg, err := s.GetContextGroupForID(req.GroupPk)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrGroupMemberUnknownGroupID.Wrap(err)
+	}
+
+	if _, err := cg.MetadataStore().CommitTreeKEM(ctx, req.RemovedDevicePks); err != nil {
+		if errcode.Is(err, errcode.ErrCode_ErrGroupInvalidType) || errcode.Is(err, errcode.ErrCode_ErrInvalidInput) || errcode.Is(err, errcode.ErrCode_ErrGroupPermissionDenied) {
+			return nil, err
+		}
+
+		return nil, errcode.ErrCode_ErrOrbitDBAppend.Wrap(err)
+	}
+
+	return &protocoltypes.MultiMemberGroupKeyUpdate_Reply{}, nil
+}
+
+// MultiMemberGroupHistorySharingUpdate allows or disallows the members of the group to share the message history with the new members
+func (s *service) MultiMemberGroupHistorySharingUpdate(ctx context.Context, req *protocoltypes.MultiMemberGroupHistorySharingUpdate_Request) (_ *protocoltypes.MultiMemberGroupHistorySharingUpdate_Reply, err error) {
+	ctx, _, endSection := tyber.Section(ctx, s.logger, fmt.Sprintf("Updating history sharing of group %s", base64.RawURLEncoding.EncodeToString(req.GroupPk)))
+	defer func() { endSection(err, "") }()
+
+	cg, err := s.GetContextGroupForID(req.GroupPk)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrGroupMemberUnknownGroupID.Wrap(err)
+	}
+
+	if _, err := cg.MetadataStore().SendHistorySharingUpdated(ctx, req.Enabled); err != nil {
+		if errcode.Is(err, errcode.ErrCode_ErrGroupInvalidType) || errcode.Is(err, errcode.ErrCode_ErrGroupPermissionDenied) {
+			return nil, err
+		}
+
+		return nil, errcode.ErrCode_ErrOrbitDBAppend.Wrap(err)
+	}
+
+	return &protocoltypes.MultiMemberGroupHistorySharingUpdate_Reply{}, nil
+}
+
+// MultiMemberGroupHistoryShare sends to a member of the group the chain keys needed to decrypt the messages sent before they joined
+func (s *service) MultiMemberGroupHistoryShare(ctx context.Context, req *protocoltypes.MultiMemberGroupHistoryShare_Request) (_ *protocoltypes.MultiMemberGroupHistoryShare_Reply, err error) {
+	ctx, _, endSection := tyber.Section(ctx, s.logger, fmt.Sprintf("Sharing history of group %s", base64.RawURLEncoding.EncodeToString(req.GroupPk)))
+	defer func() { endSection(err, "") }()
+
+	memberPK, err := crypto.UnmarshalEd25519PublicKey(req.MemberPk)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	cg, err := s.GetContextGroupForID(req.GroupPk)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrGroupMemberUnknownGroupID.Wrap(err)
+	}
+
+	if _, err := cg.MetadataStore().ShareHistory(ctx, memberPK); err != nil {
+		if errcode.Is(err, errcode.ErrCode_ErrGroupInvalidType) || errcode.Is(err, errcode.ErrCode_ErrInvalidInput) {
+			return nil, err
+		}
+
+		return nil, errcode.ErrCode_ErrOrbitDBAppend.Wrap(err)
+	}
+
+	return &protocoltypes.MultiMemberGroupHistoryShare_Reply{}, nil
+}
@@ -0,0 +1,320 @@
+package weshnet
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"go.uber.org/zap"
+
+	"berty.tech/go-orbit-db/baseorbitdb"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores"
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/lifecycle"
+	"berty.tech/weshnet/v2/pkg/logutil"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/tyber"
+)
+
+// DefaultBackgroundSyncTimeout is the duration of a background sync when the
+// request doesn't set one, mobile platforms usually give around 30 seconds to
+// a woken up app
+const DefaultBackgroundSyncTimeout = time.Second * 25
+
+// BackgroundSyncQuietPeriod is the duration without new heads nor replicated
+// entries after which a group is considered in sync with the peers met
+var BackgroundSyncQuietPeriod = time.Second * 2
+
+func (s *service) BackgroundSync(ctx context.Context, req *protocoltypes.BackgroundSync_Request) (_ *protocoltypes.BackgroundSync_Reply, err error) {
+	ctx, _, endSection := tyber.Section(ctx, s.logger, "Syncing groups in the background")
+	defer func() { endSection(err, "") }()
+
+	timeout := DefaultBackgroundSyncTimeout
+	if req.Timeout > 0 {
+		timeout = time.Duration(req.Timeout) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	groups, err := s.backgroundSyncGroups(req.GroupPks)
+	if err != nil {
+		return nil, err
+	}
+
+	// let the lifecycle consumers know the app is awake for a while, the
+	// state is restored unless the app became active meanwhile
+	if s.lifecycleManager != nil && s.lifecycleManager.SwapState(lifecycle.StateInactive, lifecycle.StateSync) {
+		defer s.lifecycleManager.SwapState(lifecycle.StateSync, lifecycle.StateInactive)
+	}
+
+	start := time.Now()
+	reports := make([]*protocoltypes.BackgroundSync_GroupReport, len(groups))
+
+	var wg sync.WaitGroup
+	for i, gc := range groups {
+		wg.Add(1)
+		go func(i int, gc *GroupContext) {
+			defer wg.Done()
+			reports[i] = s.syncGroup(ctx, gc)
+		}(i, gc)
+	}
+	wg.Wait()
+
+	reply := &protocoltypes.BackgroundSync_Reply{
+		Groups:     reports,
+		Converged:  true,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+
+	for _, report := range reports {
+		reply.Converged = reply.Converged && report.Converged
+	}
+
+	return reply, nil
+}
+
+func (s *service) backgroundSyncGroups(pks [][]byte) ([]*GroupContext, error) {
+	if len(pks) == 0 {
+		return s.listOpenedGroups(), nil
+	}
+
+	groups := make([]*GroupContext, len(pks))
+	for i, pk := range pks {
+		gc, err := s.GetContextGroupForID(pk)
+		if err != nil {
+			return nil, errcode.ErrCode_ErrGroupMissing.Wrap(err)
+		}
+
+		groups[i] = gc
+	}
+
+	return groups, nil
+}
+
+// syncGroup looks for the peers of the group until the heads they sent are
+// replicated or ctx is done
+func (s *service) syncGroup(ctx context.Context, gc *GroupContext) *protocoltypes.BackgroundSync_GroupReport {
+	logger := s.logger.With(logutil.PrivateString("group", gc.group.GroupIDAsString()))
+	report := &protocoltypes.BackgroundSync_GroupReport{GroupPk: gc.group.PublicKey}
+
+	// a subscriber keeps the replication of the group running on metered
+	// networks
+	defer s.trackGroupSubscriber(gc)()
+
+	tracker := newGroupSyncTracker(gc)
+
+	subs := make([]event.Subscription, 0, 3)
+	defer func() {
+		for _, sub := range subs {
+			sub.Close()
+		}
+	}()
+
+	for _, store := range tracker.stores {
+		sub, err := store.EventBus().Subscribe([]any{new(stores.EventReplicated), new(stores.EventNewPeer)},
+			eventbus.Name("weshnet/background-sync"))
+		if err != nil {
+			logger.Warn("unable to subscribe to store events", zap.Error(err))
+			return report
+		}
+		subs = append(subs, sub)
+	}
+
+	subHeads, err := s.odb.EventBus().Subscribe(new(baseorbitdb.EventExchangeHeads),
+		eventbus.Name("weshnet/background-sync"))
+	if err != nil {
+		logger.Warn("unable to subscribe to exchange heads events", zap.Error(err))
+		return report
+	}
+	subs = append(subs, subHeads)
+
+	// the peers still connected already exchanged heads with us
+	for _, p := range s.host.Network().Peers() {
+		if pdg, ok := s.odb.GetDevicePKForPeerID(p); ok && pdg.Group != nil && bytes.Equal(pdg.Group.PublicKey, gc.group.PublicKey) {
+			tracker.addPeer(p)
+		}
+	}
+
+	for _, store := range tracker.stores {
+		s.lookupStorePeers(ctx, store.Address().String())
+	}
+
+	ticker := time.NewTicker(BackgroundSyncQuietPeriod / 4)
+	defer ticker.Stop()
+
+	metadataAddr := gc.metadataStore.Address().String()
+	for !tracker.converged() {
+		var e any
+		select {
+		case e = <-subs[0].Out():
+		case e = <-subs[1].Out():
+		case e = <-subHeads.Out():
+		case <-ticker.C:
+			continue
+		case <-ctx.Done():
+			logger.Debug("background sync deadline reached")
+			tracker.fill(report)
+			return report
+		}
+
+		switch evt := e.(type) {
+		case stores.EventReplicated:
+			tracker.replicated(evt.Address.String() == metadataAddr, len(evt.Entries))
+		case stores.EventNewPeer:
+			tracker.addPeer(evt.Peer)
+		case baseorbitdb.EventExchangeHeads:
+			tracker.receivedHeads(evt.Peer, evt.Message)
+		}
+	}
+
+	tracker.fill(report)
+	report.Converged = true
+
+	logger.Debug("group synced in background", zap.Uint32("peers", report.Peers),
+		zap.Uint64("metadata", report.MetadataEntries), zap.Uint64("messages", report.MessageEntries))
+
+	return report
+}
+
+// lookupStorePeers looks for the peers of the store topic and connects to
+// them until ctx is done, pubsub then exchanges the heads with them
+func (s *service) lookupStorePeers(ctx context.Context, topic string) {
+	if s.tinder == nil {
+		return
+	}
+
+	sub := s.tinder.Subscribe(topic)
+	go func() {
+		if err := sub.Pull(); err != nil {
+			s.logger.Debug("unable to pull peers", logutil.PrivateString("topic", topic), zap.Error(err))
+		}
+	}()
+
+	go func() {
+		defer sub.Close()
+
+		for {
+			select {
+			case p := <-sub.Out():
+				if p.ID == s.host.ID() || len(s.host.Network().ConnsToPeer(p.ID)) > 0 {
+					continue
+				}
+
+				go func() {
+					if err := s.host.Connect(ctx, p); err != nil {
+						s.logger.Debug("unable to connect to peer", logutil.PrivateStringer("peer", p.ID), zap.Error(err))
+					}
+				}()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// groupSyncTracker follows the progress of the sync of a group
+type groupSyncTracker struct {
+	stores map[string]iface.Store
+
+	peers map[peer.ID]struct{}
+
+	// alone is true when no other device joined the group, there is nobody
+	// to sync with
+	alone bool
+
+	// pending are the heads received from the peers and not replicated yet,
+	// by store address
+	pending map[string]map[cid.Cid]struct{}
+
+	metadataEntries uint64
+	messageEntries  uint64
+
+	lastActivity time.Time
+}
+
+func newGroupSyncTracker(gc *GroupContext) *groupSyncTracker {
+	return &groupSyncTracker{
+		stores: map[string]iface.Store{
+			gc.metadataStore.Address().String(): gc.metadataStore,
+			gc.messageStore.Address().String():  gc.messageStore,
+		},
+		peers:        make(map[peer.ID]struct{}),
+		alone:        len(gc.metadataStore.ListDevices()) <= 1,
+		pending:      make(map[string]map[cid.Cid]struct{}),
+		lastActivity: time.Now(),
+	}
+}
+
+func (t *groupSyncTracker) addPeer(p peer.ID) {
+	if _, ok := t.peers[p]; !ok {
+		t.peers[p] = struct{}{}
+		t.lastActivity = time.Now()
+	}
+}
+
+func (t *groupSyncTracker) receivedHeads(p peer.ID, msg *iface.MessageExchangeHeads) {
+	if msg == nil {
+		return
+	}
+
+	if _, ok := t.stores[msg.Address]; !ok {
+		return
+	}
+
+	t.addPeer(p)
+
+	pending, ok := t.pending[msg.Address]
+	if !ok {
+		pending = make(map[cid.Cid]struct{})
+		t.pending[msg.Address] = pending
+	}
+
+	for _, head := range msg.Heads {
+		pending[head.Hash] = struct{}{}
+	}
+
+	t.lastActivity = time.Now()
+}
+
+func (t *groupSyncTracker) replicated(metadata bool, count int) {
+	if metadata {
+		t.metadataEntries += uint64(count)
+	} else {
+		t.messageEntries += uint64(count)
+	}
+
+	t.lastActivity = time.Now()
+}
+
+// converged returns true once a peer has been met, unless the device is alone
+// in the group, the heads received have been replicated and nothing happened
+// for BackgroundSyncQuietPeriod
+func (t *groupSyncTracker) converged() bool {
+	for addr, pending := range t.pending {
+		oplog := t.stores[addr].OpLog()
+		for c := range pending {
+			if _, ok := oplog.Get(c); ok {
+				delete(pending, c)
+			}
+		}
+
+		if len(pending) > 0 {
+			return false
+		}
+	}
+
+	return (len(t.peers) > 0 || t.alone) && time.Since(t.lastActivity) >= BackgroundSyncQuietPeriod
+}
+
+func (t *groupSyncTracker) fill(report *protocoltypes.BackgroundSync_GroupReport) {
+	report.Peers = uint32(len(t.peers))
+	report.MetadataEntries = t.metadataEntries
+	report.MessageEntries = t.messageEntries
+}
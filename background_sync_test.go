@@ -0,0 +1,71 @@
+package weshnet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/weshnet/v2/pkg/lifecycle"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/testutil"
+)
+
+func TestBackgroundSync(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := TestingOpts{}
+	nodes, cleanup := NewTestingProtocolWithMockedPeers(ctx, t, &opts, nil, 2)
+	defer cleanup()
+
+	g := CreateMultiMemberGroupInstance(ctx, t, nodes...)
+
+	reply, err := nodes[0].Client.AppMessageSend(ctx, &protocoltypes.AppMessageSend_Request{GroupPk: g.PublicKey, Payload: []byte("test")})
+	require.NoError(t, err)
+
+	id, err := cid.Cast(reply.Cid)
+	require.NoError(t, err)
+
+	svc := nodes[1].Service.(*service)
+	svc.lifecycleManager = lifecycle.NewManager(lifecycle.StateInactive)
+
+	synced, err := nodes[1].Client.BackgroundSync(ctx, &protocoltypes.BackgroundSync_Request{
+		GroupPks: [][]byte{g.PublicKey},
+		Timeout:  20,
+	})
+	require.NoError(t, err)
+	require.True(t, synced.Converged)
+	require.Len(t, synced.Groups, 1)
+	require.Equal(t, g.PublicKey, synced.Groups[0].GroupPk)
+	require.NotZero(t, synced.Groups[0].Peers)
+
+	gc, err := svc.GetContextGroupForID(g.PublicKey)
+	require.NoError(t, err)
+
+	_, ok := gc.messageStore.OpLog().Get(id)
+	require.True(t, ok)
+
+	// the lifecycle state is restored after the sync
+	require.Equal(t, lifecycle.StateInactive, svc.lifecycleManager.GetCurrentState())
+}
+
+func TestBackgroundSyncUnknownGroup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	node, cleanup := NewTestingProtocol(ctx, t, nil, nil)
+	defer cleanup()
+
+	g, _, err := NewGroupMultiMember()
+	require.NoError(t, err)
+
+	start := time.Now()
+	_, err = node.Client.BackgroundSync(ctx, &protocoltypes.BackgroundSync_Request{GroupPks: [][]byte{g.PublicKey}, Timeout: 5})
+	require.Error(t, err)
+	require.Less(t, time.Since(start), time.Second*5)
+}
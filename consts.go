@@ -8,6 +8,8 @@ const (
 	NamespaceOrbitDBDatastore = "orbitdb_datastore"
 	NamespaceOrbitDBDirectory = "orbitdb"
 	NamespaceIPFSDatastore    = "ipfs_datastore"
+	NamespaceStoreForward     = "storeforward_datastore"
+	NamespaceGroupBandwidth   = "group_bandwidth_datastore"
 )
 
 var InMemoryDirectory = cacheleveldown.InMemoryDirectory
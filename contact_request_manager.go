@@ -104,10 +104,11 @@ func (c *contactRequestsManager) isClosed() bool {
 
 func (c *contactRequestsManager) metadataWatcher(ctx context.Context) {
 	handlers := map[protocoltypes.EventType]func(context.Context, *protocoltypes.GroupMetadataEvent) error{
-		protocoltypes.EventType_EventTypeAccountContactRequestDisabled:         c.metadataRequestDisabled,
-		protocoltypes.EventType_EventTypeAccountContactRequestEnabled:          c.metadataRequestEnabled,
-		protocoltypes.EventType_EventTypeAccountContactRequestReferenceReset:   c.metadataRequestReset,
-		protocoltypes.EventType_EventTypeAccountContactRequestOutgoingEnqueued: c.metadataRequestEnqueued,
+		protocoltypes.EventType_EventTypeAccountContactRequestDisabled:          c.metadataRequestDisabled,
+		protocoltypes.EventType_EventTypeAccountContactRequestEnabled:           c.metadataRequestEnabled,
+		protocoltypes.EventType_EventTypeAccountContactRequestReferenceReset:    c.metadataRequestReset,
+		protocoltypes.EventType_EventTypeAccountContactRequestOutgoingEnqueued:  c.metadataRequestEnqueued,
+		protocoltypes.EventType_EventTypeGroupRendezvousRotationIntervalUpdated: c.metadataRotationIntervalUpdated,
 
 		// @FIXME: looks like we don't need those events
 		protocoltypes.EventType_EventTypeAccountContactRequestOutgoingSent:     c.metadataRequestSent,
@@ -187,6 +188,19 @@ func (c *contactRequestsManager) metadataRequestDisabled(_ context.Context, _ *p
 	return nil
 }
 
+func (c *contactRequestsManager) metadataRotationIntervalUpdated(_ context.Context, _ *protocoltypes.GroupMetadataEvent) error {
+	accPK, err := c.accountPrivateKey.GetPublic().Raw()
+	if err != nil {
+		return fmt.Errorf("unable to get raw pk: %w", err)
+	}
+
+	// the index only keeps valid intervals, the announced point is replaced
+	// if it has been computed using another interval
+	c.swiper.SetTopicRotationInterval(accPK, c.metadataStore.GetRendezvousRotationInterval())
+
+	return nil
+}
+
 func (c *contactRequestsManager) metadataRequestEnabled(ctx context.Context, evt *protocoltypes.GroupMetadataEvent) error {
 	e := &protocoltypes.AccountContactRequestEnabled{}
 	if err := proto.Unmarshal(evt.Event, e); err != nil {
@@ -350,6 +364,10 @@ func (c *contactRequestsManager) enableAnnounce(ctx context.Context, seed, accPK
 	ctx, c.announceCancel = context.WithCancel(ctx)
 	c.enabled = true
 
+	// announce using the rotation interval set on the account group, it is
+	// shared along with the seed
+	c.swiper.SetTopicRotationInterval(accPK, c.metadataStore.GetRendezvousRotationInterval())
+
 	tyber.LogStep(ctx, c.logger, "announcing on swipper")
 
 	// start announcing on swiper, this method should take care ton announce as
@@ -383,6 +401,13 @@ func (c *contactRequestsManager) enqueueRequest(ctx context.Context, to *protoco
 	// register lookup process
 	ctx = c.registerContactLookup(ctx, to.Pk)
 
+	// watch the topic using the rotation interval the contact announces with
+	if interval, err := rendezvousRotationIntervalFromSeconds(to.RendezvousRotationIntervalSeconds); err != nil {
+		c.logger.Warn("invalid contact rotation interval, using the default one", zap.Error(err))
+	} else {
+		c.swiper.SetTopicRotationInterval(to.Pk, interval)
+	}
+
 	// start watching topic on swiper, this method should take care of calling
 	// `FindPeer` as many times as needed
 	cpeers := c.swiper.WatchTopic(ctx, to.Pk, to.PublicRendezvousSeed)
@@ -455,9 +480,11 @@ func (c *contactRequestsManager) SendContactRequest(ctx context.Context, to *pro
 	c.logger.Debug("performing handshake")
 
 	tyber.LogStep(ctx, c.logger, "performing handshake")
-	if err := handshake.RequestUsingReaderWriter(ctx, c.logger, reader, writer, c.accountPrivateKey, otherPK); err != nil {
+	resumed, err := handshake.RequestWithResumption(ctx, c.logger, reader, writer, c.accountPrivateKey, otherPK, c.metadataStore.secretStore)
+	if err != nil {
 		return fmt.Errorf("an error occurred during handshake: %w", err)
 	}
+	c.logger.Debug("handshake done", zap.Bool("resumed", resumed))
 
 	tyber.LogStep(ctx, c.logger, "sending own contact")
 	// send own contact information
@@ -480,10 +507,11 @@ func (c *contactRequestsManager) handleIncomingRequest(ctx context.Context, stre
 
 	tyber.LogStep(ctx, c.logger, "responding to handshake")
 
-	otherPK, err := handshake.ResponseUsingReaderWriter(ctx, c.logger, reader, writer, c.accountPrivateKey)
+	otherPK, resumed, err := handshake.ResponseWithResumption(ctx, c.logger, reader, writer, c.accountPrivateKey, c.metadataStore.secretStore)
 	if err != nil {
 		return fmt.Errorf("handshake failed: %w", err)
 	}
+	c.logger.Debug("handshake done", zap.Bool("resumed", resumed))
 
 	otherPKBytes, err := otherPK.Raw()
 	if err != nil {
@@ -5,6 +5,7 @@ import (
 
 	cid "github.com/ipfs/go-cid"
 	"golang.org/x/crypto/nacl/secretbox"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 
 	ipfslog "berty.tech/go-ipfs-log"
@@ -13,6 +14,20 @@ import (
 	"berty.tech/weshnet/v2/pkg/protocoltypes"
 )
 
+const (
+	// groupEnvelopeVersionLegacy envelopes seal the event as is, the size of
+	// the entry can tell its event type apart
+	groupEnvelopeVersionLegacy uint32 = 0
+	// groupEnvelopeVersionPadded envelopes pad the sealed event to a multiple
+	// of groupEnvelopePaddingBlock bytes
+	groupEnvelopeVersionPadded uint32 = 1
+
+	groupEnvelopePaddingBlock = 256
+
+	// groupMetadataPaddingField is the field number of GroupMetadata.padding
+	groupMetadataPaddingField protowire.Number = 5
+)
+
 var eventTypesMapper = map[protocoltypes.EventType]struct {
 	Message    proto.Message
 	SigChecker sigChecker
@@ -31,12 +46,20 @@ var eventTypesMapper = map[protocoltypes.EventType]struct {
 	protocoltypes.EventType_EventTypeAccountContactRequestIncomingAccepted:  {Message: &protocoltypes.AccountContactRequestIncomingAccepted{}, SigChecker: sigCheckerDeviceSigned},
 	protocoltypes.EventType_EventTypeAccountContactBlocked:                  {Message: &protocoltypes.AccountContactBlocked{}, SigChecker: sigCheckerDeviceSigned},
 	protocoltypes.EventType_EventTypeAccountContactUnblocked:                {Message: &protocoltypes.AccountContactUnblocked{}, SigChecker: sigCheckerDeviceSigned},
+	protocoltypes.EventType_EventTypeAccountKeyRotated:                      {Message: &protocoltypes.AccountKeyRotated{}, SigChecker: sigCheckerAccountKeyRotated},
 	protocoltypes.EventType_EventTypeContactAliasKeyAdded:                   {Message: &protocoltypes.ContactAliasKeyAdded{}, SigChecker: sigCheckerDeviceSigned},
+	protocoltypes.EventType_EventTypeContactAccountKeyRotated:               {Message: &protocoltypes.AccountKeyRotated{}, SigChecker: sigCheckerAccountKeyRotated},
 	protocoltypes.EventType_EventTypeMultiMemberGroupAliasResolverAdded:     {Message: &protocoltypes.MultiMemberGroupAliasResolverAdded{}, SigChecker: sigCheckerDeviceSigned},
 	protocoltypes.EventType_EventTypeMultiMemberGroupInitialMemberAnnounced: {Message: &protocoltypes.MultiMemberGroupInitialMemberAnnounced{}, SigChecker: sigCheckerGroupSigned},
 	protocoltypes.EventType_EventTypeMultiMemberGroupAdminRoleGranted:       {Message: &protocoltypes.MultiMemberGroupAdminRoleGranted{}, SigChecker: sigCheckerDeviceSigned},
+	protocoltypes.EventType_EventTypeMultiMemberGroupHistorySharingUpdated:  {Message: &protocoltypes.MultiMemberGroupHistorySharingUpdated{}, SigChecker: sigCheckerDeviceSigned},
+	protocoltypes.EventType_EventTypeMultiMemberGroupHistoryChainKeysShared: {Message: &protocoltypes.MultiMemberGroupHistoryChainKeysShared{}, SigChecker: sigCheckerDeviceSigned},
+	protocoltypes.EventType_EventTypeMultiMemberGroupPermissionsUpdated:     {Message: &protocoltypes.MultiMemberGroupPermissionsUpdated{}, SigChecker: sigCheckerDeviceSigned},
+	protocoltypes.EventType_EventTypeMultiMemberGroupMessageHidden:          {Message: &protocoltypes.MultiMemberGroupMessageHidden{}, SigChecker: sigCheckerDeviceSigned},
+	protocoltypes.EventType_EventTypeMultiMemberGroupTreeKEMCommitted:       {Message: &protocoltypes.MultiMemberGroupTreeKEMCommitted{}, SigChecker: sigCheckerDeviceSigned},
 	protocoltypes.EventType_EventTypeGroupMetadataPayloadSent:               {Message: &protocoltypes.GroupMetadataPayloadSent{}, SigChecker: sigCheckerDeviceSigned},
 	protocoltypes.EventType_EventTypeGroupReplicating:                       {Message: &protocoltypes.GroupReplicating{}, SigChecker: sigCheckerDeviceSigned},
+	protocoltypes.EventType_EventTypeGroupRendezvousRotationIntervalUpdated: {Message: &protocoltypes.GroupRendezvousRotationIntervalUpdated{}, SigChecker: sigCheckerDeviceSigned},
 	protocoltypes.EventType_EventTypeAccountVerifiedCredentialRegistered:    {Message: &protocoltypes.AccountVerifiedCredentialRegistered{}, SigChecker: sigCheckerDeviceSigned},
 }
 
@@ -111,6 +134,12 @@ func openGroupEnvelope(g *protocoltypes.Group, envelopeBytes []byte) (*protocolt
 		return nil, nil, errcode.ErrCode_ErrInvalidInput.Wrap(err)
 	}
 
+	switch env.Version {
+	case groupEnvelopeVersionLegacy, groupEnvelopeVersionPadded:
+	default:
+		return nil, nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("unsupported envelope version %d", env.Version))
+	}
+
 	nonce, err := cryptoutil.NonceSliceToArray(env.Nonce)
 	if err != nil {
 		return nil, nil, errcode.ErrCode_ErrSerialization.Wrap(err)
@@ -128,6 +157,8 @@ func openGroupEnvelope(g *protocoltypes.Group, envelopeBytes []byte) (*protocolt
 		return nil, nil, errcode.ErrCode_TODO.Wrap(err)
 	}
 
+	metadataEvent.Padding = nil
+
 	et, ok := eventTypesMapper[metadataEvent.EventType]
 	if !ok {
 		return nil, nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("event type not found"))
@@ -163,6 +194,8 @@ func sealGroupEnvelope(g *protocoltypes.Group, eventType protocoltypes.EventType
 		ProtocolMetadata: &protocoltypes.ProtocolMetadata{},
 	}
 
+	event.Padding = make([]byte, groupEnvelopePaddingSize(proto.Size(event)))
+
 	eventClearBytes, err := proto.Marshal(event)
 	if err != nil {
 		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
@@ -171,9 +204,23 @@ func sealGroupEnvelope(g *protocoltypes.Group, eventType protocoltypes.EventType
 	eventBytes := secretbox.Seal(nil, eventClearBytes, nonce, g.GetSharedSecret())
 
 	env := &protocoltypes.GroupEnvelope{
-		Event: eventBytes,
-		Nonce: nonce[:],
+		Event:   eventBytes,
+		Nonce:   nonce[:],
+		Version: groupEnvelopeVersionPadded,
 	}
 
 	return proto.Marshal(env)
 }
+
+// groupEnvelopePaddingSize returns the length of the padding field bringing
+// an event of the given size to a multiple of groupEnvelopePaddingBlock bytes,
+// an empty padding isn't serialized so it is at least one byte long
+func groupEnvelopePaddingSize(size int) int {
+	paddingTagSize := protowire.SizeTag(groupMetadataPaddingField)
+
+	for padding := 1; ; padding++ {
+		if (size+paddingTagSize+protowire.SizeBytes(padding))%groupEnvelopePaddingBlock == 0 {
+			return padding
+		}
+	}
+}
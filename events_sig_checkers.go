@@ -6,6 +6,7 @@ import (
 
 	"berty.tech/weshnet/v2/pkg/errcode"
 	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/secretstore"
 )
 
 type sigChecker func(g *protocoltypes.Group, metadata *protocoltypes.GroupMetadata, message proto.Message) error
@@ -78,3 +79,16 @@ func sigCheckerGroupMemberDeviceAdded(g *protocoltypes.Group, metadata *protocol
 
 	return sigCheckerDeviceSigned(g, metadata, message)
 }
+
+func sigCheckerAccountKeyRotated(g *protocoltypes.Group, metadata *protocoltypes.GroupMetadata, message proto.Message) error {
+	msg, ok := message.(*protocoltypes.AccountKeyRotated)
+	if !ok {
+		return errcode.ErrCode_ErrDeserialization
+	}
+
+	if _, _, err := secretstore.VerifyAccountKeySuccession(msg.Succession); err != nil {
+		return err
+	}
+
+	return sigCheckerDeviceSigned(g, metadata, message)
+}
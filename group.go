@@ -42,3 +42,30 @@ func getAndFilterGroupDeviceChainKeyAddedPayload(m *protocoltypes.GroupMetadata,
 
 	return senderDevicePubKey, s.Payload, nil
 }
+
+func getAndFilterGroupHistoryChainKeysSharedPayload(m *protocoltypes.GroupMetadata, localMemberPublicKey crypto.PubKey) (crypto.PubKey, []byte, error) {
+	if m == nil || m.EventType != protocoltypes.EventType_EventTypeMultiMemberGroupHistoryChainKeysShared {
+		return nil, nil, errcode.ErrCode_ErrInvalidInput
+	}
+
+	s := &protocoltypes.MultiMemberGroupHistoryChainKeysShared{}
+	if err := proto.Unmarshal(m.Payload, s); err != nil {
+		return nil, nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	senderDevicePubKey, err := crypto.UnmarshalEd25519PublicKey(s.DevicePk)
+	if err != nil {
+		return nil, nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	destMemberPubKey, err := crypto.UnmarshalEd25519PublicKey(s.DestMemberPk)
+	if err != nil {
+		return nil, nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	if !localMemberPublicKey.Equals(destMemberPubKey) {
+		return nil, nil, errcode.ErrCode_ErrGroupSecretOtherDestMember
+	}
+
+	return senderDevicePubKey, s.Payload, nil
+}
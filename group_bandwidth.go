@@ -0,0 +1,414 @@
+package weshnet
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+const groupBandwidthMetricNamespace = "bty_group_bandwidth"
+
+// DefaultGroupBandwidthQuotaPeriod is the duration of a quota period when the
+// quota doesn't set one
+const DefaultGroupBandwidthQuotaPeriod = time.Hour * 24
+
+// GroupBandwidthQuotaCheckInterval is the interval between two checks of the
+// end of the quota periods
+var GroupBandwidthQuotaCheckInterval = time.Minute
+
+var (
+	collectorGroupBandwidth = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: groupBandwidthMetricNamespace,
+			Name:      "bytes_total",
+			Help:      "bytes exchanged for a group",
+		}, []string{"group_pk", "kind", "direction"},
+	)
+	collectorsGroupBandwidth = []prometheus.Collector{
+		collectorGroupBandwidth,
+	}
+)
+
+// protocols of the streams attributed to the groups, the traffic of the
+// other protocols isn't specific to a group
+const (
+	directChannelProtocolPrefix = "/go-orbit-db/direct-channel/"
+	bitswapProtocolPrefix       = "/ipfs/bitswap"
+)
+
+type groupBandwidthKind int
+
+const (
+	groupBandwidthPubSub groupBandwidthKind = iota
+	groupBandwidthDirectChannel
+	groupBandwidthBlocks
+
+	groupBandwidthKinds
+)
+
+func (k groupBandwidthKind) String() string {
+	switch k {
+	case groupBandwidthPubSub:
+		return "pubsub"
+	case groupBandwidthDirectChannel:
+		return "direct_channel"
+	case groupBandwidthBlocks:
+		return "blocks"
+	default:
+		return "unknown"
+	}
+}
+
+// groupTraffic is the traffic of a group and the state of its quota
+type groupTraffic struct {
+	in  [groupBandwidthKinds]uint64
+	out [groupBandwidthKinds]uint64
+
+	quota       *protocoltypes.GroupBandwidthQuota
+	periodStart time.Time
+	periodUsage uint64
+
+	// gc is the context of the group while it is active, throttled is true
+	// while its replication is paused by the quota
+	gc        *GroupContext
+	throttled bool
+}
+
+func (t *groupTraffic) period() time.Duration {
+	if t.quota.GetPeriodSeconds() > 0 {
+		return time.Duration(t.quota.GetPeriodSeconds()) * time.Second
+	}
+
+	return DefaultGroupBandwidthQuotaPeriod
+}
+
+func (t *groupTraffic) exceeded() bool {
+	return t.quota != nil && t.periodUsage > t.quota.LimitBytes
+}
+
+// groupBandwidthAccounting attributes the pubsub, direct channel and block
+// traffic to the groups, using the topics of the stores and the groups of
+// the peers, and throttles the groups exceeding their quota
+type groupBandwidthAccounting struct {
+	ctx       context.Context
+	logger    *zap.Logger
+	odb       atomic.Pointer[WeshOrbitDB]
+	datastore ds.Datastore
+
+	groups map[string]*groupTraffic
+	mu     sync.Mutex
+}
+
+func newGroupBandwidthAccounting(logger *zap.Logger, reg prometheus.Registerer) *groupBandwidthAccounting {
+	for _, collector := range collectorsGroupBandwidth {
+		if err := reg.Register(collector); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				panic(fmt.Errorf("group bandwidth metrics errors: %w", err))
+			}
+		}
+	}
+
+	return &groupBandwidthAccounting{
+		logger: logger.Named("bandwidth"),
+		groups: make(map[string]*groupTraffic),
+	}
+}
+
+// start loads the quotas and starts attributing the traffic, the traffic
+// reported before is ignored
+func (a *groupBandwidthAccounting) start(ctx context.Context, odb *WeshOrbitDB, datastore ds.Datastore) error {
+	res, err := datastore.Query(ctx, dsq.Query{})
+	if err != nil {
+		return fmt.Errorf("unable to list quotas: %w", err)
+	}
+	defer res.Close()
+
+	a.mu.Lock()
+	for entry := range res.Next() {
+		if entry.Error != nil {
+			a.mu.Unlock()
+			return fmt.Errorf("unable to list quotas: %w", entry.Error)
+		}
+
+		pk, err := hex.DecodeString(strings.TrimPrefix(entry.Key, "/"))
+		if err != nil {
+			continue
+		}
+
+		quota := &protocoltypes.GroupBandwidthQuota{}
+		if err := proto.Unmarshal(entry.Value, quota); err != nil {
+			a.logger.Warn("unable to read group quota", zap.Error(err))
+			continue
+		}
+
+		t := a.traffic(pk)
+		t.quota = quota
+		t.periodStart = time.Now()
+	}
+	a.mu.Unlock()
+
+	a.ctx = ctx
+	a.datastore = datastore
+	a.odb.Store(odb)
+
+	go a.checkPeriods(ctx)
+
+	return nil
+}
+
+// traffic returns the traffic of the given group, a.mu must be held
+func (a *groupBandwidthAccounting) traffic(pk []byte) *groupTraffic {
+	t, ok := a.groups[string(pk)]
+	if !ok {
+		t = &groupTraffic{}
+		a.groups[string(pk)] = t
+	}
+
+	return t
+}
+
+func (a *groupBandwidthAccounting) account(group *protocoltypes.Group, kind groupBandwidthKind, in, out int64) {
+	if group == nil || (in == 0 && out == 0) {
+		return
+	}
+
+	if in > 0 {
+		collectorGroupBandwidth.WithLabelValues(group.GroupIDAsString(), kind.String(), "in").Add(float64(in))
+	}
+	if out > 0 {
+		collectorGroupBandwidth.WithLabelValues(group.GroupIDAsString(), kind.String(), "out").Add(float64(out))
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t := a.traffic(group.PublicKey)
+	t.in[kind] += uint64(in)
+	t.out[kind] += uint64(out)
+
+	if t.quota == nil {
+		return
+	}
+
+	t.periodUsage += uint64(in + out)
+	a.applyQuota(t)
+}
+
+// topicTraffic attributes the traffic of a pubsub topic to the group of the
+// store using it
+func (a *groupBandwidthAccounting) topicTraffic(topic string, in, out int64) {
+	odb := a.odb.Load()
+	if odb == nil {
+		return
+	}
+
+	if group, ok := odb.messageMarshaler.GetGroupForTopic(topic); ok {
+		a.account(group, groupBandwidthPubSub, in, out)
+	}
+}
+
+// streamTraffic attributes the traffic of the direct channel and block
+// exchange streams to the group of the peer, a peer member of several groups
+// is attributed to the last group it exchanged heads for
+func (a *groupBandwidthAccounting) streamTraffic(p peer.ID, proto protocol.ID, in, out int64) {
+	var kind groupBandwidthKind
+	switch {
+	case strings.HasPrefix(string(proto), directChannelProtocolPrefix):
+		kind = groupBandwidthDirectChannel
+	case strings.HasPrefix(string(proto), bitswapProtocolPrefix):
+		kind = groupBandwidthBlocks
+	default:
+		return
+	}
+
+	odb := a.odb.Load()
+	if odb == nil {
+		return
+	}
+
+	if pdg, ok := odb.GetDevicePKForPeerID(p); ok {
+		a.account(pdg.Group, kind, in, out)
+	}
+}
+
+// applyQuota starts a new period if needed, and pauses or resumes the
+// replication of the group depending on its usage, a.mu must be held
+func (a *groupBandwidthAccounting) applyQuota(t *groupTraffic) {
+	if t.quota != nil && time.Since(t.periodStart) >= t.period() {
+		t.periodStart = time.Now()
+		t.periodUsage = 0
+	}
+
+	odb := a.odb.Load()
+	switch exceeded := t.exceeded(); {
+	case exceeded && !t.throttled && t.gc != nil && odb != nil:
+		odb.pauseGroupReplication(t.gc)
+		t.throttled = true
+		a.logger.Info("group quota exceeded, replication paused", zap.String("group", t.gc.group.GroupIDAsString()))
+	case !exceeded && t.throttled:
+		t.throttled = false
+		if t.gc != nil && odb != nil && !t.gc.IsClosed() {
+			odb.resumeGroupReplication(a.ctx, t.gc)
+			a.logger.Info("group replication resumed", zap.String("group", t.gc.group.GroupIDAsString()))
+		}
+	}
+}
+
+func (a *groupBandwidthAccounting) checkPeriods(ctx context.Context) {
+	ticker := time.NewTicker(GroupBandwidthQuotaCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		a.mu.Lock()
+		for _, t := range a.groups {
+			if t.quota != nil || t.throttled {
+				a.applyQuota(t)
+			}
+		}
+		a.mu.Unlock()
+	}
+}
+
+// updateGroup registers an activated group, its replication is paused right
+// away if its quota is exceeded
+func (a *groupBandwidthAccounting) updateGroup(_ context.Context, gc *GroupContext) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t := a.traffic(gc.group.PublicKey)
+	t.gc = gc
+	t.throttled = false
+	a.applyQuota(t)
+}
+
+// groupClosed forgets the context of a deactivated group, its deferred heads
+// are dropped with the group
+func (a *groupBandwidthAccounting) groupClosed(gc *GroupContext) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if t, ok := a.groups[string(gc.group.PublicKey)]; ok && t.gc == gc {
+		t.gc = nil
+		t.throttled = false
+	}
+}
+
+func (a *groupBandwidthAccounting) setQuota(ctx context.Context, group *protocoltypes.Group, quota *protocoltypes.GroupBandwidthQuota) error {
+	if quota.GetLimitBytes() == 0 {
+		quota = nil
+	}
+
+	key := ds.NewKey(hex.EncodeToString(group.PublicKey))
+	if quota == nil {
+		if err := a.datastore.Delete(ctx, key); err != nil {
+			return errcode.ErrCode_ErrInternal.Wrap(err)
+		}
+	} else {
+		data, err := proto.Marshal(quota)
+		if err != nil {
+			return errcode.ErrCode_ErrSerialization.Wrap(err)
+		}
+
+		if err := a.datastore.Put(ctx, key, data); err != nil {
+			return errcode.ErrCode_ErrInternal.Wrap(err)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t := a.traffic(group.PublicKey)
+	if t.quota == nil {
+		t.periodStart = time.Now()
+		t.periodUsage = 0
+	}
+	t.quota = quota
+	a.applyQuota(t)
+
+	return nil
+}
+
+// metrics returns the traffic of the given group, or of every group when pk
+// is empty
+func (a *groupBandwidthAccounting) metrics(pk []byte) []*protocoltypes.GroupBandwidth {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	groups := []*protocoltypes.GroupBandwidth{}
+	for id, t := range a.groups {
+		if len(pk) > 0 && id != string(pk) {
+			continue
+		}
+
+		groups = append(groups, &protocoltypes.GroupBandwidth{
+			GroupPk:          []byte(id),
+			PubsubIn:         t.in[groupBandwidthPubSub],
+			PubsubOut:        t.out[groupBandwidthPubSub],
+			DirectChannelIn:  t.in[groupBandwidthDirectChannel],
+			DirectChannelOut: t.out[groupBandwidthDirectChannel],
+			BlocksIn:         t.in[groupBandwidthBlocks],
+			BlocksOut:        t.out[groupBandwidthBlocks],
+			Quota:            t.quota,
+			PeriodUsage:      t.periodUsage,
+			Throttled:        t.throttled,
+		})
+	}
+
+	return groups
+}
+
+// groupBandwidthTracer is a pubsub.RawTracer attributing the messages of the
+// store topics to their group
+type groupBandwidthTracer struct {
+	accounting *groupBandwidthAccounting
+}
+
+var _ pubsub.RawTracer = (*groupBandwidthTracer)(nil)
+
+func (t *groupBandwidthTracer) RecvRPC(rpc *pubsub.RPC) {
+	for _, msg := range rpc.GetPublish() {
+		t.accounting.topicTraffic(msg.GetTopic(), int64(msg.Size()), 0)
+	}
+}
+
+func (t *groupBandwidthTracer) SendRPC(rpc *pubsub.RPC, _ peer.ID) {
+	for _, msg := range rpc.GetPublish() {
+		t.accounting.topicTraffic(msg.GetTopic(), 0, int64(msg.Size()))
+	}
+}
+
+func (t *groupBandwidthTracer) AddPeer(peer.ID, protocol.ID)          {}
+func (t *groupBandwidthTracer) RemovePeer(peer.ID)                    {}
+func (t *groupBandwidthTracer) Join(string)                           {}
+func (t *groupBandwidthTracer) Leave(string)                          {}
+func (t *groupBandwidthTracer) Graft(peer.ID, string)                 {}
+func (t *groupBandwidthTracer) Prune(peer.ID, string)                 {}
+func (t *groupBandwidthTracer) ValidateMessage(*pubsub.Message)       {}
+func (t *groupBandwidthTracer) DeliverMessage(*pubsub.Message)        {}
+func (t *groupBandwidthTracer) RejectMessage(*pubsub.Message, string) {}
+func (t *groupBandwidthTracer) DuplicateMessage(*pubsub.Message)      {}
+func (t *groupBandwidthTracer) ThrottlePeer(peer.ID)                  {}
+func (t *groupBandwidthTracer) DropRPC(*pubsub.RPC, peer.ID)          {}
+func (t *groupBandwidthTracer) UndeliverableMessage(*pubsub.Message)  {}
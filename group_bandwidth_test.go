@@ -0,0 +1,87 @@
+package weshnet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dsync "github.com/ipfs/go-datastore/sync"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+func TestGroupBandwidthAccounting(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	datastore := dsync.MutexWrap(ds.NewMapDatastore())
+
+	acc := newGroupBandwidthAccounting(zap.NewNop(), prometheus.NewRegistry())
+	require.NoError(t, acc.start(ctx, nil, datastore))
+
+	g, _, err := NewGroupMultiMember()
+	require.NoError(t, err)
+
+	other, _, err := NewGroupMultiMember()
+	require.NoError(t, err)
+
+	acc.account(g, groupBandwidthPubSub, 100, 10)
+	acc.account(g, groupBandwidthBlocks, 1000, 0)
+	acc.account(other, groupBandwidthDirectChannel, 0, 20)
+
+	require.Len(t, acc.metrics(nil), 2)
+
+	metrics := acc.metrics(g.PublicKey)
+	require.Len(t, metrics, 1)
+	require.Equal(t, uint64(100), metrics[0].PubsubIn)
+	require.Equal(t, uint64(10), metrics[0].PubsubOut)
+	require.Equal(t, uint64(1000), metrics[0].BlocksIn)
+	require.Nil(t, metrics[0].Quota)
+	require.Zero(t, metrics[0].PeriodUsage)
+
+	// the usage is counted from the moment the quota is set
+	require.NoError(t, acc.setQuota(ctx, g, &protocoltypes.GroupBandwidthQuota{LimitBytes: 500, PeriodSeconds: 3600}))
+	acc.account(g, groupBandwidthBlocks, 400, 0)
+	acc.account(g, groupBandwidthPubSub, 0, 200)
+
+	metrics = acc.metrics(g.PublicKey)
+	require.Equal(t, uint64(500), metrics[0].Quota.LimitBytes)
+	require.Equal(t, uint64(600), metrics[0].PeriodUsage)
+
+	// a new period resets the usage
+	acc.mu.Lock()
+	acc.groups[string(g.PublicKey)].periodStart = time.Now().Add(-time.Hour)
+	acc.mu.Unlock()
+
+	acc.account(g, groupBandwidthPubSub, 50, 0)
+	require.Equal(t, uint64(50), acc.metrics(g.PublicKey)[0].PeriodUsage)
+
+	// quotas are persisted
+	restarted := newGroupBandwidthAccounting(zap.NewNop(), prometheus.NewRegistry())
+	require.NoError(t, restarted.start(ctx, nil, datastore))
+
+	metrics = restarted.metrics(g.PublicKey)
+	require.Len(t, metrics, 1)
+	require.Equal(t, uint64(500), metrics[0].Quota.LimitBytes)
+
+	// a zero limit removes the quota
+	require.NoError(t, acc.setQuota(ctx, g, &protocoltypes.GroupBandwidthQuota{}))
+	require.Nil(t, acc.metrics(g.PublicKey)[0].Quota)
+
+	restarted = newGroupBandwidthAccounting(zap.NewNop(), prometheus.NewRegistry())
+	require.NoError(t, restarted.start(ctx, nil, datastore))
+	require.Empty(t, restarted.metrics(g.PublicKey))
+}
+
+func TestGroupBandwidthStreamKinds(t *testing.T) {
+	acc := newGroupBandwidthAccounting(zap.NewNop(), prometheus.NewRegistry())
+
+	// traffic is ignored until the accounting is started
+	acc.streamTraffic("peer", "/ipfs/bitswap/1.2.0", 10, 10)
+	acc.topicTraffic("topic", 10, 10)
+	require.Empty(t, acc.metrics(nil))
+}
@@ -1,6 +1,7 @@
 package weshnet
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
@@ -17,6 +19,7 @@ import (
 	"berty.tech/weshnet/v2/pkg/ipfsutil"
 	"berty.tech/weshnet/v2/pkg/logutil"
 	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/rendezvous"
 	"berty.tech/weshnet/v2/pkg/secretstore"
 )
 
@@ -36,6 +39,9 @@ type GroupContext struct {
 	muDevicesAdded    sync.RWMutex
 	selfAnnounced     chan struct{}
 	selfAnnouncedOnce sync.Once
+
+	// subscribers is the number of live subscriptions to the group events
+	subscribers atomic.Int32
 }
 
 func (gc *GroupContext) SecretStore() secretstore.SecretStore {
@@ -138,6 +144,12 @@ func (gc *GroupContext) ActivateGroupContext(contactPK crypto.PubKey) (err error
 					gc.logger.Error("unable to handle EventTypeGroupDeviceSecretAdded", zap.Error(err))
 				}
 
+				// the messages denied by the roles of the group are checked
+				// again against the updated metadata
+				if gc.group.GroupType == protocoltypes.GroupType_GroupTypeMultiMember {
+					gc.MessageStore().ProcessMessageQueues()
+				}
+
 				// if t := time.Since(start).Milliseconds(); t > 0 {
 				// 	fmt.Printf("elapsed: %dms\n", t)
 				// }
@@ -145,6 +157,13 @@ func (gc *GroupContext) ActivateGroupContext(contactPK crypto.PubKey) (err error
 		}()
 	}
 
+	// use the rendezvous rotation interval agreed on by the group members
+	gc.applyRendezvousRotationInterval()
+
+	// the history chain keys are kept from the registration of the previous
+	// chain keys if the group shares its history
+	gc.applyHistorySharing()
+
 	// send secret and register key from existing members.
 	// we should wait until all the events have been retrieved.
 	{
@@ -160,6 +179,13 @@ func (gc *GroupContext) ActivateGroupContext(contactPK crypto.PubKey) (err error
 		}()
 
 		go func() {
+			// the members of a group using the TreeKEM keying share an
+			// epoch secret instead of their chain keys
+			if gc.usesTreeKEM() {
+				wgExistingMembers.Done()
+				return
+			}
+
 			start := time.Now()
 			gc.sendSecretsToExistingMembers(contactPK)
 			wgExistingMembers.Done()
@@ -185,6 +211,12 @@ func (gc *GroupContext) ActivateGroupContext(contactPK crypto.PubKey) (err error
 		}
 	}
 
+	if gc.usesTreeKEM() {
+		if err := gc.joinTreeKEM(); err != nil {
+			return fmt.Errorf("unable to join ratchet tree: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -205,6 +237,10 @@ func (gc *GroupContext) handleGroupMetadataEvent(e *protocoltypes.GroupMetadataE
 			gc.selfAnnouncedOnce.Do(func() { close(gc.selfAnnounced) }) // mark has self announced
 		}
 
+		if gc.usesTreeKEM() {
+			return nil
+		}
+
 		if _, err := gc.MetadataStore().SendSecret(gc.ctx, memberPK); err != nil {
 			if !errcode.Is(err, errcode.ErrCode_ErrGroupSecretAlreadySentToMember) {
 				return fmt.Errorf("unable to send secret to member: %w", err)
@@ -232,13 +268,146 @@ func (gc *GroupContext) handleGroupMetadataEvent(e *protocoltypes.GroupMetadataE
 			// process queued message and check if cached messages can be opened with it
 			gc.MessageStore().ProcessMessageQueueForDevicePK(gc.ctx, rawPK)
 		}
+
+	case protocoltypes.EventType_EventTypeMultiMemberGroupHistoryChainKeysShared:
+		senderPublicKey, encryptedHistoryChainKeys, err := getAndFilterGroupHistoryChainKeysSharedPayload(e.Metadata, gc.ownMemberDevice.Member())
+		switch err {
+		case nil: // ok
+		case errcode.ErrCode_ErrInvalidInput, errcode.ErrCode_ErrGroupSecretOtherDestMember:
+			return nil
+		default:
+			return fmt.Errorf("an error occurred while opening history chain keys: %w", err)
+		}
+
+		if err := gc.registerHistoryChainKeys(senderPublicKey, encryptedHistoryChainKeys); err != nil {
+			return fmt.Errorf("unable to register history chain keys: %w", err)
+		}
+
+	case protocoltypes.EventType_EventTypeMultiMemberGroupTreeKEMCommitted:
+		// a concurrent commit may have discarded the one adding the device
+		if err := gc.joinTreeKEM(); err != nil {
+			return fmt.Errorf("unable to join ratchet tree: %w", err)
+		}
+
+		// the messages of the devices of the new epoch can now be opened
+		devices, _ := gc.MetadataStore().GetTreeKEMDevices()
+		for _, rawPK := range devices {
+			gc.MessageStore().ProcessMessageQueueForDevicePK(gc.ctx, rawPK)
+		}
+
+	case protocoltypes.EventType_EventTypeMultiMemberGroupMessageHidden:
+		event := &protocoltypes.MultiMemberGroupMessageHidden{}
+		if err := proto.Unmarshal(e.Event, event); err != nil {
+			return fmt.Errorf("unable to unmarshal hidden message: %w", err)
+		}
+
+		_, id, err := cid.CidFromBytes(event.MessageCid)
+		if err != nil {
+			return fmt.Errorf("unable to parse hidden message cid: %w", err)
+		}
+
+		// the live subscribers are notified of the message already delivered
+		gc.MessageStore().emitHiddenMessage(gc.ctx, id)
+
+	case protocoltypes.EventType_EventTypeGroupRendezvousRotationIntervalUpdated:
+		gc.applyRendezvousRotationInterval()
+
+	case protocoltypes.EventType_EventTypeMultiMemberGroupHistorySharingUpdated:
+		// the chain keys already registered are registered again so their
+		// oldest value is kept
+		if gc.applyHistorySharing() {
+			gc.fillMessageKeysHolderUsingPreviousData()
+		}
 	}
 
 	return nil
 }
 
+// usesTreeKEM returns whether the message keys of the group are derived from
+// the epoch secret of a ratchet tree
+func (gc *GroupContext) usesTreeKEM() bool {
+	return gc.group.GroupType == protocoltypes.GroupType_GroupTypeMultiMember && gc.group.Keying == protocoltypes.GroupKeying_GroupKeyingTreeKEM
+}
+
+// joinTreeKEM adds the current device to the ratchet tree of the group,
+// unless it is already part of it or has been removed from it
+func (gc *GroupContext) joinTreeKEM() error {
+	devices, removed := gc.MetadataStore().GetTreeKEMDevices()
+	if removed {
+		return nil
+	}
+
+	devicePK, err := gc.ownMemberDevice.Device().Raw()
+	if err != nil {
+		return errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	for _, d := range devices {
+		if bytes.Equal(d, devicePK) {
+			return nil
+		}
+	}
+
+	_, err = gc.MetadataStore().CommitTreeKEM(gc.ctx, nil)
+
+	return err
+}
+
+// registerHistoryChainKeys registers the history chain keys shared by a
+// member, if the group allows it, and processes the messages which can now
+// be opened
+func (gc *GroupContext) registerHistoryChainKeys(senderPublicKey crypto.PubKey, encryptedHistoryChainKeys []byte) error {
+	if !gc.MetadataStore().IsHistorySharingEnabled() {
+		gc.logger.Warn("ignoring history chain keys, history sharing is not enabled on the group")
+		return nil
+	}
+
+	devices, err := gc.SecretStore().RegisterHistoryChainKeys(gc.ctx, gc.Group(), senderPublicKey, encryptedHistoryChainKeys)
+	for _, device := range devices {
+		if rawPK, err := device.Raw(); err == nil {
+			gc.MessageStore().ProcessMessageQueueForDevicePK(gc.ctx, rawPK)
+		}
+	}
+
+	return err
+}
+
+// applyHistorySharing sets whether the secret store keeps the chain keys of
+// the group to share them with its new members, returns whether they are kept
+func (gc *GroupContext) applyHistorySharing() bool {
+	if gc.group.GroupType != protocoltypes.GroupType_GroupTypeMultiMember {
+		return false
+	}
+
+	enabled := gc.MetadataStore().IsHistorySharingEnabled()
+	if err := gc.SecretStore().SetHistorySharing(gc.ctx, gc.Group(), enabled); err != nil {
+		gc.logger.Error("unable to set history sharing", zap.Error(err))
+		return false
+	}
+
+	return enabled
+}
+
+// applyRendezvousRotationInterval registers the rotation interval set in the
+// group metadata for the topics of the group stores, both as used by the
+// heads exchange and as looked up by the swiper
+func (gc *GroupContext) applyRendezvousRotationInterval() {
+	m := gc.MetadataStore()
+	if m.rotationInterval == nil {
+		return
+	}
+
+	interval := m.GetRendezvousRotationInterval()
+	for _, address := range []string{m.Address().String(), gc.MessageStore().Address().String()} {
+		m.rotationInterval.SetTopicInterval(address, interval)
+		m.rotationInterval.SetTopicInterval(rendezvous.TopicKey([]byte(address)), interval)
+	}
+
+	gc.logger.Debug("group rendezvous rotation interval updated", zap.Duration("interval", interval))
+}
+
 func (gc *GroupContext) fillMessageKeysHolderUsingPreviousData() {
-	publishedSecrets := gc.metadataStoreListSecrets()
+	publishedSecrets, sharedHistories := gc.metadataStoreListSecrets()
 
 	for senderPublicKey, encryptedSecret := range publishedSecrets {
 		if err := gc.SecretStore().RegisterChainKey(gc.ctx, gc.Group(), senderPublicKey, encryptedSecret); err != nil {
@@ -250,22 +419,48 @@ func (gc *GroupContext) fillMessageKeysHolderUsingPreviousData() {
 			gc.MessageStore().ProcessMessageQueueForDevicePK(gc.ctx, rawPK)
 		}
 	}
+
+	// the history chain keys are only used for the devices whose chain key
+	// is known, they are registered once all the chain keys are
+	for _, history := range sharedHistories {
+		if err := gc.registerHistoryChainKeys(history.senderPublicKey, history.encryptedHistoryChainKeys); err != nil {
+			gc.logger.Error("unable to register history chain keys", zap.Error(err))
+		}
+	}
+}
+
+// sharedHistoryChainKeys is a history of chain keys shared with the current
+// member
+type sharedHistoryChainKeys struct {
+	senderPublicKey           crypto.PubKey
+	encryptedHistoryChainKeys []byte
 }
 
-func (gc *GroupContext) metadataStoreListSecrets() map[crypto.PubKey][]byte {
+// metadataStoreListSecrets returns the chain keys and the history chain keys
+// shared with the current member, the metadata log is only read once
+func (gc *GroupContext) metadataStoreListSecrets() (map[crypto.PubKey][]byte, []sharedHistoryChainKeys) {
 	publishedSecrets := map[crypto.PubKey][]byte{}
+	sharedHistories := []sharedHistoryChainKeys(nil)
 
 	m := gc.MetadataStore()
 
 	metadatas, err := m.ListEvents(gc.ctx, nil, nil, false)
 	if err != nil {
-		return nil
+		return nil, nil
 	}
 	for metadata := range metadatas {
 		if metadata == nil {
 			continue
 		}
 
+		if gc.group.GroupType == protocoltypes.GroupType_GroupTypeMultiMember {
+			pk, encryptedHistoryChainKeys, err := getAndFilterGroupHistoryChainKeysSharedPayload(metadata.Metadata, gc.MemberPubKey())
+			if err == nil {
+				sharedHistories = append(sharedHistories, sharedHistoryChainKeys{pk, encryptedHistoryChainKeys})
+				continue
+			}
+		}
+
 		pk, encryptedDeviceChainKey, err := getAndFilterGroupDeviceChainKeyAddedPayload(metadata.Metadata, gc.MemberPubKey())
 		if errcode.Is(err, errcode.ErrCode_ErrInvalidInput) || errcode.Is(err, errcode.ErrCode_ErrGroupSecretOtherDestMember) {
 			continue
@@ -279,7 +474,7 @@ func (gc *GroupContext) metadataStoreListSecrets() map[crypto.PubKey][]byte {
 		publishedSecrets[pk] = encryptedDeviceChainKey
 	}
 
-	return publishedSecrets
+	return publishedSecrets, sharedHistories
 }
 
 func (gc *GroupContext) sendSecretsToExistingMembers(contact crypto.PubKey) {
@@ -0,0 +1,293 @@
+package weshnet
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"google.golang.org/protobuf/proto"
+
+	orbitdb "berty.tech/go-orbit-db"
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+const (
+	syncBundleManifestFilename = "manifest"
+
+	// syncBundleMaxManifestSize limits the memory used to read the manifest
+	// of an untrusted bundle
+	syncBundleMaxManifestSize = 16 * 1024 * 1024
+)
+
+// exportGroupSyncBundle writes the entries of the group that can't be
+// reached from the given peer heads. The bundle starts with a manifest signed
+// with the group key, followed by the entries as stored in the group log,
+// sealed with the group keys.
+func (s *service) exportGroupSyncBundle(ctx context.Context, gc *GroupContext, peerMetaHeads, peerMessageHeads []cid.Cid, output io.Writer) error {
+	entries := append(
+		missingStoreEntries(gc.metadataStore, peerMetaHeads),
+		missingStoreEntries(gc.messageStore, peerMessageHeads)...,
+	)
+
+	manifest := &protocoltypes.GroupSyncBundle_Manifest{
+		GroupPk:           gc.group.PublicKey,
+		MetadataHeadsCids: storeHeadsCIDs(gc.metadataStore),
+		MessagesHeadsCids: storeHeadsCIDs(gc.messageStore),
+		EntriesCids:       make([][]byte, len(entries)),
+		CreatedAt:         time.Now().UnixNano(),
+	}
+
+	for i, c := range entries {
+		manifest.EntriesCids[i] = c.Bytes()
+	}
+
+	bundle, err := signGroupSyncBundleManifest(gc.group, manifest)
+	if err != nil {
+		return err
+	}
+
+	data, err := proto.Marshal(bundle)
+	if err != nil {
+		return errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	tw := tar.NewWriter(output)
+	defer tw.Close()
+
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     syncBundleManifestFilename,
+		Mode:     0o600,
+		Size:     int64(len(data)),
+	}); err != nil {
+		return errcode.ErrCode_ErrStreamWrite.Wrap(err)
+	}
+
+	if _, err := tw.Write(data); err != nil {
+		return errcode.ErrCode_ErrStreamWrite.Wrap(err)
+	}
+
+	for _, c := range entries {
+		if err := s.exportOrbitDBEntry(ctx, tw, c.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importGroupSyncBundle reads a bundle created by exportGroupSyncBundle and
+// loads its entries in the stores of the group, it returns the group and the
+// number of entries imported
+func (s *service) importGroupSyncBundle(ctx context.Context, input io.Reader) (*protocoltypes.Group, uint64, error) {
+	tr := tar.NewReader(input)
+
+	header, err := tr.Next()
+	if err != nil {
+		return nil, 0, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("unable to read bundle manifest: %w", err))
+	}
+
+	if header.Name != syncBundleManifestFilename {
+		return nil, 0, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("bundle doesn't start with a manifest"))
+	}
+
+	if header.Size <= 0 || header.Size > syncBundleMaxManifestSize {
+		return nil, 0, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("invalid manifest size"))
+	}
+
+	data := new(bytes.Buffer)
+	if _, err := io.Copy(data, tr); err != nil {
+		return nil, 0, errcode.ErrCode_ErrStreamRead.Wrap(err)
+	}
+
+	bundle := &protocoltypes.GroupSyncBundle{}
+	if err := proto.Unmarshal(data.Bytes(), bundle); err != nil {
+		return nil, 0, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	manifest := &protocoltypes.GroupSyncBundle_Manifest{}
+	if err := proto.Unmarshal(bundle.Manifest, manifest); err != nil {
+		return nil, 0, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	groupPK, err := crypto.UnmarshalEd25519PublicKey(manifest.GroupPk)
+	if err != nil {
+		return nil, 0, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	g, err := s.getGroupForPK(ctx, groupPK)
+	if err != nil {
+		return nil, 0, errcode.ErrCode_ErrGroupUnknown.Wrap(err)
+	}
+
+	if err := verifyGroupSyncBundle(g, bundle); err != nil {
+		return nil, 0, err
+	}
+
+	metaHeads, err := parseCIDs(manifest.MetadataHeadsCids)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	messageHeads, err := parseCIDs(manifest.MessagesHeadsCids)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	expected := make(map[string]bool, len(manifest.EntriesCids))
+	for _, cidBytes := range manifest.EntriesCids {
+		c, err := cid.Cast(cidBytes)
+		if err != nil {
+			return nil, 0, errcode.ErrCode_ErrDeserialization.Wrap(err)
+		}
+
+		expected[c.String()] = false
+	}
+
+	entries := uint64(0)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, 0, errcode.ErrCode_ErrStreamRead.Wrap(err)
+		}
+
+		cidStr, ok := strings.CutPrefix(header.Name, exportOrbitDBEntriesPrefix)
+		if !ok {
+			return nil, 0, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("unexpected file %q in bundle", header.Name))
+		}
+
+		// only the entries covered by the signature are accepted
+		if added, ok := expected[cidStr]; !ok || added {
+			return nil, 0, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("entry %s not expected in bundle", cidStr))
+		}
+
+		node, err := readExportCBORNode(header.Size, cidStr, tr)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if err := s.ipfsCoreAPI.Dag().Add(ctx, node); err != nil {
+			return nil, 0, errcode.ErrCode_ErrInternal.Wrap(err)
+		}
+
+		expected[cidStr] = true
+		entries++
+	}
+
+	if entries != uint64(len(expected)) {
+		return nil, 0, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("bundle is truncated, %d entries of %d found", entries, len(expected)))
+	}
+
+	if err := s.odb.setHeadsForGroup(ctx, g, metaHeads, messageHeads); err != nil {
+		return nil, 0, errcode.ErrCode_ErrOrbitDBAppend.Wrap(err)
+	}
+
+	return g, entries, nil
+}
+
+func signGroupSyncBundleManifest(g *protocoltypes.Group, manifest *protocoltypes.GroupSyncBundle_Manifest) (*protocoltypes.GroupSyncBundle, error) {
+	data, err := proto.Marshal(manifest)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	sk, err := g.GetSigningPrivKey()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
+	}
+
+	sig, err := sk.Sign(data)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoSignature.Wrap(err)
+	}
+
+	return &protocoltypes.GroupSyncBundle{
+		Manifest:  data,
+		Signature: sig,
+	}, nil
+}
+
+func verifyGroupSyncBundle(g *protocoltypes.Group, bundle *protocoltypes.GroupSyncBundle) error {
+	pk, err := g.GetSigningPubKey()
+	if err != nil {
+		return errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
+	}
+
+	ok, err := pk.Verify(bundle.Manifest, bundle.Signature)
+	if err != nil {
+		return errcode.ErrCode_ErrCryptoSignatureVerification.Wrap(err)
+	}
+
+	if !ok {
+		return errcode.ErrCode_ErrCryptoSignatureVerification.Wrap(fmt.Errorf("invalid bundle signature"))
+	}
+
+	return nil
+}
+
+// missingStoreEntries returns the entries of the store which are not
+// ancestors of the given heads, heads unknown locally are ignored
+func missingStoreEntries(store orbitdb.Store, heads []cid.Cid) []cid.Cid {
+	known := make(map[string]struct{})
+	queue := append([]cid.Cid{}, heads...)
+
+	for len(queue) > 0 {
+		c := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		if _, ok := known[c.KeyString()]; ok {
+			continue
+		}
+
+		e, ok := store.OpLog().Get(c)
+		if !ok {
+			continue
+		}
+
+		known[c.KeyString()] = struct{}{}
+		queue = append(queue, e.GetNext()...)
+	}
+
+	missing := []cid.Cid{}
+	for _, e := range store.OpLog().GetEntries().Slice() {
+		if _, ok := known[e.GetHash().KeyString()]; !ok {
+			missing = append(missing, e.GetHash())
+		}
+	}
+
+	return missing
+}
+
+func storeHeadsCIDs(store orbitdb.Store) [][]byte {
+	rawHeads := store.OpLog().RawHeads()
+	cids := make([][]byte, rawHeads.Len())
+	for i, raw := range rawHeads.Slice() {
+		cids[i] = raw.GetHash().Bytes()
+	}
+
+	return cids
+}
+
+func parseCIDs(cidsBytes [][]byte) ([]cid.Cid, error) {
+	cids := make([]cid.Cid, len(cidsBytes))
+	for i, cidBytes := range cidsBytes {
+		c, err := cid.Cast(cidBytes)
+		if err != nil {
+			return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+		}
+
+		cids[i] = c
+	}
+
+	return cids, nil
+}
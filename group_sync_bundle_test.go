@@ -0,0 +1,130 @@
+package weshnet
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsync "github.com/ipfs/go-datastore/sync"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/testutil"
+)
+
+func TestGroupSyncBundle(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	g, _, err := NewGroupMultiMember()
+	require.NoError(t, err)
+
+	// nodes are on distinct networks, entries can only go through bundles
+	newNode := func() *TestingProtocol {
+		mn := mocknet.New()
+		t.Cleanup(func() { mn.Close() })
+
+		node, cleanup := NewTestingProtocol(ctx, t, &TestingOpts{Mocknet: mn}, dsync.MutexWrap(ds.NewMapDatastore()))
+		t.Cleanup(cleanup)
+
+		_, err := node.Client.MultiMemberGroupJoin(ctx, &protocoltypes.MultiMemberGroupJoin_Request{Group: g})
+		require.NoError(t, err)
+
+		_, err = node.Client.ActivateGroup(ctx, &protocoltypes.ActivateGroup_Request{GroupPk: g.PublicKey})
+		require.NoError(t, err)
+
+		return node
+	}
+
+	nodeA, nodeB := newNode(), newNode()
+
+	syncNodes := func(from, to *TestingProtocol) uint64 {
+		info, err := to.Client.GroupInfo(ctx, &protocoltypes.GroupInfo_Request{GroupPk: g.PublicKey})
+		require.NoError(t, err)
+
+		exported, err := from.Client.GroupSyncBundleExport(ctx, &protocoltypes.GroupSyncBundleExport_Request{
+			GroupPk:           g.PublicKey,
+			MetadataHeadsCids: info.MetadataHeadsCids,
+			MessagesHeadsCids: info.MessagesHeadsCids,
+		})
+		require.NoError(t, err)
+
+		imported, err := to.Client.GroupSyncBundleImport(ctx)
+		require.NoError(t, err)
+
+		for {
+			chunk, err := exported.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			require.NoError(t, imported.Send(&protocoltypes.GroupSyncBundleImport_Request{BundleData: chunk.BundleData}))
+		}
+
+		reply, err := imported.CloseAndRecv()
+		require.NoError(t, err)
+		require.Equal(t, g.PublicKey, reply.GroupPk)
+
+		return reply.Entries
+	}
+
+	expected := []cid.Cid{}
+	send := func(node *TestingProtocol, payload string) {
+		reply, err := node.Client.AppMessageSend(ctx, &protocoltypes.AppMessageSend_Request{GroupPk: g.PublicKey, Payload: []byte(payload)})
+		require.NoError(t, err)
+
+		id, err := cid.Cast(reply.Cid)
+		require.NoError(t, err)
+		expected = append(expected, id)
+	}
+
+	send(nodeA, "message 1")
+	send(nodeA, "message 2")
+
+	require.NotZero(t, syncNodes(nodeA, nodeB))
+
+	// only the entries missing to the peer are exported
+	send(nodeA, "message 3")
+	require.Equal(t, uint64(1), syncNodes(nodeA, nodeB))
+
+	// messages can't be opened until the device secrets are exchanged, only
+	// check that the sealed entries have been loaded
+	gcB, err := nodeB.Service.(*service).GetContextGroupForID(g.PublicKey)
+	require.NoError(t, err)
+
+	for _, id := range expected {
+		_, ok := gcB.messageStore.OpLog().Get(id)
+		require.True(t, ok)
+	}
+}
+
+func TestGroupSyncBundleInvalidSignature(t *testing.T) {
+	g, _, err := NewGroupMultiMember()
+	require.NoError(t, err)
+
+	other, _, err := NewGroupMultiMember()
+	require.NoError(t, err)
+
+	manifest := &protocoltypes.GroupSyncBundle_Manifest{GroupPk: g.PublicKey}
+
+	bundle, err := signGroupSyncBundleManifest(g, manifest)
+	require.NoError(t, err)
+	require.NoError(t, verifyGroupSyncBundle(g, bundle))
+
+	// signed by another group
+	bundle, err = signGroupSyncBundleManifest(other, manifest)
+	require.NoError(t, err)
+	require.True(t, errcode.Is(verifyGroupSyncBundle(g, bundle), errcode.ErrCode_ErrCryptoSignatureVerification))
+
+	// tampered manifest
+	bundle, err = signGroupSyncBundleManifest(g, manifest)
+	require.NoError(t, err)
+	bundle.Manifest = append(bundle.Manifest, 0)
+	require.True(t, errcode.Is(verifyGroupSyncBundle(g, bundle), errcode.ErrCode_ErrCryptoSignatureVerification))
+}
@@ -0,0 +1,166 @@
+package datastoreutil
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+
+	"berty.tech/weshnet/v2/pkg/cryptoutil"
+	"berty.tech/weshnet/v2/pkg/errcode"
+)
+
+// EncryptedDatastoreKeySize is the size of the key used by an encrypted
+// datastore
+const EncryptedDatastoreKeySize = 32
+
+// encryptedValueMagic prefixes the values written by an encrypted datastore,
+// the leading zero byte can't start a valid protobuf message
+var encryptedValueMagic = []byte{0x00, 'w', 'e', 's', 0x01}
+
+type encryptedDatastore struct {
+	child ds.Datastore
+	aead  cipher.AEAD
+}
+
+// NewEncryptedDatastore wraps a datastore so the values are encrypted at rest
+// using AES-GCM, the datastore key of an entry being authenticated along with
+// its value. Keys are stored in plaintext so prefix queries keep working.
+func NewEncryptedDatastore(child ds.Datastore, key []byte) (ds.Datastore, error) {
+	if len(key) != EncryptedDatastoreKeySize {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("invalid key size %d", len(key)))
+	}
+
+	blockCipher, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoCipherInit.Wrap(err)
+	}
+
+	aead, err := cipher.NewGCM(blockCipher)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoCipherInit.Wrap(err)
+	}
+
+	return &encryptedDatastore{child: child, aead: aead}, nil
+}
+
+// IsEncryptedValue returns whether a value has been written by an encrypted
+// datastore
+func IsEncryptedValue(value []byte) bool {
+	return bytes.HasPrefix(value, encryptedValueMagic)
+}
+
+func (e *encryptedDatastore) seal(key ds.Key, value []byte) ([]byte, error) {
+	nonce, err := cryptoutil.GenerateNonceSize(e.aead.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(encryptedValueMagic)+len(nonce)+len(value)+e.aead.Overhead())
+	out = append(out, encryptedValueMagic...)
+	out = append(out, nonce...)
+
+	return e.aead.Seal(out, nonce, value, key.Bytes()), nil
+}
+
+func (e *encryptedDatastore) open(key ds.Key, value []byte) ([]byte, error) {
+	if !IsEncryptedValue(value) || len(value) < len(encryptedValueMagic)+e.aead.NonceSize() {
+		return nil, errcode.ErrCode_ErrCryptoDecrypt.Wrap(fmt.Errorf("value of %s is not encrypted", key))
+	}
+
+	value = value[len(encryptedValueMagic):]
+	nonce, ciphertext := value[:e.aead.NonceSize()], value[e.aead.NonceSize():]
+
+	plaintext, err := e.aead.Open(nil, nonce, ciphertext, key.Bytes())
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoDecrypt.Wrap(err)
+	}
+
+	return plaintext, nil
+}
+
+func (e *encryptedDatastore) Get(ctx context.Context, key ds.Key) ([]byte, error) {
+	value, err := e.child.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.open(key, value)
+}
+
+func (e *encryptedDatastore) Has(ctx context.Context, key ds.Key) (bool, error) {
+	return e.child.Has(ctx, key)
+}
+
+func (e *encryptedDatastore) GetSize(ctx context.Context, key ds.Key) (int, error) {
+	size, err := e.child.GetSize(ctx, key)
+	if err != nil {
+		return size, err
+	}
+
+	return size - len(encryptedValueMagic) - e.aead.NonceSize() - e.aead.Overhead(), nil
+}
+
+func (e *encryptedDatastore) Put(ctx context.Context, key ds.Key, value []byte) error {
+	sealed, err := e.seal(key, value)
+	if err != nil {
+		return errcode.ErrCode_ErrCryptoEncrypt.Wrap(err)
+	}
+
+	return e.child.Put(ctx, key, sealed)
+}
+
+func (e *encryptedDatastore) Delete(ctx context.Context, key ds.Key) error {
+	return e.child.Delete(ctx, key)
+}
+
+// Query fetches the matching entries from the child datastore, filters and
+// orders are then applied on the decrypted values
+func (e *encryptedDatastore) Query(ctx context.Context, q query.Query) (query.Results, error) {
+	res, err := e.child.Query(ctx, query.Query{Prefix: q.Prefix, ReturnExpirations: q.ReturnExpirations})
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted := query.ResultsFromIterator(q, query.Iterator{
+		Next: func() (query.Result, bool) {
+			r, ok := res.NextSync()
+			if !ok || r.Error != nil {
+				return r, ok
+			}
+
+			value, err := e.open(ds.RawKey(r.Key), r.Value)
+			if err != nil {
+				return query.Result{Error: err}, true
+			}
+
+			r.Value, r.Size = value, len(value)
+			if q.KeysOnly {
+				r.Value = nil
+			}
+
+			return r, true
+		},
+		Close: res.Close,
+	})
+
+	return query.NaiveQueryApply(query.Query{
+		Filters: q.Filters,
+		Orders:  q.Orders,
+		Offset:  q.Offset,
+		Limit:   q.Limit,
+	}, decrypted), nil
+}
+
+func (e *encryptedDatastore) Sync(ctx context.Context, prefix ds.Key) error {
+	return e.child.Sync(ctx, prefix)
+}
+
+func (e *encryptedDatastore) Close() error {
+	// noop
+	return nil
+}
@@ -56,5 +56,32 @@
 //     |---------------------------------->|
 //     |                                   |
 //
+// Suite Negotiation:
+// ------------------
+// Both hellos carry the version of the handshake and the suites supported by
+// the peer, the responder selects the strongest common suite and sends it
+// back in its hello. Each peer repeats its suites in its boxed payload (steps
+// 3 and 4), a peer whose hello has been tampered with to force a weaker suite
+// is rejected with ErrHandshakeDowngrade. Peers predating the negotiation
+// leave the version to zero and their suites are deduced from their hello.
+//
+// Hybrid Suite:
+// -------------
+// The requester also sends an ML-KEM-768 encapsulation key in its hello. A
+// responder selecting the hybrid suite encapsulates a secret k for it and
+// sends back the ciphertext in its hello, k is then appended to the inputs of
+// both box keys (box[a.b|a.B|k] and box[a.b|A.B|k]). Otherwise the handshake
+// falls back to the sequence above.
+//
+// Session Resumption:
+// -------------------
+// After a full handshake, both peers derive a resumption secret s from the
+// box key of step 4 and keep it in a ResumptionStore. The next requester
+// hello carries the ID of s and mac[s](a), a responder knowing s answers with
+// mac[s](a|b) in its hello and the handshake ends after this round trip.
+// Both peers then replace s with mac[s](a.b), so a resumption secret is only
+// used once. A responder not knowing s ignores these fields and the full
+// handshake is performed.
+//
 // See the documentation at https://berty.tech/protocol for more information.
 package handshake
@@ -1,8 +1,10 @@
 package handshake
 
 import (
+	"crypto/mlkem"
 	crand "crypto/rand"
 	"encoding/base64"
+	"strconv"
 
 	p2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
 	"golang.org/x/crypto/nacl/box"
@@ -25,9 +27,37 @@ type handshakeContext struct {
 	writer          protoio.Writer
 	ownAccountID    p2pcrypto.PrivKey
 	peerAccountID   p2pcrypto.PubKey
+	ownEphemeralPub *[cryptoutil.KeySize]byte
 	ownEphemeral    *[cryptoutil.KeySize]byte
 	peerEphemeral   *[cryptoutil.KeySize]byte
 	sharedEphemeral *[cryptoutil.KeySize]byte
+
+	// Suite negotiation (see negotiation.go)
+	peerVersion uint32
+	peerSuites  []HandshakeSuite
+	suite       HandshakeSuite
+
+	// Hybrid suite: the requester sends an ML-KEM encapsulation key in its
+	// hello, a responder selecting the suite answers with a ciphertext and
+	// the shared secret is mixed into the box keys
+	ownKEM    *mlkem.DecapsulationKey768
+	peerKEM   *mlkem.EncapsulationKey768
+	sharedKEM []byte
+
+	// Session resumption (see resumption.go)
+	resumption          ResumptionStore
+	resumptionID        []byte
+	resumptionSecret    []byte
+	peerResumptionID    []byte
+	peerResumptionProof []byte
+	resumed             bool
+
+	// classicOnly disables the hybrid suite, as a peer predating it would
+	classicOnly bool
+
+	// legacy omits the negotiation fields, as a peer predating the version
+	// negotiation would
+	legacy bool
 }
 
 func (hc *handshakeContext) toTyberStepMutator() tyber.StepMutator {
@@ -40,6 +70,11 @@ func (hc *handshakeContext) toTyberStepMutator() tyber.StepMutator {
 				s.Details = append(s.Details, tyber.Detail{Name: "ContactPublicKey", Description: base64.RawURLEncoding.EncodeToString(cpkb)})
 			}
 		}
+		s.Details = append(s.Details,
+			tyber.Detail{Name: "PeerVersion", Description: strconv.FormatUint(uint64(hc.peerVersion), 10)},
+			tyber.Detail{Name: "Suite", Description: hc.suite.String()},
+			tyber.Detail{Name: "Resumed", Description: strconv.FormatBool(hc.resumed)},
+		)
 		for key, val := range map[string]*[cryptoutil.KeySize]byte{
 			"OwnEphemeral":    hc.ownEphemeral,
 			"PeerEphemeral":   hc.peerEphemeral,
@@ -56,21 +91,26 @@ func (hc *handshakeContext) toTyberStepMutator() tyber.StepMutator {
 	}
 }
 
-// Generates own Ephemeral key pair and send pub key to peer
-func (hc *handshakeContext) generateOwnEphemeralAndSendPubKey() error {
-	// Generate own Ephemeral key pair
+// Generates own Ephemeral key pair
+func (hc *handshakeContext) generateOwnEphemeral() error {
 	ownEphemeralPub, ownEphemeralPriv, err := box.GenerateKey(crand.Reader)
 	if err != nil {
 		return errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(err)
 	}
 
-	// Set own Ephemeral priv key in Handshake Context
+	// Set own Ephemeral key pair in Handshake Context
+	hc.ownEphemeralPub = ownEphemeralPub
 	hc.ownEphemeral = ownEphemeralPriv
 
-	// Send own Ephemeral pub key to peer
-	hello := HelloPayload{EphemeralPubKey: ownEphemeralPub[:]}
+	return nil
+}
+
+// Sends own Ephemeral pub key to peer, along with the fields already set in
+// hello
+func (hc *handshakeContext) sendOwnEphemeralPubKey(hello *HelloPayload) error {
+	hello.EphemeralPubKey = hc.ownEphemeralPub[:]
 
-	if err := hc.writer.WriteMsg(&hello); err != nil {
+	if err := hc.writer.WriteMsg(hello); err != nil {
 		return errcode.ErrCode_ErrStreamWrite.Wrap(err)
 	}
 
@@ -78,22 +118,22 @@ func (hc *handshakeContext) generateOwnEphemeralAndSendPubKey() error {
 }
 
 // Receives peer's Ephemeral pub key
-func (hc *handshakeContext) receivePeerEphemeralPubKey() error {
+func (hc *handshakeContext) receivePeerEphemeralPubKey() (*HelloPayload, error) {
 	var err error
 
 	// Receive peer's Ephemeral pub key
 	hello := HelloPayload{}
 	if err := hc.reader.ReadMsg(&hello); err != nil {
-		return errcode.ErrCode_ErrStreamRead.Wrap(err)
+		return nil, errcode.ErrCode_ErrStreamRead.Wrap(err)
 	}
 
 	// Set peer's Ephemeral pub key in Handshake Context
 	hc.peerEphemeral, err = cryptoutil.KeySliceToArray(hello.EphemeralPubKey)
 	if err != nil {
-		return errcode.ErrCode_ErrSerialization.Wrap(err)
+		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
 	}
 
-	return nil
+	return &hello, nil
 }
 
 // Computes box key for step 3 (Requester Authenticate): box[a.b|a.B]
@@ -115,24 +155,23 @@ func (hc *handshakeContext) computeRequesterAuthenticateBoxKey(asRequester bool)
 			hc.ownEphemeral,
 		)
 	} else {
-		// Convert Ed25519 own AccountID key to X25519 key
-		mongOwnAccountID, err := cryptoutil.EdwardsToMontgomeryPriv(hc.ownAccountID)
-		if err != nil {
-			return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
-		}
-
 		// Compute shared key from peer's Ephemeral key and own AccountID key
-		box.Precompute(
+		// (X25519 converted), the latter may be held by an external signer
+		if err := cryptoutil.PrecomputeBoxKey(
 			&sharedReqEphemeralRespAccountID,
+			hc.ownAccountID,
 			hc.peerEphemeral,
-			mongOwnAccountID,
-		)
+		); err != nil {
+			return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
+		}
 	}
 
-	// Concatenate both shared keys and hash them using sha256
+	// Concatenate both shared keys, and the KEM secret in hybrid mode, and
+	// hash them using sha256
 	boxKey := cryptoutil.ConcatAndHashSha256(
 		hc.sharedEphemeral[:],
 		sharedReqEphemeralRespAccountID[:],
+		hc.sharedKEM,
 	)
 
 	return boxKey, nil
@@ -142,22 +181,23 @@ func (hc *handshakeContext) computeRequesterAuthenticateBoxKey(asRequester bool)
 func (hc *handshakeContext) computeResponderAcceptBoxKey() (*[cryptoutil.KeySize]byte, error) {
 	var sharedAccountID [cryptoutil.KeySize]byte
 
-	// Convert Ed25519 AccountID keys to X25519 keys
-	mongOwnAccountID, mongPeerAccountID, err := cryptoutil.EdwardsToMontgomery(
-		hc.ownAccountID,
-		hc.peerAccountID,
-	)
+	// Convert Ed25519 peer's AccountID key to X25519 key
+	mongPeerAccountID, err := cryptoutil.EdwardsToMontgomeryPub(hc.peerAccountID)
 	if err != nil {
 		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
 	}
 
 	// Compute shared key from AccountID keys (X25519 converted)
-	box.Precompute(&sharedAccountID, mongPeerAccountID, mongOwnAccountID)
+	if err := cryptoutil.PrecomputeBoxKey(&sharedAccountID, hc.ownAccountID, mongPeerAccountID); err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
+	}
 
-	// Concatenate both shared keys and hash them using sha256
+	// Concatenate both shared keys, and the KEM secret in hybrid mode, and
+	// hash them using sha256
 	boxKey := cryptoutil.ConcatAndHashSha256(
 		hc.sharedEphemeral[:],
 		sharedAccountID[:],
+		hc.sharedKEM,
 	)
 
 	return boxKey, nil
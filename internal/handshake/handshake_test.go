@@ -2,6 +2,7 @@ package handshake
 
 import (
 	"context"
+	"crypto/mlkem"
 	crand "crypto/rand"
 	"sync"
 	"testing"
@@ -77,6 +78,309 @@ func TestValidHandshake(t *testing.T) {
 	runHandshakeTest(t, requesterTest, responderTest)
 }
 
+func TestHybridHandshake(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	cases := []struct {
+		name               string
+		requester          handshakeContext
+		responder          handshakeContext
+		expectedHybridMode bool
+	}{
+		{"both hybrid", handshakeContext{}, handshakeContext{}, true},
+		{"classic requester", handshakeContext{classicOnly: true}, handshakeContext{}, false},
+		{"classic responder", handshakeContext{}, handshakeContext{classicOnly: true}, false},
+		{"both classic", handshakeContext{classicOnly: true}, handshakeContext{classicOnly: true}, false},
+		{"legacy hybrid requester", handshakeContext{legacy: true}, handshakeContext{}, true},
+		{"legacy classic requester", handshakeContext{legacy: true, classicOnly: true}, handshakeContext{}, false},
+		{"legacy hybrid responder", handshakeContext{}, handshakeContext{legacy: true}, true},
+		{"legacy classic responder", handshakeContext{}, handshakeContext{legacy: true, classicOnly: true}, false},
+		{"both legacy", handshakeContext{legacy: true}, handshakeContext{legacy: true}, true},
+	}
+
+	for _, tc := range cases {
+		t.Log(tc.name)
+
+		var requesterTest requesterTestFunc = func(
+			t *testing.T,
+			stream p2pnetwork.Stream,
+			mh *mockedHandshake,
+		) {
+			defer ipfsutil.FullClose(stream)
+
+			hc := newTestHandshakeContext(
+				stream,
+				mh.requester.accountID,
+				mh.responder.accountID.GetPublic(),
+			)
+			hc.classicOnly, hc.legacy = tc.requester.classicOnly, tc.requester.legacy
+
+			err := hc.request(context.TODO(), zap.NewNop())
+			require.NoError(t, err, "handshake request failed")
+			require.Equal(t, tc.expectedHybridMode, hc.isHybrid())
+		}
+
+		var responderTest responderTestFunc = func(
+			t *testing.T,
+			stream p2pnetwork.Stream,
+			mh *mockedHandshake,
+			wg *sync.WaitGroup,
+		) {
+			defer wg.Done()
+			defer ipfsutil.FullClose(stream)
+
+			hc := newTestHandshakeContext(stream, mh.responder.accountID, nil)
+			hc.classicOnly, hc.legacy = tc.responder.classicOnly, tc.responder.legacy
+
+			peerAccountID, err := hc.respond(context.TODO(), zap.NewNop())
+			require.NoError(t, err, "handshake response failed")
+			require.True(t, peerAccountID.Equals(mh.requester.accountID.GetPublic()))
+			require.Equal(t, tc.expectedHybridMode, hc.isHybrid())
+		}
+
+		runHandshakeTest(t, requesterTest, responderTest)
+	}
+}
+
+func TestHybridHandshakeKEMMismatch(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	t.Log("Responder encapsulates a secret for another key")
+	{
+		var requesterTest requesterTestFunc = func(
+			t *testing.T,
+			stream p2pnetwork.Stream,
+			mh *mockedHandshake,
+		) {
+			defer ipfsutil.FullClose(stream)
+
+			err := Request(
+				stream,
+				mh.requester.accountID,
+				mh.responder.accountID.GetPublic(),
+			)
+			require.Contains(t, errcode.Codes(err), errcode.ErrCode_ErrHandshakeResponderAccept)
+		}
+
+		var responderTest responderTestFunc = func(
+			t *testing.T,
+			stream p2pnetwork.Stream,
+			mh *mockedHandshake,
+			wg *sync.WaitGroup,
+		) {
+			defer wg.Done()
+			defer ipfsutil.FullClose(stream)
+
+			hc := newTestHandshakeContext(stream, mh.responder.accountID, nil)
+
+			err := hc.receiveRequesterHello()
+			require.NoError(t, err, "receive RequesterHello failed")
+
+			// Replace the requester encapsulation key
+			otherKEM, err := mlkem.GenerateKey768()
+			require.NoError(t, err)
+			hc.peerKEM = otherKEM.EncapsulationKey()
+
+			err = hc.sendResponderHello()
+			require.NoError(t, err, "send ResponderHello failed")
+
+			err = hc.receiveRequesterAuthenticate()
+			require.Equal(t, errcode.Codes(err), []errcode.ErrCode{errcode.ErrCode_ErrCryptoDecrypt})
+		}
+
+		runHandshakeTest(t, requesterTest, responderTest)
+	}
+}
+
+func TestHandshakeDowngrade(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	for _, tc := range []struct {
+		name   string
+		tamper func(hello *HelloPayload)
+	}{
+		{"Requester hello stripped of the hybrid suite", func(hello *HelloPayload) {
+			hello.Suites = []HandshakeSuite{HandshakeSuite_SuiteX25519}
+			hello.KemEncapsulationKey = nil
+		}},
+		{"Requester hello stripped of the negotiation fields", func(hello *HelloPayload) {
+			hello.Version, hello.Suites, hello.KemEncapsulationKey = 0, nil, nil
+		}},
+	} {
+		t.Log(tc.name)
+
+		var requesterTest requesterTestFunc = func(
+			t *testing.T,
+			stream p2pnetwork.Stream,
+			mh *mockedHandshake,
+		) {
+			defer ipfsutil.FullClose(stream)
+
+			err := Request(
+				stream,
+				mh.requester.accountID,
+				mh.responder.accountID.GetPublic(),
+			)
+			require.Equal(t, errcode.Codes(err), []errcode.ErrCode{errcode.ErrCode_ErrHandshakeResponderHello, errcode.ErrCode_ErrHandshakeDowngrade})
+		}
+
+		var responderTest responderTestFunc = func(
+			t *testing.T,
+			stream p2pnetwork.Stream,
+			mh *mockedHandshake,
+			wg *sync.WaitGroup,
+		) {
+			defer wg.Done()
+			defer ipfsutil.FullClose(stream)
+
+			hc := newTestHandshakeContext(stream, mh.responder.accountID, nil)
+			hc.reader = &tamperingReader{Reader: hc.reader, tamper: tc.tamper}
+
+			_, err := hc.respond(context.TODO(), zap.NewNop())
+			require.Error(t, err)
+		}
+
+		runHandshakeTest(t, requesterTest, responderTest)
+	}
+
+	t.Log("Requester hello tampered, responder detects it")
+	{
+		var requesterTest requesterTestFunc = func(
+			t *testing.T,
+			stream p2pnetwork.Stream,
+			mh *mockedHandshake,
+		) {
+			defer ipfsutil.FullClose(stream)
+
+			hc := newTestHandshakeContext(
+				stream,
+				mh.requester.accountID,
+				mh.responder.accountID.GetPublic(),
+			)
+
+			err := hc.sendRequesterHello()
+			require.NoError(t, err, "send RequesterHello failed")
+
+			// Accept the classic suite selected by the responder
+			hello, err := hc.receivePeerEphemeralPubKey()
+			require.NoError(t, err, "receive ResponderHello failed")
+			require.Equal(t, HandshakeSuite_SuiteX25519, hello.SelectedSuite)
+			hc.peerSuites = hello.Suites
+			box.Precompute(hc.sharedEphemeral, hc.peerEphemeral, hc.ownEphemeral)
+
+			err = hc.sendRequesterAuthenticate()
+			require.NoError(t, err, "send RequesterAuthenticate failed")
+		}
+
+		var responderTest responderTestFunc = func(
+			t *testing.T,
+			stream p2pnetwork.Stream,
+			mh *mockedHandshake,
+			wg *sync.WaitGroup,
+		) {
+			defer wg.Done()
+			defer ipfsutil.FullClose(stream)
+
+			hc := newTestHandshakeContext(stream, mh.responder.accountID, nil)
+			hc.reader = &tamperingReader{Reader: hc.reader, tamper: func(hello *HelloPayload) {
+				hello.Suites = []HandshakeSuite{HandshakeSuite_SuiteX25519}
+				hello.KemEncapsulationKey = nil
+			}}
+
+			_, err := hc.respond(context.TODO(), zap.NewNop())
+			require.Equal(t, errcode.Codes(err), []errcode.ErrCode{errcode.ErrCode_ErrHandshakeRequesterAuthenticate, errcode.ErrCode_ErrHandshakeDowngrade})
+		}
+
+		runHandshakeTest(t, requesterTest, responderTest)
+	}
+}
+
+func TestStrongestCommonSuite(t *testing.T) {
+	x25519, hybrid := HandshakeSuite_SuiteX25519, HandshakeSuite_SuiteX25519MLKEM768
+
+	require.Equal(t, hybrid, strongestCommonSuite([]HandshakeSuite{x25519, hybrid}, []HandshakeSuite{hybrid, x25519}))
+	require.Equal(t, x25519, strongestCommonSuite([]HandshakeSuite{x25519, hybrid}, []HandshakeSuite{x25519}))
+	require.Equal(t, x25519, strongestCommonSuite([]HandshakeSuite{x25519}, []HandshakeSuite{x25519, hybrid}))
+	require.Equal(t, HandshakeSuite_SuiteUndefined, strongestCommonSuite([]HandshakeSuite{x25519}, []HandshakeSuite{hybrid}))
+	require.Equal(t, HandshakeSuite_SuiteUndefined, strongestCommonSuite([]HandshakeSuite{x25519}, nil))
+}
+
+func TestHandshakeResumption(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	mh := newMockedHandshake(t, ctx)
+	defer mh.close(t)
+
+	requesterStore, responderStore := newMemResumptionStore(), newMemResumptionStore()
+
+	runResumption := func(expectedResumed bool) {
+		var requesterTest requesterTestFunc = func(
+			t *testing.T,
+			stream p2pnetwork.Stream,
+			mh *mockedHandshake,
+		) {
+			defer ipfsutil.FullClose(stream)
+
+			reader := protoio.NewDelimitedReader(stream, 2048)
+			writer := protoio.NewDelimitedWriter(stream)
+
+			resumed, err := RequestWithResumption(ctx, zap.NewNop(), reader, writer, mh.requester.accountID, mh.responder.accountID.GetPublic(), requesterStore)
+			require.NoError(t, err, "handshake request failed")
+			require.Equal(t, expectedResumed, resumed)
+		}
+
+		var responderTest responderTestFunc = func(
+			t *testing.T,
+			stream p2pnetwork.Stream,
+			mh *mockedHandshake,
+			wg *sync.WaitGroup,
+		) {
+			defer wg.Done()
+			defer ipfsutil.FullClose(stream)
+
+			reader := protoio.NewDelimitedReader(stream, 2048)
+			writer := protoio.NewDelimitedWriter(stream)
+
+			peerAccountID, resumed, err := ResponseWithResumption(ctx, zap.NewNop(), reader, writer, mh.responder.accountID, responderStore)
+			require.NoError(t, err, "handshake response failed")
+			require.Equal(t, expectedResumed, resumed)
+			require.True(t, peerAccountID.Equals(mh.requester.accountID.GetPublic()))
+		}
+
+		runHandshakeTestWithPeers(t, ctx, mh, requesterTest, responderTest)
+	}
+
+	// sharedSecret checks that both peers share the same resumption secret
+	sharedSecret := func() []byte {
+		id, secret, err := requesterStore.GetContactResumptionSecret(ctx, mh.responder.accountID.GetPublic())
+		require.NoError(t, err)
+
+		contactPK, responderSecret, err := responderStore.GetContactResumptionSecretByID(ctx, id)
+		require.NoError(t, err)
+		require.True(t, contactPK.Equals(mh.requester.accountID.GetPublic()))
+		require.Equal(t, secret, responderSecret)
+
+		return secret
+	}
+
+	t.Log("Full handshake derives a resumption secret")
+	runResumption(false)
+	first := sharedSecret()
+
+	t.Log("Next handshake is resumed and rotates the secret")
+	runResumption(true)
+	second := sharedSecret()
+	require.NotEqual(t, first, second)
+
+	t.Log("Responder without the secret falls back to a full handshake")
+	responderStore = newMemResumptionStore()
+	runResumption(false)
+	require.NotEqual(t, second, sharedSecret())
+}
+
 func TestInvalidRequesterHello(t *testing.T) {
 	testutil.FilterSpeed(t, testutil.Slow)
 
@@ -12,7 +12,9 @@ import (
 	p2ppeer "github.com/libp2p/go-libp2p/core/peer"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 
+	"berty.tech/weshnet/v2/pkg/errcode"
 	"berty.tech/weshnet/v2/pkg/ipfsutil"
 	"berty.tech/weshnet/v2/pkg/protoio"
 	"berty.tech/weshnet/v2/pkg/tinder"
@@ -103,14 +105,20 @@ func newTestHandshakeContext(stream p2pnetwork.Stream, ownAccountID p2pcrypto.Pr
 }
 
 func runHandshakeTest(t *testing.T, requesterTest requesterTestFunc, responderTest responderTestFunc) {
-	var wg sync.WaitGroup
-
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	mh := newMockedHandshake(t, ctx)
 	defer mh.close(t)
 
+	runHandshakeTestWithPeers(t, ctx, mh, requesterTest, responderTest)
+}
+
+// runHandshakeTestWithPeers runs a handshake between the already mocked
+// peers, allowing several handshakes between the same accounts
+func runHandshakeTestWithPeers(t *testing.T, ctx context.Context, mh *mockedHandshake, requesterTest requesterTestFunc, responderTest responderTestFunc) {
+	var wg sync.WaitGroup
+
 	mh.responder.coreAPI.MockNode().PeerHost.SetStreamHandler(
 		testProtocolID,
 		func(stream p2pnetwork.Stream) {
@@ -129,3 +137,76 @@ func runHandshakeTest(t *testing.T, requesterTest requesterTestFunc, responderTe
 
 	wg.Wait()
 }
+
+// tamperingReader alters the hello messages read, as an active attacker would
+type tamperingReader struct {
+	protoio.Reader
+	tamper func(hello *HelloPayload)
+}
+
+func (r *tamperingReader) ReadMsg(msg proto.Message) error {
+	if err := r.Reader.ReadMsg(msg); err != nil {
+		return err
+	}
+
+	if hello, ok := msg.(*HelloPayload); ok {
+		r.tamper(hello)
+	}
+
+	return nil
+}
+
+// memResumptionStore is a ResumptionStore keeping the secrets in memory
+type memResumptionStore struct {
+	mu      sync.Mutex
+	secrets map[string]memResumptionSecret
+}
+
+type memResumptionSecret struct {
+	contactPK p2pcrypto.PubKey
+	id        []byte
+	secret    []byte
+}
+
+func newMemResumptionStore() *memResumptionStore {
+	return &memResumptionStore{secrets: make(map[string]memResumptionSecret)}
+}
+
+func (m *memResumptionStore) GetContactResumptionSecret(_ context.Context, contactPK p2pcrypto.PubKey) ([]byte, []byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.secrets {
+		if s.contactPK.Equals(contactPK) {
+			return s.id, s.secret, nil
+		}
+	}
+
+	return nil, nil, errcode.ErrCode_ErrMissingMapKey
+}
+
+func (m *memResumptionStore) GetContactResumptionSecretByID(_ context.Context, id []byte) (p2pcrypto.PubKey, []byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.secrets[string(id)]
+	if !ok {
+		return nil, nil, errcode.ErrCode_ErrMissingMapKey
+	}
+
+	return s.contactPK, s.secret, nil
+}
+
+func (m *memResumptionStore) PutContactResumptionSecret(_ context.Context, contactPK p2pcrypto.PubKey, id []byte, secret []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, s := range m.secrets {
+		if s.contactPK.Equals(contactPK) {
+			delete(m.secrets, key)
+		}
+	}
+	m.secrets[string(id)] = memResumptionSecret{contactPK: contactPK, id: id, secret: secret}
+
+	return nil
+}
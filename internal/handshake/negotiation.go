@@ -0,0 +1,149 @@
+package handshake
+
+import (
+	"context"
+	"crypto/mlkem"
+	"errors"
+	"slices"
+
+	"go.uber.org/zap"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/tyber"
+)
+
+// HandshakeVersion is the version of the handshake implemented by this
+// package, peers predating the version negotiation are seen as version 0
+const HandshakeVersion = 1
+
+// ownSuites returns the suites supported by this side of the handshake, from
+// the weakest to the strongest
+func (hc *handshakeContext) ownSuites() []HandshakeSuite {
+	if hc.classicOnly {
+		return []HandshakeSuite{HandshakeSuite_SuiteX25519}
+	}
+
+	return []HandshakeSuite{HandshakeSuite_SuiteX25519, HandshakeSuite_SuiteX25519MLKEM768}
+}
+
+// isHybrid returns true if the hybrid suite has been negotiated
+func (hc *handshakeContext) isHybrid() bool {
+	return hc.suite == HandshakeSuite_SuiteX25519MLKEM768
+}
+
+// setPeerHello records the version and the suites of the peer, the suites of
+// a legacy peer are deduced from the KEM fields of its hello
+func (hc *handshakeContext) setPeerHello(hello *HelloPayload) {
+	// A legacy peer doesn't know about the negotiation fields
+	if hc.legacy {
+		hello.Version, hello.Suites, hello.SelectedSuite = 0, nil, HandshakeSuite_SuiteUndefined
+	}
+
+	hc.peerVersion = hello.Version
+	if hello.Version > 0 {
+		hc.peerSuites = hello.Suites
+		return
+	}
+
+	hc.peerSuites = []HandshakeSuite{HandshakeSuite_SuiteX25519}
+	if len(hello.KemEncapsulationKey) > 0 || len(hello.KemCiphertext) > 0 {
+		hc.peerSuites = append(hc.peerSuites, HandshakeSuite_SuiteX25519MLKEM768)
+	}
+}
+
+// strongestCommonSuite returns the strongest suite supported by both peers
+func strongestCommonSuite(own, peer []HandshakeSuite) HandshakeSuite {
+	selected := HandshakeSuite_SuiteUndefined
+	for _, suite := range own {
+		if suite > selected && slices.Contains(peer, suite) {
+			selected = suite
+		}
+	}
+
+	return selected
+}
+
+// Responder: selects the strongest common suite and keeps the encapsulation
+// key of the requester if needed
+func (hc *handshakeContext) selectSuite(hello *HelloPayload) error {
+	hc.setPeerHello(hello)
+
+	hc.suite = strongestCommonSuite(hc.ownSuites(), hc.peerSuites)
+	switch hc.suite {
+	case HandshakeSuite_SuiteUndefined:
+		return errcode.ErrCode_ErrHandshakeNoCommonSuite
+
+	case HandshakeSuite_SuiteX25519MLKEM768:
+		if len(hello.KemEncapsulationKey) == 0 {
+			return errcode.ErrCode_ErrHandshakeDowngrade.Wrap(errors.New("hybrid suite advertised without encapsulation key"))
+		}
+
+		var err error
+		hc.peerKEM, err = mlkem.NewEncapsulationKey768(hello.KemEncapsulationKey)
+		if err != nil {
+			return errcode.ErrCode_ErrDeserialization.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// Requester: checks the suite selected by the responder and decapsulates the
+// KEM secret if needed
+func (hc *handshakeContext) checkSelectedSuite(hello *HelloPayload) error {
+	hc.setPeerHello(hello)
+
+	expected := strongestCommonSuite(hc.ownSuites(), hc.peerSuites)
+	if expected == HandshakeSuite_SuiteUndefined {
+		return errcode.ErrCode_ErrHandshakeNoCommonSuite
+	}
+
+	// A legacy responder doesn't select anything, the suite is deduced from
+	// its hello
+	if hello.Version > 0 && hello.SelectedSuite != expected {
+		return errcode.ErrCode_ErrHandshakeDowngrade.Wrap(errors.New("responder didn't select the strongest common suite"))
+	}
+	hc.suite = expected
+
+	if hc.suite == HandshakeSuite_SuiteX25519MLKEM768 {
+		if len(hello.KemCiphertext) == 0 {
+			return errcode.ErrCode_ErrHandshakeDowngrade.Wrap(errors.New("hybrid suite selected without ciphertext"))
+		}
+
+		var err error
+		hc.sharedKEM, err = hc.ownKEM.Decapsulate(hello.KemCiphertext)
+		if err != nil {
+			return errcode.ErrCode_ErrCryptoDecrypt.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// checkAuthenticatedSuites compares the suites repeated by the peer in its
+// boxed payload with the ones received in its hello, a mismatch means the
+// hello has been tampered with. Legacy peers don't repeat their suites.
+func (hc *handshakeContext) checkAuthenticatedSuites(suites []HandshakeSuite) error {
+	if hc.legacy || len(suites) == 0 || slices.Equal(suites, hc.peerSuites) {
+		return nil
+	}
+
+	return errcode.ErrCode_ErrHandshakeDowngrade.Wrap(errors.New("peer suites don't match its hello"))
+}
+
+// negotiationFields returns the suites to send in the hello and the boxed
+// payloads, legacy peers don't send them
+func (hc *handshakeContext) negotiationFields() (uint32, []HandshakeSuite) {
+	if hc.legacy {
+		return 0, nil
+	}
+
+	return HandshakeVersion, hc.ownSuites()
+}
+
+// traceDowngrade reports the rejected downgrade attempts to tyber
+func (hc *handshakeContext) traceDowngrade(ctx context.Context, logger *zap.Logger, err error) {
+	if errcode.Has(err, errcode.ErrCode_ErrHandshakeDowngrade) {
+		tyber.LogError(ctx, logger, "Handshake downgrade attempt rejected", err, hc.toTyberStepMutator())
+	}
+}
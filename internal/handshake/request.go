@@ -2,7 +2,9 @@ package handshake
 
 import (
 	"context"
+	"crypto/mlkem"
 	"errors"
+	"slices"
 
 	p2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
 	"go.uber.org/zap"
@@ -25,6 +27,32 @@ func RequestUsingReaderWriter(ctx context.Context, logger *zap.Logger, reader pr
 		sharedEphemeral: &[cryptoutil.KeySize]byte{},
 	}
 
+	return hc.request(ctx, logger)
+}
+
+// RequestWithResumption init a handshake with the responder like
+// RequestUsingReaderWriter, resuming the previous session with it in a single
+// round trip when possible, it returns true if the session has been resumed
+func RequestWithResumption(ctx context.Context, logger *zap.Logger, reader protoio.Reader, writer protoio.Writer, ownAccountID p2pcrypto.PrivKey, peerAccountID p2pcrypto.PubKey, store ResumptionStore) (bool, error) {
+	hc := &handshakeContext{
+		reader:          reader,
+		writer:          writer,
+		ownAccountID:    ownAccountID,
+		peerAccountID:   peerAccountID,
+		sharedEphemeral: &[cryptoutil.KeySize]byte{},
+		resumption:      store,
+	}
+
+	err := hc.request(ctx, logger)
+
+	return hc.resumed, err
+}
+
+func (hc *handshakeContext) request(ctx context.Context, logger *zap.Logger) (err error) {
+	defer func() { hc.traceDowngrade(ctx, logger, err) }()
+
+	hc.loadResumptionSecret(ctx, logger)
+
 	// Handshake steps on requester side (see comments below)
 	if err := hc.sendRequesterHello(); err != nil {
 		return errcode.ErrCode_ErrHandshakeRequesterHello.Wrap(err)
@@ -34,6 +62,13 @@ func RequestUsingReaderWriter(ctx context.Context, logger *zap.Logger, reader pr
 		return errcode.ErrCode_ErrHandshakeResponderHello.Wrap(err)
 	}
 	tyber.LogStep(ctx, logger, "Received hello", hc.toTyberStepMutator())
+
+	// The responder resumed the session, the handshake ends here
+	if hc.resumed {
+		hc.storeResumptionSecret(ctx, logger)
+		return nil
+	}
+
 	if err := hc.sendRequesterAuthenticate(); err != nil {
 		return errcode.ErrCode_ErrHandshakeRequesterAuthenticate.Wrap(err)
 	}
@@ -47,12 +82,37 @@ func RequestUsingReaderWriter(ctx context.Context, logger *zap.Logger, reader pr
 	}
 	tyber.LogStep(ctx, logger, "Sent acknowledge", hc.toTyberStepMutator())
 
+	hc.storeResumptionSecret(ctx, logger)
+
 	return nil
 }
 
 // 1st step - Requester sends: a
 func (hc *handshakeContext) sendRequesterHello() error {
-	if err := hc.generateOwnEphemeralAndSendPubKey(); err != nil {
+	var (
+		hello HelloPayload
+		err   error
+	)
+
+	// Advertise own suites, along with an encapsulation key for the hybrid
+	// suite
+	hello.Version, hello.Suites = hc.negotiationFields()
+	if slices.Contains(hc.ownSuites(), HandshakeSuite_SuiteX25519MLKEM768) {
+		hc.ownKEM, err = mlkem.GenerateKey768()
+		if err != nil {
+			return errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(err)
+		}
+		hello.KemEncapsulationKey = hc.ownKEM.EncapsulationKey().Bytes()
+	}
+
+	if err := hc.generateOwnEphemeral(); err != nil {
+		return errcode.ErrCode_ErrHandshakeOwnEphemeralKeyGenSend.Wrap(err)
+	}
+
+	// Prove the knowledge of the resumption secret shared with the responder
+	hc.setRequesterResumptionProof(&hello)
+
+	if err := hc.sendOwnEphemeralPubKey(&hello); err != nil {
 		return errcode.ErrCode_ErrHandshakeOwnEphemeralKeyGenSend.Wrap(err)
 	}
 
@@ -61,10 +121,22 @@ func (hc *handshakeContext) sendRequesterHello() error {
 
 // 2nd step - Requester receives: b
 func (hc *handshakeContext) receiveResponderHello() error {
-	if err := hc.receivePeerEphemeralPubKey(); err != nil {
+	hello, err := hc.receivePeerEphemeralPubKey()
+	if err != nil {
 		return errcode.ErrCode_ErrHandshakePeerEphemeralKeyRecv.Wrap(err)
 	}
 
+	// Check the suite selected by the responder
+	if err := hc.checkSelectedSuite(hello); err != nil {
+		return err
+	}
+
+	// Check whether the responder resumed the session
+	hc.peerResumptionProof = hello.ResumptionProof
+	if err := hc.checkResumption(); err != nil {
+		return err
+	}
+
 	// Compute shared key from Ephemeral keys
 	box.Precompute(hc.sharedEphemeral, hc.peerEphemeral, hc.ownEphemeral)
 
@@ -88,6 +160,7 @@ func (hc *handshakeContext) sendRequesterAuthenticate() error {
 	if err != nil {
 		return errcode.ErrCode_ErrCryptoSignature.Wrap(err)
 	}
+	_, request.RequesterSuites = hc.negotiationFields()
 	requestBytes, err := proto.Marshal(&request)
 	if err != nil {
 		return errcode.ErrCode_ErrSerialization.Wrap(err)
@@ -162,7 +235,8 @@ func (hc *handshakeContext) receiveResponderAccept() error {
 		return errcode.ErrCode_ErrCryptoSignatureVerification
 	}
 
-	return nil
+	// Check that the responder hello hasn't been tampered with
+	return hc.checkAuthenticatedSuites(response.ResponderSuites)
 }
 
 // 5th step - Requester sends: ok
@@ -24,15 +24,47 @@ func ResponseUsingReaderWriter(ctx context.Context, logger *zap.Logger, reader p
 		sharedEphemeral: &[cryptoutil.KeySize]byte{},
 	}
 
+	return hc.respond(ctx, logger)
+}
+
+// ResponseWithResumption handle the handshake inited by the requester like
+// ResponseUsingReaderWriter, resuming the previous session with it in a
+// single round trip when possible, it returns true if the session has been
+// resumed
+func ResponseWithResumption(ctx context.Context, logger *zap.Logger, reader protoio.Reader, writer protoio.Writer, ownAccountID p2pcrypto.PrivKey, store ResumptionStore) (p2pcrypto.PubKey, bool, error) {
+	hc := &handshakeContext{
+		reader:          reader,
+		writer:          writer,
+		ownAccountID:    ownAccountID,
+		sharedEphemeral: &[cryptoutil.KeySize]byte{},
+		resumption:      store,
+	}
+
+	peerAccountID, err := hc.respond(ctx, logger)
+
+	return peerAccountID, hc.resumed, err
+}
+
+func (hc *handshakeContext) respond(ctx context.Context, logger *zap.Logger) (_ p2pcrypto.PubKey, err error) {
+	defer func() { hc.traceDowngrade(ctx, logger, err) }()
+
 	// Handshake steps on responder side (see comments below)
 	if err := hc.receiveRequesterHello(); err != nil {
 		return nil, errcode.ErrCode_ErrHandshakeRequesterHello.Wrap(err)
 	}
 	tyber.LogStep(ctx, logger, "Received hello", hc.toTyberStepMutator(), tyber.ForceReopen)
+	hc.acceptResumption(ctx, logger)
 	if err := hc.sendResponderHello(); err != nil {
 		return nil, errcode.ErrCode_ErrHandshakeResponderHello.Wrap(err)
 	}
 	tyber.LogStep(ctx, logger, "Sent hello", hc.toTyberStepMutator(), tyber.ForceReopen)
+
+	// The session has been resumed, the handshake ends here
+	if hc.resumed {
+		hc.storeResumptionSecret(ctx, logger)
+		return hc.peerAccountID, nil
+	}
+
 	if err := hc.receiveRequesterAuthenticate(); err != nil {
 		return nil, errcode.ErrCode_ErrHandshakeRequesterAuthenticate.Wrap(err)
 	}
@@ -46,21 +78,48 @@ func ResponseUsingReaderWriter(ctx context.Context, logger *zap.Logger, reader p
 	}
 	tyber.LogStep(ctx, logger, "Received acknowledge", hc.toTyberStepMutator(), tyber.ForceReopen)
 
+	hc.storeResumptionSecret(ctx, logger)
+
 	return hc.peerAccountID, nil
 }
 
 // 1st step - Responder receives: a
 func (hc *handshakeContext) receiveRequesterHello() error {
-	if err := hc.receivePeerEphemeralPubKey(); err != nil {
+	hello, err := hc.receivePeerEphemeralPubKey()
+	if err != nil {
 		return errcode.ErrCode_ErrHandshakePeerEphemeralKeyRecv.Wrap(err)
 	}
 
-	return nil
+	// Keep the resumption proof of the requester, checked with the store
+	// before answering
+	hc.peerResumptionID, hc.peerResumptionProof = hello.ResumptionId, hello.ResumptionProof
+
+	// Select the strongest suite supported by both peers
+	return hc.selectSuite(hello)
 }
 
 // 2nd step - Responder sends: b
 func (hc *handshakeContext) sendResponderHello() error {
-	if err := hc.generateOwnEphemeralAndSendPubKey(); err != nil {
+	var hello HelloPayload
+
+	// Advertise own suites and the selected one, along with a ciphertext for
+	// the hybrid suite
+	hello.Version, hello.Suites = hc.negotiationFields()
+	if hello.Version > 0 {
+		hello.SelectedSuite = hc.suite
+	}
+	if hc.isHybrid() {
+		hc.sharedKEM, hello.KemCiphertext = hc.peerKEM.Encapsulate()
+	}
+
+	if err := hc.generateOwnEphemeral(); err != nil {
+		return errcode.ErrCode_ErrHandshakeOwnEphemeralKeyGenSend.Wrap(err)
+	}
+
+	// Prove the knowledge of the resumption secret if the session is resumed
+	hc.setResponderResumptionProof(&hello)
+
+	if err := hc.sendOwnEphemeralPubKey(&hello); err != nil {
 		return errcode.ErrCode_ErrHandshakeOwnEphemeralKeyGenSend.Wrap(err)
 	}
 
@@ -120,7 +179,8 @@ func (hc *handshakeContext) receiveRequesterAuthenticate() error {
 		return errcode.ErrCode_ErrCryptoSignatureVerification
 	}
 
-	return nil
+	// Check that the requester hello hasn't been tampered with
+	return hc.checkAuthenticatedSuites(request.RequesterSuites)
 }
 
 // 4th step - Responder sends: box[a.b|A.B](sig[B](a.b))
@@ -136,6 +196,7 @@ func (hc *handshakeContext) sendResponderAccept() error {
 	if err != nil {
 		return errcode.ErrCode_ErrCryptoSignature.Wrap(err)
 	}
+	_, response.ResponderSuites = hc.negotiationFields()
 	responseBytes, err := proto.Marshal(&response)
 	if err != nil {
 		return errcode.ErrCode_ErrSerialization.Wrap(err)
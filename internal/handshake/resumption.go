@@ -0,0 +1,157 @@
+package handshake
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+
+	p2pcrypto "github.com/libp2p/go-libp2p/core/crypto"
+	"go.uber.org/zap"
+
+	"berty.tech/weshnet/v2/pkg/cryptoutil"
+	"berty.tech/weshnet/v2/pkg/errcode"
+)
+
+// Labels used to derive the resumption values
+const (
+	resumptionSecretLabel    = "weshnet/handshake/resumption-secret"
+	resumptionIDLabel        = "weshnet/handshake/resumption-id"
+	resumptionRequesterLabel = "weshnet/handshake/resumption-requester"
+	resumptionResponderLabel = "weshnet/handshake/resumption-responder"
+	resumptionNextLabel      = "weshnet/handshake/resumption-next"
+)
+
+// ResumptionStore keeps the resumption secrets shared with the contacts after
+// a handshake, secretstore.SecretStore implements it
+type ResumptionStore interface {
+	// GetContactResumptionSecret returns the ID and the resumption secret
+	// shared with the given contact
+	GetContactResumptionSecret(ctx context.Context, contactPublicKey p2pcrypto.PubKey) (id []byte, secret []byte, err error)
+
+	// GetContactResumptionSecretByID returns the contact and the resumption
+	// secret matching the given ID
+	GetContactResumptionSecretByID(ctx context.Context, id []byte) (contactPublicKey p2pcrypto.PubKey, secret []byte, err error)
+
+	// PutContactResumptionSecret replaces the resumption secret shared with
+	// the given contact
+	PutContactResumptionSecret(ctx context.Context, contactPublicKey p2pcrypto.PubKey, id []byte, secret []byte) error
+}
+
+// resumptionID returns the public identifier of a resumption secret
+func resumptionID(secret []byte) []byte {
+	return cryptoutil.ConcatAndHashSha256([]byte(resumptionIDLabel), secret)[:]
+}
+
+// resumptionMAC authenticates the given values with a resumption secret
+func resumptionMAC(secret []byte, label string, values ...[]byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(label))
+	for _, value := range values {
+		mac.Write(value)
+	}
+
+	return mac.Sum(nil)
+}
+
+// Requester: loads the resumption secret shared with the responder, if any
+func (hc *handshakeContext) loadResumptionSecret(ctx context.Context, logger *zap.Logger) {
+	if hc.resumption == nil {
+		return
+	}
+
+	id, secret, err := hc.resumption.GetContactResumptionSecret(ctx, hc.peerAccountID)
+	if err != nil {
+		logger.Debug("no resumption secret for contact, performing a full handshake", zap.Error(err))
+		return
+	}
+
+	hc.resumptionID, hc.resumptionSecret = id, secret
+}
+
+// Requester: proves the knowledge of the resumption secret, bound to own
+// ephemeral key
+func (hc *handshakeContext) setRequesterResumptionProof(hello *HelloPayload) {
+	if hc.resumptionSecret == nil {
+		return
+	}
+
+	hello.ResumptionId = hc.resumptionID
+	hello.ResumptionProof = resumptionMAC(hc.resumptionSecret, resumptionRequesterLabel, hc.ownEphemeralPub[:])
+}
+
+// Responder: resumes the session if the requester proved the knowledge of a
+// known resumption secret, the full handshake is performed otherwise
+func (hc *handshakeContext) acceptResumption(ctx context.Context, logger *zap.Logger) {
+	if hc.resumption == nil || len(hc.peerResumptionID) == 0 {
+		return
+	}
+
+	contactPK, secret, err := hc.resumption.GetContactResumptionSecretByID(ctx, hc.peerResumptionID)
+	if err != nil {
+		logger.Debug("unknown resumption secret, performing a full handshake", zap.Error(err))
+		return
+	}
+
+	expected := resumptionMAC(secret, resumptionRequesterLabel, hc.peerEphemeral[:])
+	if !hmac.Equal(expected, hc.peerResumptionProof) {
+		logger.Warn("invalid resumption proof, performing a full handshake")
+		return
+	}
+
+	hc.peerAccountID = contactPK
+	hc.resumptionSecret = secret
+	hc.resumed = true
+}
+
+// Responder: proves the knowledge of the resumption secret, bound to both
+// ephemeral keys
+func (hc *handshakeContext) setResponderResumptionProof(hello *HelloPayload) {
+	if !hc.resumed {
+		return
+	}
+
+	hello.ResumptionProof = resumptionMAC(hc.resumptionSecret, resumptionResponderLabel, hc.peerEphemeral[:], hc.ownEphemeralPub[:])
+}
+
+// Requester: checks whether the responder resumed the session
+func (hc *handshakeContext) checkResumption() error {
+	if hc.resumptionSecret == nil || len(hc.peerResumptionProof) == 0 {
+		return nil
+	}
+
+	expected := resumptionMAC(hc.resumptionSecret, resumptionResponderLabel, hc.ownEphemeralPub[:], hc.peerEphemeral[:])
+	if !hmac.Equal(expected, hc.peerResumptionProof) {
+		return errcode.ErrCode_ErrCryptoSignatureVerification.Wrap(errors.New("invalid resumption proof"))
+	}
+
+	hc.resumed = true
+
+	return nil
+}
+
+// storeResumptionSecret derives the secret used to resume the next handshake
+// and stores it, a resumed session derives it from the previous secret and
+// the fresh ephemeral keys, a full handshake from its box keys
+func (hc *handshakeContext) storeResumptionSecret(ctx context.Context, logger *zap.Logger) {
+	if hc.resumption == nil {
+		return
+	}
+
+	var secret []byte
+	if hc.resumed {
+		secret = resumptionMAC(hc.resumptionSecret, resumptionNextLabel, hc.sharedEphemeral[:], hc.sharedKEM)
+	} else {
+		boxKey, err := hc.computeResponderAcceptBoxKey()
+		if err != nil {
+			logger.Warn("unable to derive resumption secret", zap.Error(err))
+			return
+		}
+
+		secret = cryptoutil.ConcatAndHashSha256([]byte(resumptionSecretLabel), boxKey[:])[:]
+	}
+
+	if err := hc.resumption.PutContactResumptionSecret(ctx, hc.peerAccountID, resumptionID(secret), secret); err != nil {
+		logger.Warn("unable to store resumption secret", zap.Error(err))
+	}
+}
@@ -1,22 +1,39 @@
 package weshnet
 
 import (
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/crypto"
 	peer "github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/nacl/secretbox"
 	"google.golang.org/protobuf/proto"
 
 	"berty.tech/go-ipfs-log/enc"
 	"berty.tech/go-ipfs-log/entry"
 	"berty.tech/go-orbit-db/iface"
+	"berty.tech/weshnet/v2/pkg/cryptoutil"
 	"berty.tech/weshnet/v2/pkg/protocoltypes"
 	"berty.tech/weshnet/v2/pkg/rendezvous"
 	"berty.tech/weshnet/v2/pkg/secretstore"
 )
 
+// maxDeferredHeads is the maximum number of heads deferred for a paused store,
+// the oldest ones are dropped and will be fetched with the next exchange
+const maxDeferredHeads = 64
+
+// maxPendingSenders is the maximum number of direct channel payloads waiting
+// to be unmarshaled for which the sender is kept
+const maxPendingSenders = 256
+
+var errReplicationPaused = errors.New("replication paused")
+
 type PeerDeviceGroup struct {
 	Group    *protocoltypes.Group
 	DevicePK crypto.PubKey
@@ -31,8 +48,25 @@ type OrbitDBMessageMarshaler struct {
 	selfid       peer.ID
 	secretStore  secretstore.SecretStore
 
+	// heads received for the paused stores, loaded on resume
+	deferredHeads map[string][]*entry.Entry
+	// number of PauseTopic calls not resumed yet, by topic
+	pauses map[string]int
+
 	// in Replication Mode DeviceKey should not be sent
 	useReplicationMode bool
+
+	// connected peers of a topic which have only sent their device in clear,
+	// the device is also sent in clear while one of them is known, entries
+	// are dropped on disconnection or when the peer leaves the topic
+	legacyDevicePeers map[string]map[peer.ID]struct{}
+
+	logger *zap.Logger
+
+	// authenticated senders of the direct channel payloads not unmarshaled
+	// yet, by payload hash
+	senders   map[[sha256.Size]byte]peer.ID
+	muSenders sync.Mutex
 }
 
 func NewOrbitDBMessageMarshaler(selfid peer.ID, secretStore secretstore.SecretStore, rp *rendezvous.RotationInterval, useReplicationMode bool) *OrbitDBMessageMarshaler {
@@ -41,12 +75,27 @@ func NewOrbitDBMessageMarshaler(selfid peer.ID, secretStore secretstore.SecretSt
 		sharedKeys:         make(map[string]enc.SharedKey),
 		deviceCaches:       make(map[peer.ID]*PeerDeviceGroup),
 		topicGroup:         make(map[string]*protocoltypes.Group),
+		deferredHeads:      make(map[string][]*entry.Entry),
+		pauses:             make(map[string]int),
+		senders:            make(map[[sha256.Size]byte]peer.ID),
+		legacyDevicePeers:  make(map[string]map[peer.ID]struct{}),
+		logger:             zap.NewNop(),
 		rp:                 rp,
 		secretStore:        secretStore,
 		useReplicationMode: useReplicationMode,
 	}
 }
 
+func (m *OrbitDBMessageMarshaler) SetLogger(logger *zap.Logger) {
+	if logger == nil {
+		return
+	}
+
+	m.muMarshall.Lock()
+	m.logger = logger
+	m.muMarshall.Unlock()
+}
+
 func (m *OrbitDBMessageMarshaler) RegisterSharedKeyForTopic(topic string, sk enc.SharedKey) {
 	m.muMarshall.Lock()
 	m.sharedKeys[topic] = sk
@@ -66,6 +115,115 @@ func (m *OrbitDBMessageMarshaler) GetDevicePKForPeerID(id peer.ID) (pdg *PeerDev
 	return
 }
 
+// PauseTopic defers the heads received for the store of the given topic
+// until ResumeTopic is called, pauses are counted and the topic is resumed
+// once every PauseTopic has been matched with a ResumeTopic
+func (m *OrbitDBMessageMarshaler) PauseTopic(topic string) {
+	m.muMarshall.Lock()
+	m.pauses[topic]++
+	if _, ok := m.deferredHeads[topic]; !ok {
+		m.deferredHeads[topic] = []*entry.Entry{}
+	}
+	m.muMarshall.Unlock()
+}
+
+// ResumeTopic releases a pause of the given topic, once the last one is
+// released the heads received while paused are returned
+func (m *OrbitDBMessageMarshaler) ResumeTopic(topic string) []*entry.Entry {
+	m.muMarshall.Lock()
+	defer m.muMarshall.Unlock()
+
+	if m.pauses[topic] > 1 {
+		m.pauses[topic]--
+		return nil
+	}
+
+	heads := m.deferredHeads[topic]
+	delete(m.deferredHeads, topic)
+	delete(m.pauses, topic)
+
+	return heads
+}
+
+// ClearTopic releases every pause of the given topic and drops the heads
+// deferred
+func (m *OrbitDBMessageMarshaler) ClearTopic(topic string) {
+	m.muMarshall.Lock()
+	delete(m.deferredHeads, topic)
+	delete(m.pauses, topic)
+	delete(m.legacyDevicePeers, topic)
+	m.muMarshall.Unlock()
+}
+
+// RemovePeer forgets the capabilities of a disconnected peer
+func (m *OrbitDBMessageMarshaler) RemovePeer(pid peer.ID) {
+	m.muMarshall.Lock()
+	defer m.muMarshall.Unlock()
+
+	for topic := range m.legacyDevicePeers {
+		m.removeLegacyDevicePeer(topic, pid)
+	}
+}
+
+// RemoveTopicPeer forgets the capabilities of a peer which has left the
+// pubsub topic, if the peer is ourselves the whole topic is forgotten
+func (m *OrbitDBMessageMarshaler) RemoveTopicPeer(topic string, pid peer.ID) {
+	m.muMarshall.Lock()
+	defer m.muMarshall.Unlock()
+
+	if pid == m.selfid {
+		delete(m.legacyDevicePeers, topic)
+		return
+	}
+
+	m.removeLegacyDevicePeer(topic, pid)
+}
+
+// GetGroupForTopic returns the group of the store of the given topic
+func (m *OrbitDBMessageMarshaler) GetGroupForTopic(topic string) (group *protocoltypes.Group, ok bool) {
+	m.muMarshall.RLock()
+	group, ok = m.topicGroup[topic]
+	m.muMarshall.RUnlock()
+	return
+}
+
+// DeferHeads keeps the given heads if the topic is paused, it returns false
+// if they must be loaded now
+func (m *OrbitDBMessageMarshaler) DeferHeads(topic string, heads []*entry.Entry) bool {
+	m.muMarshall.Lock()
+	defer m.muMarshall.Unlock()
+
+	return m.deferHeads(topic, heads)
+}
+
+func (m *OrbitDBMessageMarshaler) deferHeads(topic string, heads []*entry.Entry) bool {
+	deferred, ok := m.deferredHeads[topic]
+	if !ok {
+		return false
+	}
+
+	for _, head := range heads {
+		known := false
+		for _, d := range deferred {
+			if d.Hash.Equals(head.Hash) {
+				known = true
+				break
+			}
+		}
+
+		if !known {
+			deferred = append(deferred, head)
+		}
+	}
+
+	if len(deferred) > maxDeferredHeads {
+		deferred = deferred[len(deferred)-maxDeferredHeads:]
+	}
+
+	m.deferredHeads[topic] = deferred
+	return true
+}
+
 func (m *OrbitDBMessageMarshaler) getSharedKeyFor(topic string) (sk enc.SharedKey, ok bool) {
 	sk, ok = m.sharedKeys[topic]
 	return
@@ -90,7 +248,7 @@ func (m *OrbitDBMessageMarshaler) Marshal(msg *iface.MessageExchangeHeads) ([]by
 		return nil, fmt.Errorf("unknown group for topic: %s", topic)
 	}
 
-	var ownPK []byte
+	var clearPK, sealedPK []byte
 
 	// in replication mode, it doesn't make sense to send DevicePK
 	if !m.useReplicationMode {
@@ -99,10 +257,21 @@ func (m *OrbitDBMessageMarshaler) Marshal(msg *iface.MessageExchangeHeads) ([]by
 			return nil, fmt.Errorf("unable to get own member device key for group: %w", err)
 		}
 
-		ownPK, err = ownDevice.Device().Raw()
+		ownPK, err := ownDevice.Device().Raw()
 		if err != nil {
 			return nil, fmt.Errorf("unable to get raw pk for device: %w", err)
 		}
+
+		// the box is sealed using the link key known by the replicas,
+		// the device is only disclosed to the members of the group
+		if sealedPK, err = sealDevicePK(group, ownPK); err != nil {
+			return nil, fmt.Errorf("unable to seal device pk: %w", err)
+		}
+
+		// older versions only read the device in clear
+		if len(m.legacyDevicePeers[topic]) > 0 {
+			clearPK = ownPK
+		}
 	}
 
 	// @TODO(gfanton): use protobuf for this ?
@@ -112,10 +281,12 @@ func (m *OrbitDBMessageMarshaler) Marshal(msg *iface.MessageExchangeHeads) ([]by
 	}
 
 	box := &protocoltypes.OrbitDBMessageHeads_Box{
-		Address:  msg.Address,
-		Heads:    heads,
-		DevicePk: ownPK,
-		PeerId:   pid,
+		Address:        msg.Address,
+		Heads:          heads,
+		PeerId:         pid,
+		DevicePk:       clearPK,
+		SentAt:         time.Now().UnixNano(),
+		SealedDevicePk: sealedPK,
 	}
 
 	sealedBox, err := m.sealBox(msg.Address, box)
@@ -136,6 +307,49 @@ func (m *OrbitDBMessageMarshaler) Marshal(msg *iface.MessageExchangeHeads) ([]by
 	return payload, nil
 }
 
+// DirectChannelFactory wraps the given factory so the marshaler knows the
+// authenticated peer which has sent each payload received on a direct channel
+func (m *OrbitDBMessageMarshaler) DirectChannelFactory(factory iface.DirectChannelFactory) iface.DirectChannelFactory {
+	return func(ctx context.Context, emitter iface.DirectChannelEmitter, opts *iface.DirectChannelOptions) (iface.DirectChannel, error) {
+		return factory(ctx, &senderDirectChannelEmitter{DirectChannelEmitter: emitter, marshaler: m}, opts)
+	}
+}
+
+func (m *OrbitDBMessageMarshaler) registerSender(payload []byte, sender peer.ID) {
+	m.muSenders.Lock()
+	defer m.muSenders.Unlock()
+
+	// payloads dropped before being unmarshaled should not accumulate
+	if len(m.senders) >= maxPendingSenders {
+		clear(m.senders)
+	}
+
+	m.senders[sha256.Sum256(payload)] = sender
+}
+
+func (m *OrbitDBMessageMarshaler) popSender(payload []byte) (peer.ID, bool) {
+	m.muSenders.Lock()
+	defer m.muSenders.Unlock()
+
+	key := sha256.Sum256(payload)
+	sender, ok := m.senders[key]
+	delete(m.senders, key)
+
+	return sender, ok
+}
+
+// senderDirectChannelEmitter registers the sender of the payloads before
+// emitting them
+type senderDirectChannelEmitter struct {
+	iface.DirectChannelEmitter
+	marshaler *OrbitDBMessageMarshaler
+}
+
+func (e *senderDirectChannelEmitter) Emit(evt *iface.EventPubSubPayload) error {
+	e.marshaler.registerSender(evt.Payload, evt.Peer)
+	return e.DirectChannelEmitter.Emit(evt)
+}
+
 func (m *OrbitDBMessageMarshaler) Unmarshal(payload []byte, msg *iface.MessageExchangeHeads) error {
 	m.muMarshall.Lock()
 	defer m.muMarshall.Unlock()
@@ -168,10 +382,20 @@ func (m *OrbitDBMessageMarshaler) Unmarshal(payload []byte, msg *iface.MessageEx
 	msg.Address = box.Address
 	msg.Heads = entries
 
-	if box.DevicePk == nil {
+	// use the sender clock to estimate our own clock skew, samples are only
+	// kept for the authenticated sender of the payload so a member can't
+	// forge several peers to control the estimation
+	sender, authenticated := m.popSender(payload)
+	if authenticated && box.SentAt != 0 {
+		m.rp.ClockSkew().AddSample(sender.String(), time.Unix(0, box.SentAt), time.Now())
+	}
+
+	group, ok := m.topicGroup[msg.Address]
+
+	if box.DevicePk == nil && box.SealedDevicePk == nil {
 		// @NOTE(gfanton): this is probably a message from a replication server
 		// which should not have a DevicePK
-		return nil
+		return m.checkPaused(msg)
 	}
 
 	pid, err := peer.IDFromBytes(box.PeerId)
@@ -179,22 +403,80 @@ func (m *OrbitDBMessageMarshaler) Unmarshal(payload []byte, msg *iface.MessageEx
 		return fmt.Errorf("unable to parse peer id: %w", err)
 	}
 
+	// the capabilities claimed by a peer which can't be authenticated are
+	// ignored, a peer could otherwise make us send the device in clear
+	if authenticated {
+		m.registerDevicePeer(msg.Address, sender, len(box.SealedDevicePk) > 0)
+	}
+
+	// older versions send the device in clear
+	devicePK := box.DevicePk
+	if len(box.SealedDevicePk) > 0 && ok && len(group.GetSecret()) > 0 {
+		if devicePK, err = openDevicePK(group, box.SealedDevicePk); err != nil {
+			m.logger.Warn("unable to open sender device", zap.String("peer", pid.String()), zap.Error(err))
+			return m.checkPaused(msg)
+		}
+	}
+
+	if devicePK == nil {
+		// the device can't be opened by the replicas of the group
+		return m.checkPaused(msg)
+	}
+
 	// store device into cache
 	var pdg PeerDeviceGroup
 
-	pub, err := crypto.UnmarshalEd25519PublicKey(box.DevicePk)
+	pub, err := crypto.UnmarshalEd25519PublicKey(devicePK)
 	if err != nil {
 		return fmt.Errorf("unable to unmarshal remote device pk: %w", err)
 	}
 
 	pdg.DevicePK = pub
-	group, ok := m.topicGroup[msg.Address]
 	if ok {
 		// @FIXME(gfanton): do we need to raise an error here ?
 		pdg.Group = group
 	}
 	m.deviceCaches[pid] = &pdg
 
+	return m.checkPaused(msg)
+}
+
+// registerDevicePeer records whether an authenticated peer of a topic has
+// sent its device sealed
+func (m *OrbitDBMessageMarshaler) registerDevicePeer(topic string, pid peer.ID, sealed bool) {
+	if sealed {
+		m.removeLegacyDevicePeer(topic, pid)
+		return
+	}
+
+	peers, ok := m.legacyDevicePeers[topic]
+	if !ok {
+		peers = make(map[peer.ID]struct{})
+		m.legacyDevicePeers[topic] = peers
+	}
+
+	peers[pid] = struct{}{}
+}
+
+func (m *OrbitDBMessageMarshaler) removeLegacyDevicePeer(topic string, pid peer.ID) {
+	peers, ok := m.legacyDevicePeers[topic]
+	if !ok {
+		return
+	}
+
+	delete(peers, pid)
+	if len(peers) == 0 {
+		delete(m.legacyDevicePeers, topic)
+	}
+}
+
+// checkPaused defers the heads of a paused store, the message is then
+// dropped by the store
+func (m *OrbitDBMessageMarshaler) checkPaused(msg *iface.MessageExchangeHeads) error {
+	if m.deferHeads(msg.Address, msg.Heads) {
+		return errReplicationPaused
+	}
+
 	return nil
 }
 
@@ -235,3 +517,42 @@ func (m *OrbitDBMessageMarshaler) openBox(topic string, payload []byte) (*protoc
 
 	return box, nil
 }
+
+// sealDevicePK encrypts the device of the sender of a box using the group
+// secret, the nonce is prepended to the ciphertext
+func sealDevicePK(group *protocoltypes.Group, devicePK []byte) ([]byte, error) {
+	if len(group.GetSecret()) == 0 {
+		return nil, fmt.Errorf("the group secret is unknown")
+	}
+
+	nonce, err := cryptoutil.GenerateNonce()
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate nonce: %w", err)
+	}
+
+	return secretbox.Seal(nonce[:], devicePK, nonce, group.GetSharedSecret()), nil
+}
+
+// openDevicePK decrypts the device of the sender of a box, it fails if the
+// group secret is unknown
+func openDevicePK(group *protocoltypes.Group, sealedDevicePK []byte) ([]byte, error) {
+	if len(group.GetSecret()) == 0 {
+		return nil, fmt.Errorf("the group secret is unknown")
+	}
+
+	if len(sealedDevicePK) < cryptoutil.NonceSize+secretbox.Overhead {
+		return nil, fmt.Errorf("sealed device pk is too short")
+	}
+
+	nonce, err := cryptoutil.NonceSliceToArray(sealedDevicePK[:cryptoutil.NonceSize])
+	if err != nil {
+		return nil, fmt.Errorf("unable to read nonce: %w", err)
+	}
+
+	devicePK, ok := secretbox.Open(nil, sealedDevicePK[cryptoutil.NonceSize:], nonce, group.GetSharedSecret())
+	if !ok {
+		return nil, fmt.Errorf("unable to open sealed device pk")
+	}
+
+	return devicePK, nil
+}
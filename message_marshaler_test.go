@@ -4,13 +4,16 @@ import (
 	"testing"
 	"time"
 
+	peer "github.com/libp2p/go-libp2p/core/peer"
 	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 
 	"berty.tech/go-ipfs-log/enc"
 	"berty.tech/go-ipfs-log/entry"
 	"berty.tech/go-orbit-db/iface"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
 	"berty.tech/weshnet/v2/pkg/rendezvous"
 	"berty.tech/weshnet/v2/pkg/secretstore"
 )
@@ -208,3 +211,163 @@ func TestRotationMessageMarshalWrongKey(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, ret.Address, msg.Address)
 }
+
+func TestMessageMarshalerSealedDevicePK(t *testing.T) {
+	msg := &iface.MessageExchangeHeads{
+		Address: "address_1",
+		Heads:   []*entry.Entry{},
+	}
+
+	mn := mocknet.New()
+	defer mn.Close()
+
+	g, _, err := NewGroupMultiMember()
+	require.NoError(t, err)
+
+	linkKey, err := g.GetLinkKeyArray()
+	require.NoError(t, err)
+
+	key, err := enc.NewSecretbox(linkKey[:])
+	require.NoError(t, err)
+
+	replicatedGroup, err := FilterGroupForReplication(g)
+	require.NoError(t, err)
+
+	newMarshaler := func(group *protocoltypes.Group, replication bool) (*OrbitDBMessageMarshaler, peer.ID) {
+		p, err := mn.GenPeer()
+		require.NoError(t, err)
+
+		store, err := secretstore.NewInMemSecretStore(nil)
+		require.NoError(t, err)
+
+		t.Cleanup(func() { _ = store.Close() })
+
+		rp := rendezvous.NewStaticRotationInterval()
+		rp.RegisterRotation(time.Now(), msg.Address, testSeed1)
+
+		m := NewOrbitDBMessageMarshaler(p.ID(), store, rp, replication)
+		m.RegisterGroup(msg.Address, group)
+		m.RegisterSharedKeyForTopic(msg.Address, key)
+
+		return m, p.ID()
+	}
+
+	sender, senderID := newMarshaler(g, false)
+	member, _ := newMarshaler(g, false)
+	replica, _ := newMarshaler(replicatedGroup, true)
+
+	openBox := func(payload []byte) *protocoltypes.OrbitDBMessageHeads_Box {
+		heads := &protocoltypes.OrbitDBMessageHeads{}
+		require.NoError(t, proto.Unmarshal(payload, heads))
+
+		box, err := replica.openBox(msg.Address, heads.SealedBox)
+		require.NoError(t, err)
+
+		return box
+	}
+
+	// the device is sealed by default, the replicas can open the box but not
+	// the device of the sender
+	payload, err := sender.Marshal(msg)
+	require.NoError(t, err)
+
+	box := openBox(payload)
+	require.Empty(t, box.DevicePk)
+	require.NotEmpty(t, box.SealedDevicePk)
+
+	memberPayload, err := member.Marshal(msg)
+	require.NoError(t, err)
+
+	require.NoError(t, replica.Unmarshal(payload, &iface.MessageExchangeHeads{}))
+	_, ok := replica.GetDevicePKForPeerID(senderID)
+	require.False(t, ok)
+
+	require.NoError(t, member.Unmarshal(payload, &iface.MessageExchangeHeads{}))
+	pdg, ok := member.GetDevicePKForPeerID(senderID)
+	require.True(t, ok)
+
+	senderDevice, err := sender.secretStore.GetOwnMemberDeviceForGroup(g)
+	require.NoError(t, err)
+	require.True(t, pdg.DevicePK.Equals(senderDevice.Device()))
+
+	// the other peers are sending a box like the member one
+	otherPeerPayload := func(devicePK []byte, sealedDevicePK []byte) ([]byte, peer.ID) {
+		p, err := mn.GenPeer()
+		require.NoError(t, err)
+
+		box := openBox(memberPayload)
+		box.PeerId, err = p.ID().MarshalBinary()
+		require.NoError(t, err)
+		box.DevicePk, box.SealedDevicePk = devicePK, sealedDevicePK
+
+		heads := &protocoltypes.OrbitDBMessageHeads{}
+		require.NoError(t, proto.Unmarshal(memberPayload, heads))
+
+		heads.SealedBox, err = member.sealBox(msg.Address, box)
+		require.NoError(t, err)
+
+		payload, err := proto.Marshal(heads)
+		require.NoError(t, err)
+
+		return payload, p.ID()
+	}
+
+	// a sealed device which can't be opened is skipped
+	corruptedPK := openBox(memberPayload).SealedDevicePk
+	corruptedPK[len(corruptedPK)-1] ^= 0xff
+
+	corruptedPayload, corruptedID := otherPeerPayload(nil, corruptedPK)
+	require.NoError(t, sender.Unmarshal(corruptedPayload, &iface.MessageExchangeHeads{}))
+	_, ok = sender.GetDevicePKForPeerID(corruptedID)
+	require.False(t, ok)
+
+	// the capabilities claimed by a peer which can't be authenticated are
+	// ignored
+	legacyPK, err := senderDevice.Device().Raw()
+	require.NoError(t, err)
+
+	legacyPayload, legacyID := otherPeerPayload(legacyPK, nil)
+	require.NoError(t, sender.Unmarshal(legacyPayload, &iface.MessageExchangeHeads{}))
+	_, ok = sender.GetDevicePKForPeerID(legacyID)
+	require.True(t, ok)
+
+	payload, err = sender.Marshal(msg)
+	require.NoError(t, err)
+	require.Empty(t, openBox(payload).DevicePk)
+
+	// the device is sent in clear while an older peer is connected
+	receiveLegacy := func() {
+		sender.registerSender(legacyPayload, legacyID)
+		require.NoError(t, sender.Unmarshal(legacyPayload, &iface.MessageExchangeHeads{}))
+
+		payload, err := sender.Marshal(msg)
+		require.NoError(t, err)
+		require.NotEmpty(t, openBox(payload).DevicePk)
+	}
+
+	requireSealed := func() {
+		payload, err := sender.Marshal(msg)
+		require.NoError(t, err)
+		require.Empty(t, openBox(payload).DevicePk)
+		require.Empty(t, sender.legacyDevicePeers)
+	}
+
+	receiveLegacy()
+	sender.RemovePeer(legacyID)
+	requireSealed()
+
+	receiveLegacy()
+	sender.RemoveTopicPeer(msg.Address, legacyID)
+	requireSealed()
+
+	receiveLegacy()
+	sender.RemoveTopicPeer(msg.Address, senderID)
+	requireSealed()
+
+	// a peer sending a sealed device is no longer considered as older
+	receiveLegacy()
+	sealedPayload, _ := otherPeerPayload(nil, openBox(memberPayload).SealedDevicePk)
+	sender.registerSender(sealedPayload, legacyID)
+	require.NoError(t, sender.Unmarshal(sealedPayload, &iface.MessageExchangeHeads{}))
+	requireSealed()
+}
@@ -0,0 +1,312 @@
+package weshnet
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+
+	"berty.tech/weshnet/v2/pkg/netmanager"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/tinder"
+)
+
+const (
+	DefaultMeteredAdvertiseTTL  = time.Hour * 6
+	DefaultMeteredConnLowWater  = 16
+	DefaultMeteredConnHighWater = 32
+)
+
+// MeteredConnTrimInterval is the interval between two checks of the
+// connection limits on metered networks
+var MeteredConnTrimInterval = time.Second * 30
+
+// MeteredPolicy configures the reduced sync applied when the device is on a
+// metered network, as reported by Opts.NetManager
+type MeteredPolicy struct {
+	// Disabled turns off the policy, the node syncs the same way on every
+	// network.
+	Disabled bool
+
+	// AdvertiseTTL is the ttl requested when advertising on metered networks,
+	// advertisements are renewed less often.
+	AdvertiseTTL time.Duration
+
+	// KeepInactiveGroups keeps replicating the groups without subscribers on
+	// metered networks. Otherwise the heads received for these groups, and
+	// the history they would fetch, are deferred until the group gets a
+	// subscriber or the network isn't metered anymore.
+	KeepInactiveGroups bool
+
+	// ConnLowWater and ConnHighWater are the connection manager limits on
+	// metered networks, connections to the less valuable peers are trimmed
+	// down to ConnLowWater when ConnHighWater is exceeded.
+	ConnLowWater  int
+	ConnHighWater int
+}
+
+func (p *MeteredPolicy) applyDefaults() {
+	if p.AdvertiseTTL <= 0 {
+		p.AdvertiseTTL = DefaultMeteredAdvertiseTTL
+	}
+
+	if p.ConnHighWater <= 0 {
+		p.ConnHighWater = DefaultMeteredConnHighWater
+	}
+
+	if p.ConnLowWater <= 0 {
+		p.ConnLowWater = DefaultMeteredConnLowWater
+	}
+
+	if p.ConnLowWater > p.ConnHighWater {
+		p.ConnLowWater = p.ConnHighWater
+	}
+}
+
+// IsMeteredNetwork returns true if the device is on a metered network, cellular
+// networks are considered metered when the platform doesn't report metering
+func IsMeteredNetwork(info netmanager.ConnectivityInfo) bool {
+	if info.State == netmanager.ConnectivityStateOff {
+		return false
+	}
+
+	switch info.Metering {
+	case netmanager.ConnectivityStateOn:
+		return true
+	case netmanager.ConnectivityStateOff:
+		return false
+	default:
+		return info.NetType == netmanager.ConnectivityNetCellular
+	}
+}
+
+// meteredPolicyEngine applies the metered policy following the network
+// manager events
+type meteredPolicyEngine struct {
+	policy     MeteredPolicy
+	logger     *zap.Logger
+	netManager *netmanager.NetManager
+	tinder     *tinder.Service
+	host       host.Host
+	odb        *WeshOrbitDB
+	groups     func() []*GroupContext
+
+	metered atomic.Bool
+
+	paused   map[string]*GroupContext
+	muPaused sync.Mutex
+}
+
+func newMeteredPolicyEngine(ctx context.Context, logger *zap.Logger, policy MeteredPolicy, nm *netmanager.NetManager, s *service, ts *tinder.Service) *meteredPolicyEngine {
+	policy.applyDefaults()
+
+	e := &meteredPolicyEngine{
+		policy:     policy,
+		logger:     logger.Named("metered"),
+		netManager: nm,
+		tinder:     ts,
+		host:       s.host,
+		odb:        s.odb,
+		groups:     s.listOpenedGroups,
+		paused:     make(map[string]*GroupContext),
+	}
+
+	e.setMetered(ctx, IsMeteredNetwork(nm.GetCurrentState()))
+
+	go e.watchNetwork(ctx)
+	go e.trimLoop(ctx)
+
+	return e
+}
+
+func (e *meteredPolicyEngine) watchNetwork(ctx context.Context) {
+	current := e.netManager.GetCurrentState()
+	events := netmanager.ConnectivityStateChanged | netmanager.ConnectivityMeteringChanged | netmanager.ConnectivityNetTypeChanged
+
+	for {
+		if ok, _ := e.netManager.WaitForStateChange(ctx, &current, events); !ok {
+			return
+		}
+
+		current = e.netManager.GetCurrentState()
+		e.setMetered(ctx, IsMeteredNetwork(current))
+	}
+}
+
+func (e *meteredPolicyEngine) setMetered(ctx context.Context, metered bool) {
+	if e.metered.Swap(metered) == metered {
+		return
+	}
+
+	e.logger.Info("network changed", zap.Bool("metered", metered))
+
+	if e.tinder != nil {
+		if metered {
+			e.tinder.SetAdvertiseTTL(e.policy.AdvertiseTTL)
+		} else {
+			e.tinder.SetAdvertiseTTL(0)
+		}
+	}
+
+	for _, gc := range e.groups() {
+		e.updateGroup(ctx, gc)
+	}
+
+	if metered {
+		e.trimConns()
+	}
+}
+
+// updateGroup pauses or resumes the replication of the group depending on
+// the network and its subscribers
+func (e *meteredPolicyEngine) updateGroup(ctx context.Context, gc *GroupContext) {
+	pause := e.metered.Load() && !e.policy.KeepInactiveGroups &&
+		gc.group.GroupType != protocoltypes.GroupType_GroupTypeAccount &&
+		gc.subscribers.Load() == 0 && !gc.IsClosed()
+
+	id := string(gc.group.PublicKey)
+
+	e.muPaused.Lock()
+	defer e.muPaused.Unlock()
+
+	_, paused := e.paused[id]
+	switch {
+	case pause && !paused:
+		e.odb.pauseGroupReplication(gc)
+		e.paused[id] = gc
+		e.logger.Debug("group replication paused", zap.String("group", gc.group.GroupIDAsString()))
+	case !pause && paused:
+		delete(e.paused, id)
+		if !gc.IsClosed() {
+			e.odb.resumeGroupReplication(ctx, gc)
+			e.logger.Debug("group replication resumed", zap.String("group", gc.group.GroupIDAsString()))
+		}
+	}
+}
+
+// groupClosed forgets a deactivated group, its deferred heads are dropped
+// with the group
+func (e *meteredPolicyEngine) groupClosed(gc *GroupContext) {
+	e.muPaused.Lock()
+	delete(e.paused, string(gc.group.PublicKey))
+	e.muPaused.Unlock()
+}
+
+func (e *meteredPolicyEngine) trimLoop(ctx context.Context) {
+	ticker := time.NewTicker(MeteredConnTrimInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		if e.metered.Load() {
+			e.trimConns()
+		}
+	}
+}
+
+// trimConns closes the connections to the less valuable peers when the
+// metered limits are exceeded, protected peers are kept
+func (e *meteredPolicyEngine) trimConns() {
+	peers := e.host.Network().Peers()
+	if len(peers) <= e.policy.ConnHighWater {
+		return
+	}
+
+	type candidate struct {
+		id    peer.ID
+		value int
+	}
+
+	cm := e.host.ConnManager()
+	candidates := make([]candidate, 0, len(peers))
+	for _, p := range peers {
+		if cm.IsProtected(p, "") {
+			continue
+		}
+
+		c := candidate{id: p}
+		if info := cm.GetTagInfo(p); info != nil {
+			c.value = info.Value
+		}
+		candidates = append(candidates, c)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].value < candidates[j].value })
+
+	closed := 0
+	for _, c := range candidates[:min(len(peers)-e.policy.ConnLowWater, len(candidates))] {
+		if err := e.host.Network().ClosePeer(c.id); err != nil {
+			e.logger.Debug("unable to close peer connections", zap.Error(err))
+			continue
+		}
+		closed++
+	}
+
+	e.logger.Debug("connections trimmed", zap.Int("peers", len(peers)), zap.Int("closed", closed))
+}
+
+func (e *meteredPolicyEngine) configuration() *protocoltypes.ServiceGetConfiguration_MeteredPolicy {
+	e.muPaused.Lock()
+	paused := len(e.paused)
+	e.muPaused.Unlock()
+
+	return &protocoltypes.ServiceGetConfiguration_MeteredPolicy{
+		State:               protocoltypes.ServiceGetConfiguration_Enabled,
+		Metered:             e.metered.Load(),
+		AdvertiseTtlSeconds: int64(e.policy.AdvertiseTTL / time.Second),
+		PauseInactiveGroups: !e.policy.KeepInactiveGroups,
+		PausedGroups:        uint32(paused),
+		ConnLowWater:        uint32(e.policy.ConnLowWater),
+		ConnHighWater:       uint32(e.policy.ConnHighWater),
+	}
+}
+
+// trackGroupSubscriber registers a live subscriber of the group events, the
+// replication of a group with subscribers is never paused. The returned
+// function must be called once the subscription ends.
+func (s *service) trackGroupSubscriber(gc *GroupContext) func() {
+	gc.subscribers.Add(1)
+	if s.meteredPolicy != nil {
+		s.meteredPolicy.updateGroup(s.ctx, gc)
+	}
+
+	return func() {
+		gc.subscribers.Add(-1)
+		if s.meteredPolicy != nil {
+			s.meteredPolicy.updateGroup(s.ctx, gc)
+		}
+	}
+}
+
+func (s *service) listOpenedGroups() []*GroupContext {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	groups := make([]*GroupContext, 0, len(s.openedGroups))
+	for _, gc := range s.openedGroups {
+		groups = append(groups, gc)
+	}
+
+	return groups
+}
+
+func (s *service) meteredPolicyConfiguration() *protocoltypes.ServiceGetConfiguration_MeteredPolicy {
+	switch {
+	case s.meteredPolicy != nil:
+		return s.meteredPolicy.configuration()
+	case s.meteredPolicyDisabled:
+		return &protocoltypes.ServiceGetConfiguration_MeteredPolicy{State: protocoltypes.ServiceGetConfiguration_Disabled}
+	default:
+		return &protocoltypes.ServiceGetConfiguration_MeteredPolicy{State: protocoltypes.ServiceGetConfiguration_Unavailable}
+	}
+}
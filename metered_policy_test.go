@@ -0,0 +1,41 @@
+package weshnet
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/weshnet/v2/pkg/netmanager"
+)
+
+func TestIsMeteredNetwork(t *testing.T) {
+	cases := []struct {
+		info     netmanager.ConnectivityInfo
+		expected bool
+	}{
+		{netmanager.ConnectivityInfo{State: netmanager.ConnectivityStateOn, NetType: netmanager.ConnectivityNetWifi}, false},
+		{netmanager.ConnectivityInfo{State: netmanager.ConnectivityStateOn, NetType: netmanager.ConnectivityNetCellular}, true},
+		{netmanager.ConnectivityInfo{State: netmanager.ConnectivityStateOn, NetType: netmanager.ConnectivityNetWifi, Metering: netmanager.ConnectivityStateOn}, true},
+		{netmanager.ConnectivityInfo{State: netmanager.ConnectivityStateOn, NetType: netmanager.ConnectivityNetCellular, Metering: netmanager.ConnectivityStateOff}, false},
+		{netmanager.ConnectivityInfo{State: netmanager.ConnectivityStateOff, NetType: netmanager.ConnectivityNetCellular, Metering: netmanager.ConnectivityStateOn}, false},
+	}
+
+	for _, tc := range cases {
+		require.Equal(t, tc.expected, IsMeteredNetwork(tc.info), tc.info.String())
+	}
+}
+
+func TestMeteredPolicyDefaults(t *testing.T) {
+	p := MeteredPolicy{}
+	p.applyDefaults()
+	require.Equal(t, DefaultMeteredAdvertiseTTL, p.AdvertiseTTL)
+	require.Equal(t, DefaultMeteredConnLowWater, p.ConnLowWater)
+	require.Equal(t, DefaultMeteredConnHighWater, p.ConnHighWater)
+
+	p = MeteredPolicy{AdvertiseTTL: time.Hour, ConnLowWater: 10, ConnHighWater: 4}
+	p.applyDefaults()
+	require.Equal(t, time.Hour, p.AdvertiseTTL)
+	require.Equal(t, 4, p.ConnLowWater)
+	require.Equal(t, 4, p.ConnHighWater)
+}
@@ -187,8 +187,13 @@ func NewWeshOrbitDB(ctx context.Context, ipfs coreiface.CoreAPI, options *NewOrb
 	}
 
 	mm := NewOrbitDBMessageMarshaler(self.ID(), options.SecretStore, options.RotationInterval, options.ReplicationMode)
+	mm.SetLogger(options.Logger)
 	options.MessageMarshaler = mm
 
+	if options.DirectChannelFactory != nil {
+		options.DirectChannelFactory = mm.DirectChannelFactory(options.DirectChannelFactory)
+	}
+
 	orbitDB, err := baseorbitdb.NewOrbitDB(ctx, ipfs, &options.NewOrbitDBOptions)
 	if err != nil {
 		return nil, errcode.ErrCode_TODO.Wrap(err)
@@ -342,10 +347,10 @@ func (s *WeshOrbitDB) loadHeads(ctx context.Context, store iface.Store, heads []
 	defer sub.Close()
 
 	// check and generate missing entries if needed
-	headsEntries := make([]ipfslog.Entry, len(heads))
-	for i, h := range heads {
+	headsEntries := []ipfslog.Entry{}
+	for _, h := range heads {
 		if _, ok := store.OpLog().Get(h); !ok {
-			headsEntries[i] = &entry.Entry{Hash: h}
+			headsEntries = append(headsEntries, &entry.Entry{Hash: h})
 		}
 	}
 
@@ -355,7 +360,7 @@ func (s *WeshOrbitDB) loadHeads(ctx context.Context, store iface.Store, heads []
 
 	store.Replicator().Load(ctx, headsEntries)
 
-	for found := 0; found < len(heads); {
+	for found := 0; found < len(headsEntries); {
 		// wait for load to finish
 		select {
 		case e := <-sub.Out():
@@ -371,6 +376,8 @@ func (s *WeshOrbitDB) loadHeads(ctx context.Context, store iface.Store, heads []
 				}
 			}
 
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-s.ctx.Done():
 			return s.ctx.Err()
 		}
@@ -510,6 +517,40 @@ func (s *WeshOrbitDB) getGroupContext(id string) (*GroupContext, error) {
 	return g.(*GroupContext), nil
 }
 
+// pauseGroupReplication defers the heads received for the stores of the group
+// until resumeGroupReplication is called
+func (s *WeshOrbitDB) pauseGroupReplication(gc *GroupContext) {
+	for _, store := range []iface.Store{gc.metadataStore, gc.messageStore} {
+		s.messageMarshaler.PauseTopic(store.Address().String())
+	}
+}
+
+// resumeGroupReplication loads the heads received while the replication of
+// the group was paused
+func (s *WeshOrbitDB) resumeGroupReplication(ctx context.Context, gc *GroupContext) {
+	for _, store := range []iface.Store{gc.metadataStore, gc.messageStore} {
+		heads := s.messageMarshaler.ResumeTopic(store.Address().String())
+		if len(heads) == 0 {
+			continue
+		}
+
+		entries := make([]ipfslog.Entry, len(heads))
+		for i, head := range heads {
+			entries[i] = head
+		}
+
+		store.Replicator().Load(ctx, entries)
+	}
+}
+
+// clearGroupReplication drops the heads deferred for the stores of a closed
+// group
+func (s *WeshOrbitDB) clearGroupReplication(gc *GroupContext) {
+	for _, store := range []iface.Store{gc.metadataStore, gc.messageStore} {
+		s.messageMarshaler.ClearTopic(store.Address().String())
+	}
+}
+
 // SetGroupSigPubKey registers a new group signature pubkey, mainly used to
 // replicate a store data without needing to access to its content
 func (s *WeshOrbitDB) SetGroupSigPubKey(groupID string, pubKey crypto.PubKey) error {
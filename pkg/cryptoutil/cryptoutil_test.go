@@ -10,6 +10,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"golang.org/x/crypto/curve25519"
 	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/box"
 
 	"berty.tech/weshnet/v2/pkg/errcode"
 )
@@ -69,6 +70,26 @@ func TestEdwardsToMontgomeryPriv(t *testing.T) {
 	}
 }
 
+func TestPrecomputeBoxKey(t *testing.T) {
+	priv, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+
+	_, peerPub, err := crypto.GenerateEd25519Key(rand.Reader)
+	require.NoError(t, err)
+
+	mongPriv, mongPub, err := EdwardsToMontgomery(priv, peerPub)
+	require.NoError(t, err)
+
+	var expected, sharedKey [KeySize]byte
+	box.Precompute(&expected, mongPub, mongPriv)
+
+	require.NoError(t, PrecomputeBoxKey(&sharedKey, priv, mongPub))
+	require.Equal(t, expected, sharedKey)
+
+	// a low order point is rejected
+	require.Error(t, PrecomputeBoxKey(&sharedKey, priv, &[KeySize]byte{}))
+}
+
 func TestDeriveKey(t *testing.T) {
 	cases := []struct {
 		passphrase []byte
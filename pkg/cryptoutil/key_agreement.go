@@ -0,0 +1,52 @@
+package cryptoutil
+
+import (
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/salsa20/salsa"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+)
+
+// KeyAgreer is implemented by the private keys whose raw value isn't
+// available, their key agreement being performed elsewhere (e.g. by an
+// external signer).
+type KeyAgreer interface {
+	// X25519 computes the X25519 shared secret of the Montgomery form of the
+	// private key and the given X25519 public key
+	X25519(peerPublicKey *[KeySize]byte) (*[KeySize]byte, error)
+}
+
+// X25519 computes the X25519 shared secret of an ed25519 private key,
+// converted to its Montgomery form, and an X25519 public key.
+func X25519(privKey crypto.PrivKey, peerPublicKey *[KeySize]byte) (*[KeySize]byte, error) {
+	if agreer, ok := privKey.(KeyAgreer); ok {
+		return agreer.X25519(peerPublicKey)
+	}
+
+	mongPriv, err := EdwardsToMontgomeryPriv(privKey)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
+	}
+
+	secret, err := curve25519.X25519(mongPriv[:], peerPublicKey[:])
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(err)
+	}
+
+	return KeySliceToArray(secret)
+}
+
+// PrecomputeBoxKey computes the nacl box shared key of an ed25519 private key
+// and an X25519 public key, like box.Precompute does with the Montgomery form
+// of the private key.
+func PrecomputeBoxKey(sharedKey *[KeySize]byte, privKey crypto.PrivKey, peerPublicKey *[KeySize]byte) error {
+	secret, err := X25519(privKey, peerPublicKey)
+	if err != nil {
+		return err
+	}
+
+	salsa.HSalsa20(sharedKey, &[16]byte{}, secret, &salsa.Sigma)
+
+	return nil
+}
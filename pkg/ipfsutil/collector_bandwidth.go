@@ -1,7 +1,11 @@
 package ipfsutil
 
 import (
+	"sync/atomic"
+
 	metrics "github.com/libp2p/go-libp2p/core/metrics"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	prometheus "github.com/prometheus/client_golang/prometheus"
 )
 
@@ -50,3 +54,53 @@ func (bc *BandwidthCollector) Collect(cmetric chan<- prometheus.Metric) {
 func (bc *BandwidthCollector) Describe(ch chan<- *prometheus.Desc) {
 	prometheus.DescribeByCollect(bc, ch)
 }
+
+// BandwidthTapFunc receives the size of the messages of a stream, in and out
+type BandwidthTapFunc func(p peer.ID, proto protocol.ID, in, out int64)
+
+// BandwidthTap is a metrics.Reporter passing the size of the stream messages
+// to a BandwidthTapFunc, with their peer and protocol, before reporting them
+// to the wrapped reporter. It must be given to the host with
+// libp2p.BandwidthReporter.
+type BandwidthTap struct {
+	metrics.Reporter
+
+	tap atomic.Pointer[BandwidthTapFunc]
+}
+
+var _ metrics.Reporter = (*BandwidthTap)(nil)
+
+func NewBandwidthTap(reporter metrics.Reporter) *BandwidthTap {
+	if reporter == nil {
+		reporter = metrics.NewBandwidthCounter()
+	}
+
+	return &BandwidthTap{Reporter: reporter}
+}
+
+// SetTap sets the function receiving the stream messages sizes, nil removes
+// the current one
+func (t *BandwidthTap) SetTap(fn BandwidthTapFunc) {
+	if fn == nil {
+		t.tap.Store(nil)
+		return
+	}
+
+	t.tap.Store(&fn)
+}
+
+func (t *BandwidthTap) LogSentMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	if fn := t.tap.Load(); fn != nil {
+		(*fn)(p, proto, 0, size)
+	}
+
+	t.Reporter.LogSentMessageStream(size, proto, p)
+}
+
+func (t *BandwidthTap) LogRecvMessageStream(size int64, proto protocol.ID, p peer.ID) {
+	if fn := t.tap.Load(); fn != nil {
+		(*fn)(p, proto, size, 0)
+	}
+
+	t.Reporter.LogRecvMessageStream(size, proto, p)
+}
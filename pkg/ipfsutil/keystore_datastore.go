@@ -4,6 +4,7 @@ import (
 	"context"
 
 	datastore "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
 	keystore "github.com/ipfs/go-ipfs-keystore"
 	"github.com/libp2p/go-libp2p/core/crypto"
 
@@ -43,7 +44,22 @@ func (k *datastoreKeystore) Delete(name string) error {
 }
 
 func (k *datastoreKeystore) List() ([]string, error) {
-	return nil, errcode.ErrCode_ErrNotImplemented
+	res, err := k.ds.Query(context.TODO(), query.Query{KeysOnly: true})
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDBRead.Wrap(err)
+	}
+
+	entries, err := res.Rest()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDBRead.Wrap(err)
+	}
+
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = datastore.RawKey(e.Key).BaseNamespace()
+	}
+
+	return names, nil
 }
 
 func NewDatastoreKeystore(ds datastore.Datastore) keystore.Keystore {
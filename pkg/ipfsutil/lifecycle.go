@@ -98,6 +98,10 @@ func (cl *ConnLifecycle) monitorAppState(ctx context.Context) {
 		case lifecycle.StateActive:
 			cl.logger.Debug("active mode")
 			go cl.dropUnavailableConn()
+		case lifecycle.StateSync:
+			// connections may have died while the app was asleep
+			cl.logger.Debug("background sync mode")
+			go cl.dropUnavailableConn()
 		}
 	}
 }
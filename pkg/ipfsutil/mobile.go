@@ -10,6 +10,7 @@ import (
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p-kad-dht/dual"
 	host "github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/metrics"
 	"github.com/libp2p/go-libp2p/core/peer"
 	p2p_routing "github.com/libp2p/go-libp2p/core/routing"
 	quict "github.com/libp2p/go-libp2p/p2p/transport/quic"
@@ -44,6 +45,9 @@ type MobileOptions struct {
 	RoutingConfigFunc ipfs_mobile.RoutingConfigFunc
 
 	ExtraOpts map[string]bool
+
+	// BandwidthReporter, if set, receives the bandwidth metrics of the host
+	BandwidthReporter metrics.Reporter
 }
 
 func (o *MobileOptions) fillDefault() {
@@ -104,6 +108,10 @@ func NewIPFSMobile(ctx context.Context, repo *ipfs_mobile.RepoMobile, opts *Mobi
 		return nil, fmt.Errorf("unable p2p option: cannot be nil")
 	}
 
+	if opts.BandwidthReporter != nil {
+		p2popts = append(p2popts, p2p.BandwidthReporter(opts.BandwidthReporter))
+	}
+
 	// configure host
 	hostconfig := &ipfs_mobile.HostConfig{
 		// called after host init
@@ -119,6 +119,11 @@ type TestingAPIOpts struct {
 	Mocknet         mocknet.Mocknet
 	Datastore       ds.Batching
 	DiscoveryServer *tinder.MockDriverServer
+
+	// HostOption, if set, replaces the mocknet host, it can be used to run
+	// the node over another transport listening on SwarmAddrs
+	HostOption ipfs_p2p.HostOption
+	SwarmAddrs []string
 }
 
 // TestingCoreAPIUsingMockNet returns a fully initialized mocked Core API with the given mocknet
@@ -140,11 +145,22 @@ func TestingCoreAPIUsingMockNet(ctx context.Context, t testing.TB, opts *Testing
 
 	repo := TestingRepo(t, ctx, datastore)
 
+	hostOption := MockHostOption(opts.Mocknet)
+	if opts.HostOption != nil {
+		hostOption = opts.HostOption
+
+		cfg, err := repo.Config()
+		require.NoError(t, err)
+
+		cfg.Addresses.Swarm = opts.SwarmAddrs
+		require.NoError(t, repo.SetConfig(cfg))
+	}
+
 	mrepo := ipfs_mobile.NewRepoMobile("", repo)
 	t.Cleanup(func() { mrepo.Close() })
 
 	mnode, err := NewIPFSMobile(ctx, mrepo, &MobileOptions{
-		HostOption:    MockHostOption(opts.Mocknet),
+		HostOption:    hostOption,
 		RoutingOption: ipfs_p2p.NilRouterOption,
 		ExtraOpts: map[string]bool{
 			"pubsub": false,
@@ -12,6 +12,9 @@ type State int
 const (
 	StateActive State = iota
 	StateInactive
+	// StateSync is an inactive app woken up for a limited time to sync in
+	// the background
+	StateSync
 )
 
 type Manager struct {
@@ -42,6 +45,24 @@ func (m *Manager) UpdateState(state State) {
 	m.locker.Unlock()
 }
 
+// SwapState sets the state to `to` if the current state is `from`, false is
+// returned otherwise
+func (m *Manager) SwapState(from, to State) bool {
+	m.locker.Lock()
+	defer m.locker.Unlock()
+
+	if m.currentState != from {
+		return false
+	}
+
+	if from != to {
+		m.currentState = to
+		m.notify.Broadcast()
+	}
+
+	return true
+}
+
 // WaitForStateChange waits until the currentState changes from sourceState or ctx expires. A true value is returned in former case and false in latter.
 func (m *Manager) WaitForStateChange(ctx context.Context, sourceState State) bool {
 	m.locker.Lock()
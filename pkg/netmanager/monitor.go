@@ -0,0 +1,137 @@
+package netmanager
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// ErrMonitorUnsupported is returned by StartMonitor on platforms without a
+// network monitor, the state must be updated by the embedding app
+var ErrMonitorUnsupported = errors.New("network monitor not supported on this platform")
+
+// NewSystemNetManager returns a NetManager kept up to date by the network
+// monitor of the platform. It falls back to NewNoopNetManager when the
+// platform has no monitor.
+func NewSystemNetManager(ctx context.Context, logger *zap.Logger) *NetManager {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	m := NewNoopNetManager()
+	if err := StartMonitor(ctx, logger, m); err != nil {
+		logger.Debug("network monitor not started, using a static state", zap.Error(err))
+	}
+
+	return m
+}
+
+// interfaceInfo describes a network interface of the host
+type interfaceInfo struct {
+	name string
+
+	// up is true when the interface is up and has a carrier
+	up bool
+
+	// global is true when the interface has a global unicast address
+	global bool
+
+	// wireless and cellular are set when the system reports the interface
+	// as a wifi or a mobile broadband device
+	wireless bool
+	cellular bool
+
+	// physical is true when the interface is backed by a device
+	physical bool
+}
+
+var (
+	// host-local interfaces created by bridges, containers and VMs, they
+	// don't give access to the network
+	localInterfacePrefixes = []string{"docker", "br-", "veth", "virbr", "vboxnet", "vmnet", "lxcbr", "lxdbr", "cni", "flannel", "kube-"}
+
+	// predictable interface names and the usual kernel ones
+	wifiInterfacePrefixes     = []string{"wl", "wifi", "ath"}
+	cellularInterfacePrefixes = []string{"ww", "rmnet", "ccmni", "pdp"}
+	ethernetInterfacePrefixes = []string{"en", "eth", "em"}
+)
+
+// interfaceNetType guesses the type of network an interface is connected to
+func interfaceNetType(i interfaceInfo) ConnectivityNetType {
+	switch {
+	case i.wireless:
+		return ConnectivityNetWifi
+	case i.cellular:
+		return ConnectivityNetCellular
+	case hasAnyPrefix(i.name, wifiInterfacePrefixes):
+		return ConnectivityNetWifi
+	case hasAnyPrefix(i.name, cellularInterfacePrefixes):
+		return ConnectivityNetCellular
+	case hasAnyPrefix(i.name, ethernetInterfacePrefixes):
+		return ConnectivityNetEthernet
+	case i.physical:
+		// a wired device which is neither wifi nor mobile broadband
+		return ConnectivityNetEthernet
+	default:
+		// tunnels and unknown virtual interfaces
+		return ConnectivityNetUnknown
+	}
+}
+
+// netTypePriority orders the net types when several interfaces are
+// connected, the one most likely used by the default route wins
+func netTypePriority(t ConnectivityNetType) int {
+	switch t {
+	case ConnectivityNetEthernet:
+		return 3
+	case ConnectivityNetWifi:
+		return 2
+	case ConnectivityNetCellular:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// connectivityFromInterfaces computes the state from the interfaces of the
+// host, the fields not handled by the monitor are kept from current
+func connectivityFromInterfaces(current ConnectivityInfo, ifaces []interfaceInfo) ConnectivityInfo {
+	state := current
+	state.State = ConnectivityStateOff
+	state.NetType = ConnectivityNetNone
+	state.CellularType = ConnectivityCellularNone
+
+	priority := -1
+	for _, i := range ifaces {
+		if !i.up || !i.global || hasAnyPrefix(i.name, localInterfacePrefixes) {
+			continue
+		}
+
+		state.State = ConnectivityStateOn
+
+		netType := interfaceNetType(i)
+		if p := netTypePriority(netType); p > priority {
+			priority = p
+			state.NetType = netType
+		}
+	}
+
+	if state.NetType == ConnectivityNetCellular {
+		// the generation of the cellular network isn't exposed by the interfaces
+		state.CellularType = ConnectivityCellularUnknown
+	}
+
+	return state
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,235 @@
+//go:build linux
+
+package netmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MonitorDebounce is the delay to wait for the following netlink events
+// before reading the interfaces, changes usually come in bursts
+var MonitorDebounce = time.Millisecond * 200
+
+const (
+	sysClassNet = "/sys/class/net"
+
+	// arphrdRawIP is the hardware type of the raw ip interfaces used by
+	// mobile broadband modems
+	arphrdRawIP = 519
+
+	// netlink multicast groups, from linux/rtnetlink.h
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+// StartMonitor fills the state of m from the network interfaces of the host,
+// and keeps it up to date with the link and address changes reported by
+// netlink until ctx is done.
+func StartMonitor(ctx context.Context, logger *zap.Logger, m *NetManager) error {
+	sock, err := openNetlinkSocket()
+	if err != nil {
+		return err
+	}
+
+	ifaces, err := readInterfaces()
+	if err != nil {
+		_ = sock.Close()
+		return err
+	}
+
+	m.UpdateState(connectivityFromInterfaces(m.GetCurrentState(), ifaces))
+
+	go monitorNetlink(ctx, logger, sock, m)
+
+	return nil
+}
+
+func openNetlinkSocket() (*os.File, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open netlink socket: %w", err)
+	}
+
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr,
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		_ = syscall.Close(fd)
+		return nil, fmt.Errorf("unable to bind netlink socket: %w", err)
+	}
+
+	// the socket is non-blocking, reads go through the runtime poller and
+	// are interrupted by Close
+	return os.NewFile(uintptr(fd), "netlink"), nil
+}
+
+func monitorNetlink(ctx context.Context, logger *zap.Logger, sock *os.File, m *NetManager) {
+	defer sock.Close()
+
+	changes := make(chan struct{}, 1)
+	go readNetlinkChanges(ctx, logger, sock, changes)
+
+	for {
+		select {
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		select {
+		case <-time.After(MonitorDebounce):
+		case <-ctx.Done():
+			return
+		}
+
+		// the changes received meanwhile are covered by this read
+		select {
+		case <-changes:
+		default:
+		}
+
+		ifaces, err := readInterfaces()
+		if err != nil {
+			logger.Warn("unable to read network interfaces", zap.Error(err))
+			continue
+		}
+
+		state := connectivityFromInterfaces(m.GetCurrentState(), ifaces)
+		logger.Debug("network state updated", zap.Stringer("state", state))
+		m.UpdateState(state)
+	}
+}
+
+func readNetlinkChanges(ctx context.Context, logger *zap.Logger, sock *os.File, changes chan<- struct{}) {
+	defer close(changes)
+
+	buf := make([]byte, os.Getpagesize()*4)
+	for {
+		n, err := sock.Read(buf)
+		switch {
+		case errors.Is(err, syscall.ENOBUFS):
+			// some events have been dropped, the interfaces are read
+			// again anyway
+		case err != nil:
+			if ctx.Err() == nil {
+				logger.Warn("netlink monitor stopped", zap.Error(err))
+			}
+			return
+		case !isNetlinkChange(buf[:n]):
+			continue
+		}
+
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func isNetlinkChange(data []byte) bool {
+	msgs, err := syscall.ParseNetlinkMessage(data)
+	if err != nil {
+		return true
+	}
+
+	for _, msg := range msgs {
+		switch msg.Header.Type {
+		case syscall.RTM_NEWLINK, syscall.RTM_DELLINK, syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+			return true
+		}
+	}
+
+	return false
+}
+
+func readInterfaces() ([]interfaceInfo, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list network interfaces: %w", err)
+	}
+
+	infos := make([]interfaceInfo, 0, len(ifaces))
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		info := interfaceInfo{
+			name: iface.Name,
+			up:   iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagRunning != 0,
+		}
+
+		if info.up {
+			addrs, err := iface.Addrs()
+			if err != nil {
+				return nil, fmt.Errorf("unable to list addresses of %s: %w", iface.Name, err)
+			}
+
+			for _, addr := range addrs {
+				if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.IsGlobalUnicast() {
+					info.global = true
+					break
+				}
+			}
+		}
+
+		dir := filepath.Join(sysClassNet, iface.Name)
+		info.wireless = pathExists(filepath.Join(dir, "wireless")) || pathExists(filepath.Join(dir, "phy80211"))
+		info.cellular = sysfsDevType(dir) == "wwan" || sysfsHardwareType(dir) == arphrdRawIP
+		info.physical = pathExists(filepath.Join(dir, "device"))
+
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func sysfsDevType(dir string) string {
+	uevent, err := os.ReadFile(filepath.Join(dir, "uevent"))
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(string(uevent), "\n") {
+		if devType, ok := strings.CutPrefix(line, "DEVTYPE="); ok {
+			return devType
+		}
+	}
+
+	return ""
+}
+
+func sysfsHardwareType(dir string) int {
+	raw, err := os.ReadFile(filepath.Join(dir, "type"))
+	if err != nil {
+		return -1
+	}
+
+	t, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return -1
+	}
+
+	return t
+}
+
+func pathExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package netmanager
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestStartMonitor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := NewNetManager(ConnectivityInfo{})
+	if err := StartMonitor(ctx, zap.NewNop(), m); err != nil {
+		t.Skipf("netlink unavailable: %s", err)
+	}
+
+	// the state is read from the interfaces when the monitor starts
+	require.NotEqual(t, ConnectivityStateUnknown, m.GetCurrentState().State)
+}
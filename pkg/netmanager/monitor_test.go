@@ -0,0 +1,78 @@
+package netmanager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterfaceNetType(t *testing.T) {
+	cases := []struct {
+		iface    interfaceInfo
+		expected ConnectivityNetType
+	}{
+		{interfaceInfo{name: "wlp2s0", physical: true}, ConnectivityNetWifi},
+		{interfaceInfo{name: "mlan0", wireless: true, physical: true}, ConnectivityNetWifi},
+		{interfaceInfo{name: "wwp0s20u4", physical: true}, ConnectivityNetCellular},
+		{interfaceInfo{name: "rmnet_data0"}, ConnectivityNetCellular},
+		{interfaceInfo{name: "usb0", cellular: true, physical: true}, ConnectivityNetCellular},
+		{interfaceInfo{name: "enp0s31f6", physical: true}, ConnectivityNetEthernet},
+		{interfaceInfo{name: "eth0"}, ConnectivityNetEthernet},
+		{interfaceInfo{name: "usb0", physical: true}, ConnectivityNetEthernet},
+		{interfaceInfo{name: "tun0"}, ConnectivityNetUnknown},
+		{interfaceInfo{name: "wg0"}, ConnectivityNetUnknown},
+	}
+
+	for _, tc := range cases {
+		require.Equal(t, tc.expected, interfaceNetType(tc.iface), tc.iface.name)
+	}
+}
+
+func TestConnectivityFromInterfaces(t *testing.T) {
+	current := ConnectivityInfo{
+		State:     ConnectivityStateOn,
+		Bluetooth: ConnectivityStateOn,
+		NetType:   ConnectivityNetWifi,
+	}
+
+	// no interfaces
+	state := connectivityFromInterfaces(current, nil)
+	require.Equal(t, ConnectivityStateOff, state.State)
+	require.Equal(t, ConnectivityNetNone, state.NetType)
+	require.Equal(t, ConnectivityStateOn, state.Bluetooth)
+
+	// host-local interfaces and interfaces without a global address don't
+	// give access to the network
+	state = connectivityFromInterfaces(current, []interfaceInfo{
+		{name: "docker0", up: true, global: true},
+		{name: "wlp2s0", up: true, physical: true},
+		{name: "enp0s31f6", global: true, physical: true},
+	})
+	require.Equal(t, ConnectivityStateOff, state.State)
+	require.Equal(t, ConnectivityNetNone, state.NetType)
+
+	// cellular
+	state = connectivityFromInterfaces(current, []interfaceInfo{
+		{name: "wwan0", up: true, global: true, physical: true},
+	})
+	require.Equal(t, ConnectivityStateOn, state.State)
+	require.Equal(t, ConnectivityNetCellular, state.NetType)
+	require.Equal(t, ConnectivityCellularUnknown, state.CellularType)
+
+	// ethernet is preferred over wifi and tunnels
+	state = connectivityFromInterfaces(current, []interfaceInfo{
+		{name: "tun0", up: true, global: true},
+		{name: "wlp2s0", up: true, global: true, wireless: true, physical: true},
+		{name: "enp0s31f6", up: true, global: true, physical: true},
+	})
+	require.Equal(t, ConnectivityStateOn, state.State)
+	require.Equal(t, ConnectivityNetEthernet, state.NetType)
+	require.Equal(t, ConnectivityCellularNone, state.CellularType)
+
+	// only a tunnel
+	state = connectivityFromInterfaces(current, []interfaceInfo{
+		{name: "tun0", up: true, global: true},
+	})
+	require.Equal(t, ConnectivityStateOn, state.State)
+	require.Equal(t, ConnectivityNetUnknown, state.NetType)
+}
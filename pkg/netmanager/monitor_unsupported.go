@@ -0,0 +1,15 @@
+//go:build !linux
+
+package netmanager
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// StartMonitor is not available on this platform, the state must be updated
+// by the embedding app with UpdateState
+func StartMonitor(_ context.Context, _ *zap.Logger, _ *NetManager) error {
+	return ErrMonitorUnsupported
+}
@@ -92,6 +92,30 @@ func (m *GroupReplicating) SetDevicePK(pk []byte) {
 	m.DevicePk = pk
 }
 
+func (m *GroupRendezvousRotationIntervalUpdated) SetDevicePK(pk []byte) {
+	m.DevicePk = pk
+}
+
 func (m *AccountVerifiedCredentialRegistered) SetDevicePK(pk []byte) {
 	m.DevicePk = pk
 }
+
+func (m *AccountKeyRotated) SetDevicePK(pk []byte) {
+	m.DevicePk = pk
+}
+
+func (m *MultiMemberGroupHistorySharingUpdated) SetDevicePK(pk []byte) {
+	m.DevicePk = pk
+}
+
+func (m *MultiMemberGroupPermissionsUpdated) SetDevicePK(pk []byte) {
+	m.DevicePk = pk
+}
+
+func (m *MultiMemberGroupMessageHidden) SetDevicePK(pk []byte) {
+	m.DevicePk = pk
+}
+
+func (m *MultiMemberGroupTreeKEMCommitted) SetDevicePK(pk []byte) {
+	m.DevicePk = pk
+}
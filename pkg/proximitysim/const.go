@@ -0,0 +1,7 @@
+package proximitysim
+
+const (
+	DefaultAddr  = "/sim/Qmeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"
+	ProtocolCode = 0x0045
+	ProtocolName = "sim"
+)
@@ -0,0 +1,223 @@
+package proximitysim
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"berty.tech/weshnet/v2/pkg/logutil"
+	proximity "berty.tech/weshnet/v2/pkg/proximitytransport"
+)
+
+// Driver is a ProximityDriver exchanging data with the other drivers of the
+// same Medium, it allows running the proximity transport without hardware.
+type Driver struct {
+	medium *Medium
+	logger *zap.Logger
+
+	localPID  string
+	x, y      float64 // position, protected by the medium lock
+	transport proximity.ProximityTransport
+
+	events   []func()
+	signal   chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	muDriver sync.Mutex
+}
+
+// Driver is a proximity.ProximityDriver.
+var _ proximity.ProximityDriver = (*Driver)(nil)
+
+// Driver is a proximity.TransportBinder.
+var _ proximity.TransportBinder = (*Driver)(nil)
+
+// Driver is a proximity.MTUDriver.
+var _ proximity.MTUDriver = (*Driver)(nil)
+
+func NewDriver(logger *zap.Logger, medium *Medium) *Driver {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	return &Driver{
+		medium: medium,
+		logger: logger.Named("ProximitySim"),
+		signal: make(chan struct{}, 1),
+	}
+}
+
+// BindTransport sets the transport handling the driver events.
+func (d *Driver) BindTransport(t proximity.ProximityTransport) {
+	d.muDriver.Lock()
+	d.transport = t
+	d.muDriver.Unlock()
+}
+
+// Start joins the medium, the driver will discover the peers in range.
+func (d *Driver) Start(localPID string) {
+	d.logger.Debug("Start", logutil.PrivateString("localPID", localPID))
+
+	d.muDriver.Lock()
+	if d.ctx != nil && d.ctx.Err() == nil {
+		d.muDriver.Unlock()
+		d.logger.Warn("Start: driver already started")
+		return
+	}
+
+	d.localPID = localPID
+	d.events = nil
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	go d.handleEvents(d.ctx)
+	d.muDriver.Unlock()
+
+	d.medium.join(d)
+}
+
+// Stop leaves the medium, peers in range will lose this driver.
+func (d *Driver) Stop() {
+	d.logger.Debug("Stop")
+
+	d.muDriver.Lock()
+	if d.ctx == nil || d.ctx.Err() != nil {
+		d.muDriver.Unlock()
+		return
+	}
+	d.cancel()
+	d.muDriver.Unlock()
+
+	d.medium.leave(d)
+}
+
+// MoveTo moves the driver to the given position of the medium.
+func (d *Driver) MoveTo(x, y float64) {
+	d.medium.move(d, x, y)
+}
+
+// MTU returns the maximum size of a packet sent to the given peer, 0 if
+// unlimited.
+func (d *Driver) MTU(remotePID string) int {
+	d.medium.mu.Lock()
+	defer d.medium.mu.Unlock()
+
+	if conditions, ok := d.medium.overrides[newLinkKey(d.localPID, remotePID)]; ok {
+		return conditions.MTU
+	}
+
+	return d.medium.conditions.MTU
+}
+
+func (d *Driver) DialPeer(remotePID string) bool {
+	return d.medium.isLinked(d.localPID, remotePID)
+}
+
+func (d *Driver) SendToPeer(remotePID string, payload []byte) bool {
+	return d.medium.send(d.localPID, remotePID, payload)
+}
+
+func (d *Driver) CloseConnWithPeer(remotePID string) {
+	d.logger.Debug("CloseConnWithPeer", logutil.PrivateString("remotePID", remotePID))
+	d.medium.disconnect(d.localPID, remotePID)
+}
+
+func (d *Driver) ProtocolCode() int {
+	return ProtocolCode
+}
+
+func (d *Driver) ProtocolName() string {
+	return ProtocolName
+}
+
+func (d *Driver) DefaultAddr() string {
+	return DefaultAddr
+}
+
+func (d *Driver) getTransport() proximity.ProximityTransport {
+	d.muDriver.Lock()
+	t := d.transport
+	d.muDriver.Unlock()
+
+	if t != nil {
+		return t
+	}
+
+	// fallback on the global transport like native drivers
+	proximity.TransportMapMutex.RLock()
+	defer proximity.TransportMapMutex.RUnlock()
+
+	if t, ok := proximity.TransportMap[ProtocolName]; ok {
+		return t
+	}
+
+	return nil
+}
+
+func (d *Driver) foundPeer(remotePID string) {
+	d.pushEvent(func() {
+		t := d.getTransport()
+		if t == nil {
+			d.logger.Error("foundPeer: no transport")
+			return
+		}
+
+		if !t.HandleFoundPeer(remotePID) {
+			d.logger.Error("foundPeer: transport refused the peer", logutil.PrivateString("remotePID", remotePID))
+			d.CloseConnWithPeer(remotePID)
+		}
+	})
+}
+
+func (d *Driver) lostPeer(remotePID string) {
+	d.pushEvent(func() {
+		if t := d.getTransport(); t != nil {
+			t.HandleLostPeer(remotePID)
+		}
+	})
+}
+
+func (d *Driver) receive(remotePID string, payload []byte) bool {
+	t := d.getTransport()
+	if t == nil {
+		return false
+	}
+
+	t.ReceiveFromPeer(remotePID, payload)
+	return true
+}
+
+// pushEvent queues an event, events are handled in order by a dedicated
+// goroutine so the medium is never blocked by the transport.
+func (d *Driver) pushEvent(event func()) {
+	d.muDriver.Lock()
+	d.events = append(d.events, event)
+	d.muDriver.Unlock()
+
+	select {
+	case d.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (d *Driver) handleEvents(ctx context.Context) {
+	for {
+		select {
+		case <-d.signal:
+		case <-ctx.Done():
+			return
+		}
+
+		for {
+			d.muDriver.Lock()
+			if len(d.events) == 0 || ctx.Err() != nil {
+				d.muDriver.Unlock()
+				break
+			}
+			event := d.events[0]
+			d.events = d.events[1:]
+			d.muDriver.Unlock()
+
+			event()
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package proximitysim_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/weshnet/v2/pkg/proximitysim"
+	proximity "berty.tech/weshnet/v2/pkg/proximitytransport"
+	"berty.tech/weshnet/v2/pkg/testutil"
+)
+
+type recorderTransport struct {
+	found    chan string
+	lost     chan string
+	received chan []byte
+}
+
+func newRecorderTransport() *recorderTransport {
+	return &recorderTransport{
+		found:    make(chan string, 16),
+		lost:     make(chan string, 16),
+		received: make(chan []byte, 16),
+	}
+}
+
+func (r *recorderTransport) HandleFoundPeer(remotePID string) bool {
+	r.found <- remotePID
+	return true
+}
+
+func (r *recorderTransport) HandleLostPeer(remotePID string) { r.lost <- remotePID }
+
+func (r *recorderTransport) ReceiveFromPeer(_ string, payload []byte) { r.received <- payload }
+
+func (r *recorderTransport) Log(int, string) {}
+
+func expectEvent[T any](t *testing.T, c <-chan T) T {
+	t.Helper()
+
+	select {
+	case v := <-c:
+		return v
+	case <-time.After(time.Second * 5):
+		require.FailNow(t, "timeout while waiting for event")
+	}
+
+	var zero T
+	return zero
+}
+
+func TestDriverDiscoveryAndRange(t *testing.T) {
+	logger, cleanup := testutil.Logger(t)
+	defer cleanup()
+
+	medium := proximitysim.NewMedium(logger, proximitysim.LinkConditions{MTU: 4})
+	medium.SetRadius(10)
+
+	transportA, transportB := newRecorderTransport(), newRecorderTransport()
+
+	driverA := proximitysim.NewDriver(logger, medium)
+	driverA.BindTransport(transportA)
+	driverB := proximitysim.NewDriver(logger, medium)
+	driverB.BindTransport(transportB)
+	driverB.MoveTo(20, 0)
+
+	driverA.Start("peerA")
+	defer driverA.Stop()
+	driverB.Start("peerB")
+	defer driverB.Stop()
+
+	// out of range
+	require.False(t, driverA.DialPeer("peerB"))
+	require.False(t, driverA.SendToPeer("peerB", []byte("hello")))
+
+	// moving in range
+	driverB.MoveTo(5, 0)
+	require.Equal(t, "peerB", expectEvent(t, transportA.found))
+	require.Equal(t, "peerA", expectEvent(t, transportB.found))
+	require.True(t, driverA.DialPeer("peerB"))
+
+	// payloads are split according to the MTU
+	require.Equal(t, 4, driverA.MTU("peerB"))
+	require.True(t, driverA.SendToPeer("peerB", []byte("hello")))
+	require.Equal(t, []byte("hell"), expectEvent(t, transportB.received))
+	require.Equal(t, []byte("o"), expectEvent(t, transportB.received))
+
+	// every packet is lost
+	medium.SetLinkConditions("peerA", "peerB", proximitysim.LinkConditions{PacketLoss: 1})
+	require.True(t, driverA.SendToPeer("peerB", []byte("lost")))
+	medium.SetLinkConditions("peerA", "peerB", proximitysim.LinkConditions{Latency: time.Millisecond * 50})
+	start := time.Now()
+	require.True(t, driverB.SendToPeer("peerA", []byte("late")))
+	require.Equal(t, []byte("late"), expectEvent(t, transportA.received))
+	require.GreaterOrEqual(t, time.Since(start), time.Millisecond*50)
+	require.Len(t, transportB.received, 0)
+
+	// moving out of range
+	driverB.MoveTo(30, 0)
+	require.Equal(t, "peerB", expectEvent(t, transportA.lost))
+	require.Equal(t, "peerA", expectEvent(t, transportB.lost))
+	require.False(t, driverA.DialPeer("peerB"))
+}
+
+func newProximityHost(t *testing.T, ctx context.Context, medium *proximitysim.Medium) host.Host {
+	t.Helper()
+
+	logger, cleanup := testutil.Logger(t)
+	t.Cleanup(cleanup)
+
+	driver := proximitysim.NewDriver(logger, medium)
+	h, err := libp2p.New(
+		libp2p.NoTransports,
+		libp2p.Transport(proximity.NewTransport(ctx, logger, driver)),
+		libp2p.ListenAddrStrings(proximitysim.DefaultAddr),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+
+	return h
+}
+
+func TestTransportOverSimulatedDriver(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	testTransportOverSimulatedDriver(t, proximitysim.LinkConditions{
+		MTU:     512,
+		Latency: time.Millisecond * 5,
+		Jitter:  time.Millisecond * 5,
+	})
+}
+
+func TestTransportOverLossySimulatedDriver(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	testTransportOverSimulatedDriver(t, proximitysim.LinkConditions{
+		MTU:        128,
+		Latency:    time.Millisecond * 5,
+		PacketLoss: 0.1,
+	})
+}
+
+func testTransportOverSimulatedDriver(t *testing.T, conditions proximitysim.LinkConditions) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	medium := proximitysim.NewMedium(nil, conditions)
+
+	hostA := newProximityHost(t, ctx, medium)
+	hostB := newProximityHost(t, ctx, medium)
+
+	const protocolID = "/proximitysim/echo/1.0.0"
+	hostB.SetStreamHandler(protocolID, func(s network.Stream) {
+		defer s.Close()
+		_, _ = io.Copy(s, s)
+	})
+
+	require.Eventually(t, func() bool {
+		return hostA.Network().Connectedness(hostB.ID()) == network.Connected
+	}, time.Second*10, time.Millisecond*100)
+
+	s, err := hostA.NewStream(ctx, hostB.ID(), protocolID)
+	require.NoError(t, err)
+
+	payload := bytes.Repeat([]byte("weshnet"), 1024)
+
+	cerr := make(chan error, 1)
+	go func() {
+		if _, err := s.Write(payload); err != nil {
+			cerr <- err
+			return
+		}
+
+		cerr <- s.CloseWrite()
+	}()
+
+	received, err := io.ReadAll(s)
+	require.NoError(t, err)
+	require.Equal(t, payload, received)
+	require.NoError(t, <-cerr)
+
+	require.Equal(t, []peer.ID{hostB.ID()}, hostA.Network().Peers())
+}
@@ -0,0 +1,353 @@
+package proximitysim
+
+import (
+	"context"
+	"math"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"moul.io/srand"
+
+	"berty.tech/weshnet/v2/pkg/logutil"
+)
+
+// LinkConditions describes the radio conditions between two devices.
+type LinkConditions struct {
+	// MTU is the maximum size of a packet, bigger payloads are split in
+	// multiple packets. 0 means unlimited.
+	MTU int
+
+	// Latency is the delay before a packet is delivered.
+	Latency time.Duration
+
+	// Jitter is the maximum random delay added to the latency. Packets are
+	// still delivered in order.
+	Jitter time.Duration
+
+	// PacketLoss is the probability, between 0 and 1, for a packet to be lost.
+	PacketLoss float64
+}
+
+// Medium simulates the radio environment shared by a set of drivers.
+// Started drivers discover each other when they are in range, and lose each
+// other when they move away or stop.
+type Medium struct {
+	logger     *zap.Logger
+	conditions LinkConditions
+	overrides  map[linkKey]LinkConditions
+	radius     float64
+
+	drivers map[string]*Driver // started drivers by peer ID
+	links   map[linkKey]*link
+	closed  map[linkKey]struct{}
+	rand    *mrand.Rand
+	mu      sync.Mutex
+}
+
+// NewMedium returns a new Medium applying the given conditions to every link.
+func NewMedium(logger *zap.Logger, conditions LinkConditions) *Medium {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	return &Medium{
+		logger:     logger.Named("ProximitySim"),
+		conditions: conditions,
+		overrides:  make(map[linkKey]LinkConditions),
+		drivers:    make(map[string]*Driver),
+		links:      make(map[linkKey]*link),
+		closed:     make(map[linkKey]struct{}),
+		rand:       mrand.New(mrand.NewSource(srand.MustSecure())), // nolint:gosec
+	}
+}
+
+// SetRadius sets the range of the drivers, peers farther than the radius
+// can't see each other. A radius of 0 means an unlimited range.
+func (m *Medium) SetRadius(radius float64) {
+	m.mu.Lock()
+	m.radius = radius
+	events := m.updateNeighbors()
+	m.mu.Unlock()
+
+	events.dispatch()
+}
+
+// SetConditions sets the default conditions of the links.
+func (m *Medium) SetConditions(conditions LinkConditions) {
+	m.mu.Lock()
+	m.conditions = conditions
+	m.mu.Unlock()
+}
+
+// SetLinkConditions overrides the conditions of the link between two peers.
+func (m *Medium) SetLinkConditions(peerA, peerB string, conditions LinkConditions) {
+	m.mu.Lock()
+	m.overrides[newLinkKey(peerA, peerB)] = conditions
+	m.mu.Unlock()
+}
+
+// Rediscover lets peers whose connection has been closed by the transport
+// discover each other again if they are still in range.
+func (m *Medium) Rediscover() {
+	m.mu.Lock()
+	m.closed = make(map[linkKey]struct{})
+	events := m.updateNeighbors()
+	m.mu.Unlock()
+
+	events.dispatch()
+}
+
+func (m *Medium) join(d *Driver) {
+	m.mu.Lock()
+	m.drivers[d.localPID] = d
+	events := m.updateNeighbors()
+	m.mu.Unlock()
+
+	events.dispatch()
+}
+
+func (m *Medium) leave(d *Driver) {
+	m.mu.Lock()
+	delete(m.drivers, d.localPID)
+	events := m.updateNeighbors()
+	m.mu.Unlock()
+
+	events.dispatch()
+}
+
+func (m *Medium) move(d *Driver, x, y float64) {
+	m.mu.Lock()
+	d.x, d.y = x, y
+	events := m.updateNeighbors()
+	m.mu.Unlock()
+
+	events.dispatch()
+}
+
+func (m *Medium) isLinked(localPID, remotePID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.links[newLinkKey(localPID, remotePID)]
+	return ok
+}
+
+// disconnect closes the link between two peers, only the remote peer is
+// notified as the local one initiated the disconnection.
+func (m *Medium) disconnect(localPID, remotePID string) {
+	key := newLinkKey(localPID, remotePID)
+
+	m.mu.Lock()
+	l, ok := m.links[key]
+	if ok {
+		delete(m.links, key)
+		m.closed[key] = struct{}{}
+		l.close()
+	}
+	remote := m.drivers[remotePID]
+	m.mu.Unlock()
+
+	if ok && remote != nil {
+		remote.lostPeer(localPID)
+	}
+}
+
+func (m *Medium) send(localPID, remotePID string, payload []byte) bool {
+	key := newLinkKey(localPID, remotePID)
+
+	m.mu.Lock()
+	l, ok := m.links[key]
+	conditions, overridden := m.overrides[key]
+	if !overridden {
+		conditions = m.conditions
+	}
+
+	// split the payload according to the MTU and roll the dice for each
+	// packet, an empty payload is sent as a single empty packet
+	now := time.Now()
+	packets := []packet{}
+	for first := true; first || len(payload) > 0; first = false {
+		size := len(payload)
+		if conditions.MTU > 0 && size > conditions.MTU {
+			size = conditions.MTU
+		}
+
+		data := make([]byte, size)
+		copy(data, payload[:size])
+		payload = payload[size:]
+
+		if conditions.PacketLoss > 0 && m.rand.Float64() < conditions.PacketLoss {
+			m.logger.Debug("packet lost", logutil.PrivateString("remotePID", remotePID), zap.Int("size", size))
+			continue
+		}
+
+		delay := conditions.Latency
+		if conditions.Jitter > 0 {
+			delay += time.Duration(m.rand.Int63n(int64(conditions.Jitter)))
+		}
+
+		packets = append(packets, packet{data: data, deliverAt: now.Add(delay)})
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	return l.send(localPID, packets)
+}
+
+// updateNeighbors must be called with the medium locked, it returns the
+// events to dispatch once the lock has been released.
+func (m *Medium) updateNeighbors() (events mediumEvents) {
+	// drop links of peers which are not reachable anymore
+	for key, l := range m.links {
+		a, aok := m.drivers[key.a]
+		b, bok := m.drivers[key.b]
+		if aok && bok && m.inRange(a, b) {
+			continue
+		}
+
+		delete(m.links, key)
+		l.close()
+
+		if aok {
+			events = append(events, func() { a.lostPeer(key.b) })
+		}
+		if bok {
+			events = append(events, func() { b.lostPeer(key.a) })
+		}
+	}
+
+	// forget closed links of peers which are not in range anymore, so they
+	// can discover each other again when coming back
+	for key := range m.closed {
+		a, aok := m.drivers[key.a]
+		b, bok := m.drivers[key.b]
+		if !aok || !bok || !m.inRange(a, b) {
+			delete(m.closed, key)
+		}
+	}
+
+	// create links between new neighbors
+	for _, a := range m.drivers {
+		for _, b := range m.drivers {
+			if a.localPID >= b.localPID || !m.inRange(a, b) {
+				continue
+			}
+
+			key := newLinkKey(a.localPID, b.localPID)
+			if _, ok := m.links[key]; ok {
+				continue
+			}
+			if _, ok := m.closed[key]; ok {
+				continue
+			}
+
+			m.links[key] = newLink(m.logger, a, b)
+
+			events = append(events, func() { a.foundPeer(b.localPID) })
+			events = append(events, func() { b.foundPeer(a.localPID) })
+		}
+	}
+
+	return events
+}
+
+func (m *Medium) inRange(a, b *Driver) bool {
+	if m.radius <= 0 {
+		return true
+	}
+
+	return math.Hypot(a.x-b.x, a.y-b.y) <= m.radius
+}
+
+type mediumEvents []func()
+
+func (e mediumEvents) dispatch() {
+	for _, event := range e {
+		event()
+	}
+}
+
+type linkKey struct {
+	a, b string
+}
+
+func newLinkKey(peerA, peerB string) linkKey {
+	if peerA > peerB {
+		peerA, peerB = peerB, peerA
+	}
+
+	return linkKey{a: peerA, b: peerB}
+}
+
+type packet struct {
+	data      []byte
+	deliverAt time.Time
+}
+
+// link is an established connection between two drivers, packets are
+// delivered in order in each direction.
+type link struct {
+	queues map[string]chan []packet // by sender peer ID
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newLink(logger *zap.Logger, a, b *Driver) *link {
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &link{
+		queues: map[string]chan []packet{
+			a.localPID: make(chan []packet, 64),
+			b.localPID: make(chan []packet, 64),
+		},
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	go l.deliver(logger, l.queues[a.localPID], a.localPID, b)
+	go l.deliver(logger, l.queues[b.localPID], b.localPID, a)
+
+	return l
+}
+
+func (l *link) send(senderPID string, packets []packet) bool {
+	select {
+	case l.queues[senderPID] <- packets:
+		return true
+	case <-l.ctx.Done():
+		return false
+	}
+}
+
+func (l *link) deliver(logger *zap.Logger, queue <-chan []packet, senderPID string, receiver *Driver) {
+	for {
+		var packets []packet
+		select {
+		case packets = <-queue:
+		case <-l.ctx.Done():
+			return
+		}
+
+		for _, p := range packets {
+			if delay := time.Until(p.deliverAt); delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-l.ctx.Done():
+					return
+				}
+			}
+
+			if !receiver.receive(senderPID, p.data) {
+				logger.Debug("packet dropped: no transport", logutil.PrivateString("remotePID", senderPID))
+			}
+		}
+	}
+}
+
+func (l *link) close() {
+	l.cancel()
+}
@@ -3,8 +3,8 @@ package proximitytransport
 import (
 	"context"
 	"fmt"
-	"io"
 	"net"
+	"os"
 	"sync"
 	"time"
 
@@ -22,12 +22,27 @@ import (
 // Conn is a manet.Conn.
 var _ manet.Conn = &Conn{}
 
+var (
+	// ConnReadBufferSize is the amount of data buffered for a connection
+	// before applying backpressure on the native driver.
+	ConnReadBufferSize = 256 * 1024
+
+	// ConnReceiveQueueSize is the number of payloads received from the native
+	// driver queued for a connection. The driver is never blocked, payloads
+	// received while the queue is full are dropped and the framing layer
+	// sends them again.
+	ConnReceiveQueueSize = 256
+)
+
 // Conn is the equivalent of a net.Conn object. It is the
 // result of calling the Dial or Listen functions in this
 // package, with associated local and remote Multiaddrs.
 type Conn struct {
-	readIn  *io.PipeWriter
-	readOut *io.PipeReader
+	readBuf       *readBuffer
+	readDeadline  deadline
+	writeDeadline deadline
+	writeSlot     chan struct{} // only one payload is sent at a time
+	framer        *framer
 
 	localMa  ma.Multiaddr
 	remoteMa ma.Multiaddr
@@ -57,20 +72,21 @@ func newConn(ctx context.Context, t *proximityTransport, remoteMa ma.Multiaddr,
 	}
 
 	// Creates a manet.Conn
-	pr, pw := io.Pipe()
 	connCtx, cancel := context.WithCancel(t.listener.ctx)
 
 	maconn := &Conn{
-		readIn:    pw,
-		readOut:   pr,
-		localMa:   t.listener.localMa,
-		remoteMa:  remoteMa,
-		ready:     false,
-		cache:     NewRingBufferMap(t.logger, 128),
-		mp:        newMplex(connCtx, t.logger),
-		ctx:       connCtx,
-		cancel:    cancel,
-		transport: t,
+		readBuf:       newReadBuffer(ConnReadBufferSize),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+		writeSlot:     make(chan struct{}, 1),
+		localMa:       t.listener.localMa,
+		remoteMa:      remoteMa,
+		ready:         false,
+		cache:         NewRingBufferMap(t.logger, 128),
+		mp:            newMplex(connCtx, t.logger),
+		ctx:           connCtx,
+		cancel:        cancel,
+		transport:     t,
 	}
 
 	// Stores the conn in connMap, will be deleted during conn.Close()
@@ -78,36 +94,45 @@ func newConn(ctx context.Context, t *proximityTransport, remoteMa ma.Multiaddr,
 	t.connMap[maconn.RemoteAddr().String()] = maconn
 	t.connMapMutex.Unlock()
 
+	// Configure the framing layer, it writes received payloads in order into
+	// the read buffer
+	maconn.framer = newFramer(connCtx, t.logger, t.driver, maconn.RemoteAddr().String(), maconn.readBuf, maconn.closeWithError)
+
 	// Configure mplex and run it
 	maconn.mp.addInputCache(t.cache)
 	maconn.mp.addInputCache(maconn.cache)
-	maconn.mp.setOutput(pw)
+	maconn.mp.setOutput(maconn.framer)
+	maconn.framer.negotiate()
 
 	// Returns an upgraded CapableConn (muxed, addr filtered, secured, etc...)
 	return t.upgrader.Upgrade(ctx, t, maconn, netdir, remotePID, connScope)
 }
 
 // Read reads data from the connection.
-// Timeout handled by the native driver.
+// Read blocks until data is received, the connection is closed or the read
+// deadline is exceeded.
 func (c *Conn) Read(payload []byte) (n int, err error) {
 	c.transport.logger.Debug("Conn.Read", logutil.PrivateString("remoteAddr", c.RemoteAddr().String()))
-	if c.ctx.Err() != nil {
-		c.transport.logger.Error("Conn.Read failed: conn already closed")
-		return 0, fmt.Errorf("error: Conn.Read failed: conn already closed")
-	}
 
-	n, err = c.readOut.Read(payload)
-	if err != nil {
-		err = errors.Wrap(err, "error: Conn.Read failed: native read failed")
-		c.transport.logger.Error("Conn.Read", zap.Error(err))
-	} else {
+	n, err = c.readBuf.Read(payload, c.readDeadline.wait())
+	switch {
+	case err == nil:
 		c.transport.logger.Debug("Conn.Read successful")
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		c.transport.logger.Debug("Conn.Read: deadline exceeded")
+	default:
+		err = errors.Wrap(err, "error: Conn.Read failed")
+		c.transport.logger.Error("Conn.Read", zap.Error(err))
 	}
 	return n, err
 }
 
 // Write writes data to the connection.
-// Timeout handled by the native driver.
+// Payloads are split into frames handed to the native driver one at a time,
+// Write blocks until the driver accepted the frames or the write deadline is
+// exceeded. Frames are sent again until the remote peer acknowledges them, so
+// on deadline the number of bytes of the frames already handed off is
+// returned.
 func (c *Conn) Write(payload []byte) (n int, err error) {
 	c.transport.logger.Debug("Conn.Write", logutil.PrivateString("remoteAddr", c.RemoteAddr().String()), logutil.PrivateBinary("payload", payload))
 	if c.ctx.Err() != nil {
@@ -121,17 +146,40 @@ func (c *Conn) Write(payload []byte) (n int, err error) {
 			c.ready = true
 		}
 		c.Unlock()
-		go c.mp.run(c.RemoteAddr().String())
+		go func() {
+			if err := c.mp.run(c.RemoteAddr().String()); err != nil {
+				c.closeWithError(err)
+			}
+		}()
+	}
+
+	// Wait for the previous payload to be sent.
+	select {
+	case c.writeSlot <- struct{}{}:
+	case <-c.writeDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	case <-c.ctx.Done():
+		return 0, fmt.Errorf("error: Conn.Write failed: conn already closed")
 	}
 
-	// Write to the peer's device using native driver.
-	if !c.transport.driver.SendToPeer(c.RemoteAddr().String(), payload) {
-		c.transport.logger.Error("Conn.Write failed")
-		return 0, fmt.Errorf("error: Conn.Write failed: native write failed")
+	defer func() { <-c.writeSlot }()
+
+	// Write to the peer's device using native driver, the deadline is checked
+	// before handing off each frame.
+	n, err = c.framer.send(payload, c.writeDeadline.wait())
+	switch {
+	case err == nil:
+		c.transport.logger.Debug("Conn.Write successful")
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		c.transport.logger.Debug("Conn.Write: deadline exceeded", zap.Int("written", n))
+	case c.ctx.Err() != nil:
+		err = fmt.Errorf("error: Conn.Write failed: conn already closed")
+	default:
+		c.transport.logger.Error("Conn.Write failed", zap.Error(err))
+		err = errors.Wrap(err, "error: Conn.Write failed")
 	}
-	c.transport.logger.Debug("Conn.Write successful")
 
-	return len(payload), nil
+	return n, err
 }
 
 // Close closes the connection.
@@ -140,9 +188,8 @@ func (c *Conn) Close() error {
 	c.transport.logger.Debug("Conn.Close()")
 	c.cancel()
 
-	// Closes read pipe
-	c.readIn.Close()
-	c.readOut.Close()
+	// Unblocks readers and the driver
+	c.readBuf.closeWithError(net.ErrClosed)
 
 	// Removes conn from connmgr's connMap
 	c.transport.connMapMutex.Lock()
@@ -155,6 +202,14 @@ func (c *Conn) Close() error {
 	return nil
 }
 
+// closeWithError closes the connection, pending and future reads will
+// return the given error.
+func (c *Conn) closeWithError(err error) {
+	c.transport.logger.Error("Conn closed", zap.Error(err))
+	c.readBuf.closeWithError(err)
+	c.Close()
+}
+
 // isReady tells if  libp2p is ready to accept input connections
 func (c *Conn) isReady() bool {
 	c.Lock()
@@ -188,13 +243,21 @@ func (c *Conn) LocalMultiaddr() ma.Multiaddr { return c.localMa }
 // with this connection.
 func (c *Conn) RemoteMultiaddr() ma.Multiaddr { return c.remoteMa }
 
-// Noop deadline methods, handled by the native driver.
-
-// SetDeadline does nothing
-func (c *Conn) SetDeadline(time.Time) error { return nil }
+// SetDeadline sets the read and write deadlines.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
 
-// SetReadDeadline does nothing
-func (c *Conn) SetReadDeadline(time.Time) error { return nil }
+// SetReadDeadline sets the deadline for future and pending Read calls.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
 
-// SetWriteDeadline does nothing
-func (c *Conn) SetWriteDeadline(time.Time) error { return nil }
+// SetWriteDeadline sets the deadline for future and pending Write calls.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
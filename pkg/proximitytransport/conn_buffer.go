@@ -0,0 +1,175 @@
+package proximitytransport
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrBufferOverflow is returned when the data received from a peer could not
+// be buffered, the stream is then incomplete and the connection is closed.
+var ErrBufferOverflow = errors.New("proximity transport buffer overflow")
+
+// readBuffer is a bounded buffer between the native driver and the libp2p
+// reader. Writers are blocked while the buffer is full, the frames are then
+// not acknowledged which propagates backpressure up to the remote sender.
+type readBuffer struct {
+	buf      bytes.Buffer
+	limit    int
+	err      error
+	readable chan struct{}
+	writable chan struct{}
+	done     chan struct{}
+	mu       sync.Mutex
+}
+
+func newReadBuffer(limit int) *readBuffer {
+	return &readBuffer{
+		limit:    limit,
+		readable: make(chan struct{}, 1),
+		writable: make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// Write appends the payload to the buffer, waiting for the reader to consume
+// data if the buffer is full.
+func (b *readBuffer) Write(payload []byte) (int, error) {
+	for {
+		b.mu.Lock()
+		if b.err != nil {
+			b.mu.Unlock()
+			return 0, b.err
+		}
+
+		if b.buf.Len() < b.limit {
+			b.buf.Write(payload)
+			b.mu.Unlock()
+			signal(b.readable)
+			return len(payload), nil
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-b.writable:
+		case <-b.done:
+		}
+	}
+}
+
+// Read reads buffered data, waiting until data is available, the buffer is
+// closed or the deadline is exceeded.
+func (b *readBuffer) Read(payload []byte, deadline <-chan struct{}) (int, error) {
+	for {
+		b.mu.Lock()
+		if b.buf.Len() > 0 {
+			n, _ := b.buf.Read(payload)
+			b.mu.Unlock()
+			signal(b.writable)
+			return n, nil
+		}
+
+		if b.err != nil {
+			b.mu.Unlock()
+			return 0, b.err
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-b.readable:
+		case <-b.done:
+		case <-deadline:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// closeWithError unblocks readers and writers, only the first error is kept.
+func (b *readBuffer) closeWithError(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.err != nil {
+		return
+	}
+
+	b.err = err
+	close(b.done)
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// deadline is an abstraction for handling timeouts, adapted from net.Pipe.
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{} // Must be non-nil
+}
+
+func makeDeadline() deadline {
+	return deadline{cancel: make(chan struct{})}
+}
+
+// set sets the point in time when the deadline will time out.
+// A timeout event is signaled by closing the channel returned by wait.
+// Once a timeout has occurred, the deadline can be refreshed by specifying a
+// t value in the future.
+//
+// A zero value for t prevents timeout.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // Wait for the timer callback to finish and close cancel
+	}
+	d.timer = nil
+
+	// Time is zero, then there is no deadline.
+	closed := isClosedChan(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	// Time in the future, setup a timer to cancel in the future.
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		d.timer = time.AfterFunc(dur, func() {
+			close(d.cancel)
+		})
+		return
+	}
+
+	// Time in the past, so close immediately.
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns a channel that is closed when the deadline is exceeded.
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+func isClosedChan(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,96 @@
+package proximitytransport
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestReadBufferBackpressure(t *testing.T) {
+	buf := newReadBuffer(4)
+
+	_, err := buf.Write([]byte("1234"))
+	require.NoError(t, err)
+
+	// the buffer is full, the writer is blocked until data is read
+	written := make(chan struct{})
+	go func() {
+		_, err := buf.Write([]byte("5678"))
+		require.NoError(t, err)
+		close(written)
+	}()
+
+	select {
+	case <-written:
+		require.FailNow(t, "write should be blocked")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	payload := make([]byte, 4)
+	n, err := buf.Read(payload, nil)
+	require.NoError(t, err)
+	require.Equal(t, "1234", string(payload[:n]))
+
+	select {
+	case <-written:
+	case <-time.After(time.Second * 5):
+		require.FailNow(t, "write should be unblocked")
+	}
+
+	n, err = buf.Read(payload, nil)
+	require.NoError(t, err)
+	require.Equal(t, "5678", string(payload[:n]))
+
+	// pending reads are unblocked by the close error
+	buf.closeWithError(ErrBufferOverflow)
+	_, err = buf.Read(payload, nil)
+	require.ErrorIs(t, err, ErrBufferOverflow)
+	_, err = buf.Write(payload)
+	require.ErrorIs(t, err, ErrBufferOverflow)
+
+	// only the first error is kept
+	buf.closeWithError(net.ErrClosed)
+	_, err = buf.Read(payload, nil)
+	require.ErrorIs(t, err, ErrBufferOverflow)
+}
+
+func TestReadBufferDeadline(t *testing.T) {
+	buf := newReadBuffer(4)
+	d := makeDeadline()
+
+	d.set(time.Now().Add(time.Millisecond * 50))
+	_, err := buf.Read(make([]byte, 4), d.wait())
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	// the deadline can be extended once exceeded
+	d.set(time.Time{})
+	_, err = buf.Write([]byte("1234"))
+	require.NoError(t, err)
+	n, err := buf.Read(make([]byte, 4), d.wait())
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+
+	// a deadline in the past times out immediately
+	d.set(time.Now().Add(-time.Second))
+	_, err = buf.Read(make([]byte, 4), d.wait())
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestRingBufferMapOverflow(t *testing.T) {
+	rbm := NewRingBufferMap(zap.NewNop(), 2)
+
+	require.False(t, rbm.Add("peer", []byte("1")))
+	require.False(t, rbm.Add("peer", []byte("2")))
+	require.False(t, rbm.Overflowed("peer"))
+
+	require.True(t, rbm.Add("peer", []byte("3")))
+	require.True(t, rbm.Overflowed("peer"))
+	require.False(t, rbm.Overflowed("other"))
+
+	rbm.Delete("peer")
+	require.False(t, rbm.Overflowed("peer"))
+}
@@ -0,0 +1,580 @@
+package proximitytransport
+
+/*
+  The framing layer makes the connection reliable whatever the native driver
+  guarantees. Payloads written on a Conn are split into frames fitting the MTU
+  reported by the driver, each frame carries a sequence number and a checksum.
+  The receiver drops corrupted frames, reorders the other ones and delivers
+  them in order, acknowledging the next expected sequence number. Frames that
+  are not acknowledged in time are sent again.
+
+  Framing is negotiated when the connection is created: each peer sends an
+  empty payload, which is ignored by the peers that don't support framing,
+  and replies to the empty payload of the remote peer with a hello frame
+  carrying its framing version. A peer receiving one of them frames its
+  payloads. When a payload which is not a frame is received first, or when
+  nothing is received before FrameNegotiationTimeout, the raw payloads are
+  exchanged as they were before the framing layer.
+
+  Frame layout:
+  +------+-------------+------------+---------+
+  | type | seq (4 B)   | crc32 (4 B)| payload |
+  +------+-------------+------------+---------+
+*/
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"berty.tech/weshnet/v2/pkg/logutil"
+)
+
+const (
+	frameTypeData  byte = 0x01
+	frameTypeAck   byte = 0x02
+	frameTypeHello byte = 0x03
+
+	// FrameHeaderSize is the size of the header prepended to every frame.
+	FrameHeaderSize = 9
+
+	// FramingVersion is the version of the framing layer sent in the hello
+	// frames, the sequence number field carries it.
+	FramingVersion uint32 = 1
+)
+
+type framingMode int
+
+const (
+	framingUnknown framingMode = iota
+	framingEnabled
+	framingRaw
+)
+
+var (
+	// DefaultFrameSize is the size of the frames sent to drivers that don't
+	// report their MTU.
+	DefaultFrameSize = 16 * 1024
+
+	// FrameWindowSize is the maximum number of frames sent and not yet
+	// acknowledged, it is also the number of out of order frames buffered by
+	// the receiver.
+	FrameWindowSize = 64
+
+	// FrameRetransmitTimeout is the delay before a frame which has not been
+	// acknowledged is sent again, it doubles on each retransmission.
+	FrameRetransmitTimeout = 500 * time.Millisecond
+
+	// FrameMaxRetransmitTimeout caps the retransmission delay.
+	FrameMaxRetransmitTimeout = 8 * time.Second
+
+	// FrameMaxRetransmissions is the number of retransmissions of a frame
+	// after which the connection is closed.
+	FrameMaxRetransmissions = 10
+
+	// FrameNegotiationTimeout is the time to wait for the remote peer to
+	// advertise framing before falling back on raw payloads.
+	FrameNegotiationTimeout = 2 * time.Second
+
+	// ErrRetransmissionsExceeded is returned when a frame could not be
+	// delivered to the remote peer.
+	ErrRetransmissionsExceeded = errors.New("proximity transport: frame retransmissions exceeded")
+
+	errInvalidFrame = errors.New("invalid frame")
+)
+
+// MTUDriver is implemented by drivers that know the maximum size of a packet
+// delivered intact to a peer.
+type MTUDriver interface {
+	// Return the maximum size of a packet sent to the remote peer, 0 if unknown
+	MTU(remotePID string) int
+}
+
+type sentFrame struct {
+	seq     uint32
+	data    []byte
+	sentAt  time.Time
+	timeout time.Duration
+	retries int
+}
+
+// framer implements the framing layer of a connection. Incoming frames are
+// written to it by the mplex, the payloads are written in order to the output.
+type framer struct {
+	remotePID string
+	driver    ProximityDriver
+	output    io.Writer
+	onError   func(err error)
+	logger    *zap.Logger
+
+	// negotiation
+	mode          framingMode
+	remoteVersion uint32
+	negotiated    chan struct{} // closed once the mode is known
+	muMode        sync.RWMutex
+
+	// sender side
+	nextSeq  uint32
+	unacked  []*sentFrame // ordered by sequence number
+	dupAcks  int
+	writable chan struct{}
+	muSend   sync.Mutex
+
+	// receiver side, the payloads are queued in order and written to the
+	// output by deliverLoop so a full output never blocks the incoming frames
+	expected    uint32 // next sequence number to queue
+	delivered   uint32 // next sequence number to write to the output
+	pending     map[uint32][]byte
+	queued      [][]byte
+	deliverable chan struct{}
+	muRecv      sync.Mutex
+
+	ctx context.Context
+}
+
+func newFramer(ctx context.Context, logger *zap.Logger, driver ProximityDriver, remotePID string, output io.Writer, onError func(err error)) *framer {
+	f := &framer{
+		remotePID:   remotePID,
+		driver:      driver,
+		output:      output,
+		onError:     onError,
+		logger:      logger,
+		negotiated:  make(chan struct{}),
+		writable:    make(chan struct{}, 1),
+		pending:     make(map[uint32][]byte),
+		deliverable: make(chan struct{}, 1),
+		ctx:         ctx,
+	}
+
+	go f.retransmitLoop(time.NewTicker(FrameRetransmitTimeout / 2))
+	go f.deliverLoop()
+
+	return f
+}
+
+// negotiate advertises framing to the remote peer until it advertises it
+// too, the raw mode is used if it doesn't in time. Drivers unable to send
+// empty payloads always fall back on raw payloads.
+func (f *framer) negotiate() {
+	f.driver.SendToPeer(f.remotePID, []byte{})
+
+	ticker := time.NewTicker(FrameRetransmitTimeout)
+	timeout := time.NewTimer(FrameNegotiationTimeout)
+
+	go func() {
+		defer ticker.Stop()
+		defer timeout.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				f.driver.SendToPeer(f.remotePID, []byte{})
+			case <-timeout.C:
+				if f.setMode(framingRaw, 0) {
+					f.logger.Info("framer: framing not supported by the remote peer, using raw payloads", logutil.PrivateString("remotePID", f.remotePID))
+				}
+				return
+			case <-f.negotiated:
+				return
+			case <-f.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// setMode sets the framing mode if it is not known yet, it returns false
+// otherwise.
+func (f *framer) setMode(mode framingMode, remoteVersion uint32) bool {
+	f.muMode.Lock()
+	defer f.muMode.Unlock()
+
+	if f.mode != framingUnknown {
+		return false
+	}
+
+	f.mode = mode
+	f.remoteVersion = remoteVersion
+	close(f.negotiated)
+
+	f.logger.Debug("framer: mode negotiated", zap.Bool("framing", mode == framingEnabled), zap.Uint32("remoteVersion", remoteVersion))
+	return true
+}
+
+func (f *framer) getMode() framingMode {
+	f.muMode.RLock()
+	defer f.muMode.RUnlock()
+
+	return f.mode
+}
+
+// isRaw returns true unless framing has been negotiated, the payloads
+// received can't be dropped in this case.
+func (f *framer) isRaw() bool {
+	return f.getMode() != framingEnabled
+}
+
+// waitNegotiation waits for the framing mode to be known.
+func (f *framer) waitNegotiation(cancel <-chan struct{}) (framingMode, error) {
+	select {
+	case <-f.negotiated:
+		return f.getMode(), nil
+	case <-cancel:
+		return framingUnknown, os.ErrDeadlineExceeded
+	case <-f.ctx.Done():
+		return framingUnknown, f.ctx.Err()
+	}
+}
+
+// frameSize returns the maximum size of the payload of a data frame.
+func (f *framer) frameSize() int {
+	size := DefaultFrameSize
+	if d, ok := f.driver.(MTUDriver); ok {
+		if mtu := d.MTU(f.remotePID); mtu > 0 {
+			size = mtu
+		}
+	}
+
+	if size <= FrameHeaderSize {
+		return 1
+	}
+	return size - FrameHeaderSize
+}
+
+// send splits the payload into frames and sends them, waiting for the remote
+// peer to acknowledge previous frames when the window is full. A registered
+// frame is sent until it is acknowledged, so when cancel is closed send stops
+// before the next frame and returns the number of bytes already registered.
+func (f *framer) send(payload []byte, cancel <-chan struct{}) (n int, err error) {
+	mode, err := f.waitNegotiation(cancel)
+	if err != nil {
+		return 0, err
+	}
+
+	if mode == framingRaw {
+		if !f.driver.SendToPeer(f.remotePID, payload) {
+			return 0, fmt.Errorf("native write failed")
+		}
+		return len(payload), nil
+	}
+
+	frameSize := f.frameSize()
+
+	for n < len(payload) {
+		size := min(len(payload)-n, frameSize)
+
+		frame, err := f.reserve(payload[n:n+size], cancel)
+		if err != nil {
+			return n, err
+		}
+		n += size
+
+		if !f.driver.SendToPeer(f.remotePID, frame.data) {
+			return n, fmt.Errorf("native write failed")
+		}
+	}
+
+	return n, nil
+}
+
+// reserve waits for a free slot in the window and registers a new frame.
+func (f *framer) reserve(payload []byte, cancel <-chan struct{}) (*sentFrame, error) {
+	for {
+		select {
+		case <-cancel:
+			return nil, os.ErrDeadlineExceeded
+		default:
+		}
+
+		f.muSend.Lock()
+		if len(f.unacked) < FrameWindowSize {
+			frame := &sentFrame{
+				seq:     f.nextSeq,
+				data:    encodeFrame(frameTypeData, f.nextSeq, payload),
+				sentAt:  time.Now(),
+				timeout: FrameRetransmitTimeout,
+			}
+			f.nextSeq++
+			f.unacked = append(f.unacked, frame)
+			f.muSend.Unlock()
+			return frame, nil
+		}
+		f.muSend.Unlock()
+
+		select {
+		case <-f.writable:
+		case <-cancel:
+			return nil, os.ErrDeadlineExceeded
+		case <-f.ctx.Done():
+			return nil, f.ctx.Err()
+		}
+	}
+}
+
+// Write handles a payload received from the native driver.
+func (f *framer) Write(data []byte) (int, error) {
+	// an empty payload advertises framing
+	if len(data) == 0 {
+		f.handleProbe()
+		return 0, nil
+	}
+
+	mode := f.getMode()
+	if mode == framingRaw {
+		return f.output.Write(data)
+	}
+
+	typ, seq, payload, err := decodeFrame(data)
+	switch {
+	case err != nil && mode == framingUnknown:
+		// the remote peer doesn't frame its payloads
+		if f.setMode(framingRaw, 0) {
+			f.logger.Info("framer: raw payload received, using raw payloads", logutil.PrivateString("remotePID", f.remotePID))
+		}
+		return f.output.Write(data)
+	case err != nil:
+		// corrupted frames are dropped, they will be sent again
+		f.logger.Warn("framer: frame dropped", zap.Error(err), logutil.PrivateString("remotePID", f.remotePID))
+		return len(data), nil
+	}
+
+	switch typ {
+	case frameTypeHello:
+		f.setMode(framingEnabled, seq)
+	case frameTypeData:
+		f.setMode(framingEnabled, FramingVersion)
+		f.handleData(seq, payload)
+	case frameTypeAck:
+		f.setMode(framingEnabled, FramingVersion)
+		f.handleAck(seq)
+	}
+
+	return len(data), nil
+}
+
+// handleProbe replies to the empty payload of a remote peer supporting
+// framing with our framing version, every probe is answered as the remote
+// peer sends them until it received a reply.
+func (f *framer) handleProbe() {
+	if f.getMode() == framingRaw {
+		return
+	}
+
+	f.setMode(framingEnabled, FramingVersion)
+	f.driver.SendToPeer(f.remotePID, encodeFrame(frameTypeHello, FramingVersion, nil))
+}
+
+func (f *framer) handleData(seq uint32, payload []byte) {
+	f.muRecv.Lock()
+
+	switch {
+	case seqLess(seq, f.expected):
+		// duplicate, our ack has probably been lost
+	case seq-f.delivered >= uint32(FrameWindowSize):
+		f.logger.Warn("framer: frame out of window", zap.Uint32("seq", seq), zap.Uint32("expected", f.expected))
+	default:
+		f.pending[seq] = append([]byte(nil), payload...)
+	}
+
+	// queue consecutive frames
+	queued := false
+	for {
+		payload, ok := f.pending[f.expected]
+		if !ok {
+			break
+		}
+		delete(f.pending, f.expected)
+
+		f.queued = append(f.queued, payload)
+		f.expected++
+		queued = true
+	}
+
+	ack := f.delivered
+	f.muRecv.Unlock()
+
+	if queued {
+		signal(f.deliverable)
+	} else {
+		f.sendAck(ack)
+	}
+}
+
+// deliverLoop writes the queued payloads to the output, they are
+// acknowledged once written so a full output slows down the remote sender.
+func (f *framer) deliverLoop() {
+	for {
+		select {
+		case <-f.deliverable:
+		case <-f.ctx.Done():
+			return
+		}
+
+		for {
+			f.muRecv.Lock()
+			if len(f.queued) == 0 {
+				ack := f.delivered
+				f.muRecv.Unlock()
+
+				f.sendAck(ack)
+				break
+			}
+			payload := f.queued[0]
+			f.queued = f.queued[1:]
+			f.muRecv.Unlock()
+
+			// the output may block, the lock is released meanwhile
+			if _, err := f.output.Write(payload); err != nil {
+				f.logger.Debug("framer: unable to write payload", zap.Error(err))
+				return
+			}
+
+			f.muRecv.Lock()
+			f.delivered++
+			f.muRecv.Unlock()
+		}
+	}
+}
+
+func (f *framer) sendAck(ack uint32) {
+	f.driver.SendToPeer(f.remotePID, encodeFrame(frameTypeAck, ack, nil))
+}
+
+// handleAck removes the acknowledged frames, ack is the next sequence number
+// expected by the remote peer.
+func (f *framer) handleAck(ack uint32) {
+	f.muSend.Lock()
+
+	acked := 0
+	for acked < len(f.unacked) && seqLess(f.unacked[acked].seq, ack) {
+		acked++
+	}
+
+	if acked > 0 {
+		// the link is alive again, restart the backoff of pending frames
+		f.unacked = f.unacked[acked:]
+		for _, frame := range f.unacked {
+			frame.timeout = FrameRetransmitTimeout
+		}
+		f.dupAcks = 0
+		f.muSend.Unlock()
+
+		signal(f.writable)
+		return
+	}
+
+	// fast retransmit of the first frame after three duplicate acks
+	var resend []byte
+	if len(f.unacked) > 0 && f.unacked[0].seq == ack {
+		f.dupAcks++
+		if f.dupAcks == 3 {
+			f.unacked[0].sentAt = time.Now()
+			resend = f.unacked[0].data
+		}
+	}
+	f.muSend.Unlock()
+
+	if resend != nil {
+		f.logger.Debug("framer: fast retransmit", zap.Uint32("seq", ack))
+		f.driver.SendToPeer(f.remotePID, resend)
+	}
+}
+
+func (f *framer) retransmitLoop(ticker *time.Ticker) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-f.ctx.Done():
+			return
+		}
+
+		resend, err := f.expiredFrames(time.Now())
+		if err != nil {
+			f.onError(err)
+			return
+		}
+
+		for _, data := range resend {
+			if !f.driver.SendToPeer(f.remotePID, data) {
+				break
+			}
+		}
+	}
+}
+
+// expiredFrames returns the frames to send again.
+func (f *framer) expiredFrames(now time.Time) ([][]byte, error) {
+	f.muSend.Lock()
+	defer f.muSend.Unlock()
+
+	var resend [][]byte
+	for _, frame := range f.unacked {
+		if now.Sub(frame.sentAt) < frame.timeout {
+			continue
+		}
+
+		if frame.retries >= FrameMaxRetransmissions {
+			return nil, ErrRetransmissionsExceeded
+		}
+
+		frame.retries++
+		frame.sentAt = now
+		frame.timeout = min(frame.timeout*2, FrameMaxRetransmitTimeout)
+		resend = append(resend, frame.data)
+	}
+
+	if len(resend) > 0 {
+		f.logger.Debug("framer: retransmitting frames", zap.Int("count", len(resend)))
+	}
+
+	return resend, nil
+}
+
+func encodeFrame(typ byte, seq uint32, payload []byte) []byte {
+	frame := make([]byte, FrameHeaderSize+len(payload))
+	frame[0] = typ
+	binary.BigEndian.PutUint32(frame[1:5], seq)
+	copy(frame[FrameHeaderSize:], payload)
+	binary.BigEndian.PutUint32(frame[5:9], frameChecksum(frame))
+
+	return frame
+}
+
+func decodeFrame(frame []byte) (typ byte, seq uint32, payload []byte, err error) {
+	if len(frame) < FrameHeaderSize {
+		return 0, 0, nil, fmt.Errorf("%w: frame too short", errInvalidFrame)
+	}
+
+	if binary.BigEndian.Uint32(frame[5:9]) != frameChecksum(frame) {
+		return 0, 0, nil, fmt.Errorf("%w: checksum mismatch", errInvalidFrame)
+	}
+
+	typ = frame[0]
+	if typ != frameTypeData && typ != frameTypeAck && typ != frameTypeHello {
+		return 0, 0, nil, fmt.Errorf("%w: unknown type %d", errInvalidFrame, typ)
+	}
+
+	return typ, binary.BigEndian.Uint32(frame[1:5]), frame[FrameHeaderSize:], nil
+}
+
+// frameChecksum computes the checksum of the frame, skipping the checksum
+// field itself.
+func frameChecksum(frame []byte) uint32 {
+	crc := crc32.ChecksumIEEE(frame[:5])
+	return crc32.Update(crc, crc32.IEEETable, frame[FrameHeaderSize:])
+}
+
+// seqLess compares sequence numbers, handling wrap around.
+func seqLess(a, b uint32) bool {
+	return int32(a-b) < 0
+}
@@ -0,0 +1,244 @@
+package proximitytransport
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// lossyDriver delivers frames to the remote framer, dropping or corrupting
+// some of them and swapping the order of the others.
+type lossyDriver struct {
+	NoopProximityDriver
+
+	mtu    int
+	remote *framer
+	count  int
+	held   []byte
+	mu     sync.Mutex
+}
+
+func (d *lossyDriver) MTU(string) int { return d.mtu }
+
+func (d *lossyDriver) SendToPeer(_ string, payload []byte) bool {
+	d.mu.Lock()
+	d.count++
+	var deliver [][]byte
+	switch {
+	case d.count%7 == 0: // lost
+	case d.count%11 == 0: // corrupted
+		corrupted := append([]byte(nil), payload...)
+		corrupted[len(corrupted)-1] ^= 0xff
+		deliver = append(deliver, corrupted)
+	case d.count%3 == 0: // delivered after the next one
+		d.held = append([]byte(nil), payload...)
+	default:
+		deliver = append(deliver, append([]byte(nil), payload...))
+		if d.held != nil {
+			deliver = append(deliver, d.held)
+			d.held = nil
+		}
+	}
+	remote := d.remote
+	d.mu.Unlock()
+
+	go func() {
+		for _, data := range deliver {
+			remote.Write(data)
+		}
+	}()
+	return true
+}
+
+// blackholeDriver accepts every frame without delivering them.
+type blackholeDriver struct {
+	NoopProximityDriver
+}
+
+func (d *blackholeDriver) SendToPeer(string, []byte) bool { return true }
+
+// pipeDriver delivers every payload to the remote framer, or to the legacy
+// handler when the remote peer doesn't support framing.
+type pipeDriver struct {
+	NoopProximityDriver
+
+	remote *framer
+	legacy func(payload []byte)
+}
+
+func (d *pipeDriver) SendToPeer(_ string, payload []byte) bool {
+	payload = append([]byte(nil), payload...)
+	if d.legacy != nil {
+		d.legacy(payload)
+	} else {
+		go d.remote.Write(payload)
+	}
+	return true
+}
+
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func TestFrameEncoding(t *testing.T) {
+	frame := encodeFrame(frameTypeData, 42, []byte("payload"))
+	require.Len(t, frame, FrameHeaderSize+len("payload"))
+
+	typ, seq, payload, err := decodeFrame(frame)
+	require.NoError(t, err)
+	require.Equal(t, frameTypeData, typ)
+	require.Equal(t, uint32(42), seq)
+	require.Equal(t, []byte("payload"), payload)
+
+	frame[FrameHeaderSize] ^= 0x01
+	_, _, _, err = decodeFrame(frame)
+	require.ErrorIs(t, err, errInvalidFrame)
+
+	_, _, _, err = decodeFrame(frame[:FrameHeaderSize-1])
+	require.ErrorIs(t, err, errInvalidFrame)
+
+	require.True(t, seqLess(^uint32(0), 0))
+	require.False(t, seqLess(0, ^uint32(0)))
+}
+
+func TestFramerReliableDelivery(t *testing.T) {
+	prevTimeout := FrameRetransmitTimeout
+	FrameRetransmitTimeout = 20 * time.Millisecond
+	defer func() { FrameRetransmitTimeout = prevTimeout }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failed := func(err error) { t.Errorf("framer failed: %v", err) }
+
+	driverA, driverB := &lossyDriver{mtu: 32}, &lossyDriver{mtu: 32}
+	outputA, outputB := &syncBuffer{}, &syncBuffer{}
+	framerA := newFramer(ctx, zap.NewNop(), driverA, "peerB", outputA, failed)
+	framerB := newFramer(ctx, zap.NewNop(), driverB, "peerA", outputB, failed)
+	driverA.remote, driverB.remote = framerB, framerA
+
+	// the negotiation is not reliable on a lossy link
+	framerA.setMode(framingEnabled, FramingVersion)
+	framerB.setMode(framingEnabled, FramingVersion)
+
+	require.Equal(t, 32-FrameHeaderSize, framerA.frameSize())
+
+	payload := bytes.Repeat([]byte("weshnet"), 512)
+	n, err := framerA.send(payload, nil)
+	require.NoError(t, err)
+	require.Equal(t, len(payload), n)
+
+	require.Eventually(t, func() bool {
+		return bytes.Equal(payload, outputB.Bytes())
+	}, time.Second*10, time.Millisecond*10)
+	require.Empty(t, outputA.Bytes())
+
+	// every frame is eventually acknowledged
+	require.Eventually(t, func() bool {
+		framerA.muSend.Lock()
+		defer framerA.muSend.Unlock()
+		return len(framerA.unacked) == 0
+	}, time.Second*10, time.Millisecond*10)
+}
+
+func TestFramerSendDeadline(t *testing.T) {
+	prevWindow := FrameWindowSize
+	FrameWindowSize = 4
+	defer func() { FrameWindowSize = prevWindow }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// frames are never acknowledged, the window is full after 4 frames
+	framer := newFramer(ctx, zap.NewNop(), &blackholeDriver{}, "peer", &syncBuffer{}, func(error) {})
+	framer.setMode(framingEnabled, FramingVersion)
+
+	deadline := make(chan struct{})
+	time.AfterFunc(time.Millisecond*50, func() { close(deadline) })
+
+	payload := bytes.Repeat([]byte("a"), DefaultFrameSize*8)
+	n, err := framer.send(payload, deadline)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	// only the bytes of the frames handed off are reported
+	require.Equal(t, (DefaultFrameSize-FrameHeaderSize)*4, n)
+}
+
+func TestFramerNegotiation(t *testing.T) {
+	prevTimeout := FrameNegotiationTimeout
+	FrameNegotiationTimeout = 50 * time.Millisecond
+	defer func() { FrameNegotiationTimeout = prevTimeout }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	failed := func(err error) { t.Errorf("framer failed: %v", err) }
+
+	t.Run("framing", func(t *testing.T) {
+		driverA, driverB := &pipeDriver{}, &pipeDriver{}
+		outputB := &syncBuffer{}
+		framerA := newFramer(ctx, zap.NewNop(), driverA, "peerB", &syncBuffer{}, failed)
+		framerB := newFramer(ctx, zap.NewNop(), driverB, "peerA", outputB, failed)
+		driverA.remote, driverB.remote = framerB, framerA
+
+		framerA.negotiate()
+		framerB.negotiate()
+
+		_, err := framerA.send([]byte("framed"), nil)
+		require.NoError(t, err)
+		require.Equal(t, framingEnabled, framerA.getMode())
+
+		require.Eventually(t, func() bool {
+			return bytes.Equal([]byte("framed"), outputB.Bytes())
+		}, time.Second, time.Millisecond*10)
+		require.Equal(t, framingEnabled, framerB.getMode())
+	})
+
+	t.Run("legacy peer", func(t *testing.T) {
+		// the legacy peer ignores the empty payload and receives raw payloads
+		received := make(chan []byte, 4)
+		driver := &pipeDriver{legacy: func(payload []byte) {
+			if len(payload) > 0 {
+				received <- payload
+			}
+		}}
+		framer := newFramer(ctx, zap.NewNop(), driver, "peer", &syncBuffer{}, failed)
+		framer.negotiate()
+
+		_, err := framer.send([]byte("raw"), nil)
+		require.NoError(t, err)
+		require.Equal(t, framingRaw, framer.getMode())
+		require.Equal(t, []byte("raw"), <-received)
+	})
+
+	t.Run("legacy payload", func(t *testing.T) {
+		output := &syncBuffer{}
+		framer := newFramer(ctx, zap.NewNop(), &blackholeDriver{}, "peer", output, failed)
+		framer.negotiate()
+
+		// a payload which is not a frame is received before the negotiation
+		_, err := framer.Write([]byte("/multistream/1.0.0"))
+		require.NoError(t, err)
+		require.Equal(t, framingRaw, framer.getMode())
+		require.Equal(t, []byte("/multistream/1.0.0"), output.Bytes())
+	})
+}
@@ -87,9 +87,11 @@ func (l *Listener) Close() error {
 	l.transport.lock.Unlock()
 
 	// Unregister this transport
-	TransportMapMutex.Lock()
-	delete(TransportMap, l.transport.driver.ProtocolName())
-	TransportMapMutex.Unlock()
+	if _, ok := l.transport.driver.(TransportBinder); !ok {
+		TransportMapMutex.Lock()
+		delete(TransportMap, l.transport.driver.ProtocolName())
+		TransportMapMutex.Unlock()
+	}
 
 	return nil
 }
@@ -8,7 +8,7 @@ package proximitytransport
   There are two types of input:
   1) RingBufferMap
   2) builtin chan []byte
-  There is only one type of output: *io.PipeWriter
+  There is only one type of output: io.Writer
   When you start mplex, its flushed buffers first in the order you set them,
   and read on its chan []byte.
 */
@@ -28,7 +28,7 @@ type mplex struct {
 	inputLock   sync.Mutex
 	input       chan []byte
 
-	output *io.PipeWriter
+	output io.Writer
 
 	ctx    context.Context
 	logger *zap.Logger
@@ -37,13 +37,13 @@ type mplex struct {
 func newMplex(ctx context.Context, logger *zap.Logger) *mplex {
 	logger = logger.Named("mplex")
 	return &mplex{
-		input:  make(chan []byte),
+		input:  make(chan []byte, ConnReceiveQueueSize),
 		ctx:    ctx,
 		logger: logger,
 	}
 }
 
-func (m *mplex) setOutput(o *io.PipeWriter) {
+func (m *mplex) setOutput(o io.Writer) {
 	m.output = o
 }
 
@@ -63,12 +63,20 @@ func (m *mplex) write(s []byte) {
 	}
 }
 
-// run flushes caches and read input channel
-func (m *mplex) run(peerID string) {
+// run flushes caches and read input channel.
+// It returns ErrBufferOverflow if payloads have been dropped from a cache.
+func (m *mplex) run(peerID string) error {
 	m.logger.Debug("run: started")
 	// flush caches
 	m.inputLock.Lock()
 	for _, cache := range m.inputCaches {
+		if cache.Overflowed(peerID) {
+			m.inputLock.Unlock()
+			m.logger.Error("run: cache overflowed, stream is incomplete")
+			cache.Delete(peerID)
+			return ErrBufferOverflow
+		}
+
 		m.logger.Debug("run: flushing one cache")
 
 		payloads := cache.Flush(peerID)
@@ -85,7 +93,7 @@ func (m *mplex) run(peerID string) {
 		case payload := <-m.input:
 			m.write(payload)
 		case <-m.ctx.Done():
-			return
+			return nil
 		}
 	}
 }
@@ -26,6 +26,14 @@ type ProximityDriver interface {
 	DefaultAddr() string
 }
 
+// TransportBinder is implemented by drivers that dispatch their events directly
+// to the transport instead of looking it up in the TransportMap, which allows
+// running multiple transports of the same driver in a single process.
+type TransportBinder interface {
+	// Bind the transport that will handle the driver events
+	BindTransport(t ProximityTransport)
+}
+
 type NoopProximityDriver struct {
 	protocolCode int
 	protocolName string
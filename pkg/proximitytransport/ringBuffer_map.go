@@ -21,7 +21,8 @@ type RingBufferMap struct {
 
 type ringBuffer struct {
 	sync.Mutex
-	buffer *ring.Ring
+	buffer     *ring.Ring
+	overflowed bool
 }
 
 // NewRingBufferMap returns a new connMgr struct
@@ -35,8 +36,9 @@ func NewRingBufferMap(logger *zap.Logger, size int) *RingBufferMap {
 	}
 }
 
-// Add adds the payload into a circular cache
-func (rbm *RingBufferMap) Add(peerID string, payload []byte) {
+// Add adds the payload into a circular cache.
+// It returns true if the cache was full and the oldest payload has been dropped.
+func (rbm *RingBufferMap) Add(peerID string, payload []byte) (overflowed bool) {
 	rbm.logger.Debug("Add", logutil.PrivateString("peerID", peerID), logutil.PrivateBinary("payload", payload))
 
 	var rBuffer *ringBuffer
@@ -51,6 +53,11 @@ func (rbm *RingBufferMap) Add(peerID string, payload []byte) {
 	}
 
 	rBuffer.Lock()
+	if rBuffer.buffer.Value != nil {
+		rbm.logger.Warn("Add: cache is full, oldest payload dropped", logutil.PrivateString("peerID", peerID))
+		rBuffer.overflowed = true
+		overflowed = true
+	}
 	rBuffer.buffer.Value = payload
 	rBuffer.buffer = rBuffer.buffer.Next()
 	rBuffer.Unlock()
@@ -58,6 +65,24 @@ func (rbm *RingBufferMap) Add(peerID string, payload []byte) {
 	rbm.Lock()
 	rbm.cache[peerID] = rBuffer
 	rbm.Unlock()
+
+	return overflowed
+}
+
+// Overflowed tells if payloads have been dropped from the peer cache since
+// it has been created.
+func (rbm *RingBufferMap) Overflowed(peerID string) bool {
+	rbm.Lock()
+	rBuffer, ok := rbm.cache[peerID]
+	rbm.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	rBuffer.Lock()
+	defer rBuffer.Unlock()
+	return rBuffer.overflowed
 }
 
 // Flush puts the cache contents into a chan and clears it
@@ -154,10 +154,16 @@ func (t *proximityTransport) Listen(localMa ma.Multiaddr) (tpt.Listener, error)
 		}
 	}
 
+	// Drivers bound to their transport don't need to be registered globally.
+	binder, isBinder := t.driver.(TransportBinder)
+
 	// If the a listener already exists for this driver, returns an error.
-	TransportMapMutex.RLock()
-	_, ok := TransportMap[t.driver.ProtocolName()]
-	TransportMapMutex.RUnlock()
+	ok := false
+	if !isBinder {
+		TransportMapMutex.RLock()
+		_, ok = TransportMap[t.driver.ProtocolName()]
+		TransportMapMutex.RUnlock()
+	}
 	t.lock.RLock()
 	if ok || t.listener != nil {
 		t.lock.RUnlock()
@@ -166,9 +172,13 @@ func (t *proximityTransport) Listen(localMa ma.Multiaddr) (tpt.Listener, error)
 	t.lock.RUnlock()
 
 	// Register this transport
-	TransportMapMutex.Lock()
-	TransportMap[t.driver.ProtocolName()] = t
-	TransportMapMutex.Unlock()
+	if isBinder {
+		binder.BindTransport(t)
+	} else {
+		TransportMapMutex.Lock()
+		TransportMap[t.driver.ProtocolName()] = t
+		TransportMapMutex.Unlock()
+	}
 
 	t.lock.Lock()
 	defer t.lock.Unlock()
@@ -198,18 +208,34 @@ func (t *proximityTransport) ReceiveFromPeer(remotePID string, payload []byte) {
 			c.Lock()
 			if !c.ready {
 				t.logger.Info("ReceiveFromPeer: connection is not ready to accept incoming packets, add it to cache")
-				c.cache.Add(remotePID, data)
+				overflowed := c.cache.Add(remotePID, data)
 				c.Unlock()
+				if overflowed {
+					c.closeWithError(ErrBufferOverflow)
+				}
 				return
 			}
 			c.Unlock()
 		}
 
-		// Write the payload into pipe
-		c.mp.input <- data
+		// Queue the payload without blocking the native driver, the framing
+		// layer sends again the frames dropped while the queue is full. Raw
+		// payloads can't be dropped, the conn is closed instead.
+		select {
+		case c.mp.input <- data:
+		default:
+			if c.framer.isRaw() {
+				t.logger.Error("ReceiveFromPeer: conn queue full, closing conn")
+				c.closeWithError(ErrBufferOverflow)
+				return
+			}
+			t.logger.Warn("ReceiveFromPeer: conn queue full, payload dropped")
+		}
 	} else {
 		t.logger.Info("ReceiveFromPeer: no Conn found, put payload in cache")
-		t.cache.Add(remotePID, data)
+		if t.cache.Add(remotePID, data) {
+			t.logger.Warn("ReceiveFromPeer: transport cache overflowed, the next conn with this peer will fail")
+		}
 	}
 }
 
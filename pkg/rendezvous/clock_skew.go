@@ -0,0 +1,115 @@
+package rendezvous
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	// RotationSkewTolerance is the minimum time window around a rotation
+	// boundary during which adjacent rendezvous points are also looked up
+	RotationSkewTolerance = time.Minute * 2
+
+	// MaxRotationSkewTolerance caps the tolerance, so the peers can't make us
+	// look up a large number of rendezvous points
+	MaxRotationSkewTolerance = time.Minute * 10
+
+	// ClockSkewThreshold is the offset above which the local clock is
+	// considered as skewed
+	ClockSkewThreshold = time.Second * 30
+
+	// ClockSkewSampleTTL is the duration after which a peer sample is ignored
+	ClockSkewSampleTTL = time.Hour
+
+	// ClockSkewMaxSamples is the maximum number of peers samples kept
+	ClockSkewMaxSamples = 256
+)
+
+type clockSample struct {
+	offset     time.Duration
+	receivedAt time.Time
+}
+
+// ClockSkew estimates the offset between the local clock and the clocks of
+// remote peers, using the timestamps they send along with their messages
+type ClockSkew struct {
+	samples   map[string]clockSample
+	muSamples sync.RWMutex
+}
+
+func NewClockSkew() *ClockSkew {
+	return &ClockSkew{
+		samples: make(map[string]clockSample),
+	}
+}
+
+// AddSample records the clock of the given peer, remote is the peer clock
+// when the message has been sent and local is our clock when it has been
+// received
+func (c *ClockSkew) AddSample(peerID string, remote, local time.Time) {
+	c.muSamples.Lock()
+	defer c.muSamples.Unlock()
+
+	if _, ok := c.samples[peerID]; !ok && len(c.samples) >= ClockSkewMaxSamples {
+		c.evictOldest()
+	}
+
+	c.samples[peerID] = clockSample{
+		offset:     remote.Sub(local),
+		receivedAt: local,
+	}
+}
+
+// Offset returns the median offset of the peers clocks relative to our own
+// clock, a positive offset means the local clock is late, along with the
+// number of peers used for the estimation
+func (c *ClockSkew) Offset() (offset time.Duration, peers int) {
+	c.muSamples.RLock()
+	defer c.muSamples.RUnlock()
+
+	offsets := make([]time.Duration, 0, len(c.samples))
+	for _, sample := range c.samples {
+		if time.Since(sample.receivedAt) > ClockSkewSampleTTL {
+			continue
+		}
+
+		offsets = append(offsets, sample.offset)
+	}
+
+	if len(offsets) == 0 {
+		return 0, 0
+	}
+
+	slices.Sort(offsets)
+	return offsets[len(offsets)/2], len(offsets)
+}
+
+// Detected returns true if the local clock is skewed compared to the peers
+func (c *ClockSkew) Detected() bool {
+	offset, _ := c.Offset()
+	return offset.Abs() > ClockSkewThreshold
+}
+
+// Tolerance returns the time window around a rotation boundary during which
+// adjacent rendezvous points should be looked up, capped to
+// MaxRotationSkewTolerance
+func (c *ClockSkew) Tolerance() time.Duration {
+	offset, _ := c.Offset()
+	return min(RotationSkewTolerance+offset.Abs(), MaxRotationSkewTolerance)
+}
+
+func (c *ClockSkew) evictOldest() {
+	var (
+		oldestID string
+		oldestAt time.Time
+	)
+
+	for id, sample := range c.samples {
+		if oldestID == "" || sample.receivedAt.Before(oldestAt) {
+			oldestID, oldestAt = id, sample.receivedAt
+		}
+	}
+
+	delete(c.samples, oldestID)
+}
@@ -0,0 +1,81 @@
+package rendezvous_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/weshnet/v2/pkg/rendezvous"
+)
+
+func TestClockSkewOffset(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		offsets   []time.Duration
+		expected  time.Duration
+		detected  bool
+		tolerance time.Duration
+	}{
+		{
+			offsets:   nil,
+			expected:  0,
+			detected:  false,
+			tolerance: rendezvous.RotationSkewTolerance,
+		},
+		{
+			offsets:   []time.Duration{time.Second, -time.Second, 0},
+			expected:  0,
+			detected:  false,
+			tolerance: rendezvous.RotationSkewTolerance,
+		},
+		{
+			offsets:   []time.Duration{time.Minute * 3, time.Minute * 3, -time.Second},
+			expected:  time.Minute * 3,
+			detected:  true,
+			tolerance: rendezvous.RotationSkewTolerance + time.Minute*3,
+		},
+		{
+			offsets:   []time.Duration{-time.Hour * 12, -time.Hour * 12, time.Second},
+			expected:  -time.Hour * 12,
+			detected:  true,
+			tolerance: rendezvous.MaxRotationSkewTolerance,
+		},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprintf("tc: %d", i), func(t *testing.T) {
+			skew := rendezvous.NewClockSkew()
+			for j, offset := range tc.offsets {
+				skew.AddSample(fmt.Sprintf("peer%d", j), now.Add(offset), now)
+			}
+
+			offset, peers := skew.Offset()
+			require.Equal(t, tc.expected, offset)
+			require.Equal(t, len(tc.offsets), peers)
+			require.Equal(t, tc.detected, skew.Detected())
+			require.Equal(t, tc.tolerance, skew.Tolerance())
+		})
+	}
+}
+
+func TestPointForRotationAdjacentPeriod(t *testing.T) {
+	rp := rendezvous.NewRotationInterval(time.Hour)
+	rp.RegisterRotation(time.Now(), "topicA", []byte("seedA"))
+
+	current, err := rp.PointForTopic("topicA")
+	require.NoError(t, err)
+
+	// a peer with a clock ahead of ours already uses the next rotation
+	next := current.NextPoint()
+	require.NotEqual(t, current.RotationTopic(), next.RotationTopic())
+	require.Equal(t, current.Deadline(), next.Start())
+
+	point, err := rp.PointForRawRotation(next.RawRotationTopic())
+	require.NoError(t, err)
+	require.Equal(t, "topicA", point.Topic())
+
+	require.Equal(t, current.RotationTopic(), next.PrevPoint().RotationTopic())
+}
@@ -92,3 +92,63 @@ func TestGenerateRendezvousPointForPeriod(t *testing.T) {
 		})
 	}
 }
+
+func TestRotationIntervalPerTopic(t *testing.T) {
+	at := time.Date(2020, 4, 10, 12, 34, 56, 0, time.UTC)
+
+	rp := rendezvous.NewRotationInterval(time.Hour)
+	rp.SetTopicInterval("topicB", time.Minute*10)
+
+	cases := []struct {
+		topic      string
+		interval   time.Duration
+		deadline   time.Time
+		announceAt time.Time
+	}{
+		{
+			topic:      "topicA",
+			interval:   time.Hour,
+			deadline:   time.Date(2020, 4, 10, 13, 0, 0, 0, time.UTC),
+			announceAt: time.Date(2020, 4, 10, 12, 55, 0, 0, time.UTC),
+		},
+		{
+			topic:      "topicB",
+			interval:   time.Minute * 10,
+			deadline:   time.Date(2020, 4, 10, 12, 40, 0, 0, time.UTC),
+			announceAt: time.Date(2020, 4, 10, 12, 37, 30, 0, time.UTC),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.topic, func(t *testing.T) {
+			require.Equal(t, tc.interval, rp.TopicInterval(tc.topic))
+
+			point := rp.NewRendezvousPointForPeriod(at, tc.topic, []byte("seed"))
+			require.Equal(t, tc.interval, point.Interval())
+			require.Equal(t, tc.deadline, point.Deadline())
+			require.Equal(t, tc.announceAt, point.AnnounceNextAt())
+
+			expected := rendezvous.GenerateRendezvousPointForPeriod([]byte(tc.topic), []byte("seed"), tc.deadline.Add(-tc.interval))
+			require.Equal(t, expected, point.RawRotationTopic())
+		})
+	}
+
+	// resetting the topic interval falls back on the default interval
+	rp.SetTopicInterval("topicB", 0)
+	require.Equal(t, time.Hour, rp.TopicInterval("topicB"))
+}
+
+func TestPointIsExpired(t *testing.T) {
+	rp := rendezvous.NewRotationInterval(time.Hour)
+
+	// a point of the current period is still valid
+	current := rp.NewRendezvousPointForPeriod(time.Now(), "topic", []byte("seed"))
+	require.False(t, current.IsExpired())
+	require.Equal(t, current.Deadline(), current.NextPoint().Deadline().Add(-time.Hour))
+
+	// a point of a previous period is expired, the next point is computed
+	// from the current time instead of its own deadline
+	past := rp.NewRendezvousPointForPeriod(time.Now().Add(-time.Hour*3), "topic", []byte("seed"))
+	require.True(t, past.IsExpired())
+	require.Equal(t, current.Deadline(), past.NextPoint().Deadline())
+}
@@ -10,11 +10,22 @@ import (
 var (
 	RotationGracePeriod  = time.Minute * 10
 	MinimumDelayRotation = time.Minute
+	MaximumDelayRotation = time.Hour * 24 * 30
+
+	// RotationAnnounceAdvance is how long before the end of a period the
+	// next rendezvous point starts being announced, it is capped to a
+	// quarter of the rotation interval
+	RotationAnnounceAdvance = time.Minute * 5
 )
 
 type RotationInterval struct {
 	interval time.Duration
 
+	topicIntervals map[string]time.Duration
+	muIntervals    sync.RWMutex
+
+	clockSkew *ClockSkew
+
 	cacheTopics    map[string]*Point
 	cacheRotations map[string]*Point
 	muCache        sync.RWMutex
@@ -29,6 +40,8 @@ func NewStaticRotationInterval() *RotationInterval {
 func NewRotationInterval(interval time.Duration) *RotationInterval {
 	return &RotationInterval{
 		interval:       interval,
+		topicIntervals: make(map[string]time.Duration),
+		clockSkew:      NewClockSkew(),
 		cacheTopics:    make(map[string]*Point),
 		cacheRotations: make(map[string]*Point),
 	}
@@ -41,6 +54,49 @@ func (r *RotationInterval) RegisterRotation(at time.Time, topic string, seed []b
 	r.muCache.Unlock()
 }
 
+// ClockSkew returns the estimator of the clock offset with remote peers
+func (r *RotationInterval) ClockSkew() *ClockSkew {
+	return r.clockSkew
+}
+
+// TopicKey returns the key under which the points and the rotation interval
+// of a binary topic are registered
+func TopicKey(topic []byte) string {
+	return base64.StdEncoding.EncodeToString(topic)
+}
+
+// SetTopicInterval overrides the rotation interval used for the given topic,
+// a zero or negative interval resets the topic to the default interval
+func (r *RotationInterval) SetTopicInterval(topic string, interval time.Duration) {
+	r.muIntervals.Lock()
+	if interval > 0 {
+		r.topicIntervals[topic] = interval
+	} else {
+		delete(r.topicIntervals, topic)
+	}
+	r.muIntervals.Unlock()
+
+	r.muCache.Lock()
+	defer r.muCache.Unlock()
+
+	// replace the registered point if it has been computed using another interval
+	if point, ok := r.cacheTopics[topic]; ok && point.interval != r.TopicInterval(topic) {
+		r.replace(point, r.NewRendezvousPointForPeriod(time.Now(), topic, point.seed), RotationGracePeriod)
+	}
+}
+
+// TopicInterval returns the rotation interval used for the given topic
+func (r *RotationInterval) TopicInterval(topic string) time.Duration {
+	r.muIntervals.RLock()
+	defer r.muIntervals.RUnlock()
+
+	if interval, ok := r.topicIntervals[topic]; ok {
+		return interval
+	}
+
+	return r.interval
+}
+
 func (r *RotationInterval) RoundTimePeriod(at time.Time) time.Time {
 	return RoundTimePeriod(at, r.interval)
 }
@@ -84,15 +140,18 @@ func (r *RotationInterval) PointForTopic(topic string) (*Point, error) {
 }
 
 func (r *RotationInterval) NewRendezvousPointForPeriod(at time.Time, topic string, seed []byte) (point *Point) {
-	at = r.RoundTimePeriod(at)
+	interval := r.TopicInterval(topic)
+
+	at = RoundTimePeriod(at, interval)
 	rotation := GenerateRendezvousPointForPeriod([]byte(topic), seed, at)
 
-	next := r.NextTimePeriod(at)
+	next := NextTimePeriod(at, interval)
 	return &Point{
 		rp:       r,
 		rotation: rotation,
 		topic:    topic,
 		seed:     seed,
+		interval: interval,
 		deadline: next,
 	}
 }
@@ -101,11 +160,20 @@ func (r *RotationInterval) registerPoint(point *Point) {
 	keytopic, keyrotation := point.keys()
 	r.cacheTopics[keytopic] = point
 	r.cacheRotations[keyrotation] = point
+
+	// also register the next rotation, so messages from peers with a clock
+	// ahead of ours can still be read near the boundary
+	point.next = r.NewRendezvousPointForPeriod(point.deadline, point.topic, point.seed)
+	if _, keynext := point.next.keys(); r.cacheRotations[keynext] == nil {
+		r.cacheRotations[keynext] = point.next
+	}
 }
 
 func (r *RotationInterval) rotate(old *Point, graceperiod time.Duration) *Point {
-	newPoint := old.NextPoint()
+	return r.replace(old, old.NextPoint(), graceperiod)
+}
 
+func (r *RotationInterval) replace(old *Point, newPoint *Point, graceperiod time.Duration) *Point {
 	// register new point
 	r.registerPoint(newPoint)
 
@@ -113,8 +181,16 @@ func (r *RotationInterval) rotate(old *Point, graceperiod time.Duration) *Point
 	// cleanup after the grace period
 	time.AfterFunc(cleanuptime, func() {
 		r.muCache.Lock()
-		_, keyrotation := old.keys()
-		delete(r.cacheRotations, keyrotation)
+		// the old rotations may still be in use if they match the new ones
+		for _, point := range []*Point{old, old.next} {
+			if point == nil {
+				continue
+			}
+
+			if _, keyrotation := point.keys(); r.cacheRotations[keyrotation] == point {
+				delete(r.cacheRotations, keyrotation)
+			}
+		}
 		r.muCache.Unlock()
 	})
 
@@ -126,7 +202,9 @@ type Point struct {
 	topic    string
 	rotation []byte
 	seed     []byte
+	interval time.Duration
 	deadline time.Time
+	next     *Point
 }
 
 func (p *Point) NextPoint() *Point {
@@ -137,6 +215,11 @@ func (p *Point) NextPoint() *Point {
 	return p.rp.NewRendezvousPointForPeriod(p.deadline.Add(time.Second), p.topic, p.seed)
 }
 
+// PrevPoint returns the point of the period preceding this one
+func (p *Point) PrevPoint() *Point {
+	return p.rp.NewRendezvousPointForPeriod(p.Start().Add(-time.Second), p.topic, p.seed)
+}
+
 func (p *Point) Seed() []byte {
 	return p.seed
 }
@@ -163,14 +246,31 @@ func (p *Point) RawRotationTopic() []byte {
 	return p.rotation
 }
 
+// Start returns the beginning of the period of this point
+func (p *Point) Start() time.Time {
+	return p.deadline.Add(-p.interval)
+}
+
 func (p *Point) Deadline() time.Time {
 	return p.deadline
 }
 
+// Interval returns the rotation interval used to compute this point
+func (p *Point) Interval() time.Duration {
+	return p.interval
+}
+
+// AnnounceNextAt returns the time at which the next point should start being
+// announced, so peers that already rotated are still able to find us
+func (p *Point) AnnounceNextAt() time.Time {
+	advance := min(RotationAnnounceAdvance, p.interval/4)
+	return p.deadline.Add(-advance)
+}
+
 func (p *Point) TTL() time.Duration {
 	return time.Until(p.deadline)
 }
 
 func (p *Point) IsExpired() bool {
-	return p.TTL() > 0
+	return p.TTL() <= 0
 }
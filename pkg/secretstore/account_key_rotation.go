@@ -0,0 +1,150 @@
+package secretstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/crypto"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+func (s *secretStore) RotateAccountKey(ctx context.Context) (*protocoltypes.AccountKeySuccession, error) {
+	s.accountKeyMutex.Lock()
+	defer s.accountKeyMutex.Unlock()
+
+	// the account group stays the one of the initial account key
+	if _, err := s.getAccountGroupPublicKey(ctx); errcode.Is(err, errcode.ErrCode_ErrMissingMapKey) {
+		accountPrivateKey, err := s.deviceKeystore.getAccountPrivateKey(ctx)
+		if err != nil {
+			return nil, errcode.ErrCode_ErrInternal.Wrap(err)
+		}
+
+		accountPublicKeyBytes, err := accountPrivateKey.GetPublic().Raw()
+		if err != nil {
+			return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+		}
+
+		if err := s.datastore.Put(ctx, dsKeyForAccountGroupPublicKey(), accountPublicKeyBytes); err != nil {
+			return nil, errcode.ErrCode_ErrDBWrite.Wrap(err)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	previous, next, err := s.deviceKeystore.rotateAccountKey()
+	if err != nil {
+		return nil, err
+	}
+
+	// the new account key is not derived from the mnemonic
+	if err := s.forgetAccountMnemonic(ctx); err != nil {
+		return nil, err
+	}
+
+	return newAccountKeySuccession(previous, next)
+}
+
+// getAccountGroupPublicKey returns the public key of the account group
+// pinned when the account key has been rotated
+func (s *secretStore) getAccountGroupPublicKey(ctx context.Context) ([]byte, error) {
+	publicKeyBytes, err := s.datastore.Get(ctx, dsKeyForAccountGroupPublicKey())
+	if err == datastore.ErrNotFound {
+		return nil, errcode.ErrCode_ErrMissingMapKey.Wrap(err)
+	} else if err != nil {
+		return nil, errcode.ErrCode_ErrDBRead.Wrap(err)
+	}
+
+	return publicKeyBytes, nil
+}
+
+// accountKeySuccessionContext prefixes the payload signed by the account keys
+// in a succession statement, so no other signature made by an account key can
+// be used as a succession proof
+const accountKeySuccessionContext = "weshnet/account-key-succession/v1"
+
+// accountKeySuccessionPayload returns the payload signed by both the previous
+// and the next account keys
+func accountKeySuccessionPayload(previousBytes []byte, nextBytes []byte) []byte {
+	payload := make([]byte, 0, len(accountKeySuccessionContext)+len(previousBytes)+len(nextBytes))
+	payload = append(payload, accountKeySuccessionContext...)
+	payload = append(payload, previousBytes...)
+
+	return append(payload, nextBytes...)
+}
+
+// newAccountKeySuccession returns the statement of the replacement of the
+// previous account key by the next one, signed by both of them
+func newAccountKeySuccession(previous crypto.PrivKey, next crypto.PrivKey) (*protocoltypes.AccountKeySuccession, error) {
+	previousBytes, err := previous.GetPublic().Raw()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	nextBytes, err := next.GetPublic().Raw()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	payload := accountKeySuccessionPayload(previousBytes, nextBytes)
+
+	previousSig, err := previous.Sign(payload)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoSignature.Wrap(err)
+	}
+
+	nextSig, err := next.Sign(payload)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoSignature.Wrap(err)
+	}
+
+	return &protocoltypes.AccountKeySuccession{
+		PreviousAccountPk:  previousBytes,
+		AccountPk:          nextBytes,
+		PreviousAccountSig: previousSig,
+		AccountSig:         nextSig,
+	}, nil
+}
+
+// VerifyAccountKeySuccession checks that the statement has been signed by
+// both the previous and the new account keys, and returns them
+func VerifyAccountKeySuccession(succession *protocoltypes.AccountKeySuccession) (previous crypto.PubKey, next crypto.PubKey, err error) {
+	if succession == nil {
+		return nil, nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("no succession statement provided"))
+	}
+
+	previous, err = crypto.UnmarshalEd25519PublicKey(succession.PreviousAccountPk)
+	if err != nil {
+		return nil, nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	next, err = crypto.UnmarshalEd25519PublicKey(succession.AccountPk)
+	if err != nil {
+		return nil, nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	if previous.Equals(next) {
+		return nil, nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("the account key is not replaced"))
+	}
+
+	payload := accountKeySuccessionPayload(succession.PreviousAccountPk, succession.AccountPk)
+
+	for _, check := range []struct {
+		key crypto.PubKey
+		sig []byte
+	}{
+		{key: previous, sig: succession.PreviousAccountSig},
+		{key: next, sig: succession.AccountSig},
+	} {
+		ok, err := check.key.Verify(payload, check.sig)
+		if err != nil {
+			return nil, nil, errcode.ErrCode_ErrCryptoSignatureVerification.Wrap(err)
+		} else if !ok {
+			return nil, nil, errcode.ErrCode_ErrCryptoSignatureVerification
+		}
+	}
+
+	return previous, next, nil
+}
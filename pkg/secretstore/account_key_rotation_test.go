@@ -0,0 +1,172 @@
+package secretstore
+
+import (
+	"context"
+	crand "crypto/rand"
+	"testing"
+
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+func TestRotateAccountKey(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store1, err := newInMemSecretStore(nil)
+	require.NoError(t, err)
+
+	store2, err := newInMemSecretStore(nil)
+	require.NoError(t, err)
+
+	accountGroup, omd, err := store1.GetGroupForAccount()
+	require.NoError(t, err)
+
+	previousPK := omd.Member()
+
+	contactPK, err := store2.GetAccountPrivateKey()
+	require.NoError(t, err)
+
+	previousContactGroup, err := store1.GetGroupForContact(contactPK.GetPublic())
+	require.NoError(t, err)
+
+	succession, err := store1.RotateAccountKey(ctx)
+	require.NoError(t, err)
+
+	previous, next, err := VerifyAccountKeySuccession(succession)
+	require.NoError(t, err)
+	require.True(t, previous.Equals(previousPK))
+	require.False(t, next.Equals(previousPK))
+
+	// the account group is kept, its member key is the new one
+	rotatedAccountGroup, rotatedOMD, err := store1.GetGroupForAccount()
+	require.NoError(t, err)
+	require.Equal(t, accountGroup.PublicKey, rotatedAccountGroup.PublicKey)
+	require.Equal(t, accountGroup.Secret, rotatedAccountGroup.Secret)
+	require.True(t, next.Equals(rotatedOMD.Member()))
+	require.True(t, omd.Device().Equals(rotatedOMD.Device()))
+
+	// the contact group is derived from the new key on both sides
+	contactGroup1, err := store1.GetGroupForContact(contactPK.GetPublic())
+	require.NoError(t, err)
+	require.NotEqual(t, previousContactGroup.PublicKey, contactGroup1.PublicKey)
+
+	contactGroup2, err := store2.GetGroupForContact(next)
+	require.NoError(t, err)
+	require.Equal(t, contactGroup1.PublicKey, contactGroup2.PublicKey)
+	require.Equal(t, contactGroup1.Secret, contactGroup2.Secret)
+
+	// a second rotation keeps the account group as well
+	_, err = store1.RotateAccountKey(ctx)
+	require.NoError(t, err)
+
+	rotatedAccountGroup, _, err = store1.GetGroupForAccount()
+	require.NoError(t, err)
+	require.Equal(t, accountGroup.PublicKey, rotatedAccountGroup.PublicKey)
+}
+
+func TestVerifyAccountKeySuccession(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := newInMemSecretStore(nil)
+	require.NoError(t, err)
+
+	succession, err := store.RotateAccountKey(ctx)
+	require.NoError(t, err)
+
+	_, _, err = VerifyAccountKeySuccession(nil)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrInvalidInput))
+
+	for name, tamper := range map[string]func(s *protocoltypes.AccountKeySuccession){
+		"previous signature": func(s *protocoltypes.AccountKeySuccession) { s.PreviousAccountSig[0] ^= 1 },
+		"new signature":      func(s *protocoltypes.AccountKeySuccession) { s.AccountSig[0] ^= 1 },
+		"swapped signatures": func(s *protocoltypes.AccountKeySuccession) {
+			s.PreviousAccountSig, s.AccountSig = s.AccountSig, s.PreviousAccountSig
+		},
+	} {
+		tampered := proto.Clone(succession).(*protocoltypes.AccountKeySuccession)
+		tamper(tampered)
+
+		_, _, err := VerifyAccountKeySuccession(tampered)
+		require.True(t, errcode.Is(err, errcode.ErrCode_ErrCryptoSignatureVerification), name)
+	}
+
+	// the signatures of the bare keys can't be used as a succession proof
+	previousKey, _, err := crypto.GenerateEd25519Key(crand.Reader)
+	require.NoError(t, err)
+
+	nextKey, _, err := crypto.GenerateEd25519Key(crand.Reader)
+	require.NoError(t, err)
+
+	untagged, err := newAccountKeySuccession(previousKey, nextKey)
+	require.NoError(t, err)
+
+	_, _, err = VerifyAccountKeySuccession(untagged)
+	require.NoError(t, err)
+
+	untagged.PreviousAccountSig, err = previousKey.Sign(untagged.AccountPk)
+	require.NoError(t, err)
+
+	untagged.AccountSig, err = nextKey.Sign(untagged.PreviousAccountPk)
+	require.NoError(t, err)
+
+	_, _, err = VerifyAccountKeySuccession(untagged)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrCryptoSignatureVerification))
+
+	same := proto.Clone(succession).(*protocoltypes.AccountKeySuccession)
+	same.AccountPk = same.PreviousAccountPk
+
+	_, _, err = VerifyAccountKeySuccession(same)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrInvalidInput))
+}
+
+func TestRotateAccountKeyWithSigner(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := newInMemSecretStore(&NewSecretStoreOptions{
+		Signer: &keystoreSigner{keystore: keystore.NewMemKeystore()},
+	})
+	require.NoError(t, err)
+
+	_, err = store.RotateAccountKey(ctx)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrNotImplemented))
+}
+
+// keystoreSigner is a minimal Signer backed by a keystore
+type keystoreSigner struct {
+	keystore keystore.Keystore
+}
+
+func (s *keystoreSigner) key(name string) (crypto.PrivKey, error) {
+	dk := newDeviceKeystore(s.keystore, nil, nil)
+	return dk.getOrGenerateNamedKey(name)
+}
+
+func (s *keystoreSigner) PublicKey(_ context.Context, name string) (crypto.PubKey, error) {
+	privateKey, err := s.key(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return privateKey.GetPublic(), nil
+}
+
+func (s *keystoreSigner) Sign(_ context.Context, name string, data []byte) ([]byte, error) {
+	privateKey, err := s.key(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return privateKey.Sign(data)
+}
+
+func (s *keystoreSigner) KeyAgreement(context.Context, string, []byte) ([]byte, error) {
+	return nil, errcode.ErrCode_ErrNotImplemented
+}
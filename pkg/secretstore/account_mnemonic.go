@@ -0,0 +1,139 @@
+package secretstore
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/tyler-smith/go-bip39"
+	"golang.org/x/crypto/hkdf"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+)
+
+const (
+	// AccountMnemonicEntropySize is the size in bits of the entropy of a
+	// generated account mnemonic, which is then made of 24 words
+	AccountMnemonicEntropySize = 256
+
+	accountMnemonicKDFInfo = "weshnet account keys"
+)
+
+// NewAccountMnemonic generates a new BIP39 mnemonic from which the account
+// keys can be derived
+func NewAccountMnemonic() (string, error) {
+	entropy, err := bip39.NewEntropy(AccountMnemonicEntropySize)
+	if err != nil {
+		return "", errcode.ErrCode_ErrCryptoRandomGeneration.Wrap(err)
+	}
+
+	mnemonic, err := bip39.NewMnemonic(entropy)
+	if err != nil {
+		return "", errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(err)
+	}
+
+	return mnemonic, nil
+}
+
+// AccountKeysFromMnemonic deterministically derives the account and account
+// proof private keys from a BIP39 mnemonic
+func AccountKeysFromMnemonic(mnemonic string) (accountPrivateKey crypto.PrivKey, accountProofPrivateKey crypto.PrivKey, err error) {
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, "")
+	if err != nil {
+		return nil, nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("invalid mnemonic: %w", err))
+	}
+
+	prk := hkdf.Extract(sha256.New, seed, nil)
+	kdf := hkdf.Expand(sha256.New, prk, []byte(accountMnemonicKDFInfo))
+
+	privateKeys := [2]crypto.PrivKey{}
+	for i := range privateKeys {
+		keySeed, err := io.ReadAll(io.LimitReader(kdf, ed25519.SeedSize))
+		if err != nil {
+			return nil, nil, errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(err)
+		}
+
+		stdPrivateKey := ed25519.NewKeyFromSeed(keySeed)
+		privateKeys[i], _, err = crypto.KeyPairFromStdKey(&stdPrivateKey)
+		if err != nil {
+			return nil, nil, errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(err)
+		}
+	}
+
+	return privateKeys[0], privateKeys[1], nil
+}
+
+func (s *secretStore) GetAccountMnemonic(ctx context.Context) (string, error) {
+	// make sure the account keys have been created
+	if _, err := s.deviceKeystore.getAccountProofPrivateKey(ctx); err != nil {
+		return "", errcode.ErrCode_ErrInternal.Wrap(err)
+	}
+
+	mnemonic, err := s.datastore.Get(ctx, dsKeyForAccountMnemonic())
+	if err == datastore.ErrNotFound {
+		return "", errcode.ErrCode_ErrNotFound.Wrap(fmt.Errorf("the account keys have not been derived from a mnemonic"))
+	} else if err != nil {
+		return "", errcode.ErrCode_ErrDBRead.Wrap(err)
+	}
+
+	return string(mnemonic), nil
+}
+
+func (s *secretStore) RestoreAccountMnemonic(ctx context.Context, mnemonic string) error {
+	accountPrivateKey, accountProofPrivateKey, err := AccountKeysFromMnemonic(mnemonic)
+	if err != nil {
+		return err
+	}
+
+	s.accountKeyMutex.Lock()
+	defer s.accountKeyMutex.Unlock()
+
+	if err := s.deviceKeystore.replaceAccountKeys(accountPrivateKey, accountProofPrivateKey); err != nil {
+		return err
+	}
+
+	// the account group is derived from the restored account key
+	if err := s.datastore.Delete(ctx, dsKeyForAccountGroupPublicKey()); err != nil {
+		return errcode.ErrCode_ErrDBWrite.Wrap(err)
+	}
+
+	if err := s.datastore.Put(ctx, dsKeyForAccountMnemonic(), []byte(mnemonic)); err != nil {
+		return errcode.ErrCode_ErrDBWrite.Wrap(err)
+	}
+
+	return nil
+}
+
+// newAccountKeysFromMnemonic generates the keys of a new account from a new
+// mnemonic, which is kept to be shown to the user
+func (s *secretStore) newAccountKeysFromMnemonic(ctx context.Context) (crypto.PrivKey, crypto.PrivKey, error) {
+	mnemonic, err := NewAccountMnemonic()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accountPrivateKey, accountProofPrivateKey, err := AccountKeysFromMnemonic(mnemonic)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.datastore.Put(ctx, dsKeyForAccountMnemonic(), []byte(mnemonic)); err != nil {
+		return nil, nil, errcode.ErrCode_ErrDBWrite.Wrap(err)
+	}
+
+	return accountPrivateKey, accountProofPrivateKey, nil
+}
+
+// forgetAccountMnemonic removes the mnemonic once it doesn't match the
+// account keys anymore
+func (s *secretStore) forgetAccountMnemonic(ctx context.Context) error {
+	if err := s.datastore.Delete(ctx, dsKeyForAccountMnemonic()); err != nil {
+		return errcode.ErrCode_ErrDBWrite.Wrap(err)
+	}
+
+	return nil
+}
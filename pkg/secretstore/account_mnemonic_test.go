@@ -0,0 +1,124 @@
+package secretstore
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+)
+
+func TestAccountKeysFromMnemonic(t *testing.T) {
+	mnemonic, err := NewAccountMnemonic()
+	require.NoError(t, err)
+	require.Len(t, strings.Fields(mnemonic), 24)
+
+	accountPrivateKey, accountProofPrivateKey, err := AccountKeysFromMnemonic(mnemonic)
+	require.NoError(t, err)
+	require.False(t, accountPrivateKey.Equals(accountProofPrivateKey))
+
+	accountPrivateKey2, accountProofPrivateKey2, err := AccountKeysFromMnemonic(mnemonic)
+	require.NoError(t, err)
+	require.True(t, accountPrivateKey.Equals(accountPrivateKey2))
+	require.True(t, accountProofPrivateKey.Equals(accountProofPrivateKey2))
+
+	words := strings.Fields(mnemonic)
+	words[0] = "weshnet"
+
+	for _, invalid := range []string{"", "not a mnemonic", strings.Join(words, " ")} {
+		_, _, err := AccountKeysFromMnemonic(invalid)
+		require.True(t, errcode.Is(err, errcode.ErrCode_ErrInvalidInput), invalid)
+	}
+}
+
+func TestAccountMnemonicBackup(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store1, err := newInMemSecretStore(nil)
+	require.NoError(t, err)
+
+	mnemonic, err := store1.GetAccountMnemonic(ctx)
+	require.NoError(t, err)
+
+	accountPrivateKey, accountProofPrivateKey, err := AccountKeysFromMnemonic(mnemonic)
+	require.NoError(t, err)
+
+	accountPrivateKeyBytes, accountProofPrivateKeyBytes, err := store1.ExportAccountKeysForBackup()
+	require.NoError(t, err)
+
+	for expected, keyBytes := range map[crypto.PrivKey][]byte{
+		accountPrivateKey:      accountPrivateKeyBytes,
+		accountProofPrivateKey: accountProofPrivateKeyBytes,
+	} {
+		storedPrivateKey, err := crypto.UnmarshalPrivateKey(keyBytes)
+		require.NoError(t, err)
+		require.True(t, expected.Equals(storedPrivateKey))
+	}
+
+	// the account is restored on a store already used by another account
+	store2, err := newInMemSecretStore(nil)
+	require.NoError(t, err)
+
+	contactPrivateKey, _, err := crypto.GenerateEd25519Key(nil)
+	require.NoError(t, err)
+
+	_, err = store2.GetGroupForContact(contactPrivateKey.GetPublic())
+	require.NoError(t, err)
+
+	require.NoError(t, store2.RestoreAccountMnemonic(ctx, mnemonic))
+
+	accountGroup1, _, err := store1.GetGroupForAccount()
+	require.NoError(t, err)
+
+	accountGroup2, _, err := store2.GetGroupForAccount()
+	require.NoError(t, err)
+	require.Equal(t, accountGroup1.PublicKey, accountGroup2.PublicKey)
+	require.Equal(t, accountGroup1.Secret, accountGroup2.Secret)
+
+	restoredMnemonic, err := store2.GetAccountMnemonic(ctx)
+	require.NoError(t, err)
+	require.Equal(t, mnemonic, restoredMnemonic)
+
+	// the keys derived from the previous account are not used anymore
+	contactGroup1, err := store1.GetGroupForContact(contactPrivateKey.GetPublic())
+	require.NoError(t, err)
+
+	contactGroup2, err := store2.GetGroupForContact(contactPrivateKey.GetPublic())
+	require.NoError(t, err)
+	require.Equal(t, contactGroup1.PublicKey, contactGroup2.PublicKey)
+
+	require.Error(t, store2.RestoreAccountMnemonic(ctx, "not a mnemonic"))
+}
+
+func TestAccountMnemonicUnavailable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source, err := newInMemSecretStore(nil)
+	require.NoError(t, err)
+
+	accountPrivateKeyBytes, accountProofPrivateKeyBytes, err := source.ExportAccountKeysForBackup()
+	require.NoError(t, err)
+
+	// the keys imported from a backup are not derived from a known mnemonic
+	imported, err := newInMemSecretStore(nil)
+	require.NoError(t, err)
+	require.NoError(t, imported.ImportAccountKeys(accountPrivateKeyBytes, accountProofPrivateKeyBytes))
+
+	_, err = imported.GetAccountMnemonic(ctx)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrNotFound))
+
+	// nor the rotated ones
+	_, err = source.GetAccountMnemonic(ctx)
+	require.NoError(t, err)
+
+	_, err = source.RotateAccountKey(ctx)
+	require.NoError(t, err)
+
+	_, err = source.GetAccountMnemonic(ctx)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrNotFound))
+}
@@ -34,27 +34,37 @@ func encryptDeviceChainKey(localDevicePrivateKey crypto.PrivKey, remoteMemberPub
 		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
 	}
 
-	mongPriv, mongPub, err := cryptoutil.EdwardsToMontgomery(localDevicePrivateKey, remoteMemberPubKey)
+	mongPub, err := cryptoutil.EdwardsToMontgomeryPub(remoteMemberPubKey)
 	if err != nil {
 		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
 	}
 
+	var sharedKey [cryptoutil.KeySize]byte
+	if err := cryptoutil.PrecomputeBoxKey(&sharedKey, localDevicePrivateKey, mongPub); err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
+	}
+
 	nonce := groupIDToNonce(group)
-	encryptedChainKey := box.Seal(nil, chainKeyBytes, nonce, mongPub, mongPriv)
+	encryptedChainKey := box.SealAfterPrecomputation(nil, chainKeyBytes, nonce, &sharedKey)
 
 	return encryptedChainKey, nil
 }
 
 // decryptDeviceChainKey decrypts a chain key sent by the given device
 func decryptDeviceChainKey(encryptedDeviceChainKey []byte, group *protocoltypes.Group, localMemberPrivateKey crypto.PrivKey, senderDevicePubKey crypto.PubKey) (*protocoltypes.DeviceChainKey, error) {
-	mongPriv, mongPub, err := cryptoutil.EdwardsToMontgomery(localMemberPrivateKey, senderDevicePubKey)
+	mongPub, err := cryptoutil.EdwardsToMontgomeryPub(senderDevicePubKey)
 	if err != nil {
 		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
 	}
 
+	var sharedKey [cryptoutil.KeySize]byte
+	if err := cryptoutil.PrecomputeBoxKey(&sharedKey, localMemberPrivateKey, mongPub); err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
+	}
+
 	nonce := groupIDToNonce(group)
 	decryptedSecret := &protocoltypes.DeviceChainKey{}
-	decryptedMessage, ok := box.Open(nil, encryptedDeviceChainKey, nonce, mongPub, mongPriv)
+	decryptedMessage, ok := box.OpenAfterPrecomputation(nil, encryptedDeviceChainKey, nonce, &sharedKey)
 	if !ok {
 		return nil, errcode.ErrCode_ErrCryptoDecrypt.Wrap(fmt.Errorf("unable to decrypt message"))
 	}
@@ -0,0 +1,102 @@
+package secretstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+func (s *secretStore) GetContactResumptionSecret(ctx context.Context, contactPublicKey crypto.PubKey) ([]byte, []byte, error) {
+	contactPKBytes, err := contactPublicKey.Raw()
+	if err != nil {
+		return nil, nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	record, err := s.getContactResumptionSecret(ctx, contactPKBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return record.Id, record.Secret, nil
+}
+
+func (s *secretStore) GetContactResumptionSecretByID(ctx context.Context, id []byte) (crypto.PubKey, []byte, error) {
+	contactPKBytes, err := s.datastore.Get(ctx, dsKeyForContactResumptionID(id))
+	if err != nil {
+		return nil, nil, errcode.ErrCode_ErrMissingMapKey.Wrap(err)
+	}
+
+	record, err := s.getContactResumptionSecret(ctx, contactPKBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contactPublicKey, err := crypto.UnmarshalEd25519PublicKey(record.ContactPk)
+	if err != nil {
+		return nil, nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	return contactPublicKey, record.Secret, nil
+}
+
+func (s *secretStore) PutContactResumptionSecret(ctx context.Context, contactPublicKey crypto.PubKey, id []byte, secret []byte) error {
+	if len(id) == 0 || len(secret) == 0 {
+		return errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("an id and a secret are required"))
+	}
+
+	contactPKBytes, err := contactPublicKey.Raw()
+	if err != nil {
+		return errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	data, err := proto.Marshal(&protocoltypes.ContactResumptionSecret{
+		ContactPk: contactPKBytes,
+		Id:        id,
+		Secret:    secret,
+	})
+	if err != nil {
+		return errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	s.resumptionMutex.Lock()
+	defer s.resumptionMutex.Unlock()
+
+	// the previous secret can't be used anymore
+	if previous, err := s.getContactResumptionSecret(ctx, contactPKBytes); err == nil {
+		if err := s.datastore.Delete(ctx, dsKeyForContactResumptionID(previous.Id)); err != nil {
+			return errcode.ErrCode_ErrKeystorePut.Wrap(err)
+		}
+	}
+
+	if err := s.datastore.Put(ctx, dsKeyForContactResumptionSecret(contactPKBytes), data); err != nil {
+		return errcode.ErrCode_ErrKeystorePut.Wrap(err)
+	}
+
+	if err := s.datastore.Put(ctx, dsKeyForContactResumptionID(id), contactPKBytes); err != nil {
+		return errcode.ErrCode_ErrKeystorePut.Wrap(err)
+	}
+
+	return nil
+}
+
+func (s *secretStore) getContactResumptionSecret(ctx context.Context, contactPKBytes []byte) (*protocoltypes.ContactResumptionSecret, error) {
+	data, err := s.datastore.Get(ctx, dsKeyForContactResumptionSecret(contactPKBytes))
+	if err == datastore.ErrNotFound {
+		return nil, errcode.ErrCode_ErrMissingMapKey.Wrap(err)
+	} else if err != nil {
+		return nil, errcode.ErrCode_ErrKeystoreGet.Wrap(err)
+	}
+
+	record := &protocoltypes.ContactResumptionSecret{}
+	if err := proto.Unmarshal(data, record); err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	return record, nil
+}
@@ -0,0 +1,54 @@
+package secretstore
+
+import (
+	"context"
+	crand "crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+)
+
+func TestContactResumptionSecret(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	secretStore, err := newInMemSecretStore(nil)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = secretStore.Close()
+	})
+
+	_, contactPK, err := crypto.GenerateEd25519Key(crand.Reader)
+	require.NoError(t, err)
+
+	_, _, err = secretStore.GetContactResumptionSecret(ctx, contactPK)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrMissingMapKey))
+
+	require.Error(t, secretStore.PutContactResumptionSecret(ctx, contactPK, nil, []byte("secret")))
+
+	require.NoError(t, secretStore.PutContactResumptionSecret(ctx, contactPK, []byte("id1"), []byte("secret1")))
+
+	id, secret, err := secretStore.GetContactResumptionSecret(ctx, contactPK)
+	require.NoError(t, err)
+	require.Equal(t, []byte("id1"), id)
+	require.Equal(t, []byte("secret1"), secret)
+
+	pk, secret, err := secretStore.GetContactResumptionSecretByID(ctx, []byte("id1"))
+	require.NoError(t, err)
+	require.True(t, pk.Equals(contactPK))
+	require.Equal(t, []byte("secret1"), secret)
+
+	// a new secret replaces the previous one
+	require.NoError(t, secretStore.PutContactResumptionSecret(ctx, contactPK, []byte("id2"), []byte("secret2")))
+
+	_, _, err = secretStore.GetContactResumptionSecretByID(ctx, []byte("id1"))
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrMissingMapKey))
+
+	pk, secret, err = secretStore.GetContactResumptionSecretByID(ctx, []byte("id2"))
+	require.NoError(t, err)
+	require.True(t, pk.Equals(contactPK))
+	require.Equal(t, []byte("secret2"), secret)
+}
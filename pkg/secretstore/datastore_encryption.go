@@ -0,0 +1,252 @@
+package secretstore
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/internal/datastoreutil"
+	"berty.tech/weshnet/v2/pkg/cryptoutil"
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+// KeyProvider provides the key protecting the secret store datastore.
+//
+// The values of the datastore are encrypted using a random datastore key,
+// itself wrapped using the key returned by the provider. Changing the
+// provided key only requires to wrap the datastore key again.
+type KeyProvider interface {
+	// DatastoreKey returns a 32 bytes key, salt is a random value unique to
+	// the datastore which can be used to derive it
+	DatastoreKey(ctx context.Context, salt []byte) ([]byte, error)
+}
+
+type passphraseKeyProvider struct {
+	passphrase []byte
+}
+
+// NewPassphraseKeyProvider returns a KeyProvider deriving the key from a
+// passphrase using scrypt
+func NewPassphraseKeyProvider(passphrase []byte) KeyProvider {
+	return &passphraseKeyProvider{passphrase: passphrase}
+}
+
+func (p *passphraseKeyProvider) DatastoreKey(_ context.Context, salt []byte) ([]byte, error) {
+	if len(p.passphrase) == 0 {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("empty passphrase"))
+	}
+
+	key, _, err := cryptoutil.DeriveKey(p.passphrase, salt)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyDerivation.Wrap(err)
+	}
+
+	return key, nil
+}
+
+type staticKeyProvider struct {
+	key []byte
+}
+
+// NewStaticKeyProvider returns a KeyProvider using the given 32 bytes key as
+// is, e.g. a key retrieved from the keychain of the platform
+func NewStaticKeyProvider(key []byte) KeyProvider {
+	return &staticKeyProvider{key: key}
+}
+
+func (p *staticKeyProvider) DatastoreKey(context.Context, []byte) ([]byte, error) {
+	if len(p.key) != datastoreutil.EncryptedDatastoreKeySize {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("invalid key size %d", len(p.key)))
+	}
+
+	return p.key, nil
+}
+
+// IsDatastoreEncrypted returns whether the secret store data of the given
+// datastore is encrypted and requires a KeyProvider to be opened
+func IsDatastoreEncrypted(ctx context.Context, rootDatastore datastore.Datastore) (bool, error) {
+	ok, err := rootDatastore.Has(ctx, dsKeyForEncryptionHeader())
+	if err != nil {
+		return false, errcode.ErrCode_ErrDBRead.Wrap(err)
+	}
+
+	return ok, nil
+}
+
+// ChangeDatastoreKey replaces the key protecting the secret store data of the
+// given datastore, the data itself isn't encrypted again
+func ChangeDatastoreKey(ctx context.Context, rootDatastore datastore.Datastore, current KeyProvider, next KeyProvider) error {
+	if current == nil || next == nil {
+		return errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("a key provider is required"))
+	}
+
+	header, err := getEncryptionHeader(ctx, rootDatastore)
+	if err != nil {
+		return err
+	}
+
+	datastoreKey, err := unwrapDatastoreKey(ctx, header, current)
+	if err != nil {
+		return err
+	}
+
+	if err := wrapDatastoreKey(ctx, header, next, datastoreKey); err != nil {
+		return err
+	}
+
+	return putEncryptionHeader(ctx, rootDatastore, header)
+}
+
+// openEncryptedDatastore returns a datastore encrypting the values of the
+// root datastore, the datastore key is created on first use and the values
+// previously stored in plaintext by the secret store are encrypted
+func openEncryptedDatastore(ctx context.Context, rootDatastore datastore.Datastore, provider KeyProvider, logger *zap.Logger) (datastore.Datastore, error) {
+	header, err := getEncryptionHeader(ctx, rootDatastore)
+	var datastoreKey []byte
+
+	switch {
+	case errcode.Is(err, errcode.ErrCode_ErrMissingMapKey):
+		datastoreKey, err = cryptoutil.GenerateNonceSize(datastoreutil.EncryptedDatastoreKeySize)
+		if err != nil {
+			return nil, err
+		}
+
+		header = &protocoltypes.SecretStoreEncryptionHeader{}
+		if err := wrapDatastoreKey(ctx, header, provider, datastoreKey); err != nil {
+			return nil, err
+		}
+
+		if err := putEncryptionHeader(ctx, rootDatastore, header); err != nil {
+			return nil, err
+		}
+
+	case err != nil:
+		return nil, err
+
+	default:
+		if datastoreKey, err = unwrapDatastoreKey(ctx, header, provider); err != nil {
+			return nil, err
+		}
+	}
+
+	encrypted, err := datastoreutil.NewEncryptedDatastore(rootDatastore, datastoreKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if !header.Migrated {
+		count, err := encryptPlaintextValues(ctx, rootDatastore, encrypted)
+		if err != nil {
+			return nil, err
+		}
+
+		header.Migrated = true
+		if err := putEncryptionHeader(ctx, rootDatastore, header); err != nil {
+			return nil, err
+		}
+
+		logger.Info("secret store datastore encrypted", zap.Int("migrated", count))
+	}
+
+	return encrypted, nil
+}
+
+// encryptPlaintextValues encrypts the values written by the secret store
+// before the encryption was enabled, it can safely be interrupted
+func encryptPlaintextValues(ctx context.Context, rootDatastore datastore.Datastore, encrypted datastore.Datastore) (int, error) {
+	count := 0
+
+	for _, ns := range secretStoreNamespaces {
+		res, err := rootDatastore.Query(ctx, query.Query{Prefix: datastore.NewKey(ns).String()})
+		if err != nil {
+			return count, errcode.ErrCode_ErrDBRead.Wrap(err)
+		}
+
+		entries, err := res.Rest()
+		if err != nil {
+			return count, errcode.ErrCode_ErrDBRead.Wrap(err)
+		}
+
+		for _, e := range entries {
+			if datastoreutil.IsEncryptedValue(e.Value) {
+				continue
+			}
+
+			if err := encrypted.Put(ctx, datastore.NewKey(e.Key), e.Value); err != nil {
+				return count, errcode.ErrCode_ErrDBWrite.Wrap(err)
+			}
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func wrapDatastoreKey(ctx context.Context, header *protocoltypes.SecretStoreEncryptionHeader, provider KeyProvider, datastoreKey []byte) error {
+	salt, err := cryptoutil.GenerateNonceSize(cryptoutil.ScryptKeyLen)
+	if err != nil {
+		return err
+	}
+
+	key, err := provider.DatastoreKey(ctx, salt)
+	if err != nil {
+		return err
+	}
+
+	wrappedKey, err := cryptoutil.AESGCMEncrypt(key, datastoreKey)
+	if err != nil {
+		return errcode.ErrCode_ErrCryptoEncrypt.Wrap(err)
+	}
+
+	header.Salt, header.WrappedKey = salt, wrappedKey
+
+	return nil
+}
+
+func unwrapDatastoreKey(ctx context.Context, header *protocoltypes.SecretStoreEncryptionHeader, provider KeyProvider) ([]byte, error) {
+	key, err := provider.DatastoreKey(ctx, header.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	datastoreKey, err := cryptoutil.AESGCMDecrypt(key, header.WrappedKey)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoDecrypt.Wrap(fmt.Errorf("invalid secret store key: %w", err))
+	}
+
+	return datastoreKey, nil
+}
+
+func getEncryptionHeader(ctx context.Context, rootDatastore datastore.Datastore) (*protocoltypes.SecretStoreEncryptionHeader, error) {
+	data, err := rootDatastore.Get(ctx, dsKeyForEncryptionHeader())
+	if err == datastore.ErrNotFound {
+		return nil, errcode.ErrCode_ErrMissingMapKey.Wrap(err)
+	} else if err != nil {
+		return nil, errcode.ErrCode_ErrDBRead.Wrap(err)
+	}
+
+	header := &protocoltypes.SecretStoreEncryptionHeader{}
+	if err := proto.Unmarshal(data, header); err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	return header, nil
+}
+
+func putEncryptionHeader(ctx context.Context, rootDatastore datastore.Datastore, header *protocoltypes.SecretStoreEncryptionHeader) error {
+	data, err := proto.Marshal(header)
+	if err != nil {
+		return errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	if err := rootDatastore.Put(ctx, dsKeyForEncryptionHeader(), data); err != nil {
+		return errcode.ErrCode_ErrDBWrite.Wrap(err)
+	}
+
+	return nil
+}
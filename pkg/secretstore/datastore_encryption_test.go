@@ -0,0 +1,98 @@
+package secretstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/weshnet/v2/internal/datastoreutil"
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+func TestEncryptedSecretStore(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rootDatastore := dssync.MutexWrap(datastore.NewMapDatastore())
+
+	g, _, err := protocoltypes.NewGroupMultiMember()
+	require.NoError(t, err)
+
+	gPK, err := g.GetPubKey()
+	require.NoError(t, err)
+
+	// data written in plaintext before the encryption is enabled
+	plaintextStore, err := newSecretStore(rootDatastore, nil)
+	require.NoError(t, err)
+	require.NoError(t, plaintextStore.PutGroup(ctx, g))
+
+	omd, err := plaintextStore.GetOwnMemberDeviceForGroup(g)
+	require.NoError(t, err)
+
+	// the plaintext values are encrypted when opening with a key provider
+	store, err := newSecretStore(rootDatastore, &NewSecretStoreOptions{KeyProvider: NewPassphraseKeyProvider([]byte("passphrase"))})
+	require.NoError(t, err)
+
+	for _, ns := range secretStoreNamespaces {
+		res, err := rootDatastore.Query(ctx, query.Query{Prefix: datastore.NewKey(ns).String()})
+		require.NoError(t, err)
+
+		entries, err := res.Rest()
+		require.NoError(t, err)
+
+		for _, e := range entries {
+			require.True(t, datastoreutil.IsEncryptedValue(e.Value), e.Key)
+		}
+	}
+
+	fetched, err := store.FetchGroupByPublicKey(ctx, gPK)
+	require.NoError(t, err)
+	require.Equal(t, g.PublicKey, fetched.PublicKey)
+
+	encryptedOMD, err := store.GetOwnMemberDeviceForGroup(g)
+	require.NoError(t, err)
+	require.True(t, omd.Device().Equals(encryptedOMD.Device()))
+
+	// a key provider is now required
+	_, err = newSecretStore(rootDatastore, nil)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrInvalidInput))
+
+	_, err = newSecretStore(rootDatastore, &NewSecretStoreOptions{KeyProvider: NewPassphraseKeyProvider([]byte("wrong"))})
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrCryptoDecrypt))
+
+	// changing the key keeps the data readable
+	newKey := make([]byte, datastoreutil.EncryptedDatastoreKeySize)
+	newKey[0] = 1
+
+	require.Error(t, ChangeDatastoreKey(ctx, rootDatastore, NewPassphraseKeyProvider([]byte("wrong")), NewStaticKeyProvider(newKey)))
+	require.NoError(t, ChangeDatastoreKey(ctx, rootDatastore, NewPassphraseKeyProvider([]byte("passphrase")), NewStaticKeyProvider(newKey)))
+
+	_, err = newSecretStore(rootDatastore, &NewSecretStoreOptions{KeyProvider: NewPassphraseKeyProvider([]byte("passphrase"))})
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrCryptoDecrypt))
+
+	store, err = newSecretStore(rootDatastore, &NewSecretStoreOptions{KeyProvider: NewStaticKeyProvider(newKey)})
+	require.NoError(t, err)
+
+	fetched, err = store.FetchGroupByPublicKey(ctx, gPK)
+	require.NoError(t, err)
+	require.Equal(t, g.PublicKey, fetched.PublicKey)
+
+	// values can't be moved to another key
+	other, _, err := protocoltypes.NewGroupMultiMember()
+	require.NoError(t, err)
+
+	value, err := rootDatastore.Get(ctx, dsKeyForGroup(g.PublicKey))
+	require.NoError(t, err)
+	require.NoError(t, rootDatastore.Put(ctx, dsKeyForGroup(other.PublicKey), value))
+
+	otherPK, err := other.GetPubKey()
+	require.NoError(t, err)
+
+	_, err = store.FetchGroupByPublicKey(ctx, otherPK)
+	require.Error(t, err)
+}
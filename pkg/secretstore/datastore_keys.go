@@ -20,6 +20,17 @@ const (
 	// then put in the dsNamespacePrecomputedMessageKeys namespace.
 	dsNamespaceChainKeyForDeviceOnGroup = "chainKeyForDeviceOnGroup"
 
+	// dsNamespaceHistoryChainKeyForDeviceOnGroup is a namespace storing the
+	// oldest known state of a device chain key for a given group.
+	// It is shared with the new members of the groups allowing it, so they
+	// can decrypt the messages sent before they joined.
+	dsNamespaceHistoryChainKeyForDeviceOnGroup = "historyChainKeyForDeviceOnGroup"
+
+	// dsNamespaceHistorySharingGroup is a namespace marking the multi member
+	// groups sharing their history, only their chain keys are kept in the
+	// dsNamespaceHistoryChainKeyForDeviceOnGroup namespace.
+	dsNamespaceHistorySharingGroup = "historySharingGroup"
+
 	// dsNamespacePrecomputedMessageKeys is a namespace storing precomputed
 	// message keys for a given group, device and message counter.
 	// As the chain key stored has already been derived, these message keys
@@ -46,8 +57,94 @@ const (
 	// dsNamespaceGroupDatastore is a namespace to store groups by their public
 	// key
 	dsNamespaceGroupDatastore = "groupByPublicKey"
+
+	// dsNamespaceMessageKeyCIDsForGroup is a namespace indexing the CIDs of
+	// the dsNamespaceMessageKeyForCIDs namespace by group, so they can be
+	// removed once the group is left
+	dsNamespaceMessageKeyCIDsForGroup = "messageKeyCIDsForGroup"
+
+	// dsNamespaceDeviceLastSeenOnGroup is a namespace storing the last time
+	// a message or a chain key has been received from a device of a group
+	dsNamespaceDeviceLastSeenOnGroup = "deviceLastSeenOnGroup"
+
+	// dsNamespaceContactResumptionSecret is a namespace storing the secret
+	// shared with a contact after a handshake, used to resume the next one
+	dsNamespaceContactResumptionSecret = "contactResumptionSecret"
+
+	// dsNamespaceContactResumptionID is a namespace associating the ID of a
+	// resumption secret to the contact public key sharing it
+	dsNamespaceContactResumptionID = "contactResumptionID"
+
+	// dsNamespaceAccountGroupPublicKey is a namespace storing the public key
+	// of the account group once the account key has been rotated, as the
+	// account group is not derived from the current account key anymore
+	dsNamespaceAccountGroupPublicKey = "accountGroupPublicKey"
+
+	// dsNamespaceAccountMnemonic is a namespace storing the mnemonic from
+	// which the account keys have been derived
+	dsNamespaceAccountMnemonic = "accountMnemonic"
+
+	// dsNamespaceTreeKEMEpochKeys is a namespace storing the key material of
+	// the current device for the epochs of the ratchet tree of a group using
+	// the TreeKEM keying
+	dsNamespaceTreeKEMEpochKeys = "treeKEMEpochKeys"
+
+	// dsNamespaceTreeKEMCurrentEpoch is a namespace storing the identifier of
+	// the epoch used to encrypt the messages sent on a group using the
+	// TreeKEM keying
+	dsNamespaceTreeKEMCurrentEpoch = "treeKEMCurrentEpoch"
+
+	// dsNamespaceTreeKEMCounter is a namespace storing the counter of the
+	// last message sent by the current device on a group using the TreeKEM
+	// keying
+	dsNamespaceTreeKEMCounter = "treeKEMCounter"
+
+	// dsNamespaceTreeKEMDeviceOnGroup is a namespace indexing the devices of
+	// the known epochs of a group using the TreeKEM keying, whose messages
+	// can be decrypted
+	dsNamespaceTreeKEMDeviceOnGroup = "treeKEMDeviceOnGroup"
+
+	// dsNamespaceEncryptionHeader is a namespace storing, in plaintext, the
+	// wrapped key used to encrypt the values of the other namespaces
+	dsNamespaceEncryptionHeader = "secretStoreEncryption"
 )
 
+// secretStoreNamespaces lists the namespaces written by the secret store
+// whose values are encrypted when a KeyProvider is set
+var secretStoreNamespaces = []string{
+	namespaceDeviceKeystore,
+	dsNamespaceChainKeyForDeviceOnGroup,
+	dsNamespaceHistoryChainKeyForDeviceOnGroup,
+	dsNamespaceHistorySharingGroup,
+	dsNamespacePrecomputedMessageKeys,
+	dsNamespaceMessageKeyForCIDs,
+	dsNamespaceOutOfStoreGroupHint,
+	dsNamespaceOutOfStoreGroupHintCounters,
+	dsNamespaceGroupDatastore,
+	dsNamespaceMessageKeyCIDsForGroup,
+	dsNamespaceDeviceLastSeenOnGroup,
+	dsNamespaceContactResumptionSecret,
+	dsNamespaceContactResumptionID,
+	dsNamespaceAccountGroupPublicKey,
+	dsNamespaceAccountMnemonic,
+	dsNamespaceTreeKEMEpochKeys,
+	dsNamespaceTreeKEMCurrentEpoch,
+	dsNamespaceTreeKEMCounter,
+	dsNamespaceTreeKEMDeviceOnGroup,
+}
+
+func dsKeyForEncryptionHeader() datastore.Key {
+	return datastore.NewKey(dsNamespaceEncryptionHeader)
+}
+
+func dsKeyForAccountGroupPublicKey() datastore.Key {
+	return datastore.NewKey(dsNamespaceAccountGroupPublicKey)
+}
+
+func dsKeyForAccountMnemonic() datastore.Key {
+	return datastore.NewKey(dsNamespaceAccountMnemonic)
+}
+
 func dsKeyForGroup(key []byte) datastore.Key {
 	return datastore.KeyWithNamespaces([]string{
 		dsNamespaceGroupDatastore,
@@ -86,6 +183,35 @@ func dsKeyForCurrentChainKey(groupPublicKey crypto.PubKey, devicePublicKey crypt
 	}), nil
 }
 
+// dsKeyForHistoryChainKey returns a datastore.Key where will be stored the
+// oldest known device chain key for a given group.
+func dsKeyForHistoryChainKey(groupPublicKey crypto.PubKey, devicePublicKey crypto.PubKey) (datastore.Key, error) {
+	devicePublicKeyBytes, err := devicePublicKey.Raw()
+	if err != nil {
+		return datastore.Key{}, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	groupPublicKeyBytes, err := groupPublicKey.Raw()
+	if err != nil {
+		return datastore.Key{}, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	return datastore.KeyWithNamespaces([]string{
+		dsNamespaceHistoryChainKeyForDeviceOnGroup,
+		hex.EncodeToString(groupPublicKeyBytes),
+		hex.EncodeToString(devicePublicKeyBytes),
+	}), nil
+}
+
+// dsKeyForHistorySharingGroup returns a datastore.Key marking a group whose
+// history is shared with its new members.
+func dsKeyForHistorySharingGroup(groupPublicKey []byte) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{
+		dsNamespaceHistorySharingGroup,
+		hex.EncodeToString(groupPublicKey),
+	})
+}
+
 // dsKeyForMessageKeyByCID returns a datastore.Key where will be stored a
 // message decryption key for a given message CID.
 func dsKeyForMessageKeyByCID(id cid.Cid) datastore.Key {
@@ -96,6 +222,26 @@ func dsKeyForMessageKeyByCID(id cid.Cid) datastore.Key {
 	})
 }
 
+// dsKeyForMessageKeyCIDForGroup returns a datastore.Key indexing a message
+// CID of the dsNamespaceMessageKeyForCIDs namespace for a given group.
+func dsKeyForMessageKeyCIDForGroup(groupPublicKey []byte, id cid.Cid) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{
+		dsNamespaceMessageKeyCIDsForGroup,
+		hex.EncodeToString(groupPublicKey),
+		id.String(),
+	})
+}
+
+// dsKeyForDeviceLastSeen returns a datastore.Key where will be stored the
+// last time a device has been seen on a given group.
+func dsKeyForDeviceLastSeen(groupPublicKey, devicePublicKey []byte) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{
+		dsNamespaceDeviceLastSeenOnGroup,
+		hex.EncodeToString(groupPublicKey),
+		hex.EncodeToString(devicePublicKey),
+	})
+}
+
 // dsKeyForOutOfStoreMessageGroupHint returns a datastore.Key where will be
 // stored a group public key for a given push group reference.
 func dsKeyForOutOfStoreMessageGroupHint(ref []byte) datastore.Key {
@@ -115,3 +261,59 @@ func dsKeyForOutOfStoreFirstLastCounters(groupPK, devicePK []byte) datastore.Key
 		base64.RawURLEncoding.EncodeToString(devicePK),
 	})
 }
+
+// dsKeyForContactResumptionSecret returns the datastore.Key where will be
+// stored the resumption secret shared with a contact.
+func dsKeyForContactResumptionSecret(contactPK []byte) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{
+		dsNamespaceContactResumptionSecret,
+		base64.RawURLEncoding.EncodeToString(contactPK),
+	})
+}
+
+// dsKeyForContactResumptionID returns the datastore.Key where will be stored
+// the contact public key for a given resumption secret ID.
+func dsKeyForContactResumptionID(id []byte) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{
+		dsNamespaceContactResumptionID,
+		base64.RawURLEncoding.EncodeToString(id),
+	})
+}
+
+// dsKeyForTreeKEMEpochKeys returns the datastore.Key where will be stored the
+// key material of the current device for an epoch of a given group.
+func dsKeyForTreeKEMEpochKeys(groupPublicKey, epochID []byte) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{
+		dsNamespaceTreeKEMEpochKeys,
+		hex.EncodeToString(groupPublicKey),
+		hex.EncodeToString(epochID),
+	})
+}
+
+// dsKeyForTreeKEMCurrentEpoch returns the datastore.Key where will be stored
+// the identifier of the current epoch of a given group.
+func dsKeyForTreeKEMCurrentEpoch(groupPublicKey []byte) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{
+		dsNamespaceTreeKEMCurrentEpoch,
+		hex.EncodeToString(groupPublicKey),
+	})
+}
+
+// dsKeyForTreeKEMCounter returns the datastore.Key where will be stored the
+// counter of the last message sent by the current device on a given group.
+func dsKeyForTreeKEMCounter(groupPublicKey []byte) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{
+		dsNamespaceTreeKEMCounter,
+		hex.EncodeToString(groupPublicKey),
+	})
+}
+
+// dsKeyForTreeKEMDevice returns the datastore.Key indexing a device of a known
+// epoch of a given group.
+func dsKeyForTreeKEMDevice(groupPublicKey, devicePublicKey []byte) datastore.Key {
+	return datastore.KeyWithNamespaces([]string{
+		dsNamespaceTreeKEMDeviceOnGroup,
+		hex.EncodeToString(groupPublicKey),
+		hex.EncodeToString(devicePublicKey),
+	})
+}
@@ -1,6 +1,7 @@
 package secretstore
 
 import (
+	"context"
 	"crypto/ed25519"
 	crand "crypto/rand"
 	"encoding/hex"
@@ -8,7 +9,6 @@ import (
 	"strings"
 	"sync"
 
-	"github.com/aead/ecdh"
 	keystore "github.com/ipfs/go-ipfs-keystore"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"go.uber.org/zap"
@@ -33,35 +33,54 @@ type deviceKeystore struct {
 	keystore keystore.Keystore
 	mu       sync.Mutex
 	logger   *zap.Logger
+
+	// signer, if set, holds the account and device keys
+	signer     Signer
+	signerKeys map[string]crypto.PrivKey
+
+	// newAccountKeys, if set, generates the keys of a new account, they are
+	// randomly generated otherwise
+	newAccountKeys func(ctx context.Context) (accountPrivateKey crypto.PrivKey, accountProofPrivateKey crypto.PrivKey, err error)
 }
 
 // newDeviceKeystore instantiate a new device keystore
-func newDeviceKeystore(ks keystore.Keystore, logger *zap.Logger) *deviceKeystore {
+func newDeviceKeystore(ks keystore.Keystore, signer Signer, logger *zap.Logger) *deviceKeystore {
 	if logger == nil {
 		logger = zap.NewNop()
 	}
 
 	return &deviceKeystore{
-		keystore: ks,
-		logger:   logger,
+		keystore:   ks,
+		logger:     logger,
+		signer:     signer,
+		signerKeys: make(map[string]crypto.PrivKey),
 	}
 }
 
-// getAccountPrivateKey returns the private key of the current account
-func (a *deviceKeystore) getAccountPrivateKey() (crypto.PrivKey, error) {
+// getAccountPrivateKey returns the private key of the current account, the
+// account keys are generated if needed
+func (a *deviceKeystore) getAccountPrivateKey(ctx context.Context) (crypto.PrivKey, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	return a.getOrGenerateNamedKey(keyAccount)
+	if a.signer != nil {
+		return a.getSignerKey(SignerKeyAccount)
+	}
+
+	return a.getOrGenerateAccountKey(ctx, keyAccount)
 }
 
 // getAccountProofPrivateKey returns the private proof key of
 // the current account
-func (a *deviceKeystore) getAccountProofPrivateKey() (crypto.PrivKey, error) {
+func (a *deviceKeystore) getAccountProofPrivateKey(ctx context.Context) (crypto.PrivKey, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
-	return a.getOrGenerateNamedKey(keyAccountProof)
+	if a.signer != nil {
+		return a.getOrGenerateNamedKey(keyAccountProof)
+	}
+
+	return a.getOrGenerateAccountKey(ctx, keyAccountProof)
 }
 
 // devicePrivateKey returns the current private key of the current device for
@@ -70,14 +89,38 @@ func (a *deviceKeystore) devicePrivateKey() (crypto.PrivKey, error) {
 	a.mu.Lock()
 	defer a.mu.Unlock()
 
+	if a.signer != nil {
+		return a.getSignerKey(SignerKeyDevice)
+	}
+
 	return a.getOrGenerateNamedKey(keyDevice)
 }
 
+// getSignerKey returns a private key whose operations are delegated to the
+// signer
+func (a *deviceKeystore) getSignerKey(name string) (crypto.PrivKey, error) {
+	if privateKey, ok := a.signerKeys[name]; ok {
+		return privateKey, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), SignerTimeout)
+	defer cancel()
+
+	privateKey, err := newSignerPrivKey(ctx, a.signer, name)
+	if err != nil {
+		return nil, err
+	}
+
+	a.signerKeys[name] = privateKey
+
+	return privateKey, nil
+}
+
 // contactGroupPrivateKey retrieves the key for the contact group
 // shared with the supplied contact's public key, this key will be derived to
 // form the contact group keys
 func (a *deviceKeystore) contactGroupPrivateKey(contactPublicKey crypto.PubKey) (crypto.PrivKey, error) {
-	accountPrivateKey, err := a.getAccountPrivateKey()
+	accountPrivateKey, err := a.getAccountPrivateKey(context.Background())
 	if err != nil {
 		return nil, errcode.ErrCode_ErrInternal.Wrap(err)
 	}
@@ -111,7 +154,7 @@ func (a *deviceKeystore) memberDeviceForGroup(group *protocoltypes.Group) (*ownM
 
 	switch group.GetGroupType() {
 	case protocoltypes.GroupType_GroupTypeAccount, protocoltypes.GroupType_GroupTypeContact:
-		memberPrivateKey, err := a.getAccountPrivateKey()
+		memberPrivateKey, err := a.getAccountPrivateKey(context.Background())
 		if err != nil {
 			return nil, errcode.ErrCode_ErrInternal.Wrap(err)
 		}
@@ -152,6 +195,40 @@ func (a *deviceKeystore) getOrGenerateNamedKey(name string) (crypto.PrivKey, err
 	return privateKey, nil
 }
 
+// getOrGenerateAccountKey retrieves an account key by its name, both account
+// keys are generated together when none of them exists
+func (a *deviceKeystore) getOrGenerateAccountKey(ctx context.Context, name string) (crypto.PrivKey, error) {
+	if a.newAccountKeys == nil {
+		return a.getOrGenerateNamedKey(name)
+	}
+
+	for _, keyName := range []string{keyAccount, keyAccountProof} {
+		if exists, err := a.keystore.Has(keyName); err != nil {
+			return nil, errcode.ErrCode_ErrDBRead.Wrap(err)
+		} else if exists {
+			return a.getOrGenerateNamedKey(name)
+		}
+	}
+
+	accountPrivateKey, accountProofPrivateKey, err := a.newAccountKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	privateKeys := map[string]crypto.PrivKey{
+		keyAccount:      accountPrivateKey,
+		keyAccountProof: accountProofPrivateKey,
+	}
+
+	for keyName, privateKey := range privateKeys {
+		if err := a.keystore.Put(keyName, privateKey); err != nil {
+			return nil, errcode.ErrCode_ErrDBWrite.Wrap(fmt.Errorf("unable to perform put operation on keystore: %w", err))
+		}
+	}
+
+	return privateKeys[name], nil
+}
+
 // getOrGenerateDeviceKeyForMultiMemberGroup fetches or generate a new device
 // key for a multi-member group. The results do not need to be deterministic
 // as it will only be used on the current device.
@@ -189,13 +266,17 @@ func (a *deviceKeystore) getOrComputeECDH(nameSpace string, publicKey crypto.Pub
 		return nil, errcode.ErrCode_ErrDBRead.Wrap(fmt.Errorf("unable to perform get operation on keystore: %w", err))
 	}
 
-	privateKeyBytes, publicKeyBytes, err := cryptoutil.EdwardsToMontgomery(ownPrivateKey, publicKey)
+	mongPub, err := cryptoutil.EdwardsToMontgomeryPub(publicKey)
 	if err != nil {
 		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
 	}
 
-	secret := ecdh.X25519().ComputeSecret(privateKeyBytes, publicKeyBytes)
-	groupSecretPrivateKey := ed25519.NewKeyFromSeed(secret)
+	secret, err := cryptoutil.X25519(ownPrivateKey, mongPub)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
+	}
+
+	groupSecretPrivateKey := ed25519.NewKeyFromSeed(secret[:])
 
 	privateKey, _, err = crypto.KeyPairFromStdKey(&groupSecretPrivateKey)
 	if err != nil {
@@ -213,7 +294,7 @@ func (a *deviceKeystore) getOrComputeECDH(nameSpace string, publicKey crypto.Pub
 // for a multi member group, this allows a group to be joined from two
 // different devices simultaneously without requiring a consensus.
 func (a *deviceKeystore) computeMemberKeyForMultiMemberGroup(groupPublicKey crypto.PubKey) (crypto.PrivKey, error) {
-	accountProofPrivateKey, err := a.getAccountProofPrivateKey()
+	accountProofPrivateKey, err := a.getAccountProofPrivateKey(context.Background())
 	if err != nil {
 		return nil, errcode.ErrCode_ErrInternal.Wrap(err)
 	}
@@ -221,9 +302,105 @@ func (a *deviceKeystore) computeMemberKeyForMultiMemberGroup(groupPublicKey cryp
 	return a.getOrComputeECDH(keyMember, groupPublicKey, accountProofPrivateKey)
 }
 
+// rotateAccountKey replaces the account key by a newly generated one, the
+// contact group keys derived from the previous one are removed from the
+// keystore
+func (a *deviceKeystore) rotateAccountKey() (previous crypto.PrivKey, next crypto.PrivKey, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.signer != nil {
+		return nil, nil, errcode.ErrCode_ErrNotImplemented.Wrap(fmt.Errorf("the account key is held by the signer, it must be rotated there"))
+	}
+
+	previous, err = a.getOrGenerateNamedKey(keyAccount)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	next, _, err = crypto.GenerateEd25519Key(crand.Reader)
+	if err != nil {
+		return nil, nil, errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(fmt.Errorf("unable to generate an ed25519 key: %w", err))
+	}
+
+	if err := a.deleteDerivedKeys(keyContactGroup); err != nil {
+		return nil, nil, err
+	}
+
+	if err := a.putNamedKey(keyAccount, next); err != nil {
+		return nil, nil, err
+	}
+
+	return previous, next, nil
+}
+
+// replaceAccountKeys replaces both account keys, the keys derived from the
+// previous ones are removed from the keystore
+func (a *deviceKeystore) replaceAccountKeys(accountPrivateKey crypto.PrivKey, accountProofPrivateKey crypto.PrivKey) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.signer != nil {
+		return errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("the account key is held by the signer, it must be imported there"))
+	}
+
+	if err := a.deleteDerivedKeys(keyContactGroup, keyMember); err != nil {
+		return err
+	}
+
+	if err := a.putNamedKey(keyAccount, accountPrivateKey); err != nil {
+		return err
+	}
+
+	return a.putNamedKey(keyAccountProof, accountProofPrivateKey)
+}
+
+// putNamedKey stores a private key, replacing the existing one
+func (a *deviceKeystore) putNamedKey(name string, privateKey crypto.PrivKey) error {
+	if exists, err := a.keystore.Has(name); err != nil {
+		return errcode.ErrCode_ErrDBRead.Wrap(err)
+	} else if exists {
+		if err := a.keystore.Delete(name); err != nil {
+			return errcode.ErrCode_ErrDBWrite.Wrap(err)
+		}
+	}
+
+	if err := a.keystore.Put(name, privateKey); err != nil {
+		return errcode.ErrCode_ErrDBWrite.Wrap(err)
+	}
+
+	return nil
+}
+
+// deleteDerivedKeys removes the keys computed in the given namespaces
+func (a *deviceKeystore) deleteDerivedKeys(nameSpaces ...string) error {
+	names, err := a.keystore.List()
+	if err != nil {
+		return errcode.ErrCode_ErrDBRead.Wrap(err)
+	}
+
+	for _, name := range names {
+		for _, nameSpace := range nameSpaces {
+			if !strings.HasPrefix(name, nameSpace+"_") {
+				continue
+			}
+
+			if err := a.keystore.Delete(name); err != nil {
+				return errcode.ErrCode_ErrDBWrite.Wrap(err)
+			}
+		}
+	}
+
+	return nil
+}
+
 // restoreAccountKeys restores exported LibP2P keys into the deviceKeystore, it
 // will fail if accounts keys are already created or imported into the keystore
 func (a *deviceKeystore) restoreAccountKeys(accountPrivateKeyBytes []byte, accountProofPrivateKeyBytes []byte) error {
+	if a.signer != nil {
+		return errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("the account key is held by the signer, it must be imported there"))
+	}
+
 	privateKeys := map[string]crypto.PrivKey{}
 
 	for keyName, keyBytes := range map[string][]byte{
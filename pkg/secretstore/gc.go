@@ -0,0 +1,406 @@
+package secretstore
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"go.uber.org/zap"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/logutil"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+const (
+	// DefaultDeviceIdleThreshold is the duration after which the key
+	// material of a device which hasn't sent anything on a group is removed
+	DefaultDeviceIdleThreshold = time.Hour * 24 * 180
+
+	// deviceLastSeenResolution is the minimum interval between two writes of
+	// the last time a device has been seen
+	deviceLastSeenResolution = time.Hour
+)
+
+// GarbageCollectReport summarizes a garbage collection pass
+type GarbageCollectReport struct {
+	// Groups is the number of groups no longer joined whose key material
+	// has been removed
+	Groups int
+
+	// Devices is the number of idle devices whose key material has been
+	// removed
+	Devices int
+
+	// Entries is the number of datastore entries removed
+	Entries int
+}
+
+// GarbageCollect removes the chain keys, precomputed message keys, CID to
+// message key mappings and out-of-store references of the groups not listed
+// in joinedGroups. In the joined groups, it also removes the key material of
+// the other devices idle for longer than the DeviceIdleThreshold option.
+// Message keys stored before the introduction of the garbage collection
+// aren't indexed by group and are kept.
+func (s *secretStore) GarbageCollect(ctx context.Context, joinedGroups []crypto.PubKey) (*GarbageCollectReport, error) {
+	joined := make(map[string]bool, len(joinedGroups))
+	for _, groupPublicKey := range joinedGroups {
+		groupPublicKeyBytes, err := groupPublicKey.Raw()
+		if err != nil {
+			return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+		}
+
+		joined[hex.EncodeToString(groupPublicKeyBytes)] = true
+	}
+
+	s.messageMutex.Lock()
+	defer s.messageMutex.Unlock()
+
+	report := &GarbageCollectReport{}
+	collected := make(map[string]struct{})
+
+	if err := s.collectLeftGroups(ctx, joined, collected, report); err != nil {
+		return nil, err
+	}
+	report.Groups = len(collected)
+
+	if s.deviceIdleThreshold > 0 {
+		if err := s.collectIdleDevices(ctx, joined, report); err != nil {
+			return nil, err
+		}
+	}
+
+	s.logger.Debug("secret store garbage collected", zap.Int("groups", report.Groups), zap.Int("devices", report.Devices), zap.Int("entries", report.Entries))
+
+	return report, nil
+}
+
+// collectLeftGroups removes the entries of the groups not joined anymore
+func (s *secretStore) collectLeftGroups(ctx context.Context, joined map[string]bool, collected map[string]struct{}, report *GarbageCollectReport) error {
+	isLeft := func(groupHex string) bool {
+		if joined[groupHex] {
+			return false
+		}
+
+		collected[groupHex] = struct{}{}
+		return true
+	}
+
+	// namespaces whose keys start with the hex encoded group public key
+	for _, ns := range []string{
+		dsNamespaceChainKeyForDeviceOnGroup,
+		dsNamespaceHistoryChainKeyForDeviceOnGroup,
+		dsNamespaceHistorySharingGroup,
+		dsNamespacePrecomputedMessageKeys,
+		dsNamespaceDeviceLastSeenOnGroup,
+		dsNamespaceTreeKEMEpochKeys,
+		dsNamespaceTreeKEMCurrentEpoch,
+		dsNamespaceTreeKEMCounter,
+		dsNamespaceTreeKEMDeviceOnGroup,
+	} {
+		count, err := s.deleteEntries(ctx, ns, true, func(e query.Entry) bool {
+			return isLeft(keyNamespace(e.Key, 1))
+		})
+		if err != nil {
+			return err
+		}
+		report.Entries += count
+	}
+
+	// message keys, through their group index
+	count, err := s.deleteEntries(ctx, dsNamespaceMessageKeyCIDsForGroup, true, func(e query.Entry) bool {
+		if !isLeft(keyNamespace(e.Key, 1)) {
+			return false
+		}
+
+		id, err := cid.Decode(keyNamespace(e.Key, 2))
+		if err != nil {
+			return true
+		}
+
+		if err := s.datastore.Delete(ctx, dsKeyForMessageKeyByCID(id)); err != nil {
+			s.logger.Error("unable to delete message key", zap.Error(err))
+			return false
+		}
+		report.Entries++
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	report.Entries += count
+
+	// out-of-store counters, keyed by the base64 encoded group public key
+	count, err = s.deleteEntries(ctx, dsNamespaceOutOfStoreGroupHintCounters, true, func(e query.Entry) bool {
+		groupPublicKeyBytes, err := base64.RawURLEncoding.DecodeString(keyNamespace(e.Key, 1))
+		if err != nil {
+			return false
+		}
+
+		return isLeft(hex.EncodeToString(groupPublicKeyBytes))
+	})
+	if err != nil {
+		return err
+	}
+	report.Entries += count
+
+	// out-of-store references, whose value is the group public key
+	count, err = s.deleteEntries(ctx, dsNamespaceOutOfStoreGroupHint, false, func(e query.Entry) bool {
+		return isLeft(hex.EncodeToString(e.Value))
+	})
+	if err != nil {
+		return err
+	}
+	report.Entries += count
+
+	return nil
+}
+
+// collectIdleDevices removes the key material of the other devices of the
+// joined groups which haven't been seen for longer than the idle threshold
+func (s *secretStore) collectIdleDevices(ctx context.Context, joined map[string]bool, report *GarbageCollectReport) error {
+	now := time.Now()
+	threshold := now.Add(-s.deviceIdleThreshold)
+
+	res, err := s.datastore.Query(ctx, query.Query{Prefix: datastore.NewKey(dsNamespaceChainKeyForDeviceOnGroup).String(), KeysOnly: true})
+	if err != nil {
+		return errcode.ErrCode_ErrDBRead.Wrap(err)
+	}
+
+	entries, err := res.Rest()
+	if err != nil {
+		return errcode.ErrCode_ErrDBRead.Wrap(err)
+	}
+
+	ownDevices := make(map[string]string)
+	for _, e := range entries {
+		groupHex, deviceHex := keyNamespace(e.Key, 1), keyNamespace(e.Key, 2)
+		if !joined[groupHex] {
+			continue
+		}
+
+		groupPublicKeyBytes, err := hex.DecodeString(groupHex)
+		if err != nil {
+			continue
+		}
+
+		devicePublicKeyBytes, err := hex.DecodeString(deviceHex)
+		if err != nil {
+			continue
+		}
+
+		ownDevice, ok := ownDevices[groupHex]
+		if !ok {
+			ownDevice = s.ownDeviceHexForGroup(ctx, groupPublicKeyBytes)
+			ownDevices[groupHex] = ownDevice
+		}
+
+		// the own device can't be told apart from an unknown group
+		if ownDevice == "" || ownDevice == deviceHex {
+			continue
+		}
+
+		lastSeen, err := s.getDeviceLastSeen(ctx, groupPublicKeyBytes, devicePublicKeyBytes)
+		if err == datastore.ErrNotFound {
+			// devices registered before the last seen tracking get a full
+			// idle period from now on
+			if err := s.putDeviceLastSeen(ctx, groupPublicKeyBytes, devicePublicKeyBytes, now); err != nil {
+				s.logger.Error("unable to initialize device last seen", zap.Error(err))
+			}
+			continue
+		} else if err != nil || lastSeen.After(threshold) {
+			continue
+		}
+
+		count, err := s.deleteDeviceKeys(ctx, groupPublicKeyBytes, devicePublicKeyBytes)
+		if err != nil {
+			return err
+		}
+
+		report.Devices++
+		report.Entries += count
+	}
+
+	return nil
+}
+
+// deleteDeviceKeys removes the key material of a device on a group
+func (s *secretStore) deleteDeviceKeys(ctx context.Context, groupPublicKeyBytes, devicePublicKeyBytes []byte) (int, error) {
+	groupHex, deviceHex := hex.EncodeToString(groupPublicKeyBytes), hex.EncodeToString(devicePublicKeyBytes)
+
+	count, err := s.deleteEntries(ctx, datastore.KeyWithNamespaces([]string{dsNamespacePrecomputedMessageKeys, groupHex, deviceHex}).String(), true, func(query.Entry) bool {
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// out-of-store references are derived from the group secret, they can
+	// only be removed if the group is known
+	if group, err := s.fetchGroupByPublicKeyBytes(ctx, groupPublicKeyBytes); err == nil {
+		if first, last, err := s.firstLastCachedGroupRefsForMember(ctx, devicePublicKeyBytes, group); err == nil {
+			for i := first; i != last; i++ {
+				ref, err := createOutOfStoreGroupReference(group, devicePublicKeyBytes, i)
+				if err != nil {
+					continue
+				}
+
+				if err := s.datastore.Delete(ctx, dsKeyForOutOfStoreMessageGroupHint(ref)); err == nil {
+					count++
+				}
+			}
+		}
+	}
+
+	for _, key := range []datastore.Key{
+		datastore.KeyWithNamespaces([]string{dsNamespaceChainKeyForDeviceOnGroup, groupHex, deviceHex}),
+		datastore.KeyWithNamespaces([]string{dsNamespaceHistoryChainKeyForDeviceOnGroup, groupHex, deviceHex}),
+		dsKeyForOutOfStoreFirstLastCounters(groupPublicKeyBytes, devicePublicKeyBytes),
+		dsKeyForDeviceLastSeen(groupPublicKeyBytes, devicePublicKeyBytes),
+	} {
+		if ok, err := s.datastore.Has(ctx, key); err != nil || !ok {
+			continue
+		}
+
+		if err := s.datastore.Delete(ctx, key); err != nil {
+			return count, errcode.ErrCode_ErrDBWrite.Wrap(err)
+		}
+		count++
+	}
+
+	s.logger.Debug("removed key material of idle device",
+		logutil.PrivateBinary("devicePublicKey", devicePublicKeyBytes),
+		logutil.PrivateBinary("groupPublicKey", groupPublicKeyBytes),
+	)
+
+	return count, nil
+}
+
+// deleteEntries removes the entries of a namespace matching the given
+// function, and returns their count
+func (s *secretStore) deleteEntries(ctx context.Context, prefix string, keysOnly bool, match func(e query.Entry) bool) (int, error) {
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = datastore.NewKey(prefix).String()
+	}
+
+	res, err := s.datastore.Query(ctx, query.Query{Prefix: prefix, KeysOnly: keysOnly})
+	if err != nil {
+		return 0, errcode.ErrCode_ErrDBRead.Wrap(err)
+	}
+
+	entries, err := res.Rest()
+	if err != nil {
+		return 0, errcode.ErrCode_ErrDBRead.Wrap(err)
+	}
+
+	count := 0
+	for _, e := range entries {
+		if !match(e) {
+			continue
+		}
+
+		if err := s.datastore.Delete(ctx, datastore.NewKey(e.Key)); err != nil {
+			return count, errcode.ErrCode_ErrDBWrite.Wrap(err)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// ownDeviceHexForGroup returns the hex encoded public key of the current
+// device on a group, or an empty string if the group is unknown
+func (s *secretStore) ownDeviceHexForGroup(ctx context.Context, groupPublicKeyBytes []byte) string {
+	group, err := s.fetchGroupByPublicKeyBytes(ctx, groupPublicKeyBytes)
+	if err != nil {
+		return ""
+	}
+
+	md, err := s.deviceKeystore.memberDeviceForGroup(group)
+	if err != nil {
+		return ""
+	}
+
+	devicePublicKeyBytes, err := md.Device().Raw()
+	if err != nil {
+		return ""
+	}
+
+	return hex.EncodeToString(devicePublicKeyBytes)
+}
+
+// markDeviceSeen records that a device has been seen on a group, the writes
+// are throttled to one per deviceLastSeenResolution
+func (s *secretStore) markDeviceSeen(ctx context.Context, groupPublicKey crypto.PubKey, devicePublicKey crypto.PubKey) {
+	groupPublicKeyBytes, err := groupPublicKey.Raw()
+	if err != nil {
+		return
+	}
+
+	devicePublicKeyBytes, err := devicePublicKey.Raw()
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	cacheKey := string(groupPublicKeyBytes) + string(devicePublicKeyBytes)
+
+	s.lastSeenMutex.Lock()
+	if last, ok := s.lastSeen[cacheKey]; ok && now.Sub(last) < deviceLastSeenResolution {
+		s.lastSeenMutex.Unlock()
+		return
+	}
+	s.lastSeen[cacheKey] = now
+	s.lastSeenMutex.Unlock()
+
+	if err := s.putDeviceLastSeen(ctx, groupPublicKeyBytes, devicePublicKeyBytes, now); err != nil {
+		s.logger.Error("unable to store device last seen", zap.Error(err))
+	}
+}
+
+func (s *secretStore) putDeviceLastSeen(ctx context.Context, groupPublicKeyBytes, devicePublicKeyBytes []byte, t time.Time) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(t.Unix()))
+
+	return s.datastore.Put(ctx, dsKeyForDeviceLastSeen(groupPublicKeyBytes, devicePublicKeyBytes), value)
+}
+
+func (s *secretStore) getDeviceLastSeen(ctx context.Context, groupPublicKeyBytes, devicePublicKeyBytes []byte) (time.Time, error) {
+	value, err := s.datastore.Get(ctx, dsKeyForDeviceLastSeen(groupPublicKeyBytes, devicePublicKeyBytes))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if len(value) != 8 {
+		return time.Time{}, errcode.ErrCode_ErrDeserialization
+	}
+
+	return time.Unix(int64(binary.BigEndian.Uint64(value)), 0), nil
+}
+
+func (s *secretStore) fetchGroupByPublicKeyBytes(ctx context.Context, groupPublicKeyBytes []byte) (*protocoltypes.Group, error) {
+	groupPublicKey, err := crypto.UnmarshalEd25519PublicKey(groupPublicKeyBytes)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	return s.FetchGroupByPublicKey(ctx, groupPublicKey)
+}
+
+// keyNamespace returns the namespace at the given index of a datastore key
+func keyNamespace(key string, index int) string {
+	namespaces := datastore.NewKey(key).Namespaces()
+	if index >= len(namespaces) {
+		return ""
+	}
+
+	return namespaces[index]
+}
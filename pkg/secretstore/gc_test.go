@@ -0,0 +1,154 @@
+package secretstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+// gcTestGroup sets up a group on which the second store has received a
+// message from the first one, and returns the public key of the sender
+func gcTestGroup(ctx context.Context, t *testing.T, store1, store2 *secretStore, id cid.Cid) (*protocoltypes.Group, crypto.PubKey) {
+	t.Helper()
+
+	g, _, err := protocoltypes.NewGroupMultiMember()
+	require.NoError(t, err)
+
+	require.NoError(t, store1.PutGroup(ctx, g))
+	require.NoError(t, store2.PutGroup(ctx, g))
+
+	omd1, err := store1.GetOwnMemberDeviceForGroup(g)
+	require.NoError(t, err)
+
+	omd2, err := store2.GetOwnMemberDeviceForGroup(g)
+	require.NoError(t, err)
+
+	chainKey, err := store1.GetShareableChainKey(ctx, g, omd2.Member())
+	require.NoError(t, err)
+	require.NoError(t, store2.RegisterChainKey(ctx, g, omd1.Device(), chainKey))
+
+	payload, err := proto.Marshal(&protocoltypes.EncryptedMessage{Plaintext: []byte("test")})
+	require.NoError(t, err)
+
+	env, err := store1.SealEnvelope(ctx, g, payload)
+	require.NoError(t, err)
+
+	msgEnv, headers, err := store2.OpenEnvelopeHeaders(env, g)
+	require.NoError(t, err)
+
+	gPK, err := g.GetPubKey()
+	require.NoError(t, err)
+
+	_, err = store2.OpenEnvelopePayload(ctx, msgEnv, headers, gPK, omd2.Device(), id)
+	require.NoError(t, err)
+
+	return g, omd1.Device()
+}
+
+func TestGarbageCollect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store1, err := newInMemSecretStore(nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store1.Close() })
+
+	store2, err := newInMemSecretStore(nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store2.Close() })
+
+	leftCID, err := cid.Parse("QmbdQXQh9B2bWZgZJqfbjNPV5jGN2owbQ3vjeYsaDaCDqU")
+	require.NoError(t, err)
+
+	joinedCID, err := cid.Parse("Qmf8oj9wbfu73prNAA1cRQVDqA52gD5B3ApnYQQjcjffH4")
+	require.NoError(t, err)
+
+	leftGroup, sender := gcTestGroup(ctx, t, store1, store2, leftCID)
+	joinedGroup, _ := gcTestGroup(ctx, t, store1, store2, joinedCID)
+
+	leftGroupPK, err := leftGroup.GetPubKey()
+	require.NoError(t, err)
+
+	joinedGroupPK, err := joinedGroup.GetPubKey()
+	require.NoError(t, err)
+
+	report, err := store2.GarbageCollect(ctx, []crypto.PubKey{joinedGroupPK})
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Groups)
+	require.Equal(t, 0, report.Devices)
+	require.NotZero(t, report.Entries)
+
+	// key material of the left group is gone
+	_, err = store2.getKeyForCID(ctx, leftCID)
+	require.Error(t, err)
+
+	_, err = store2.getDeviceChainKeyForGroupAndDevice(ctx, leftGroupPK, sender)
+	require.Error(t, err)
+
+	// the joined group is untouched
+	_, err = store2.getKeyForCID(ctx, joinedCID)
+	require.NoError(t, err)
+
+	// a second pass has nothing left to collect
+	report, err = store2.GarbageCollect(ctx, []crypto.PubKey{joinedGroupPK})
+	require.NoError(t, err)
+	require.Equal(t, 0, report.Groups)
+	require.Equal(t, 0, report.Entries)
+}
+
+func TestGarbageCollectIdleDevices(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store1, err := newInMemSecretStore(nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store1.Close() })
+
+	store2, err := newInMemSecretStore(&NewSecretStoreOptions{DeviceIdleThreshold: time.Hour})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store2.Close() })
+
+	g, sender := gcTestGroup(ctx, t, store1, store2, cid.Undef)
+
+	gPK, err := g.GetPubKey()
+	require.NoError(t, err)
+
+	omd2, err := store2.GetOwnMemberDeviceForGroup(g)
+	require.NoError(t, err)
+
+	// recently seen devices are kept
+	report, err := store2.GarbageCollect(ctx, []crypto.PubKey{gPK})
+	require.NoError(t, err)
+	require.Equal(t, 0, report.Devices)
+
+	_, err = store2.getDeviceChainKeyForGroupAndDevice(ctx, gPK, sender)
+	require.NoError(t, err)
+
+	senderBytes, err := sender.Raw()
+	require.NoError(t, err)
+	require.NoError(t, store2.putDeviceLastSeen(ctx, g.GetPublicKey(), senderBytes, time.Now().Add(-2*time.Hour)))
+
+	report, err = store2.GarbageCollect(ctx, []crypto.PubKey{gPK})
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Devices)
+	require.Equal(t, 0, report.Groups)
+
+	_, err = store2.getDeviceChainKeyForGroupAndDevice(ctx, gPK, sender)
+	require.Error(t, err)
+
+	first, last, err := store2.firstLastCachedGroupRefsForMember(ctx, senderBytes, g)
+	require.Error(t, err)
+	require.Zero(t, first)
+	require.Zero(t, last)
+
+	// the own device is never collected
+	_, err = store2.getDeviceChainKeyForGroupAndDevice(ctx, gPK, omd2.Device())
+	require.NoError(t, err)
+}
@@ -0,0 +1,378 @@
+package secretstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/nacl/box"
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/pkg/cryptoutil"
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/logutil"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+// maxHistoryMessageKeys is the maximum number of message keys derived for a
+// device when registering a shared history chain key
+const maxHistoryMessageKeys = 1 << 16
+
+// SetHistorySharing sets whether the oldest chain keys of the devices of a
+// multi member group are kept to be shared with its new members. When
+// enabled, the current chain key of the current device is kept as the older
+// ones are gone, the ones of the other devices are kept as their chain keys
+// are registered again. When disabled, the kept chain keys are removed.
+func (s *secretStore) SetHistorySharing(ctx context.Context, group *protocoltypes.Group, enabled bool) error {
+	if group.GroupType != protocoltypes.GroupType_GroupTypeMultiMember {
+		return errcode.ErrCode_ErrGroupInvalidType
+	}
+
+	groupPublicKey, err := group.GetPubKey()
+	if err != nil {
+		return errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	key := dsKeyForHistorySharingGroup(group.PublicKey)
+
+	if !enabled {
+		if ok, err := s.datastore.Has(ctx, key); err != nil {
+			return errcode.ErrCode_ErrDBRead.Wrap(err)
+		} else if !ok {
+			return nil
+		}
+
+		if err := s.datastore.Delete(ctx, key); err != nil {
+			return errcode.ErrCode_ErrDBWrite.Wrap(err)
+		}
+
+		prefix := datastore.KeyWithNamespaces([]string{dsNamespaceHistoryChainKeyForDeviceOnGroup, hex.EncodeToString(group.PublicKey)})
+
+		s.messageMutex.Lock()
+		defer s.messageMutex.Unlock()
+
+		_, err := s.deleteEntries(ctx, prefix.String(), true, func(query.Entry) bool { return true })
+		return err
+	}
+
+	if err := s.datastore.Put(ctx, key, []byte{1}); err != nil {
+		return errcode.ErrCode_ErrDBWrite.Wrap(err)
+	}
+
+	if s.deviceKeystore == nil {
+		return nil
+	}
+
+	localMemberDevice, err := s.deviceKeystore.memberDeviceForGroup(group)
+	if err != nil {
+		return errcode.ErrCode_ErrGroupMemberUnknownGroupID.Wrap(err)
+	}
+
+	s.messageMutex.Lock()
+	defer s.messageMutex.Unlock()
+
+	deviceChainKey, err := s.getDeviceChainKeyForGroupAndDevice(ctx, groupPublicKey, localMemberDevice.Device())
+	if errcode.Is(err, errcode.ErrCode_ErrMissingInput) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return s.keepOldestHistoryChainKey(ctx, groupPublicKey, localMemberDevice.Device(), deviceChainKey)
+}
+
+// isHistorySharingEnabled returns whether the history chain keys of the
+// given group are kept
+func (s *secretStore) isHistorySharingEnabled(ctx context.Context, group *protocoltypes.Group) bool {
+	if group.GroupType != protocoltypes.GroupType_GroupTypeMultiMember {
+		return false
+	}
+
+	ok, err := s.datastore.Has(ctx, dsKeyForHistorySharingGroup(group.PublicKey))
+	return err == nil && ok
+}
+
+// keepOldestHistoryChainKey stores the given chain key as the history chain
+// key of the device, unless an older one is already known
+func (s *secretStore) keepOldestHistoryChainKey(ctx context.Context, groupPublicKey crypto.PubKey, devicePublicKey crypto.PubKey, deviceChainKey *protocoltypes.DeviceChainKey) error {
+	historyChainKey, err := s.getHistoryChainKey(ctx, groupPublicKey, devicePublicKey)
+	if err == nil && historyChainKey.Counter <= deviceChainKey.Counter {
+		return nil
+	} else if err != nil && !errcode.Is(err, errcode.ErrCode_ErrMissingInput) {
+		return err
+	}
+
+	return s.putHistoryChainKey(ctx, groupPublicKey, devicePublicKey, deviceChainKey)
+}
+
+// GetShareableHistoryChainKeys returns the oldest chain keys known for the
+// devices of a group, encrypted for the provided member
+func (s *secretStore) GetShareableHistoryChainKeys(ctx context.Context, group *protocoltypes.Group, targetMemberPublicKey crypto.PubKey) ([]byte, error) {
+	if s.deviceKeystore == nil {
+		return nil, errcode.ErrCode_ErrCryptoSignature.Wrap(fmt.Errorf("message keystore is opened in read-only mode"))
+	}
+
+	privateMemberDevice, err := s.deviceKeystore.memberDeviceForGroup(group)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(err)
+	}
+
+	groupPublicKey, err := group.GetPubKey()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	groupPublicKeyBytes, err := groupPublicKey.Raw()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	prefix := datastore.KeyWithNamespaces([]string{dsNamespaceHistoryChainKeyForDeviceOnGroup, hex.EncodeToString(groupPublicKeyBytes)})
+
+	s.messageMutex.RLock()
+	res, err := s.datastore.Query(ctx, query.Query{Prefix: prefix.String()})
+	if err != nil {
+		s.messageMutex.RUnlock()
+		return nil, errcode.ErrCode_ErrDBRead.Wrap(err)
+	}
+
+	entries, err := res.Rest()
+	s.messageMutex.RUnlock()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDBRead.Wrap(err)
+	}
+
+	historyChainKeys := &protocoltypes.GroupHistoryChainKeys{}
+	for _, e := range entries {
+		devicePublicKeyBytes, err := hex.DecodeString(keyNamespace(e.Key, 2))
+		if err != nil {
+			continue
+		}
+
+		deviceChainKey := &protocoltypes.DeviceChainKey{}
+		if err := proto.Unmarshal(e.Value, deviceChainKey); err != nil {
+			continue
+		}
+
+		historyChainKeys.ChainKeys = append(historyChainKeys.ChainKeys, &protocoltypes.GroupHistoryChainKeys_DeviceHistoryChainKey{
+			DevicePk: devicePublicKeyBytes,
+			ChainKey: deviceChainKey,
+		})
+	}
+
+	if len(historyChainKeys.ChainKeys) == 0 {
+		return nil, errcode.ErrCode_ErrNotFound.Wrap(fmt.Errorf("no history chain key known for the group"))
+	}
+
+	historyChainKeysBytes, err := proto.Marshal(historyChainKeys)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	mongPub, err := cryptoutil.EdwardsToMontgomeryPub(targetMemberPublicKey)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
+	}
+
+	var sharedKey [cryptoutil.KeySize]byte
+	if err := cryptoutil.PrecomputeBoxKey(&sharedKey, privateMemberDevice.device, mongPub); err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
+	}
+
+	// unlike the chain keys, the history can be sent several times to the
+	// same member, the nonce can't be derived from the group
+	nonce, err := cryptoutil.GenerateNonce()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoNonceGeneration.Wrap(err)
+	}
+
+	return box.SealAfterPrecomputation(nonce[:], historyChainKeysBytes, nonce, &sharedKey), nil
+}
+
+// RegisterHistoryChainKeys records the history chain keys sent by another
+// member and derives the message keys of the messages sent before the known
+// chain keys, the devices whose message keys have changed are returned. The
+// chain keys of the devices which are not known yet are ignored.
+func (s *secretStore) RegisterHistoryChainKeys(ctx context.Context, group *protocoltypes.Group, senderDevicePublicKey crypto.PubKey, encryptedHistoryChainKeys []byte) ([]crypto.PubKey, error) {
+	if s.deviceKeystore == nil {
+		return nil, errcode.ErrCode_ErrCryptoSignature.Wrap(fmt.Errorf("message keystore is opened in read-only mode"))
+	}
+
+	localMemberDevice, err := s.deviceKeystore.memberDeviceForGroup(group)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrGroupMemberUnknownGroupID.Wrap(err)
+	}
+
+	groupPublicKey, err := group.GetPubKey()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	if len(encryptedHistoryChainKeys) < cryptoutil.NonceSize {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("history chain keys payload is too short"))
+	}
+
+	nonce, err := cryptoutil.NonceSliceToArray(encryptedHistoryChainKeys[:cryptoutil.NonceSize])
+	if err != nil {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(err)
+	}
+
+	mongPub, err := cryptoutil.EdwardsToMontgomeryPub(senderDevicePublicKey)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
+	}
+
+	var sharedKey [cryptoutil.KeySize]byte
+	if err := cryptoutil.PrecomputeBoxKey(&sharedKey, localMemberDevice.member, mongPub); err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
+	}
+
+	historyChainKeysBytes, ok := box.OpenAfterPrecomputation(nil, encryptedHistoryChainKeys[cryptoutil.NonceSize:], nonce, &sharedKey)
+	if !ok {
+		return nil, errcode.ErrCode_ErrCryptoDecrypt.Wrap(fmt.Errorf("unable to decrypt history chain keys"))
+	}
+
+	historyChainKeys := &protocoltypes.GroupHistoryChainKeys{}
+	if err := proto.Unmarshal(historyChainKeysBytes, historyChainKeys); err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	var updated []crypto.PubKey
+	for _, historyChainKey := range historyChainKeys.ChainKeys {
+		devicePublicKey, err := crypto.UnmarshalEd25519PublicKey(historyChainKey.DevicePk)
+		if err != nil || historyChainKey.ChainKey == nil {
+			continue
+		}
+
+		// the messages of the current device are already readable
+		if devicePublicKey.Equals(localMemberDevice.Device()) {
+			continue
+		}
+
+		ok, err := s.registerHistoryChainKey(ctx, groupPublicKey, devicePublicKey, historyChainKey.ChainKey)
+		if errcode.Is(err, errcode.ErrCode_ErrCryptoKeyDerivation) {
+			s.logger.Warn("ignoring forged history chain key",
+				logutil.PrivateBinary("devicePublicKey", historyChainKey.DevicePk),
+				logutil.PrivateBinary("senderDevicePublicKey", logutil.CryptoKeyToBytes(senderDevicePublicKey)),
+			)
+			continue
+		} else if err != nil {
+			return updated, err
+		}
+
+		if ok {
+			updated = append(updated, devicePublicKey)
+		}
+	}
+
+	return updated, nil
+}
+
+// registerHistoryChainKey precomputes the message keys between the given
+// chain key and the oldest known one for the device. The chain key is only
+// used if it derives into the known one, a member can't forge the history of
+// a device whose chain key is unknown yet. Returns whether it has been used.
+func (s *secretStore) registerHistoryChainKey(ctx context.Context, groupPublicKey crypto.PubKey, devicePublicKey crypto.PubKey, deviceChainKey *protocoltypes.DeviceChainKey) (bool, error) {
+	if !s.IsChainKeyKnownForDevice(ctx, groupPublicKey, devicePublicKey) {
+		return false, nil
+	}
+
+	s.messageMutex.Lock()
+	defer s.messageMutex.Unlock()
+
+	// chain keys registered before the history chain keys were kept can
+	// only be completed up to the current one
+	knownDeviceChainKey, err := s.getHistoryChainKey(ctx, groupPublicKey, devicePublicKey)
+	if errcode.Is(err, errcode.ErrCode_ErrMissingInput) {
+		knownDeviceChainKey, err = s.getDeviceChainKeyForGroupAndDevice(ctx, groupPublicKey, devicePublicKey)
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if deviceChainKey.Counter >= knownDeviceChainKey.Counter {
+		return false, nil
+	}
+
+	if knownDeviceChainKey.Counter-deviceChainKey.Counter > maxHistoryMessageKeys {
+		return false, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("history chain key is more than %d messages old", maxHistoryMessageKeys))
+	}
+
+	groupPublicKeyBytes, err := groupPublicKey.Raw()
+	if err != nil {
+		return false, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	chainKeyValue := deviceChainKey.ChainKey
+	preComputedKeys := make([]computedMessageKey, 0, knownDeviceChainKey.Counter-deviceChainKey.Counter)
+	for counter := deviceChainKey.Counter + 1; counter <= knownDeviceChainKey.Counter; counter++ {
+		newChainKeyValue, mk, err := deriveNextKeys(chainKeyValue, nil, groupPublicKeyBytes)
+		if err != nil {
+			return false, errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(err)
+		}
+
+		chainKeyValue = newChainKeyValue
+		preComputedKeys = append(preComputedKeys, computedMessageKey{counter, &mk})
+	}
+
+	if !bytes.Equal(chainKeyValue, knownDeviceChainKey.ChainKey) {
+		return false, errcode.ErrCode_ErrCryptoKeyDerivation.Wrap(fmt.Errorf("history chain key doesn't derive into the known chain key"))
+	}
+
+	if err := s.putPrecomputedKeys(ctx, groupPublicKey, devicePublicKey, preComputedKeys); err != nil {
+		return false, errcode.ErrCode_ErrMessageKeyPersistencePut.Wrap(err)
+	}
+
+	if err := s.putHistoryChainKey(ctx, groupPublicKey, devicePublicKey, deviceChainKey); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// getHistoryChainKey returns the oldest known chain key for the given group
+// and device.
+func (s *secretStore) getHistoryChainKey(ctx context.Context, groupPublicKey crypto.PubKey, devicePublicKey crypto.PubKey) (*protocoltypes.DeviceChainKey, error) {
+	key, err := dsKeyForHistoryChainKey(groupPublicKey, devicePublicKey)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrInternal.Wrap(err)
+	}
+
+	deviceChainKeyBytes, err := s.datastore.Get(ctx, key)
+	if err == datastore.ErrNotFound {
+		return nil, errcode.ErrCode_ErrMissingInput.Wrap(err)
+	} else if err != nil {
+		return nil, errcode.ErrCode_ErrMessageKeyPersistenceGet.Wrap(err)
+	}
+
+	deviceChainKey := &protocoltypes.DeviceChainKey{}
+	if err := proto.Unmarshal(deviceChainKeyBytes, deviceChainKey); err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	return deviceChainKey, nil
+}
+
+// putHistoryChainKey stores the oldest known chain key for the given group
+// and device.
+func (s *secretStore) putHistoryChainKey(ctx context.Context, groupPublicKey crypto.PubKey, devicePublicKey crypto.PubKey, deviceChainKey *protocoltypes.DeviceChainKey) error {
+	deviceChainKeyBytes, err := proto.Marshal(deviceChainKey)
+	if err != nil {
+		return errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	key, err := dsKeyForHistoryChainKey(groupPublicKey, devicePublicKey)
+	if err != nil {
+		return err
+	}
+
+	if err := s.datastore.Put(ctx, key, deviceChainKeyBytes); err != nil {
+		return errcode.ErrCode_ErrMessageKeyPersistencePut.Wrap(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package secretstore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+func TestHistoryChainKeys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	group, _, err := protocoltypes.NewGroupMultiMember()
+	require.NoError(t, err)
+
+	groupPublicKey, err := group.GetPubKey()
+	require.NoError(t, err)
+
+	stores := make([]*secretStore, 3)
+	memberDevices := make([]OwnMemberDevice, 3)
+	for i := range stores {
+		stores[i], err = newInMemSecretStore(nil)
+		require.NoError(t, err)
+
+		t.Cleanup(func() { _ = stores[i].Close() })
+
+		require.NoError(t, stores[i].PutGroup(ctx, group))
+
+		memberDevices[i], err = stores[i].GetOwnMemberDeviceForGroup(group)
+		require.NoError(t, err)
+	}
+
+	sender, newcomer, other := stores[0], stores[1], stores[2]
+
+	require.NoError(t, sender.SetHistorySharing(ctx, group, true))
+
+	_, err = sender.getOwnDeviceChainKeyForGroup(ctx, group)
+	require.NoError(t, err)
+
+	seal := func(plaintext string) []byte {
+		payload, err := proto.Marshal(&protocoltypes.EncryptedMessage{Plaintext: []byte(plaintext)})
+		require.NoError(t, err)
+
+		envelope, err := sender.SealEnvelope(ctx, group, payload)
+		require.NoError(t, err)
+
+		return envelope
+	}
+
+	// messages sent before the newcomer joins
+	envelopes := make([][]byte, 3)
+	for i := range envelopes {
+		envelopes[i] = seal(fmt.Sprintf("message %d", i))
+	}
+
+	chainKey, err := sender.GetShareableChainKey(ctx, group, memberDevices[1].Member())
+	require.NoError(t, err)
+	require.NoError(t, newcomer.RegisterChainKey(ctx, group, memberDevices[0].Device(), chainKey))
+
+	openEnvelope := func(store *secretStore, envelope []byte) ([]byte, error) {
+		env, headers, err := store.OpenEnvelopeHeaders(envelope, group)
+		require.NoError(t, err)
+
+		msg, err := store.OpenEnvelopePayload(ctx, env, headers, groupPublicKey, memberDevices[1].Device(), cid.Undef)
+		if err != nil {
+			return nil, err
+		}
+
+		return msg.Plaintext, nil
+	}
+
+	_, err = openEnvelope(newcomer, envelopes[0])
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrCryptoDecryptPayload))
+
+	historyChainKeys, err := sender.GetShareableHistoryChainKeys(ctx, group, memberDevices[1].Member())
+	require.NoError(t, err)
+
+	// the history is sealed for the newcomer only
+	_, err = other.RegisterHistoryChainKeys(ctx, group, memberDevices[0].Device(), historyChainKeys)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrCryptoDecrypt))
+
+	// another member can't forge the history of the devices, nor share the
+	// chain keys of devices unknown to the newcomer
+	forgedChainKey := &protocoltypes.DeviceChainKey{ChainKey: make([]byte, 32), Counter: 0}
+	for _, device := range []crypto.PubKey{memberDevices[0].Device(), memberDevices[2].Device()} {
+		require.NoError(t, other.putHistoryChainKey(ctx, groupPublicKey, device, forgedChainKey))
+	}
+
+	forgedHistoryChainKeys, err := other.GetShareableHistoryChainKeys(ctx, group, memberDevices[1].Member())
+	require.NoError(t, err)
+
+	updated, err := newcomer.RegisterHistoryChainKeys(ctx, group, memberDevices[2].Device(), forgedHistoryChainKeys)
+	require.NoError(t, err)
+	require.Empty(t, updated)
+	require.False(t, newcomer.IsChainKeyKnownForDevice(ctx, groupPublicKey, memberDevices[2].Device()))
+
+	_, err = openEnvelope(newcomer, envelopes[0])
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrCryptoDecryptPayload))
+
+	updated, err = newcomer.RegisterHistoryChainKeys(ctx, group, memberDevices[0].Device(), historyChainKeys)
+	require.NoError(t, err)
+	require.Len(t, updated, 1)
+	require.True(t, updated[0].Equals(memberDevices[0].Device()))
+
+	for i, envelope := range envelopes {
+		plaintext, err := openEnvelope(newcomer, envelope)
+		require.NoError(t, err)
+		require.Equal(t, []byte(fmt.Sprintf("message %d", i)), plaintext)
+	}
+
+	// the messages sent after joining are still readable
+	plaintext, err := openEnvelope(newcomer, seal("new message"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("new message"), plaintext)
+
+	// the same history is only registered once
+	updated, err = newcomer.RegisterHistoryChainKeys(ctx, group, memberDevices[0].Device(), historyChainKeys)
+	require.NoError(t, err)
+	require.Empty(t, updated)
+}
+
+func TestHistoryChainKeysOptIn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	group, _, err := protocoltypes.NewGroupMultiMember()
+	require.NoError(t, err)
+
+	groupPublicKey, err := group.GetPubKey()
+	require.NoError(t, err)
+
+	stores := make([]*secretStore, 2)
+	memberDevices := make([]OwnMemberDevice, 2)
+	for i := range stores {
+		stores[i], err = newInMemSecretStore(nil)
+		require.NoError(t, err)
+
+		t.Cleanup(func() { _ = stores[i].Close() })
+
+		require.NoError(t, stores[i].PutGroup(ctx, group))
+
+		memberDevices[i], err = stores[i].GetOwnMemberDeviceForGroup(group)
+		require.NoError(t, err)
+	}
+
+	sender, receiver := stores[0], stores[1]
+	senderDevice, receiverMember := memberDevices[0].Device(), memberDevices[1].Member()
+
+	chainKey, err := sender.GetShareableChainKey(ctx, group, receiverMember)
+	require.NoError(t, err)
+	require.NoError(t, receiver.RegisterChainKey(ctx, group, senderDevice, chainKey))
+
+	for i := 0; i < 2; i++ {
+		_, err := sender.SealEnvelope(ctx, group, []byte(fmt.Sprintf("message %d", i)))
+		require.NoError(t, err)
+	}
+
+	// the first chain keys are not kept until the group opts in
+	for _, store := range stores {
+		_, err = store.getHistoryChainKey(ctx, groupPublicKey, senderDevice)
+		require.True(t, errcode.Is(err, errcode.ErrCode_ErrMissingInput))
+	}
+
+	// the current device can only share the messages sent from now on, the
+	// other devices the ones sent from their registered chain key
+	require.NoError(t, sender.SetHistorySharing(ctx, group, true))
+	require.NoError(t, receiver.SetHistorySharing(ctx, group, true))
+	require.NoError(t, receiver.RegisterChainKey(ctx, group, senderDevice, chainKey))
+
+	historyChainKey, err := sender.getHistoryChainKey(ctx, groupPublicKey, senderDevice)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), historyChainKey.Counter)
+
+	historyChainKey, err = receiver.getHistoryChainKey(ctx, groupPublicKey, senderDevice)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), historyChainKey.Counter)
+
+	// the kept chain keys are removed once the group opts out
+	require.NoError(t, receiver.SetHistorySharing(ctx, group, false))
+	_, err = receiver.getHistoryChainKey(ctx, groupPublicKey, senderDevice)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrMissingInput))
+
+	require.NoError(t, receiver.RegisterChainKey(ctx, group, senderDevice, chainKey))
+	_, err = receiver.getHistoryChainKey(ctx, groupPublicKey, senderDevice)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrMissingInput))
+
+	// only the multi member groups can share their history
+	contactGroup, _, err := protocoltypes.NewGroupMultiMember()
+	require.NoError(t, err)
+	contactGroup.GroupType = protocoltypes.GroupType_GroupTypeContact
+
+	require.True(t, errcode.Is(sender.SetHistorySharing(ctx, contactGroup, true), errcode.ErrCode_ErrGroupInvalidType))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/ipfs/go-datastore"
@@ -30,10 +31,15 @@ type secretStore struct {
 	datastore      datastore.Datastore
 	deviceKeystore *deviceKeystore
 
-	messageMutex sync.RWMutex
+	messageMutex    sync.RWMutex
+	resumptionMutex sync.Mutex
+	accountKeyMutex sync.Mutex
+	lastSeenMutex   sync.Mutex
+	lastSeen        map[string]time.Time
 
 	preComputedKeysCount               int
 	precomputeOutOfStoreGroupRefsCount uint64
+	deviceIdleThreshold                time.Duration
 }
 
 func (o *NewSecretStoreOptions) applyDefaults(rootDatastore datastore.Datastore) {
@@ -52,6 +58,10 @@ func (o *NewSecretStoreOptions) applyDefaults(rootDatastore datastore.Datastore)
 	if o.PrecomputeOutOfStoreGroupRefsCount <= 0 {
 		o.PrecomputeOutOfStoreGroupRefsCount = PrecomputeOutOfStoreGroupRefsCount
 	}
+
+	if o.DeviceIdleThreshold == 0 {
+		o.DeviceIdleThreshold = DefaultDeviceIdleThreshold
+	}
 }
 
 // NewSecretStore instantiates a new SecretStore
@@ -69,19 +79,41 @@ func newSecretStore(rootDatastore datastore.Datastore, opts *NewSecretStoreOptio
 		opts = &NewSecretStoreOptions{}
 	}
 
+	if opts.KeyProvider != nil {
+		logger := opts.Logger
+		if logger == nil {
+			logger = zap.NewNop()
+		}
+
+		encrypted, err := openEncryptedDatastore(context.Background(), rootDatastore, opts.KeyProvider, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		rootDatastore = encrypted
+	} else if ok, err := IsDatastoreEncrypted(context.Background(), rootDatastore); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("the secret store is encrypted, a key provider is required"))
+	}
+
 	opts.applyDefaults(rootDatastore)
 
-	devKeystore := newDeviceKeystore(opts.Keystore, opts.Logger)
+	devKeystore := newDeviceKeystore(opts.Keystore, opts.Signer, opts.Logger)
 
 	store := &secretStore{
 		logger:         opts.Logger,
 		datastore:      rootDatastore,
 		deviceKeystore: devKeystore,
+		lastSeen:       make(map[string]time.Time),
 
 		preComputedKeysCount:               opts.PreComputedKeysCount,
 		precomputeOutOfStoreGroupRefsCount: uint64(opts.PrecomputeOutOfStoreGroupRefsCount),
+		deviceIdleThreshold:                opts.DeviceIdleThreshold,
 	}
 
+	devKeystore.newAccountKeys = store.newAccountKeysFromMnemonic
+
 	return store, nil
 }
 
@@ -216,7 +248,7 @@ func (s *secretStore) FetchGroupByPublicKey(ctx context.Context, publicKey crypt
 }
 
 func (s *secretStore) GetAccountProofPublicKey() (crypto.PubKey, error) {
-	privateKey, err := s.deviceKeystore.getAccountPrivateKey()
+	privateKey, err := s.deviceKeystore.getAccountPrivateKey(context.Background())
 	if err != nil {
 		return nil, errcode.ErrCode_ErrInternal.Wrap(err)
 	}
@@ -229,12 +261,17 @@ func (s *secretStore) ImportAccountKeys(accountPrivateKeyBytes []byte, accountPr
 }
 
 func (s *secretStore) ExportAccountKeysForBackup() (accountPrivateKeyBytes []byte, accountProofPrivateKeyBytes []byte, err error) {
-	accountPrivateKey, err := s.deviceKeystore.getAccountPrivateKey()
+	// the account key never leaves the signer
+	if s.deviceKeystore.signer != nil {
+		return nil, nil, errcode.ErrCode_ErrNotImplemented.Wrap(fmt.Errorf("the account key is held by a signer, it can't be exported for a backup"))
+	}
+
+	accountPrivateKey, err := s.deviceKeystore.getAccountPrivateKey(context.Background())
 	if err != nil {
 		return nil, nil, errcode.ErrCode_ErrInternal.Wrap(err)
 	}
 
-	accountProofPrivateKey, err := s.deviceKeystore.getAccountProofPrivateKey()
+	accountProofPrivateKey, err := s.deviceKeystore.getAccountProofPrivateKey(context.Background())
 	if err != nil {
 		return nil, nil, errcode.ErrCode_ErrInternal.Wrap(err)
 	}
@@ -253,7 +290,7 @@ func (s *secretStore) ExportAccountKeysForBackup() (accountPrivateKeyBytes []byt
 }
 
 func (s *secretStore) GetAccountPrivateKey() (crypto.PrivKey, error) {
-	accountPrivateKey, err := s.deviceKeystore.getAccountPrivateKey()
+	accountPrivateKey, err := s.deviceKeystore.getAccountPrivateKey(context.Background())
 	if err != nil {
 		return nil, errcode.ErrCode_ErrInternal.Wrap(err)
 	}
@@ -262,12 +299,12 @@ func (s *secretStore) GetAccountPrivateKey() (crypto.PrivKey, error) {
 }
 
 func (s *secretStore) GetGroupForAccount() (*protocoltypes.Group, OwnMemberDevice, error) {
-	accountPrivateKey, err := s.deviceKeystore.getAccountPrivateKey()
+	accountPrivateKey, err := s.deviceKeystore.getAccountPrivateKey(context.Background())
 	if err != nil {
 		return nil, nil, errcode.ErrCode_ErrOrbitDBOpen.Wrap(err)
 	}
 
-	accountProofPrivateKey, err := s.deviceKeystore.getAccountProofPrivateKey()
+	accountProofPrivateKey, err := s.deviceKeystore.getAccountProofPrivateKey(context.Background())
 	if err != nil {
 		return nil, nil, errcode.ErrCode_ErrOrbitDBOpen.Wrap(err)
 	}
@@ -277,9 +314,14 @@ func (s *secretStore) GetGroupForAccount() (*protocoltypes.Group, OwnMemberDevic
 		return nil, nil, errcode.ErrCode_ErrInternal.Wrap(err)
 	}
 
-	pubBytes, err := accountPrivateKey.GetPublic().Raw()
-	if err != nil {
-		return nil, nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	pubBytes, err := s.getAccountGroupPublicKey(context.TODO())
+	if errcode.Is(err, errcode.ErrCode_ErrMissingMapKey) {
+		pubBytes, err = accountPrivateKey.GetPublic().Raw()
+		if err != nil {
+			return nil, nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+		}
+	} else if err != nil {
+		return nil, nil, errcode.ErrCode_ErrDBRead.Wrap(err)
 	}
 
 	signingBytes, err := cryptoutil.SeedFromEd25519PrivateKey(accountProofPrivateKey)
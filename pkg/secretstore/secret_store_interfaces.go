@@ -2,6 +2,7 @@ package secretstore
 
 import (
 	"context"
+	"time"
 
 	"github.com/ipfs/go-cid"
 	keystore "github.com/ipfs/go-ipfs-keystore"
@@ -9,6 +10,7 @@ import (
 	"go.uber.org/zap"
 
 	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/treekem"
 )
 
 const (
@@ -29,12 +31,21 @@ type SecretStore interface {
 	// ImportAccountKeys restores backup of account keys into the SecretStore, it should fail if the store is already used by an account
 	ImportAccountKeys(accountPrivateKey []byte, accountProofPrivateKey []byte) error
 
-	// ExportAccountKeysForBackup returns the account's private key and proof private key of the user for a backup
+	// ExportAccountKeysForBackup returns the account's private key and proof private key of the user for a backup, it fails when the account key is held by a Signer
 	ExportAccountKeysForBackup() (accountPrivateKey []byte, accountProofPrivateKey []byte, err error)
 
 	// GetAccountPrivateKey returns the account's private key, avoid using it, use GetGroupForAccount to get the account public key or sign data instead
 	GetAccountPrivateKey() (accountPrivateKey crypto.PrivKey, err error)
 
+	// GetAccountMnemonic returns the BIP39 mnemonic from which the account keys have been derived, if any
+	GetAccountMnemonic(ctx context.Context) (mnemonic string, err error)
+
+	// RestoreAccountMnemonic replaces the account keys by the ones derived from the given BIP39 mnemonic
+	RestoreAccountMnemonic(ctx context.Context, mnemonic string) error
+
+	// RotateAccountKey replaces the account key by a new one and returns the succession statement to announce to the contacts, the account group is kept
+	RotateAccountKey(ctx context.Context) (succession *protocoltypes.AccountKeySuccession, err error)
+
 	//
 	// Groups methods
 	//
@@ -81,9 +92,31 @@ type SecretStore interface {
 	// GetShareableChainKey returns a chain-key that can be decrypted by the provided member of a group
 	GetShareableChainKey(ctx context.Context, group *protocoltypes.Group, targetMemberPublicKey crypto.PubKey) (encryptedDeviceChainKey []byte, err error)
 
+	// SetHistorySharing sets whether the oldest chain-keys of the devices of a multi-member group are kept to be shared with its new members, they are removed once disabled
+	SetHistorySharing(ctx context.Context, group *protocoltypes.Group, enabled bool) error
+
+	// GetShareableHistoryChainKeys returns the oldest known chain-keys of the devices of a group, they can be decrypted by the provided member
+	GetShareableHistoryChainKeys(ctx context.Context, group *protocoltypes.Group, targetMemberPublicKey crypto.PubKey) (encryptedHistoryChainKeys []byte, err error)
+
+	// RegisterHistoryChainKeys records the history chain-keys sent by another member, allowing to decrypt the messages sent before the known chain-keys, only the chain-keys deriving into the known ones are used
+	RegisterHistoryChainKeys(ctx context.Context, group *protocoltypes.Group, senderDevicePublicKey crypto.PubKey, encryptedHistoryChainKeys []byte) (updatedDevicePublicKeys []crypto.PubKey, err error)
+
 	// IsChainKeyKnownForDevice checks whether a chain key of a device is already known
 	IsChainKeyKnownForDevice(ctx context.Context, groupPublicKey crypto.PubKey, devicePublicKey crypto.PubKey) (isKnown bool)
 
+	//
+	// Ratchet tree methods
+	//
+
+	// CommitTreeKEM creates a commit replacing the keys of the path of the current device in the ratchet tree of a group, adding the device to the tree if needed and removing the given devices
+	CommitTreeKEM(ctx context.Context, group *protocoltypes.Group, tree *treekem.Tree, parentEpochID []byte, removedDevicePublicKeys [][]byte) (commit []byte, err error)
+
+	// RegisterTreeKEMCommit records the epoch secret created by a commit of another device, the tree is the one of the parent epoch
+	RegisterTreeKEMCommit(ctx context.Context, group *protocoltypes.Group, tree *treekem.Tree, commit []byte) error
+
+	// SetTreeKEMEpoch sets the epoch of the ratchet tree used to encrypt the messages sent on a group
+	SetTreeKEMEpoch(ctx context.Context, group *protocoltypes.Group, epochID []byte) error
+
 	//
 	// Out-of-store messages methods
 	//
@@ -97,6 +130,25 @@ type SecretStore interface {
 	// UpdateOutOfStoreGroupReferences computes references of messages which might be received outside a synchronized store
 	UpdateOutOfStoreGroupReferences(ctx context.Context, devicePublicKeyBytes []byte, first uint64, group *protocoltypes.Group) error
 
+	//
+	// Contact sessions methods
+	//
+
+	// GetContactResumptionSecret returns the ID and the secret shared with a contact to resume the next handshake
+	GetContactResumptionSecret(ctx context.Context, contactPublicKey crypto.PubKey) (id []byte, secret []byte, err error)
+
+	// GetContactResumptionSecretByID returns the contact and the resumption secret matching the given ID
+	GetContactResumptionSecretByID(ctx context.Context, id []byte) (contactPublicKey crypto.PubKey, secret []byte, err error)
+
+	// PutContactResumptionSecret replaces the secret shared with a contact to resume the next handshake
+	PutContactResumptionSecret(ctx context.Context, contactPublicKey crypto.PubKey, id []byte, secret []byte) error
+
+	// Maintenance methods
+
+	// GarbageCollect removes the key material of the groups not listed in
+	// joinedGroups and of the devices idle for too long
+	GarbageCollect(ctx context.Context, joinedGroups []crypto.PubKey) (*GarbageCollectReport, error)
+
 	// Close frees resources created by the secret store
 	Close() error
 }
@@ -116,12 +168,26 @@ type NewSecretStoreOptions struct {
 	// software one
 	Keystore keystore.Keystore
 
+	// Signer specifies an external signer holding the account and device
+	// keys, they are stored in Keystore by default. The keys of the multi
+	// member groups are still derived and stored in Keystore.
+	Signer Signer
+
 	// Logger specifies which logger to use, logging is disabled by default
 	Logger *zap.Logger
 
 	// DisableOutOfStoreSupport explicitly disables support of out-of-store
 	// payloads
 	DisableOutOfStoreSupport bool
+
+	// KeyProvider specifies the key used to encrypt the values stored in the
+	// datastore, they are stored in plaintext by default
+	KeyProvider KeyProvider
+
+	// DeviceIdleThreshold specifies the duration after which the key
+	// material of an idle device is garbage collected, defaults to
+	// DefaultDeviceIdleThreshold, a negative value disables it
+	DeviceIdleThreshold time.Duration
 }
 
 // MemberDevice is the public keys of a device and its member
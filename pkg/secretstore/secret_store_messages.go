@@ -63,7 +63,8 @@ func (s *secretStore) getDeviceChainKeyForGroupAndDevice(ctx context.Context, gr
 	return ds, nil
 }
 
-// IsChainKeyKnownForDevice returns true if the device chain key is known for the given group and device.
+// IsChainKeyKnownForDevice returns true if the device chain key is known for the given group and device,
+// or if the device is part of a known epoch of the ratchet tree of the group.
 func (s *secretStore) IsChainKeyKnownForDevice(ctx context.Context, groupPublicKey crypto.PubKey, devicePublicKey crypto.PubKey) (has bool) {
 	if s == nil {
 		return false
@@ -77,9 +78,21 @@ func (s *secretStore) IsChainKeyKnownForDevice(ctx context.Context, groupPublicK
 	s.messageMutex.RLock()
 	defer s.messageMutex.RUnlock()
 
-	has, _ = s.datastore.Has(ctx, key)
+	if has, _ = s.datastore.Has(ctx, key); has {
+		return
+	}
+
+	groupPublicKeyBytes, err := groupPublicKey.Raw()
+	if err != nil {
+		return false
+	}
+
+	devicePublicKeyBytes, err := devicePublicKey.Raw()
+	if err != nil {
+		return false
+	}
 
-	return
+	return s.isTreeKEMDeviceKnown(ctx, groupPublicKeyBytes, devicePublicKeyBytes)
 }
 
 // delPrecomputedKey deletes the message key in the cache namespace for the given group, device and counter.
@@ -132,7 +145,7 @@ func (s *secretStore) postDecryptActions(ctx context.Context, decryptionCtx *dec
 		return errcode.ErrCode_ErrDeserialization.Wrap(err)
 	}
 
-	if err = s.putKeyForCID(ctx, decryptionCtx.cid, decryptionCtx.messageKey); err != nil {
+	if err = s.putKeyForCID(ctx, groupPublicKey, decryptionCtx.cid, decryptionCtx.messageKey); err != nil {
 		return errcode.ErrCode_ErrInternal.Wrap(err)
 	}
 
@@ -150,6 +163,8 @@ func (s *secretStore) postDecryptActions(ctx context.Context, decryptionCtx *dec
 		if err = s.updateCurrentKey(ctx, groupPublicKey, devicePublicKey, deviceChainKey); err != nil {
 			return errcode.ErrCode_ErrInternal.Wrap(err)
 		}
+
+		s.markDeviceSeen(ctx, groupPublicKey, devicePublicKey)
 	}
 
 	return nil
@@ -262,7 +277,17 @@ func (s *secretStore) registerChainKey(ctx context.Context, group *protocoltypes
 		return errcode.ErrCode_ErrDeserialization.Wrap(err)
 	}
 
+	keepHistory := s.isHistorySharingEnabled(ctx, group)
+
 	if _, err := s.getDeviceChainKeyForGroupAndDevice(ctx, groupPublicKey, devicePublicKey); err == nil {
+		// The chain keys are registered again once the history sharing is
+		// enabled, the oldest one is kept
+		if keepHistory {
+			if err := s.keepOldestHistoryChainKey(ctx, groupPublicKey, devicePublicKey, deviceChainKey); err != nil {
+				return errcode.ErrCode_ErrInternal.Wrap(err)
+			}
+		}
+
 		// Device is already registered, ignore it
 		s.logger.Debug("device already registered in group",
 			logutil.PrivateBinary("devicePublicKey", logutil.CryptoKeyToBytes(devicePublicKey)),
@@ -276,6 +301,13 @@ func (s *secretStore) registerChainKey(ctx context.Context, group *protocoltypes
 		logutil.PrivateBinary("groupPublicKey", logutil.CryptoKeyToBytes(groupPublicKey)),
 	)
 
+	// Keep the first known chain key if it can be shared with future members
+	if keepHistory {
+		if err := s.putHistoryChainKey(ctx, groupPublicKey, devicePublicKey, deviceChainKey); err != nil {
+			return errcode.ErrCode_ErrInternal.Wrap(err)
+		}
+	}
+
 	// If own Device store key as is, no need to precompute future keys
 	if isCurrentDeviceChainKey {
 		if err := s.putDeviceChainKey(ctx, groupPublicKey, devicePublicKey, deviceChainKey); err != nil {
@@ -299,6 +331,8 @@ func (s *secretStore) registerChainKey(ctx context.Context, group *protocoltypes
 
 	s.messageMutex.Unlock()
 
+	s.markDeviceSeen(ctx, groupPublicKey, devicePublicKey)
+
 	devicePublicKeyBytes, err := devicePublicKey.Raw()
 	if err == nil {
 		if err := s.UpdateOutOfStoreGroupReferences(ctx, devicePublicKeyBytes, deviceChainKey.Counter, group); err != nil {
@@ -512,8 +546,9 @@ func (s *secretStore) putPrecomputedKeysNonBatched(ctx context.Context, groupRaw
 	return nil
 }
 
-// putKeyForCID puts the given message key in the datastore for a specified CID.
-func (s *secretStore) putKeyForCID(ctx context.Context, messageCID cid.Cid, messageKey *messageKey) error {
+// putKeyForCID puts the given message key in the datastore for a specified CID,
+// and indexes the CID by group.
+func (s *secretStore) putKeyForCID(ctx context.Context, groupPublicKey crypto.PubKey, messageCID cid.Cid, messageKey *messageKey) error {
 	if s == nil {
 		return errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("calling method of a non instantiated message keystore"))
 	}
@@ -527,6 +562,15 @@ func (s *secretStore) putKeyForCID(ctx context.Context, messageCID cid.Cid, mess
 		return errcode.ErrCode_ErrMessageKeyPersistencePut.Wrap(err)
 	}
 
+	groupPublicKeyBytes, err := groupPublicKey.Raw()
+	if err != nil {
+		return errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	if err := s.datastore.Put(ctx, dsKeyForMessageKeyCIDForGroup(groupPublicKeyBytes, messageCID), nil); err != nil {
+		return errcode.ErrCode_ErrMessageKeyPersistencePut.Wrap(err)
+	}
+
 	return nil
 }
 
@@ -537,6 +581,22 @@ func (s *secretStore) OpenEnvelopePayload(ctx context.Context, msgEnvelope *prot
 	s.messageMutex.Lock()
 	defer s.messageMutex.Unlock()
 
+	// messages of groups using the TreeKEM keying are encrypted using the
+	// secret of an epoch of the ratchet tree instead of a device chain key
+	if len(msgHeaders.EpochId) > 0 {
+		msgBytes, err := s.openTreeKEMPayload(ctx, msgCID, groupPublicKey, msgEnvelope.Message, msgHeaders)
+		if err != nil {
+			return nil, errcode.ErrCode_ErrCryptoDecryptPayload.Wrap(err)
+		}
+
+		var msg protocoltypes.EncryptedMessage
+		if err := proto.Unmarshal(msgBytes, &msg); err != nil {
+			return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+		}
+
+		return &msg, nil
+	}
+
 	msgBytes, decryptionCtx, err := s.openPayload(ctx, msgCID, groupPublicKey, msgEnvelope.Message, msgHeaders)
 	if err != nil {
 		return nil, errcode.ErrCode_ErrCryptoDecryptPayload.Wrap(err)
@@ -689,6 +749,10 @@ func (s *secretStore) SealEnvelope(ctx context.Context, group *protocoltypes.Gro
 		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
 	}
 
+	if group.Keying == protocoltypes.GroupKeying_GroupKeyingTreeKEM {
+		return s.sealTreeKEMEnvelope(ctx, group, localMemberDevice, messagePayload)
+	}
+
 	s.messageMutex.Lock()
 	defer s.messageMutex.Unlock()
 
@@ -990,12 +1054,16 @@ func sealEnvelope(messagePayload []byte, deviceChainKey *protocoltypes.DeviceCha
 		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
 	}
 
-	h := &protocoltypes.MessageHeaders{
+	return newMessageEnvelope(&protocoltypes.MessageHeaders{
 		Counter:  deviceChainKey.Counter + 1,
 		DevicePk: devicePublicKeyRaw,
 		Sig:      sig,
-	}
+	}, encryptedPayload, g)
+}
 
+// newMessageEnvelope encrypts the headers of a message using the group shared
+// secret and returns the serialized envelope.
+func newMessageEnvelope(h *protocoltypes.MessageHeaders, encryptedPayload []byte, g *protocoltypes.Group) ([]byte, error) {
 	headers, err := proto.Marshal(h)
 	if err != nil {
 		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
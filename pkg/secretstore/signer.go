@@ -0,0 +1,113 @@
+package secretstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	pb "github.com/libp2p/go-libp2p/core/crypto/pb"
+
+	"berty.tech/weshnet/v2/pkg/cryptoutil"
+	"berty.tech/weshnet/v2/pkg/errcode"
+)
+
+const (
+	// SignerKeyAccount is the name of the account key held by a Signer
+	SignerKeyAccount = "account"
+
+	// SignerKeyDevice is the name of the device key held by a Signer
+	SignerKeyDevice = "device"
+)
+
+// SignerTimeout is the maximum duration of the operations delegated to a
+// Signer by the secret store, crypto.PrivKey methods don't take a context
+var SignerTimeout = time.Second * 30
+
+// Signer holds ed25519 private keys outside of the secret store, e.g. in an
+// agent running in another process, and performs the operations requiring
+// them. The keys are identified by their names, which are SignerKeyAccount
+// and SignerKeyDevice.
+type Signer interface {
+	// PublicKey returns the public key of the named key, generating it if
+	// needed
+	PublicKey(ctx context.Context, name string) (crypto.PubKey, error)
+
+	// Sign signs data with the named key
+	Sign(ctx context.Context, name string, data []byte) ([]byte, error)
+
+	// KeyAgreement computes the X25519 shared secret of the Montgomery form
+	// of the named key and the given X25519 public key
+	KeyAgreement(ctx context.Context, name string, peerPublicKey []byte) ([]byte, error)
+}
+
+// signerPrivKey is a crypto.PrivKey whose operations are delegated to a
+// Signer, its raw value is not available
+type signerPrivKey struct {
+	signer Signer
+	name   string
+	public crypto.PubKey
+}
+
+var (
+	_ crypto.PrivKey       = (*signerPrivKey)(nil)
+	_ cryptoutil.KeyAgreer = (*signerPrivKey)(nil)
+)
+
+func newSignerPrivKey(ctx context.Context, signer Signer, name string) (*signerPrivKey, error) {
+	public, err := signer.PublicKey(ctx, name)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrKeystoreGet.Wrap(err)
+	}
+
+	if public.Type() != pb.KeyType_Ed25519 {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("the signer key %s is not an ed25519 key", name))
+	}
+
+	return &signerPrivKey{signer: signer, name: name, public: public}, nil
+}
+
+func (k *signerPrivKey) Equals(other crypto.Key) bool {
+	otherPrivKey, ok := other.(crypto.PrivKey)
+	if !ok {
+		return false
+	}
+
+	return k.public.Equals(otherPrivKey.GetPublic())
+}
+
+func (k *signerPrivKey) Raw() ([]byte, error) {
+	return nil, errcode.ErrCode_ErrNotImplemented.Wrap(fmt.Errorf("the signer key %s can't be exported", k.name))
+}
+
+func (k *signerPrivKey) Type() pb.KeyType {
+	return pb.KeyType_Ed25519
+}
+
+func (k *signerPrivKey) Sign(data []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), SignerTimeout)
+	defer cancel()
+
+	sig, err := k.signer.Sign(ctx, k.name, data)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoSignature.Wrap(err)
+	}
+
+	return sig, nil
+}
+
+func (k *signerPrivKey) GetPublic() crypto.PubKey {
+	return k.public
+}
+
+func (k *signerPrivKey) X25519(peerPublicKey *[cryptoutil.KeySize]byte) (*[cryptoutil.KeySize]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), SignerTimeout)
+	defer cancel()
+
+	secret, err := k.signer.KeyAgreement(ctx, k.name, peerPublicKey[:])
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(err)
+	}
+
+	return cryptoutil.KeySliceToArray(secret)
+}
@@ -0,0 +1,328 @@
+package secretstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/secretbox"
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/treekem"
+)
+
+const treeKEMMessageKeyInfo = "weshnet treekem message"
+
+// CommitTreeKEM creates a commit on the ratchet tree of a group, the current
+// device adds itself to the tree if it is not part of it yet. The key
+// material of the resulting epoch is kept, it is used once the commit is
+// accepted by the group.
+func (s *secretStore) CommitTreeKEM(ctx context.Context, group *protocoltypes.Group, tree *treekem.Tree, parentEpochID []byte, removedDevicePublicKeys [][]byte) ([]byte, error) {
+	if s.deviceKeystore == nil {
+		return nil, errcode.ErrCode_ErrCryptoSignature.Wrap(fmt.Errorf("message keystore is opened in read-only mode"))
+	}
+
+	if group.Keying != protocoltypes.GroupKeying_GroupKeyingTreeKEM {
+		return nil, errcode.ErrCode_ErrGroupInvalidType.Wrap(fmt.Errorf("the group doesn't use the TreeKEM keying"))
+	}
+
+	localMemberDevice, err := s.deviceKeystore.memberDeviceForGroup(group)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrGroupMemberUnknownGroupID.Wrap(err)
+	}
+
+	devicePublicKeyBytes, err := localMemberDevice.Device().Raw()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	var added [][]byte
+	if !tree.Contains(devicePublicKeyBytes) {
+		added = [][]byte{devicePublicKeyBytes}
+	}
+
+	commit, epoch, err := treekem.Commit(tree, parentEpochID, devicePublicKeyBytes, added, removedDevicePublicKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	s.messageMutex.Lock()
+	defer s.messageMutex.Unlock()
+
+	if err := s.putTreeKEMEpoch(ctx, group.PublicKey, treekem.EpochID(commit), epoch); err != nil {
+		return nil, err
+	}
+
+	return commit, nil
+}
+
+// RegisterTreeKEMCommit records the key material of the epoch created by a
+// commit of another device, the commit is processed using the key material of
+// its parent epoch. An error is returned if the current device is not part of
+// the resulting tree.
+func (s *secretStore) RegisterTreeKEMCommit(ctx context.Context, group *protocoltypes.Group, tree *treekem.Tree, commit []byte) error {
+	if s.deviceKeystore == nil {
+		return errcode.ErrCode_ErrCryptoSignature.Wrap(fmt.Errorf("message keystore is opened in read-only mode"))
+	}
+
+	localMemberDevice, err := s.deviceKeystore.memberDeviceForGroup(group)
+	if err != nil {
+		return errcode.ErrCode_ErrGroupMemberUnknownGroupID.Wrap(err)
+	}
+
+	devicePublicKeyBytes, err := localMemberDevice.Device().Raw()
+	if err != nil {
+		return errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	epochID := treekem.EpochID(commit)
+
+	s.messageMutex.Lock()
+	defer s.messageMutex.Unlock()
+
+	// the commits of the current device are registered when created
+	if has, _ := s.datastore.Has(ctx, dsKeyForTreeKEMEpochKeys(group.PublicKey, epochID)); has {
+		return nil
+	}
+
+	c := &protocoltypes.TreeKEMCommit{}
+	if err := proto.Unmarshal(commit, c); err != nil {
+		return errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	deviceKey, err := treekem.NewDeviceKey(localMemberDevice.device)
+	if err != nil {
+		return err
+	}
+
+	keys := []treekem.NodeKey{deviceKey}
+	if len(c.ParentEpochId) > 0 {
+		parentEpochKeys, err := s.getTreeKEMEpochKeys(ctx, group.PublicKey, c.ParentEpochId)
+		if err != nil && !errcode.Is(err, errcode.ErrCode_ErrMissingInput) {
+			return err
+		}
+
+		if parentEpochKeys != nil {
+			nodeKeys, err := treekem.NodeKeysFromEpochKeys(parentEpochKeys)
+			if err != nil {
+				return err
+			}
+
+			keys = append(keys, nodeKeys...)
+		}
+	}
+
+	epoch, err := treekem.Process(tree, c, devicePublicKeyBytes, keys)
+	if err != nil {
+		return err
+	}
+
+	return s.putTreeKEMEpoch(ctx, group.PublicKey, epochID, epoch)
+}
+
+// SetTreeKEMEpoch sets the epoch whose secret encrypts the messages sent on a
+// group, it is unset when epochID is empty
+func (s *secretStore) SetTreeKEMEpoch(ctx context.Context, group *protocoltypes.Group, epochID []byte) error {
+	key := dsKeyForTreeKEMCurrentEpoch(group.PublicKey)
+
+	s.messageMutex.Lock()
+	defer s.messageMutex.Unlock()
+
+	if len(epochID) == 0 {
+		if err := s.datastore.Delete(ctx, key); err != nil {
+			return errcode.ErrCode_ErrDBWrite.Wrap(err)
+		}
+
+		return nil
+	}
+
+	if err := s.datastore.Put(ctx, key, epochID); err != nil {
+		return errcode.ErrCode_ErrDBWrite.Wrap(err)
+	}
+
+	return nil
+}
+
+// putTreeKEMEpoch stores the key material of an epoch and indexes the devices
+// of its tree, whose messages can then be decrypted
+func (s *secretStore) putTreeKEMEpoch(ctx context.Context, groupPublicKeyBytes []byte, epochID []byte, epoch *treekem.Epoch) error {
+	epochKeysBytes, err := proto.Marshal(epoch.Keys())
+	if err != nil {
+		return errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	if err := s.datastore.Put(ctx, dsKeyForTreeKEMEpochKeys(groupPublicKeyBytes, epochID), epochKeysBytes); err != nil {
+		return errcode.ErrCode_ErrMessageKeyPersistencePut.Wrap(err)
+	}
+
+	for _, devicePublicKeyBytes := range epoch.Tree.Devices() {
+		if err := s.datastore.Put(ctx, dsKeyForTreeKEMDevice(groupPublicKeyBytes, devicePublicKeyBytes), nil); err != nil {
+			return errcode.ErrCode_ErrMessageKeyPersistencePut.Wrap(err)
+		}
+	}
+
+	return nil
+}
+
+// getTreeKEMEpochKeys returns the key material of the current device for an
+// epoch of a group
+func (s *secretStore) getTreeKEMEpochKeys(ctx context.Context, groupPublicKeyBytes []byte, epochID []byte) (*protocoltypes.TreeKEMEpochKeys, error) {
+	epochKeysBytes, err := s.datastore.Get(ctx, dsKeyForTreeKEMEpochKeys(groupPublicKeyBytes, epochID))
+	if err == datastore.ErrNotFound {
+		return nil, errcode.ErrCode_ErrMissingInput.Wrap(fmt.Errorf("unknown epoch"))
+	} else if err != nil {
+		return nil, errcode.ErrCode_ErrMessageKeyPersistenceGet.Wrap(err)
+	}
+
+	epochKeys := &protocoltypes.TreeKEMEpochKeys{}
+	if err := proto.Unmarshal(epochKeysBytes, epochKeys); err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	return epochKeys, nil
+}
+
+// isTreeKEMDeviceKnown returns whether a device is part of a known epoch of a
+// group
+func (s *secretStore) isTreeKEMDeviceKnown(ctx context.Context, groupPublicKeyBytes []byte, devicePublicKeyBytes []byte) bool {
+	has, _ := s.datastore.Has(ctx, dsKeyForTreeKEMDevice(groupPublicKeyBytes, devicePublicKeyBytes))
+	return has
+}
+
+// nextTreeKEMCounter increments the counter of the messages sent by the
+// current device on a group
+func (s *secretStore) nextTreeKEMCounter(ctx context.Context, groupPublicKeyBytes []byte) (uint64, error) {
+	key := dsKeyForTreeKEMCounter(groupPublicKeyBytes)
+
+	var counter uint64
+
+	counterBytes, err := s.datastore.Get(ctx, key)
+	if err == nil && len(counterBytes) == 8 {
+		counter = binary.BigEndian.Uint64(counterBytes)
+	} else if err != nil && err != datastore.ErrNotFound {
+		return 0, errcode.ErrCode_ErrDBRead.Wrap(err)
+	}
+
+	counter++
+
+	if err := s.datastore.Put(ctx, key, binary.BigEndian.AppendUint64(nil, counter)); err != nil {
+		return 0, errcode.ErrCode_ErrDBWrite.Wrap(err)
+	}
+
+	return counter, nil
+}
+
+// sealTreeKEMEnvelope encrypts a payload using a message key derived from the
+// secret of the current epoch of a group
+func (s *secretStore) sealTreeKEMEnvelope(ctx context.Context, group *protocoltypes.Group, localMemberDevice *ownMemberDevice, messagePayload []byte) ([]byte, error) {
+	devicePublicKeyBytes, err := localMemberDevice.Device().Raw()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	s.messageMutex.Lock()
+	defer s.messageMutex.Unlock()
+
+	epochID, err := s.datastore.Get(ctx, dsKeyForTreeKEMCurrentEpoch(group.PublicKey))
+	if err == datastore.ErrNotFound {
+		return nil, errcode.ErrCode_ErrCryptoEncrypt.Wrap(fmt.Errorf("the device is not part of the ratchet tree of the group yet"))
+	} else if err != nil {
+		return nil, errcode.ErrCode_ErrDBRead.Wrap(err)
+	}
+
+	epochKeys, err := s.getTreeKEMEpochKeys(ctx, group.PublicKey, epochID)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoEncrypt.Wrap(err)
+	}
+
+	counter, err := s.nextTreeKEMCounter(ctx, group.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	msgKey, err := treeKEMMessageKey(epochKeys.EpochSecret, devicePublicKeyBytes, counter)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := localMemberDevice.device.Sign(messagePayload)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoSignature.Wrap(err)
+	}
+
+	return newMessageEnvelope(&protocoltypes.MessageHeaders{
+		Counter:  counter,
+		DevicePk: devicePublicKeyBytes,
+		Sig:      sig,
+		EpochId:  epochID,
+	}, secretbox.Seal(nil, messagePayload, uint64AsNonce(counter), (*[32]byte)(msgKey)), group)
+}
+
+// openTreeKEMPayload opens the payload of a message encrypted using the
+// secret of an epoch, the message key is then kept for its CID
+func (s *secretStore) openTreeKEMPayload(ctx context.Context, msgCID cid.Cid, groupPublicKey crypto.PubKey, payload []byte, msgHeaders *protocoltypes.MessageHeaders) ([]byte, error) {
+	devicePublicKey, err := crypto.UnmarshalEd25519PublicKey(msgHeaders.DevicePk)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	groupPublicKeyBytes, err := groupPublicKey.Raw()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	decryptionCtx := &decryptionContext{
+		cid:            msgCID,
+		newlyDecrypted: true,
+	}
+
+	if decryptionCtx.messageKey, err = s.getKeyForCID(ctx, msgCID); err == nil {
+		decryptionCtx.newlyDecrypted = false
+	} else {
+		epochKeys, err := s.getTreeKEMEpochKeys(ctx, groupPublicKeyBytes, msgHeaders.EpochId)
+		if err != nil {
+			return nil, errcode.ErrCode_ErrCryptoDecrypt.Wrap(err)
+		}
+
+		if decryptionCtx.messageKey, err = treeKEMMessageKey(epochKeys.EpochSecret, msgHeaders.DevicePk, msgHeaders.Counter); err != nil {
+			return nil, err
+		}
+	}
+
+	msg, decryptionCtx, err := s.openPayloadWithMessageKey(decryptionCtx, devicePublicKey, payload, msgHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	if decryptionCtx.newlyDecrypted {
+		if err := s.putKeyForCID(ctx, groupPublicKey, decryptionCtx.cid, decryptionCtx.messageKey); err != nil {
+			return nil, errcode.ErrCode_ErrInternal.Wrap(err)
+		}
+
+		s.markDeviceSeen(ctx, groupPublicKey, devicePublicKey)
+	}
+
+	return msg, nil
+}
+
+// treeKEMMessageKey derives the key of a message sent by a device from the
+// secret of an epoch
+func treeKEMMessageKey(epochSecret []byte, devicePublicKeyBytes []byte, counter uint64) (*messageKey, error) {
+	info := append([]byte(treeKEMMessageKeyInfo), devicePublicKeyBytes...)
+	info = binary.BigEndian.AppendUint64(info, counter)
+
+	var msgKey messageKey
+	if _, err := io.ReadFull(hkdf.New(sha256.New, epochSecret, nil, info), msgKey[:]); err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(err)
+	}
+
+	return &msgKey, nil
+}
@@ -0,0 +1,110 @@
+package secretstore
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/treekem"
+)
+
+func TestTreeKEMEnvelopes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	group, _, err := protocoltypes.NewGroupMultiMember()
+	require.NoError(t, err)
+
+	group.Keying = protocoltypes.GroupKeying_GroupKeyingTreeKEM
+
+	groupPublicKey, err := group.GetPubKey()
+	require.NoError(t, err)
+
+	stores := make([]*secretStore, 3)
+	memberDevices := make([]OwnMemberDevice, 3)
+	for i := range stores {
+		stores[i], err = newInMemSecretStore(nil)
+		require.NoError(t, err)
+
+		t.Cleanup(func() { _ = stores[i].Close() })
+
+		require.NoError(t, stores[i].PutGroup(ctx, group))
+
+		memberDevices[i], err = stores[i].GetOwnMemberDeviceForGroup(group)
+		require.NoError(t, err)
+	}
+
+	sender, receiver, outsider := stores[0], stores[1], stores[2]
+
+	payload, err := proto.Marshal(&protocoltypes.EncryptedMessage{Plaintext: []byte("test")})
+	require.NoError(t, err)
+
+	// messages can't be sent before joining the tree
+	_, err = sender.SealEnvelope(ctx, group, payload)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrCryptoEncrypt))
+
+	// the sender and the receiver join the tree
+	tree := treekem.NewTree()
+	var epochID []byte
+	for _, store := range []*secretStore{sender, receiver} {
+		commitBytes, err := store.CommitTreeKEM(ctx, group, tree, epochID, nil)
+		require.NoError(t, err)
+
+		commit := &protocoltypes.TreeKEMCommit{}
+		require.NoError(t, proto.Unmarshal(commitBytes, commit))
+
+		for _, other := range stores {
+			err := other.RegisterTreeKEMCommit(ctx, group, tree, commitBytes)
+			if other == outsider || (other == receiver && len(epochID) == 0) {
+				require.True(t, errcode.Is(err, errcode.ErrCode_ErrNotFound))
+			} else {
+				require.NoError(t, err)
+			}
+		}
+
+		tree, err = tree.Apply(commit)
+		require.NoError(t, err)
+
+		epochID = treekem.EpochID(commitBytes)
+	}
+
+	for _, store := range stores {
+		require.NoError(t, store.SetTreeKEMEpoch(ctx, group, epochID))
+	}
+
+	require.True(t, receiver.IsChainKeyKnownForDevice(ctx, groupPublicKey, memberDevices[0].Device()))
+	require.False(t, outsider.IsChainKeyKnownForDevice(ctx, groupPublicKey, memberDevices[0].Device()))
+
+	envelope, err := sender.SealEnvelope(ctx, group, payload)
+	require.NoError(t, err)
+
+	openEnvelope := func(store *secretStore, index int) ([]byte, error) {
+		env, headers, err := store.OpenEnvelopeHeaders(envelope, group)
+		require.NoError(t, err)
+		require.Equal(t, epochID, headers.EpochId)
+
+		msg, err := store.OpenEnvelopePayload(ctx, env, headers, groupPublicKey, memberDevices[index].Device(), cid.Undef)
+		if err != nil {
+			return nil, err
+		}
+
+		return msg.Plaintext, nil
+	}
+
+	plaintext, err := openEnvelope(receiver, 1)
+	require.NoError(t, err)
+	require.Equal(t, []byte("test"), plaintext)
+
+	// the sender can read its own messages
+	plaintext, err = openEnvelope(sender, 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("test"), plaintext)
+
+	_, err = openEnvelope(outsider, 2)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrCryptoDecryptPayload))
+}
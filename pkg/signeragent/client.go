@@ -0,0 +1,76 @@
+package signeragent
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/secretstore"
+	"berty.tech/weshnet/v2/pkg/signeragenttypes"
+)
+
+// Client is a secretstore.Signer delegating the operations to a signer agent
+type Client struct {
+	cc     *grpc.ClientConn
+	client signeragenttypes.SignerAgentServiceClient
+}
+
+var _ secretstore.Signer = (*Client)(nil)
+
+// NewClient returns a Client reaching the agent served on the unix socket
+// at socketPath
+func NewClient(socketPath string, opts ...grpc.DialOption) (*Client, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
+
+	cc, err := grpc.NewClient("unix://"+socketPath, opts...)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrInternal.Wrap(err)
+	}
+
+	return NewClientFromConn(cc), nil
+}
+
+// NewClientFromConn returns a Client using an existing connection to an agent
+func NewClientFromConn(cc *grpc.ClientConn) *Client {
+	return &Client{cc: cc, client: signeragenttypes.NewSignerAgentServiceClient(cc)}
+}
+
+func (c *Client) PublicKey(ctx context.Context, name string) (crypto.PubKey, error) {
+	reply, err := c.client.PublicKey(ctx, &signeragenttypes.PublicKey_Request{KeyName: name})
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := crypto.UnmarshalPublicKey(reply.PublicKey)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	return publicKey, nil
+}
+
+func (c *Client) Sign(ctx context.Context, name string, data []byte) ([]byte, error) {
+	reply, err := c.client.Sign(ctx, &signeragenttypes.Sign_Request{KeyName: name, Data: data})
+	if err != nil {
+		return nil, err
+	}
+
+	return reply.Signature, nil
+}
+
+func (c *Client) KeyAgreement(ctx context.Context, name string, peerPublicKey []byte) ([]byte, error) {
+	reply, err := c.client.KeyAgreement(ctx, &signeragenttypes.KeyAgreement_Request{KeyName: name, PeerPublicKey: peerPublicKey})
+	if err != nil {
+		return nil, err
+	}
+
+	return reply.SharedSecret, nil
+}
+
+// Close closes the connection to the agent
+func (c *Client) Close() error {
+	return c.cc.Close()
+}
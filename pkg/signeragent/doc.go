@@ -0,0 +1,9 @@
+// Package signeragent contains a signer agent holding the account and device
+// keys of a secret store outside of the weshnet process, and the client used
+// by the secret store to reach it over a local socket.
+//
+// The agent is served with Serve, using a Signer such as the SoftwareSigner
+// reference implementation. The secret store uses it through a Client, given
+// as the Signer option of secretstore.NewSecretStore. The socket is only
+// protected by its file permissions.
+package signeragent
@@ -0,0 +1,131 @@
+package signeragent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/secretstore"
+	"berty.tech/weshnet/v2/pkg/signeragenttypes"
+)
+
+type server struct {
+	signer secretstore.Signer
+	logger *zap.Logger
+
+	signeragenttypes.UnimplementedSignerAgentServiceServer
+}
+
+// NewServer returns a SignerAgentServiceServer exposing the given signer
+func NewServer(signer secretstore.Signer, logger *zap.Logger) signeragenttypes.SignerAgentServiceServer {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+
+	return &server{signer: signer, logger: logger}
+}
+
+func (s *server) PublicKey(ctx context.Context, req *signeragenttypes.PublicKey_Request) (*signeragenttypes.PublicKey_Reply, error) {
+	publicKey, err := s.signer.PublicKey(ctx, req.KeyName)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKeyBytes, err := crypto.MarshalPublicKey(publicKey)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	return &signeragenttypes.PublicKey_Reply{PublicKey: publicKeyBytes}, nil
+}
+
+func (s *server) Sign(ctx context.Context, req *signeragenttypes.Sign_Request) (*signeragenttypes.Sign_Reply, error) {
+	sig, err := s.signer.Sign(ctx, req.KeyName, req.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Debug("signed data", zap.String("key", req.KeyName), zap.Int("size", len(req.Data)))
+
+	return &signeragenttypes.Sign_Reply{Signature: sig}, nil
+}
+
+func (s *server) KeyAgreement(ctx context.Context, req *signeragenttypes.KeyAgreement_Request) (*signeragenttypes.KeyAgreement_Reply, error) {
+	secret, err := s.signer.KeyAgreement(ctx, req.KeyName, req.PeerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &signeragenttypes.KeyAgreement_Reply{SharedSecret: secret}, nil
+}
+
+// Serve serves the signer on a unix socket created at socketPath, only
+// accessible by the current user, until ctx is done
+func Serve(ctx context.Context, socketPath string, signer secretstore.Signer, logger *zap.Logger) error {
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return errcode.ErrCode_ErrInternal.Wrap(fmt.Errorf("unable to remove stale socket: %w", err))
+	}
+
+	l, err := listenPrivate(socketPath)
+	if err != nil {
+		return errcode.ErrCode_ErrInternal.Wrap(err)
+	}
+	defer os.Remove(socketPath)
+
+	s := grpc.NewServer()
+	signeragenttypes.RegisterSignerAgentServiceServer(s, NewServer(signer, logger))
+
+	go func() {
+		<-ctx.Done()
+		s.GracefulStop()
+	}()
+
+	if err := s.Serve(l); err != nil && ctx.Err() == nil {
+		return errcode.ErrCode_ErrInternal.Wrap(err)
+	}
+
+	return nil
+}
+
+// listenPrivate listens on a unix socket which is never accessible by other
+// users: it is created in a private directory, restricted, then moved to
+// socketPath
+func listenPrivate(socketPath string) (net.Listener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(socketPath), ".signeragent-")
+	if err != nil {
+		return nil, fmt.Errorf("unable to create socket directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Chmod(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	tmpPath := filepath.Join(dir, "signer.sock")
+	l, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// the socket is removed by Serve once moved
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+
+	if err := os.Chmod(tmpPath, 0o600); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	if err := os.Rename(tmpPath, socketPath); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("unable to move socket: %w", err)
+	}
+
+	return l, nil
+}
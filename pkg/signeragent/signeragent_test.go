@@ -0,0 +1,138 @@
+package signeragent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/secretstore"
+)
+
+func serveTestAgent(ctx context.Context, t *testing.T, signer secretstore.Signer) *Client {
+	t.Helper()
+
+	// socket paths are limited in length, avoid the long test directories
+	dir, err := os.MkdirTemp("", "wesh-agent")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	socketPath := filepath.Join(dir, "agent.sock")
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- Serve(ctx, socketPath, signer, nil) }()
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(socketPath)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the socket is created private, the temporary directory is removed
+	info, err := os.Stat(socketPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	client, err := NewClient(socketPath)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	return client
+}
+
+func TestSecretStoreWithSignerAgent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	agentKeystore := keystore.NewMemKeystore()
+	client := serveTestAgent(ctx, t, NewSoftwareSigner(agentKeystore))
+
+	storeKeystore := keystore.NewMemKeystore()
+	store1, err := secretstore.NewInMemSecretStore(&secretstore.NewSecretStoreOptions{
+		Signer:   client,
+		Keystore: storeKeystore,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store1.Close() })
+
+	store2, err := secretstore.NewInMemSecretStore(nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store2.Close() })
+
+	// the account key is the one of the agent
+	accountPublicKey, err := client.PublicKey(ctx, secretstore.SignerKeyAccount)
+	require.NoError(t, err)
+
+	account1, omd1, err := store1.GetGroupForAccount()
+	require.NoError(t, err)
+	require.True(t, accountPublicKey.Equals(omd1.Member()))
+
+	pk1, err := account1.GetPubKey()
+	require.NoError(t, err)
+
+	account2, omd2, err := store2.GetGroupForAccount()
+	require.NoError(t, err)
+
+	pk2, err := account2.GetPubKey()
+	require.NoError(t, err)
+
+	// contact group derivation relies on the key agreement of the agent
+	group1, err := store1.GetGroupForContact(pk2)
+	require.NoError(t, err)
+
+	group2, err := store2.GetGroupForContact(pk1)
+	require.NoError(t, err)
+	require.Equal(t, group1.PublicKey, group2.PublicKey)
+	require.Equal(t, group1.Secret, group2.Secret)
+
+	require.NoError(t, store1.PutGroup(ctx, group1))
+	require.NoError(t, store2.PutGroup(ctx, group2))
+
+	// the chain key is encrypted with the agent's device key
+	chainKey, err := store1.GetShareableChainKey(ctx, group1, omd2.Member())
+	require.NoError(t, err)
+	require.NoError(t, store2.RegisterChainKey(ctx, group2, omd1.Device(), chainKey))
+
+	// and the messages are signed by the agent
+	payload, err := proto.Marshal(&protocoltypes.EncryptedMessage{Plaintext: []byte("test")})
+	require.NoError(t, err)
+
+	env, err := store1.SealEnvelope(ctx, group1, payload)
+	require.NoError(t, err)
+
+	msgEnv, headers, err := store2.OpenEnvelopeHeaders(env, group2)
+	require.NoError(t, err)
+
+	gPK, err := group2.GetPubKey()
+	require.NoError(t, err)
+
+	msg, err := store2.OpenEnvelopePayload(ctx, msgEnv, headers, gPK, omd2.Device(), cid.Undef)
+	require.NoError(t, err)
+	require.Equal(t, []byte("test"), msg.Plaintext)
+
+	// the keys held by the agent never reach the secret store
+	for _, name := range []string{"accountSK", "deviceSK"} {
+		has, err := storeKeystore.Has(name)
+		require.NoError(t, err)
+		require.False(t, has, name)
+	}
+
+	_, _, err = store1.ExportAccountKeysForBackup()
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrNotImplemented))
+}
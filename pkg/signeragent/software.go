@@ -0,0 +1,99 @@
+package signeragent
+
+import (
+	"context"
+	crand "crypto/rand"
+	"fmt"
+	"sync"
+
+	keystore "github.com/ipfs/go-ipfs-keystore"
+	"github.com/libp2p/go-libp2p/core/crypto"
+
+	"berty.tech/weshnet/v2/pkg/cryptoutil"
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/secretstore"
+)
+
+// SoftwareSigner is a secretstore.Signer keeping its keys in a keystore, it
+// is meant to be served by an agent process
+type SoftwareSigner struct {
+	keystore keystore.Keystore
+	mu       sync.Mutex
+}
+
+var _ secretstore.Signer = (*SoftwareSigner)(nil)
+
+// NewSoftwareSigner instantiates a SoftwareSigner, the missing keys are
+// generated in the given keystore on first use
+func NewSoftwareSigner(ks keystore.Keystore) *SoftwareSigner {
+	return &SoftwareSigner{keystore: ks}
+}
+
+func (s *SoftwareSigner) PublicKey(_ context.Context, name string) (crypto.PubKey, error) {
+	privateKey, err := s.getOrGenerateKey(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return privateKey.GetPublic(), nil
+}
+
+func (s *SoftwareSigner) Sign(_ context.Context, name string, data []byte) ([]byte, error) {
+	privateKey, err := s.getOrGenerateKey(name)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := privateKey.Sign(data)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoSignature.Wrap(err)
+	}
+
+	return sig, nil
+}
+
+func (s *SoftwareSigner) KeyAgreement(_ context.Context, name string, peerPublicKey []byte) ([]byte, error) {
+	privateKey, err := s.getOrGenerateKey(name)
+	if err != nil {
+		return nil, err
+	}
+
+	peer, err := cryptoutil.KeySliceToArray(peerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := cryptoutil.X25519(privateKey, peer)
+	if err != nil {
+		return nil, err
+	}
+
+	return secret[:], nil
+}
+
+func (s *SoftwareSigner) getOrGenerateKey(name string) (crypto.PrivKey, error) {
+	if name == "" {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("a key name is required"))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	privateKey, err := s.keystore.Get(name)
+	if err == nil {
+		return privateKey, nil
+	} else if err.Error() != keystore.ErrNoSuchKey.Error() {
+		return nil, errcode.ErrCode_ErrKeystoreGet.Wrap(err)
+	}
+
+	privateKey, _, err = crypto.GenerateEd25519Key(crand.Reader)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(err)
+	}
+
+	if err := s.keystore.Put(name, privateKey); err != nil {
+		return nil, errcode.ErrCode_ErrKeystorePut.Wrap(err)
+	}
+
+	return privateKey, nil
+}
@@ -0,0 +1,422 @@
+package storeforward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	msmux "github.com/multiformats/go-multistream"
+	"go.uber.org/zap"
+
+	"berty.tech/weshnet/v2/pkg/logutil"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/protoio"
+)
+
+var (
+	// ExchangeTimeout is the maximum duration of an exchange with a peer
+	ExchangeTimeout = time.Minute * 2
+
+	// ExchangeDebounce is the delay before offering new envelopes to the
+	// connected peers
+	ExchangeDebounce = time.Second
+
+	// GCInterval is the interval between two cleanups of the expired envelopes
+	GCInterval = time.Minute * 10
+
+	// MaxOffer is the maximum number of envelope ids offered in an exchange
+	MaxOffer = 16 * 1024
+)
+
+// DeliverFunc is called with the envelopes received from other relays, it
+// allows the local node to consume the entries of the groups it belongs to
+type DeliverFunc func(env *protocoltypes.StoreForwardEnvelope)
+
+// Relay carries envelopes and exchanges them with the peers it meets over
+// the connections allowed by the ConnFilter option
+type Relay struct {
+	host    host.Host
+	store   *Store
+	deliver DeliverFunc
+	filter  ConnFilter
+	logger  *zap.Logger
+
+	exchanging   map[peer.ID]bool // true if another exchange is pending
+	muExchanging sync.Mutex
+
+	notify chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewRelay starts a relay using the given store, deliver can be nil
+func NewRelay(h host.Host, store *Store, deliver DeliverFunc) (*Relay, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r := &Relay{
+		host:       h,
+		store:      store,
+		deliver:    deliver,
+		filter:     store.opts.ConnFilter,
+		logger:     store.logger.Named("storeforward"),
+		exchanging: make(map[peer.ID]bool),
+		notify:     make(chan struct{}, 1),
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+
+	// identification is required to know if the peer supports the protocol
+	sub, err := h.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted),
+		eventbus.Name("weshnet/storeforward/peer-identified"))
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("unable to subscribe to identification events: %w", err)
+	}
+
+	h.SetStreamHandler(ProtocolID, r.handleStream)
+
+	r.wg.Add(2)
+	go r.monitorPeers(sub)
+	go r.loop()
+
+	return r, nil
+}
+
+// Publish adds an entry of a group the local node belongs to, it will be
+// handed on to the peers met. The envelope is signed with the key of the
+// host.
+func (r *Relay) Publish(ctx context.Context, topic []byte, c cid.Cid, payload []byte) error {
+	env := &protocoltypes.StoreForwardEnvelope{
+		Topic:     topic,
+		Cid:       c.Bytes(),
+		Payload:   payload,
+		ExpiresAt: time.Now().Add(r.store.opts.TTL).UnixNano(),
+	}
+
+	sk := r.host.Peerstore().PrivKey(r.host.ID())
+	if sk == nil {
+		return fmt.Errorf("unable to get the private key of the host")
+	}
+
+	if err := SignEnvelope(env, sk); err != nil {
+		return err
+	}
+
+	added, err := r.store.Put(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	if added {
+		r.signal()
+	}
+
+	return nil
+}
+
+// Store returns the store of the relay
+func (r *Relay) Store() *Store {
+	return r.store
+}
+
+// Close stops the relay
+func (r *Relay) Close() error {
+	r.host.RemoveStreamHandler(ProtocolID)
+	r.cancel()
+	r.wg.Wait()
+	return nil
+}
+
+func (r *Relay) signal() {
+	select {
+	case r.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Relay) monitorPeers(sub event.Subscription) {
+	defer r.wg.Done()
+	defer sub.Close()
+
+	for {
+		var evt any
+		select {
+		case evt = <-sub.Out():
+		case <-r.ctx.Done():
+			return
+		}
+
+		e := evt.(event.EvtPeerIdentificationCompleted)
+
+		// only one of the peers starts the exchange when they meet
+		if r.host.ID() > e.Peer {
+			continue
+		}
+
+		if r.filter(e.Conn) && r.supportsProtocol(e.Peer) {
+			go r.exchangeWithPeer(e.Peer)
+		}
+	}
+}
+
+// loop offers new envelopes to the connected peers and drops the expired ones
+func (r *Relay) loop() {
+	defer r.wg.Done()
+
+	gcTicker := time.NewTicker(GCInterval)
+	defer gcTicker.Stop()
+
+	for {
+		select {
+		case <-r.notify:
+		case <-gcTicker.C:
+			if err := r.store.GC(r.ctx); err != nil {
+				r.logger.Error("unable to drop expired envelopes", zap.Error(err))
+			}
+			continue
+		case <-r.ctx.Done():
+			return
+		}
+
+		// wait for more envelopes before notifying peers
+		select {
+		case <-time.After(ExchangeDebounce):
+		case <-r.ctx.Done():
+			return
+		}
+
+		for _, p := range r.host.Network().Peers() {
+			if r.allowedPeer(p) && r.supportsProtocol(p) {
+				go r.exchangeWithPeer(p)
+			}
+		}
+	}
+}
+
+// allowedPeer tells if one of the connections to the peer is allowed by the
+// filter
+func (r *Relay) allowedPeer(p peer.ID) bool {
+	for _, c := range r.host.Network().ConnsToPeer(p) {
+		if r.filter(c) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Relay) supportsProtocol(p peer.ID) bool {
+	protocols, err := r.host.Peerstore().SupportsProtocols(p, ProtocolID)
+	return err == nil && len(protocols) > 0
+}
+
+// exchangeWithPeer runs an exchange with the given peer, if an exchange is
+// already running it is run again once done
+func (r *Relay) exchangeWithPeer(p peer.ID) {
+	r.muExchanging.Lock()
+	if _, ok := r.exchanging[p]; ok {
+		r.exchanging[p] = true
+		r.muExchanging.Unlock()
+		return
+	}
+	r.exchanging[p] = false
+	r.muExchanging.Unlock()
+
+	for {
+		if err := r.exchange(r.ctx, p); err != nil {
+			r.logger.Debug("exchange failed", logutil.PrivateString("peer", p.String()), zap.Error(err))
+		}
+
+		r.muExchanging.Lock()
+		if pending := r.exchanging[p]; !pending || r.ctx.Err() != nil {
+			delete(r.exchanging, p)
+			r.muExchanging.Unlock()
+			return
+		}
+		r.exchanging[p] = false
+		r.muExchanging.Unlock()
+	}
+}
+
+func (r *Relay) exchange(ctx context.Context, p peer.ID) error {
+	ctx, cancel := context.WithTimeout(ctx, ExchangeTimeout)
+	defer cancel()
+
+	s, err := r.newStream(ctx, p)
+	if err != nil {
+		return fmt.Errorf("unable to open stream: %w", err)
+	}
+	defer s.Close()
+
+	_ = s.SetDeadline(time.Now().Add(ExchangeTimeout))
+
+	reader := protoio.NewDelimitedReader(s, r.maxMessageSize())
+	writer := protoio.NewDelimitedWriter(s)
+
+	if err := writer.WriteMsg(&protocoltypes.StoreForwardExchange{Offer: r.offer()}); err != nil {
+		return fmt.Errorf("unable to send offer: %w", err)
+	}
+
+	reply := &protocoltypes.StoreForwardExchange{}
+	if err := reader.ReadMsg(reply); err != nil {
+		return fmt.Errorf("unable to read reply: %w", err)
+	}
+
+	if err := writer.WriteMsg(&protocoltypes.StoreForwardExchange{Want: r.store.Wanted(reply.Offer)}); err != nil {
+		return fmt.Errorf("unable to send wanted envelopes: %w", err)
+	}
+
+	return r.transfer(ctx, s, reader, writer, reply.Want)
+}
+
+// newStream opens a stream on a connection allowed by the filter
+func (r *Relay) newStream(ctx context.Context, p peer.ID) (network.Stream, error) {
+	for _, c := range r.host.Network().ConnsToPeer(p) {
+		if !r.filter(c) {
+			continue
+		}
+
+		s, err := c.NewStream(network.WithAllowLimitedConn(ctx, "storeforward"))
+		if err != nil {
+			return nil, err
+		}
+
+		_ = s.SetDeadline(time.Now().Add(ExchangeTimeout))
+		if err := msmux.SelectProtoOrFail(ProtocolID, s); err != nil {
+			_ = s.Reset()
+			return nil, err
+		}
+
+		if err := s.SetProtocol(ProtocolID); err != nil {
+			_ = s.Reset()
+			return nil, err
+		}
+
+		return s, nil
+	}
+
+	return nil, fmt.Errorf("no connection allowed to the peer")
+}
+
+func (r *Relay) handleStream(s network.Stream) {
+	if !r.filter(s.Conn()) {
+		_ = s.Reset()
+		return
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(r.ctx, ExchangeTimeout)
+	defer cancel()
+
+	_ = s.SetDeadline(time.Now().Add(ExchangeTimeout))
+
+	reader := protoio.NewDelimitedReader(s, r.maxMessageSize())
+	writer := protoio.NewDelimitedWriter(s)
+
+	offer := &protocoltypes.StoreForwardExchange{}
+	if err := reader.ReadMsg(offer); err != nil {
+		r.logger.Debug("unable to read offer", zap.Error(err))
+		return
+	}
+
+	reply := &protocoltypes.StoreForwardExchange{
+		Want:  r.store.Wanted(offer.Offer),
+		Offer: r.offer(),
+	}
+	if err := writer.WriteMsg(reply); err != nil {
+		r.logger.Debug("unable to send reply", zap.Error(err))
+		return
+	}
+
+	want := &protocoltypes.StoreForwardExchange{}
+	if err := reader.ReadMsg(want); err != nil {
+		r.logger.Debug("unable to read wanted envelopes", zap.Error(err))
+		return
+	}
+
+	if err := r.transfer(ctx, s, reader, writer, want.Want); err != nil {
+		r.logger.Debug("transfer failed", logutil.PrivateString("peer", s.Conn().RemotePeer().String()), zap.Error(err))
+	}
+}
+
+// transfer sends the envelopes wanted by the remote peer while receiving the
+// ones we asked for
+func (r *Relay) transfer(ctx context.Context, s network.Stream, reader protoio.Reader, writer protoio.Writer, want [][]byte) error {
+	sendErr := make(chan error, 1)
+	go func() {
+		defer func() { _ = s.CloseWrite() }()
+
+		for _, id := range want {
+			env, err := r.store.Get(ctx, id)
+			if err != nil {
+				// evicted or expired since the offer
+				continue
+			}
+
+			env.Hops++
+			if err := writer.WriteMsg(&protocoltypes.StoreForwardExchange{Envelopes: []*protocoltypes.StoreForwardEnvelope{env}}); err != nil {
+				sendErr <- fmt.Errorf("unable to send envelope: %w", err)
+				return
+			}
+		}
+
+		sendErr <- nil
+	}()
+
+	received := 0
+	for {
+		msg := &protocoltypes.StoreForwardExchange{}
+		if err := reader.ReadMsg(msg); err != nil {
+			if !errors.Is(err, io.EOF) {
+				return fmt.Errorf("unable to read envelope: %w", err)
+			}
+			break
+		}
+
+		for _, env := range msg.Envelopes {
+			added, err := r.store.Put(ctx, env)
+			if err != nil {
+				r.logger.Warn("envelope rejected", zap.Error(err))
+				continue
+			}
+
+			if added {
+				received++
+				if r.deliver != nil {
+					r.deliver(env)
+				}
+			}
+		}
+	}
+
+	if received > 0 {
+		r.logger.Debug("envelopes received", logutil.PrivateString("peer", s.Conn().RemotePeer().String()), zap.Int("count", received))
+		r.signal()
+	}
+
+	return <-sendErr
+}
+
+func (r *Relay) offer() [][]byte {
+	ids := r.store.IDs()
+	if len(ids) > MaxOffer {
+		ids = ids[:MaxOffer]
+	}
+
+	return ids
+}
+
+func (r *Relay) maxMessageSize() int {
+	// an envelope with its metadata, or an offer
+	return max(r.store.opts.MaxEnvelopeSize+4096, MaxOffer*(32+4)+1024)
+}
@@ -0,0 +1,163 @@
+package storeforward_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/storeforward"
+	"berty.tech/weshnet/v2/pkg/testutil"
+)
+
+func TestRelayMultiHop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger, cleanup := testutil.Logger(t)
+	defer cleanup()
+
+	prevDebounce := storeforward.ExchangeDebounce
+	storeforward.ExchangeDebounce = time.Millisecond * 10
+	defer func() { storeforward.ExchangeDebounce = prevDebounce }()
+
+	mn := mocknet.New()
+	defer mn.Close()
+
+	// a <-> b <-> c, a and c can't reach each other
+	relays := make([]*storeforward.Relay, 3)
+	hosts := make([]host.Host, 3)
+	delivered := make([]chan *protocoltypes.StoreForwardEnvelope, 3)
+	for i := range relays {
+		h, err := mn.GenPeer()
+		require.NoError(t, err)
+		hosts[i] = h
+
+		store, err := storeforward.NewStore(ctx, ds_sync.MutexWrap(ds.NewMapDatastore()), storeforward.Options{
+			Logger:     logger,
+			ConnFilter: func(network.Conn) bool { return true },
+		})
+		require.NoError(t, err)
+
+		delivered[i] = make(chan *protocoltypes.StoreForwardEnvelope, 8)
+		ch := delivered[i]
+		relays[i], err = storeforward.NewRelay(h, store, func(env *protocoltypes.StoreForwardEnvelope) { ch <- env })
+		require.NoError(t, err)
+		defer relays[i].Close()
+	}
+
+	_, err := mn.LinkPeers(hosts[0].ID(), hosts[1].ID())
+	require.NoError(t, err)
+	_, err = mn.LinkPeers(hosts[1].ID(), hosts[2].ID())
+	require.NoError(t, err)
+
+	// envelopes published before meeting are carried
+	env := newEnvelope(t, "topic", []byte("entry"), time.Hour)
+	c, err := cid.Cast(env.Cid)
+	require.NoError(t, err)
+	require.NoError(t, relays[0].Publish(ctx, env.Topic, c, env.Payload))
+
+	_, err = mn.ConnectPeers(hosts[0].ID(), hosts[1].ID())
+	require.NoError(t, err)
+	_, err = mn.ConnectPeers(hosts[1].ID(), hosts[2].ID())
+	require.NoError(t, err)
+
+	for _, i := range []int{1, 2} {
+		select {
+		case received := <-delivered[i]:
+			require.Equal(t, env.Payload, received.Payload)
+			require.Equal(t, uint32(i), received.Hops)
+		case <-time.After(time.Second * 10):
+			require.FailNow(t, "envelope not delivered", "relay %d", i)
+		}
+	}
+
+	// envelopes published while connected are handed on
+	env = newEnvelope(t, "topic", []byte("another entry"), time.Hour)
+	c, err = cid.Cast(env.Cid)
+	require.NoError(t, err)
+	require.NoError(t, relays[2].Publish(ctx, env.Topic, c, env.Payload))
+
+	for _, i := range []int{1, 0} {
+		select {
+		case received := <-delivered[i]:
+			require.Equal(t, env.Payload, received.Payload)
+			require.Equal(t, uint32(2-i), received.Hops)
+		case <-time.After(time.Second * 10):
+			require.FailNow(t, "envelope not delivered", "relay %d", i)
+		}
+	}
+
+	// every envelope is delivered once
+	time.Sleep(time.Millisecond * 200)
+	for i := range delivered {
+		require.Len(t, delivered[i], 0)
+	}
+	require.Equal(t, 2, relays[1].Store().Stats().Envelopes)
+}
+
+func TestRelayConnFilter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger, cleanup := testutil.Logger(t)
+	defer cleanup()
+
+	prevDebounce := storeforward.ExchangeDebounce
+	storeforward.ExchangeDebounce = time.Millisecond * 10
+	defer func() { storeforward.ExchangeDebounce = prevDebounce }()
+
+	mn := mocknet.New()
+	defer mn.Close()
+
+	// mocknet links are not proximity links, nothing is exchanged with the
+	// default filter
+	relays := make([]*storeforward.Relay, 2)
+	hosts := make([]host.Host, 2)
+	delivered := make(chan *protocoltypes.StoreForwardEnvelope, 8)
+	for i := range relays {
+		h, err := mn.GenPeer()
+		require.NoError(t, err)
+		hosts[i] = h
+
+		store, err := storeforward.NewStore(ctx, ds_sync.MutexWrap(ds.NewMapDatastore()), storeforward.Options{Logger: logger})
+		require.NoError(t, err)
+
+		relays[i], err = storeforward.NewRelay(h, store, func(env *protocoltypes.StoreForwardEnvelope) { delivered <- env })
+		require.NoError(t, err)
+		defer relays[i].Close()
+	}
+
+	env := newEnvelope(t, "topic", []byte("entry"), time.Hour)
+	c, err := cid.Cast(env.Cid)
+	require.NoError(t, err)
+	require.NoError(t, relays[0].Publish(ctx, env.Topic, c, env.Payload))
+
+	require.NoError(t, mn.LinkAll())
+	_, err = mn.ConnectPeers(hosts[0].ID(), hosts[1].ID())
+	require.NoError(t, err)
+
+	// streams opened by a peer ignoring the filter are reset
+	require.Eventually(t, func() bool {
+		protocols, err := hosts[1].Peerstore().SupportsProtocols(hosts[0].ID(), storeforward.ProtocolID)
+		return err == nil && len(protocols) > 0
+	}, time.Second*5, time.Millisecond*10)
+
+	s, err := hosts[1].NewStream(ctx, hosts[0].ID(), storeforward.ProtocolID)
+	if err == nil {
+		_, err = s.Read(make([]byte, 1))
+	}
+	require.Error(t, err)
+
+	time.Sleep(time.Millisecond * 200)
+	require.Len(t, delivered, 0)
+	require.Equal(t, 0, relays[1].Store().Stats().Envelopes)
+}
@@ -0,0 +1,542 @@
+package storeforward
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+var (
+	// ErrInvalidEnvelope is returned when an envelope is malformed or when its
+	// payload doesn't match its cid
+	ErrInvalidEnvelope = errors.New("invalid store-and-forward envelope")
+
+	// ErrEnvelopeExpired is returned when an envelope TTL is exceeded
+	ErrEnvelopeExpired = errors.New("store-and-forward envelope expired")
+
+	// ErrEnvelopeTooLarge is returned when an envelope doesn't fit in the quota
+	ErrEnvelopeTooLarge = errors.New("store-and-forward envelope too large")
+
+	// ErrInvalidSignature is returned when an envelope isn't signed by its
+	// origin
+	ErrInvalidSignature = errors.New("invalid store-and-forward envelope signature")
+
+	keyEnvelopes = ds.NewKey("envelopes")
+	keySeen      = ds.NewKey("seen")
+)
+
+// EnvelopeID returns the identifier of the envelope carrying the given entry,
+// it is used for duplicate suppression
+func EnvelopeID(topic []byte, c cid.Cid) []byte {
+	h := sha256.New()
+	h.Write(topic)
+	h.Write(c.Bytes())
+	return h.Sum(nil)
+}
+
+// SignEnvelope sets the origin of the envelope and signs it with the given key
+func SignEnvelope(env *protocoltypes.StoreForwardEnvelope, sk crypto.PrivKey) error {
+	origin, err := crypto.MarshalPublicKey(sk.GetPublic())
+	if err != nil {
+		return fmt.Errorf("unable to marshal origin: %w", err)
+	}
+
+	env.Origin = origin
+	env.Signature, err = sk.Sign(signedEnvelopeBytes(env))
+	if err != nil {
+		return fmt.Errorf("unable to sign envelope: %w", err)
+	}
+
+	return nil
+}
+
+// signedEnvelopeBytes returns the fields covered by the signature, the hops
+// are updated by each relay
+func signedEnvelopeBytes(env *protocoltypes.StoreForwardEnvelope) []byte {
+	data := binary.AppendUvarint(nil, uint64(len(env.Topic)))
+	data = append(data, env.Topic...)
+	data = binary.AppendUvarint(data, uint64(len(env.Cid)))
+	data = append(data, env.Cid...)
+	return binary.BigEndian.AppendUint64(data, uint64(env.ExpiresAt))
+}
+
+type storedEnvelope struct {
+	id        []byte
+	origin    string
+	size      int64
+	expiresAt time.Time
+}
+
+// Stats describes the content of a Store
+type Stats struct {
+	Envelopes int
+	Size      int64
+	Quota     int64
+	Seen      int
+	Origins   int
+}
+
+// Store keeps the envelopes carried by the relay, within the configured
+// quota. Identifiers of the envelopes already seen are remembered until they
+// expire so the same envelope is never stored twice.
+//
+// The expiration of the received envelopes is capped locally, the envelopes
+// are stored as received so their signature can be checked by the next
+// relays.
+type Store struct {
+	datastore ds.Datastore
+	opts      Options
+	logger    *zap.Logger
+
+	envelopes map[string]*storedEnvelope // by hex id
+	seen      map[string]time.Time       // expiration by hex id
+	origins   map[string]int64           // size by origin
+	size      int64
+	muStore   sync.RWMutex
+}
+
+// NewStore returns a Store persisting its envelopes in the given datastore,
+// envelopes already stored are loaded and the expired ones are dropped
+func NewStore(ctx context.Context, datastore ds.Datastore, opts Options) (*Store, error) {
+	opts.applyDefaults()
+
+	s := &Store{
+		datastore: datastore,
+		opts:      opts,
+		logger:    opts.Logger,
+		envelopes: make(map[string]*storedEnvelope),
+		seen:      make(map[string]time.Time),
+		origins:   make(map[string]int64),
+	}
+
+	if err := s.load(ctx); err != nil {
+		return nil, fmt.Errorf("unable to load envelopes: %w", err)
+	}
+
+	return s, nil
+}
+
+func (s *Store) load(ctx context.Context) error {
+	now := time.Now()
+
+	results, err := s.datastore.Query(ctx, dsq.Query{Prefix: keySeen.String()})
+	if err != nil {
+		return err
+	}
+
+	for res := range results.Next() {
+		if res.Error != nil {
+			results.Close()
+			return res.Error
+		}
+
+		if len(res.Value) != 8 {
+			continue
+		}
+
+		expiresAt := time.Unix(0, int64(binary.BigEndian.Uint64(res.Value)))
+		s.seen[ds.RawKey(res.Key).BaseNamespace()] = expiresAt
+	}
+	results.Close()
+
+	results, err = s.datastore.Query(ctx, dsq.Query{Prefix: keyEnvelopes.String()})
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+
+	for res := range results.Next() {
+		if res.Error != nil {
+			return res.Error
+		}
+
+		env := &protocoltypes.StoreForwardEnvelope{}
+		if err := proto.Unmarshal(res.Value, env); err != nil {
+			s.logger.Warn("unable to unmarshal stored envelope", zap.Error(err))
+			continue
+		}
+
+		id, err := hex.DecodeString(ds.RawKey(res.Key).BaseNamespace())
+		if err != nil {
+			continue
+		}
+
+		// the capped expiration is kept in the seen set
+		key := hex.EncodeToString(id)
+		expiresAt := time.Unix(0, env.ExpiresAt)
+		if seenExpiresAt, ok := s.seen[key]; !ok {
+			s.seen[key] = expiresAt
+		} else if seenExpiresAt.Before(expiresAt) {
+			expiresAt = seenExpiresAt
+		}
+
+		s.addEnvelope(key, &storedEnvelope{
+			id:        id,
+			origin:    string(env.Origin),
+			size:      int64(len(env.Payload)),
+			expiresAt: expiresAt,
+		})
+	}
+
+	return s.gc(ctx, now)
+}
+
+// Put stores an envelope, it returns false if the envelope has already been
+// seen or if it went through too many relays. The envelope must be signed by
+// its origin.
+func (s *Store) Put(ctx context.Context, env *protocoltypes.StoreForwardEnvelope) (bool, error) {
+	c, err := s.validate(env)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	expiresAt := time.Unix(0, env.ExpiresAt)
+	if !expiresAt.After(now) {
+		return false, ErrEnvelopeExpired
+	}
+
+	// don't trust remote clocks more than our own TTL
+	if maxExpiresAt := now.Add(s.opts.MaxTTL); expiresAt.After(maxExpiresAt) {
+		expiresAt = maxExpiresAt
+	}
+
+	id := EnvelopeID(env.Topic, c)
+	key := hex.EncodeToString(id)
+
+	s.muStore.Lock()
+	defer s.muStore.Unlock()
+
+	if _, ok := s.seen[key]; ok {
+		return false, nil
+	}
+
+	if env.Hops > s.opts.MaxHops {
+		s.logger.Debug("envelope dropped: too many hops", zap.Uint32("hops", env.Hops))
+		return false, nil
+	}
+
+	origin := string(env.Origin)
+	size := int64(len(env.Payload))
+	if err := s.evict(ctx, origin, size); err != nil {
+		return false, err
+	}
+
+	stored := &protocoltypes.StoreForwardEnvelope{
+		Topic:     env.Topic,
+		Cid:       env.Cid,
+		Payload:   env.Payload,
+		ExpiresAt: env.ExpiresAt,
+		Hops:      env.Hops,
+		Origin:    env.Origin,
+		Signature: env.Signature,
+	}
+
+	data, err := proto.Marshal(stored)
+	if err != nil {
+		return false, fmt.Errorf("unable to marshal envelope: %w", err)
+	}
+
+	if err := s.datastore.Put(ctx, keyEnvelopes.ChildString(key), data); err != nil {
+		return false, fmt.Errorf("unable to store envelope: %w", err)
+	}
+
+	if err := s.markSeen(ctx, key, expiresAt); err != nil {
+		return false, err
+	}
+
+	s.addEnvelope(key, &storedEnvelope{id: id, origin: origin, size: size, expiresAt: expiresAt})
+
+	return true, nil
+}
+
+// Seen tells if the envelope with the given id has already been received
+func (s *Store) Seen(id []byte) bool {
+	s.muStore.RLock()
+	defer s.muStore.RUnlock()
+
+	expiresAt, ok := s.seen[hex.EncodeToString(id)]
+	return ok && expiresAt.After(time.Now())
+}
+
+// Get returns the stored envelope with the given id, as it has been received
+func (s *Store) Get(ctx context.Context, id []byte) (*protocoltypes.StoreForwardEnvelope, error) {
+	key := hex.EncodeToString(id)
+
+	s.muStore.RLock()
+	stored, ok := s.envelopes[key]
+	s.muStore.RUnlock()
+
+	if !ok || !stored.expiresAt.After(time.Now()) {
+		return nil, ds.ErrNotFound
+	}
+
+	data, err := s.datastore.Get(ctx, keyEnvelopes.ChildString(key))
+	if err != nil {
+		return nil, err
+	}
+
+	env := &protocoltypes.StoreForwardEnvelope{}
+	if err := proto.Unmarshal(data, env); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal envelope: %w", err)
+	}
+
+	return env, nil
+}
+
+// IDs returns the ids of the stored envelopes which are not expired
+func (s *Store) IDs() [][]byte {
+	s.muStore.RLock()
+	defer s.muStore.RUnlock()
+
+	now := time.Now()
+	ids := make([][]byte, 0, len(s.envelopes))
+	for _, stored := range s.envelopes {
+		if stored.expiresAt.After(now) {
+			ids = append(ids, stored.id)
+		}
+	}
+
+	return ids
+}
+
+// Wanted filters the offered ids, keeping the ones which have not been seen
+func (s *Store) Wanted(offer [][]byte) [][]byte {
+	s.muStore.RLock()
+	defer s.muStore.RUnlock()
+
+	now := time.Now()
+	wanted := [][]byte{}
+	for _, id := range offer {
+		if len(id) != sha256.Size {
+			continue
+		}
+
+		if expiresAt, ok := s.seen[hex.EncodeToString(id)]; ok && expiresAt.After(now) {
+			continue
+		}
+
+		wanted = append(wanted, id)
+	}
+
+	return wanted
+}
+
+// GC drops the expired envelopes and forgets the expired seen ids
+func (s *Store) GC(ctx context.Context) error {
+	s.muStore.Lock()
+	defer s.muStore.Unlock()
+
+	return s.gc(ctx, time.Now())
+}
+
+// Stats returns the current usage of the store
+func (s *Store) Stats() Stats {
+	s.muStore.RLock()
+	defer s.muStore.RUnlock()
+
+	return Stats{
+		Envelopes: len(s.envelopes),
+		Size:      s.size,
+		Quota:     s.opts.Quota,
+		Seen:      len(s.seen),
+		Origins:   len(s.origins),
+	}
+}
+
+func (s *Store) validate(env *protocoltypes.StoreForwardEnvelope) (cid.Cid, error) {
+	if env == nil || len(env.Topic) == 0 || len(env.Payload) == 0 {
+		return cid.Undef, ErrInvalidEnvelope
+	}
+
+	if len(env.Payload) > s.opts.MaxEnvelopeSize {
+		return cid.Undef, ErrEnvelopeTooLarge
+	}
+
+	c, err := cid.Cast(env.Cid)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("%w: %s", ErrInvalidEnvelope, err.Error())
+	}
+
+	// make sure the relay didn't tamper with the payload
+	sum, err := c.Prefix().Sum(env.Payload)
+	if err != nil || !sum.Equals(c) {
+		return cid.Undef, fmt.Errorf("%w: payload doesn't match cid", ErrInvalidEnvelope)
+	}
+
+	origin, err := crypto.UnmarshalPublicKey(env.Origin)
+	if err != nil {
+		return cid.Undef, fmt.Errorf("%w: %s", ErrInvalidSignature, err.Error())
+	}
+
+	if ok, err := origin.Verify(signedEnvelopeBytes(env), env.Signature); err != nil || !ok {
+		return cid.Undef, ErrInvalidSignature
+	}
+
+	return c, nil
+}
+
+// evict must be called with the store locked, it makes room for an envelope
+// of the given size and origin. The origin can't use more than its share of
+// the quota, then the envelopes of the origins using the most space are
+// dropped, the ones expiring first first.
+func (s *Store) evict(ctx context.Context, origin string, size int64) error {
+	if size > s.opts.Quota || size > s.opts.OriginQuota {
+		return ErrEnvelopeTooLarge
+	}
+
+	if s.size+size > s.opts.Quota || s.origins[origin]+size > s.opts.OriginQuota {
+		if err := s.gc(ctx, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	for s.origins[origin]+size > s.opts.OriginQuota {
+		if err := s.evictFirstExpiring(ctx, origin); err != nil {
+			return err
+		}
+	}
+
+	for s.size+size > s.opts.Quota {
+		if err := s.evictFirstExpiring(ctx, s.largestOrigin()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// evictFirstExpiring must be called with the store locked, it drops the
+// envelope of the given origin expiring first
+func (s *Store) evictFirstExpiring(ctx context.Context, origin string) error {
+	var first string
+	for key, stored := range s.envelopes {
+		if stored.origin != origin {
+			continue
+		}
+
+		if first == "" || stored.expiresAt.Before(s.envelopes[first].expiresAt) {
+			first = key
+		}
+	}
+
+	if first == "" {
+		return ErrEnvelopeTooLarge
+	}
+
+	s.logger.Debug("envelope evicted: quota exceeded")
+	return s.deleteEnvelope(ctx, first)
+}
+
+// largestOrigin must be called with the store locked
+func (s *Store) largestOrigin() string {
+	var largest string
+	for origin, size := range s.origins {
+		if size > s.origins[largest] {
+			largest = origin
+		}
+	}
+
+	return largest
+}
+
+// gc must be called with the store locked
+func (s *Store) gc(ctx context.Context, now time.Time) error {
+	for key, stored := range s.envelopes {
+		if stored.expiresAt.After(now) {
+			continue
+		}
+
+		if err := s.deleteEnvelope(ctx, key); err != nil {
+			return err
+		}
+	}
+
+	for key, expiresAt := range s.seen {
+		if expiresAt.After(now) {
+			continue
+		}
+
+		if err := s.datastore.Delete(ctx, keySeen.ChildString(key)); err != nil {
+			return fmt.Errorf("unable to delete seen envelope: %w", err)
+		}
+		delete(s.seen, key)
+	}
+
+	// forget the seen ids expiring first when there are too many of them
+	if len(s.seen) > s.opts.MaxSeen {
+		keys := make([]string, 0, len(s.seen))
+		for key := range s.seen {
+			if _, ok := s.envelopes[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return s.seen[keys[i]].Before(s.seen[keys[j]]) })
+
+		for _, key := range keys[:min(len(keys), len(s.seen)-s.opts.MaxSeen)] {
+			if err := s.datastore.Delete(ctx, keySeen.ChildString(key)); err != nil {
+				return fmt.Errorf("unable to delete seen envelope: %w", err)
+			}
+			delete(s.seen, key)
+		}
+	}
+
+	return nil
+}
+
+// deleteEnvelope must be called with the store locked, the envelope id stays
+// in the seen set
+func (s *Store) deleteEnvelope(ctx context.Context, key string) error {
+	stored, ok := s.envelopes[key]
+	if !ok {
+		return nil
+	}
+
+	if err := s.datastore.Delete(ctx, keyEnvelopes.ChildString(key)); err != nil {
+		return fmt.Errorf("unable to delete envelope: %w", err)
+	}
+
+	delete(s.envelopes, key)
+	s.size -= stored.size
+
+	if s.origins[stored.origin] -= stored.size; s.origins[stored.origin] <= 0 {
+		delete(s.origins, stored.origin)
+	}
+
+	return nil
+}
+
+// addEnvelope must be called with the store locked
+func (s *Store) addEnvelope(key string, stored *storedEnvelope) {
+	s.envelopes[key] = stored
+	s.size += stored.size
+	s.origins[stored.origin] += stored.size
+}
+
+// markSeen must be called with the store locked
+func (s *Store) markSeen(ctx context.Context, key string, expiresAt time.Time) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(expiresAt.UnixNano()))
+
+	if err := s.datastore.Put(ctx, keySeen.ChildString(key), value); err != nil {
+		return fmt.Errorf("unable to mark envelope as seen: %w", err)
+	}
+
+	s.seen[key] = expiresAt
+	return nil
+}
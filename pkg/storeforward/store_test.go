@@ -0,0 +1,233 @@
+package storeforward_test
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	ds_sync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/storeforward"
+)
+
+var testOrigin = newOrigin()
+
+func newOrigin() crypto.PrivKey {
+	sk, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	return sk
+}
+
+func newEnvelope(t *testing.T, topic string, payload []byte, ttl time.Duration) *protocoltypes.StoreForwardEnvelope {
+	t.Helper()
+
+	return newEnvelopeFrom(t, testOrigin, topic, payload, ttl)
+}
+
+func newEnvelopeFrom(t *testing.T, origin crypto.PrivKey, topic string, payload []byte, ttl time.Duration) *protocoltypes.StoreForwardEnvelope {
+	t.Helper()
+
+	c, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: multihash.SHA2_256}.Sum(payload)
+	require.NoError(t, err)
+
+	env := &protocoltypes.StoreForwardEnvelope{
+		Topic:     []byte(topic),
+		Cid:       c.Bytes(),
+		Payload:   payload,
+		ExpiresAt: time.Now().Add(ttl).UnixNano(),
+	}
+	require.NoError(t, storeforward.SignEnvelope(env, origin))
+
+	return env
+}
+
+func TestStoreDuplicateSuppression(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := storeforward.NewStore(ctx, ds_sync.MutexWrap(ds.NewMapDatastore()), storeforward.Options{})
+	require.NoError(t, err)
+
+	env := newEnvelope(t, "topic", []byte("entry"), time.Hour)
+	added, err := store.Put(ctx, env)
+	require.NoError(t, err)
+	require.True(t, added)
+
+	added, err = store.Put(ctx, env)
+	require.NoError(t, err)
+	require.False(t, added)
+
+	c, err := cid.Cast(env.Cid)
+	require.NoError(t, err)
+	id := storeforward.EnvelopeID([]byte("topic"), c)
+	require.True(t, store.Seen(id))
+	require.Empty(t, store.Wanted([][]byte{id}))
+	require.Len(t, store.IDs(), 1)
+
+	stored, err := store.Get(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, env.Payload, stored.Payload)
+
+	// same entry on another topic is another envelope
+	other := newEnvelope(t, "other", []byte("entry"), time.Hour)
+	require.Len(t, store.Wanted([][]byte{storeforward.EnvelopeID([]byte("other"), c)}), 1)
+	added, err = store.Put(ctx, other)
+	require.NoError(t, err)
+	require.True(t, added)
+}
+
+func TestStoreValidation(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := storeforward.NewStore(ctx, ds_sync.MutexWrap(ds.NewMapDatastore()), storeforward.Options{
+		MaxEnvelopeSize: 16,
+		MaxHops:         2,
+	})
+	require.NoError(t, err)
+
+	// tampered payload
+	env := newEnvelope(t, "topic", []byte("entry"), time.Hour)
+	env.Payload = []byte("tampered")
+	_, err = store.Put(ctx, env)
+	require.ErrorIs(t, err, storeforward.ErrInvalidEnvelope)
+
+	// tampered expiration
+	env = newEnvelope(t, "topic", []byte("entry"), time.Hour)
+	env.ExpiresAt += int64(time.Hour)
+	_, err = store.Put(ctx, env)
+	require.ErrorIs(t, err, storeforward.ErrInvalidSignature)
+
+	// anonymous envelope
+	env = newEnvelope(t, "topic", []byte("entry"), time.Hour)
+	env.Origin, env.Signature = nil, nil
+	_, err = store.Put(ctx, env)
+	require.ErrorIs(t, err, storeforward.ErrInvalidSignature)
+
+	// hops are not signed, they are updated by each relay
+	env = newEnvelope(t, "topic", []byte("entry"), time.Hour)
+	env.Hops = 1
+	added, err := store.Put(ctx, env)
+	require.NoError(t, err)
+	require.True(t, added)
+
+	_, err = store.Put(ctx, newEnvelope(t, "topic", []byte("expired"), -time.Second))
+	require.ErrorIs(t, err, storeforward.ErrEnvelopeExpired)
+
+	_, err = store.Put(ctx, newEnvelope(t, "topic", make([]byte, 17), time.Hour))
+	require.ErrorIs(t, err, storeforward.ErrEnvelopeTooLarge)
+
+	env = newEnvelope(t, "topic", []byte("far away"), time.Hour)
+	env.Hops = 3
+	added, err = store.Put(ctx, env)
+	require.NoError(t, err)
+	require.False(t, added)
+}
+
+func TestStoreQuotaAndTTL(t *testing.T) {
+	ctx := context.Background()
+	datastore := ds_sync.MutexWrap(ds.NewMapDatastore())
+
+	store, err := storeforward.NewStore(ctx, datastore, storeforward.Options{
+		Quota:  25,
+		MaxTTL: time.Hour,
+	})
+	require.NoError(t, err)
+
+	// envelopes expiring first are evicted first
+	for i, ttl := range []time.Duration{time.Minute * 30, time.Minute * 10, time.Minute * 20} {
+		added, err := store.Put(ctx, newEnvelope(t, "topic", []byte(fmt.Sprintf("envelope-%d", i)), ttl))
+		require.NoError(t, err)
+		require.True(t, added)
+	}
+
+	stats := store.Stats()
+	require.Equal(t, 2, stats.Envelopes)
+	require.Equal(t, int64(20), stats.Size)
+	require.Equal(t, 3, stats.Seen)
+
+	evicted := newEnvelope(t, "topic", []byte("envelope-1"), time.Minute*10)
+	added, err := store.Put(ctx, evicted)
+	require.NoError(t, err)
+	require.False(t, added, "evicted envelopes should still be suppressed")
+
+	// envelopes are persisted
+	reloaded, err := storeforward.NewStore(ctx, datastore, storeforward.Options{Quota: 25})
+	require.NoError(t, err)
+	require.Equal(t, store.Stats(), reloaded.Stats())
+
+	// the TTL of received envelopes is capped, they are stored unchanged
+	datastore = ds_sync.MutexWrap(ds.NewMapDatastore())
+	store, err = storeforward.NewStore(ctx, datastore, storeforward.Options{MaxTTL: time.Millisecond * 100})
+	require.NoError(t, err)
+
+	env := newEnvelope(t, "capped", []byte("capped"), time.Hour*24)
+	_, err = store.Put(ctx, env)
+	require.NoError(t, err)
+
+	c, err := cid.Cast(env.Cid)
+	require.NoError(t, err)
+	stored, err := store.Get(ctx, storeforward.EnvelopeID([]byte("capped"), c))
+	require.NoError(t, err)
+	require.Equal(t, env.ExpiresAt, stored.ExpiresAt)
+	require.Equal(t, env.Signature, stored.Signature)
+
+	time.Sleep(time.Millisecond * 200)
+
+	reloaded, err = storeforward.NewStore(ctx, datastore, storeforward.Options{})
+	require.NoError(t, err)
+	require.Equal(t, 0, reloaded.Stats().Envelopes)
+
+	require.NoError(t, store.GC(ctx))
+	require.Equal(t, 0, store.Stats().Envelopes)
+}
+
+func TestStoreOriginQuota(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := storeforward.NewStore(ctx, ds_sync.MutexWrap(ds.NewMapDatastore()), storeforward.Options{
+		Quota:       28,
+		OriginQuota: 20,
+	})
+	require.NoError(t, err)
+
+	honest, flooder := newOrigin(), newOrigin()
+
+	added, err := store.Put(ctx, newEnvelopeFrom(t, honest, "topic", []byte("honest-0"), time.Minute))
+	require.NoError(t, err)
+	require.True(t, added)
+
+	// an origin can't take more than its share of the quota
+	for i := 0; i < 10; i++ {
+		added, err := store.Put(ctx, newEnvelopeFrom(t, flooder, "topic", []byte(fmt.Sprintf("flood-%d", i)), time.Hour))
+		require.NoError(t, err)
+		require.True(t, added)
+	}
+
+	stats := store.Stats()
+	require.Equal(t, 2, stats.Origins)
+	require.Equal(t, int64(8+14), stats.Size)
+
+	// the envelopes of the origin using the most space are evicted first
+	added, err = store.Put(ctx, newEnvelopeFrom(t, honest, "topic", []byte("honest-1"), time.Hour))
+	require.NoError(t, err)
+	require.True(t, added)
+
+	stats = store.Stats()
+	require.Equal(t, 2, stats.Origins)
+	require.Equal(t, int64(8*2+7), stats.Size)
+
+	c, err := cid.V1Builder{Codec: cid.DagCBOR, MhType: multihash.SHA2_256}.Sum([]byte("honest-0"))
+	require.NoError(t, err)
+	_, err = store.Get(ctx, storeforward.EnvelopeID([]byte("topic"), c))
+	require.NoError(t, err)
+}
@@ -0,0 +1,137 @@
+// Package storeforward implements a multi-hop store-and-forward relay.
+//
+// Relays carry sealed envelopes, the entries of groups they don't
+// necessarily belong to, and hand them on to the peers they meet. It allows
+// entries to reach the members of a group through an offline mesh, without
+// any direct link between them. Envelopes are identified by a keyed hash of
+// the address of their store and the cid of their entry, relays can't read
+// them nor tell which group they belong to.
+//
+// Envelopes are signed by the relay publishing them, each origin gets a share
+// of the quota so a single peer can't evict the envelopes of the others.
+// Envelopes are only exchanged over proximity links by default.
+//
+// When two relays meet they exchange the ids of the envelopes they carry,
+// then send each other the envelopes they have never seen.
+package storeforward
+
+import (
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"go.uber.org/zap"
+)
+
+// ProtocolID is the libp2p protocol used to exchange envelopes
+const ProtocolID = "/wesh/storeforward/1.0.0"
+
+const (
+	DefaultQuota           = 64 * 1024 * 1024
+	DefaultOriginQuota     = 8 * 1024 * 1024
+	DefaultMaxEnvelopeSize = 1024 * 1024
+	DefaultTTL             = time.Hour * 72
+	DefaultMaxTTL          = time.Hour * 24 * 7
+	DefaultMaxHops         = 8
+	DefaultMaxSeen         = 100_000
+)
+
+// ProximityProtocolCodes are the multiaddr protocols of the proximity
+// transports: ble, mc, nearby and sim
+var ProximityProtocolCodes = []int{0x0042, 0x0043, 0x0044, 0x0045}
+
+// ConnFilter tells if envelopes can be exchanged over a connection
+type ConnFilter func(c network.Conn) bool
+
+// TransportFilter allows the connections whose remote address uses one of
+// the given multiaddr protocols
+func TransportFilter(codes ...int) ConnFilter {
+	return func(c network.Conn) bool {
+		for _, code := range codes {
+			if _, err := c.RemoteMultiaddr().ValueForProtocol(code); err == nil {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Options configures a relay and its store
+type Options struct {
+	Logger *zap.Logger
+
+	// Quota is the maximum size of the envelopes carried, in bytes. Envelopes
+	// expiring first are evicted when it is exceeded.
+	Quota int64
+
+	// OriginQuota is the maximum size of the envelopes carried for a single
+	// origin, in bytes. The envelopes of the origins using the most space are
+	// evicted first.
+	OriginQuota int64
+
+	// MaxEnvelopeSize is the maximum size of an envelope payload, in bytes.
+	MaxEnvelopeSize int
+
+	// TTL is the lifetime of the envelopes published by this node.
+	TTL time.Duration
+
+	// MaxTTL caps the lifetime of the envelopes received from other relays.
+	MaxTTL time.Duration
+
+	// MaxHops is the number of relays an envelope can go through.
+	MaxHops uint32
+
+	// MaxSeen is the number of envelope ids remembered for duplicate
+	// suppression.
+	MaxSeen int
+
+	// ConnFilter selects the connections envelopes are exchanged over, only
+	// the proximity transports are allowed by default.
+	ConnFilter ConnFilter
+}
+
+func (o *Options) applyDefaults() {
+	if o.Logger == nil {
+		o.Logger = zap.NewNop()
+	}
+
+	if o.Quota <= 0 {
+		o.Quota = DefaultQuota
+	}
+
+	if o.OriginQuota <= 0 {
+		o.OriginQuota = DefaultOriginQuota
+	}
+
+	if o.OriginQuota > o.Quota {
+		o.OriginQuota = o.Quota
+	}
+
+	if o.MaxEnvelopeSize <= 0 {
+		o.MaxEnvelopeSize = DefaultMaxEnvelopeSize
+	}
+
+	if o.TTL <= 0 {
+		o.TTL = DefaultTTL
+	}
+
+	if o.MaxTTL <= 0 {
+		o.MaxTTL = DefaultMaxTTL
+	}
+
+	if o.TTL > o.MaxTTL {
+		o.TTL = o.MaxTTL
+	}
+
+	if o.MaxHops == 0 {
+		o.MaxHops = DefaultMaxHops
+	}
+
+	if o.MaxSeen <= 0 {
+		o.MaxSeen = DefaultMaxSeen
+	}
+
+	if o.ConnFilter == nil {
+		o.ConnFilter = TransportFilter(ProximityProtocolCodes...)
+	}
+}
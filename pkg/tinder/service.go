@@ -25,6 +25,9 @@ type Service struct {
 	// subscribe
 	peersCache *peersCache
 	process    uint32
+
+	// advertiseTTL is the ttl requested to the drivers, 0 lets them choose
+	advertiseTTL atomic.Int64
 }
 
 func NewService(h host.Host, logger *zap.Logger, drivers ...IDriver) (*Service, error) {
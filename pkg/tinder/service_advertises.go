@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/libp2p/go-libp2p/core/discovery"
 	"go.uber.org/zap"
 
 	"berty.tech/weshnet/v2/pkg/logutil"
@@ -12,6 +13,18 @@ import (
 
 const defaultTTL = time.Hour
 
+// SetAdvertiseTTL sets the ttl requested when advertising, a longer ttl
+// reduces the advertise frequency. It is applied from the next renewal of each
+// advertisement, 0 restores the ttl chosen by the drivers.
+func (s *Service) SetAdvertiseTTL(ttl time.Duration) {
+	s.advertiseTTL.Store(int64(ttl))
+}
+
+// AdvertiseTTL returns the ttl requested when advertising
+func (s *Service) AdvertiseTTL() time.Duration {
+	return time.Duration(s.advertiseTTL.Load())
+}
+
 // StartAdvertises topic on each of service drivers
 func (s *Service) StartAdvertises(ctx context.Context, topic string, opts ...Option) error {
 	if len(s.drivers) == 0 {
@@ -44,8 +57,13 @@ func (s *Service) advertise(ctx context.Context, d IDriver, topic string) error
 	for {
 		currentAddrs := s.networkNotify.GetLastUpdatedAddrs(ctx)
 
+		var dopts []discovery.Option
+		if ttl := s.AdvertiseTTL(); ttl > 0 {
+			dopts = append(dopts, discovery.TTL(ttl))
+		}
+
 		now := time.Now()
-		ttl, err := d.Advertise(ctx, topic)
+		ttl, err := d.Advertise(ctx, topic, dopts...)
 		took := time.Since(now)
 
 		var deadline time.Duration
@@ -0,0 +1,231 @@
+package treekem
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+// Epoch is the state of a tree after a commit, as known by one of its devices
+type Epoch struct {
+	// Tree is the public state of the tree
+	Tree *Tree
+
+	// Secret is shared by the devices of the tree, the message keys of the
+	// epoch are derived from it
+	Secret []byte
+
+	keys map[[KeySize]byte]NodeKey
+}
+
+// Keys returns the key material of the device for the epoch, to be stored
+// until the next commit is processed, the key of a leaf derived from the
+// device key is omitted
+func (e *Epoch) Keys() *protocoltypes.TreeKEMEpochKeys {
+	epochKeys := &protocoltypes.TreeKEMEpochKeys{EpochSecret: e.Secret}
+
+	for _, key := range e.keys {
+		if k, ok := key.(*nodeKey); ok {
+			epochKeys.NodeKeys = append(epochKeys.NodeKeys, &protocoltypes.TreeKEMEpochKeys_NodeKey{
+				PublicKey:  k.public[:],
+				PrivateKey: k.private[:],
+			})
+		}
+	}
+
+	return epochKeys
+}
+
+// EpochID returns the identifier of the epoch created by a serialized commit
+func EpochID(commit []byte) []byte {
+	id := sha256.Sum256(commit)
+	return id[:]
+}
+
+// Commit creates a commit adding and removing devices from the tree and
+// replacing the keys of the path of the committer, which adds itself to the
+// tree when listed in added. The path secret of each ancestor of the
+// committer is encrypted for the resolution of the sibling of its child.
+func Commit(tree *Tree, parentEpochID []byte, committer []byte, added [][]byte, removed [][]byte) ([]byte, *Epoch, error) {
+	next, leaf, err := tree.applyProposals(committer, added, removed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pathSecret := make([]byte, KeySize)
+	if _, err := rand.Read(pathSecret); err != nil {
+		return nil, nil, errcode.ErrCode_ErrCryptoRandomGeneration.Wrap(err)
+	}
+
+	ancestors := directPath(leaf, next.leafCount())
+	path := make([]*protocoltypes.TreeKEMPathNode, len(ancestors)+1)
+	keys := map[[KeySize]byte]NodeKey{}
+
+	child := leaf
+	for i := range path {
+		if i > 0 {
+			if pathSecret, err = deriveSecret(pathSecret, pathSecretInfo); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		key, err := nodeKeyFromPathSecret(pathSecret)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		keys[key.public] = key
+		path[i] = &protocoltypes.TreeKEMPathNode{PublicKey: key.public[:]}
+
+		if i == 0 {
+			continue
+		}
+
+		for _, x := range next.resolution(sibling(child)) {
+			encryptedPathSecret, err := encryptPathSecret(pathSecret, next.nodes[x].PublicKey)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			path[i].EncryptedPathSecrets = append(path[i].EncryptedPathSecrets, encryptedPathSecret)
+		}
+
+		child = ancestors[i-1]
+	}
+
+	if err := next.applyPath(leaf, path); err != nil {
+		return nil, nil, err
+	}
+
+	commit, err := proto.Marshal(&protocoltypes.TreeKEMCommit{
+		ParentEpochId:     parentEpochID,
+		CommitterDevicePk: committer,
+		AddedDevicePks:    added,
+		RemovedDevicePks:  removed,
+		Path:              path,
+	})
+	if err != nil {
+		return nil, nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	epochSecret, err := deriveSecret(pathSecret, epochSecretInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return commit, &Epoch{Tree: next, Secret: epochSecret, keys: keys}, nil
+}
+
+// Process applies a commit created by another device, the path secret of the
+// common ancestor of the device and of the committer is decrypted using one
+// of the given keys, the secrets of the nodes above are then derived from it
+func Process(tree *Tree, commit *protocoltypes.TreeKEMCommit, devicePK []byte, keys []NodeKey) (*Epoch, error) {
+	if bytes.Equal(commit.CommitterDevicePk, devicePK) {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("the commit has been created by the device"))
+	}
+
+	next, committerLeaf, err := tree.applyProposals(commit.CommitterDevicePk, commit.AddedDevicePks, commit.RemovedDevicePks)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, ok := next.leafIndex(devicePK)
+	if !ok {
+		return nil, errcode.ErrCode_ErrNotFound.Wrap(fmt.Errorf("the device is not part of the tree"))
+	}
+
+	// the keys of the subtrees of the path siblings are left untouched
+	if err := next.applyPath(committerLeaf, commit.Path); err != nil {
+		return nil, err
+	}
+
+	known := make(map[[KeySize]byte]NodeKey, len(keys))
+	for _, key := range keys {
+		known[*key.PublicKey()] = key
+	}
+
+	var (
+		ancestors  = directPath(committerLeaf, next.leafCount())
+		pathSecret []byte
+		first      int
+	)
+
+	child := committerLeaf
+	for i, ancestor := range ancestors {
+		if !isInSubtree(leaf, sibling(child)) {
+			child = ancestor
+			continue
+		}
+
+		for j, x := range next.resolution(sibling(child)) {
+			var publicKey [KeySize]byte
+			copy(publicKey[:], next.nodes[x].PublicKey)
+
+			key, ok := known[publicKey]
+			if !ok {
+				continue
+			}
+
+			if pathSecret, err = decryptPathSecret(commit.Path[i+1].EncryptedPathSecrets[j], key); err != nil {
+				return nil, err
+			}
+
+			break
+		}
+
+		first = i
+		break
+	}
+
+	if pathSecret == nil {
+		return nil, errcode.ErrCode_ErrCryptoDecrypt.Wrap(fmt.Errorf("none of the keys of the device can decrypt the commit"))
+	}
+
+	epochKeys := map[[KeySize]byte]NodeKey{}
+	for i := first; i < len(ancestors); i++ {
+		if i > first {
+			if pathSecret, err = deriveSecret(pathSecret, pathSecretInfo); err != nil {
+				return nil, err
+			}
+		}
+
+		key, err := nodeKeyFromPathSecret(pathSecret)
+		if err != nil {
+			return nil, err
+		}
+
+		if !bytes.Equal(key.public[:], commit.Path[i+1].PublicKey) {
+			return nil, errcode.ErrCode_ErrCryptoDecrypt.Wrap(fmt.Errorf("the path secret doesn't match the public key of its node"))
+		}
+
+		epochKeys[key.public] = key
+	}
+
+	// the keys of the nodes of the device path which are still in use are
+	// kept for the next commits
+	for _, x := range append([]uint32{leaf}, directPath(leaf, next.leafCount())...) {
+		if next.nodes[x] == nil {
+			continue
+		}
+
+		var publicKey [KeySize]byte
+		copy(publicKey[:], next.nodes[x].PublicKey)
+
+		if key, ok := known[publicKey]; ok {
+			epochKeys[publicKey] = key
+		}
+	}
+
+	epochSecret, err := deriveSecret(pathSecret, epochSecretInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Epoch{Tree: next, Secret: epochSecret, keys: epochKeys}, nil
+}
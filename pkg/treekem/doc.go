@@ -0,0 +1,6 @@
+// Package treekem implements a ratchet tree allowing the devices of a group to
+// agree on a shared epoch secret. Each commit adds or removes devices and
+// replaces the keys of the path of its committer, the path secrets being
+// encrypted for a number of nodes growing with the logarithm of the number of
+// devices.
+package treekem
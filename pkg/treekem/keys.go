@@ -0,0 +1,179 @@
+package treekem
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/nacl/box"
+
+	"berty.tech/weshnet/v2/pkg/cryptoutil"
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+// KeySize is the size of the node keys and of the secrets
+const KeySize = cryptoutil.KeySize
+
+const (
+	pathSecretInfo  = "weshnet treekem path"
+	nodeKeyInfo     = "weshnet treekem node"
+	epochSecretInfo = "weshnet treekem epoch"
+)
+
+// NodeKey is the private key of a node of a tree known by a device
+type NodeKey interface {
+	// PublicKey returns the X25519 public key of the node
+	PublicKey() *[KeySize]byte
+
+	// BoxKey computes the nacl box shared key of the node and an X25519
+	// public key
+	BoxKey(peerPublicKey *[KeySize]byte) (*[KeySize]byte, error)
+}
+
+// nodeKey is a node key derived from a path secret
+type nodeKey struct {
+	public, private [KeySize]byte
+}
+
+func (k *nodeKey) PublicKey() *[KeySize]byte {
+	return &k.public
+}
+
+func (k *nodeKey) BoxKey(peerPublicKey *[KeySize]byte) (*[KeySize]byte, error) {
+	var sharedKey [KeySize]byte
+	box.Precompute(&sharedKey, peerPublicKey, &k.private)
+
+	return &sharedKey, nil
+}
+
+// deviceKey is the key of the leaf of a device added by another one
+type deviceKey struct {
+	public [KeySize]byte
+	device crypto.PrivKey
+}
+
+// NewDeviceKey returns the key of the leaf of a device added by another one,
+// derived from the device private key
+func NewDeviceKey(device crypto.PrivKey) (NodeKey, error) {
+	public, err := cryptoutil.EdwardsToMontgomeryPub(device.GetPublic())
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
+	}
+
+	return &deviceKey{public: *public, device: device}, nil
+}
+
+func (k *deviceKey) PublicKey() *[KeySize]byte {
+	return &k.public
+}
+
+func (k *deviceKey) BoxKey(peerPublicKey *[KeySize]byte) (*[KeySize]byte, error) {
+	var sharedKey [KeySize]byte
+	if err := cryptoutil.PrecomputeBoxKey(&sharedKey, k.device, peerPublicKey); err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
+	}
+
+	return &sharedKey, nil
+}
+
+// NodeKeysFromEpochKeys returns the node keys stored in the key material of
+// an epoch
+func NodeKeysFromEpochKeys(epochKeys *protocoltypes.TreeKEMEpochKeys) ([]NodeKey, error) {
+	keys := make([]NodeKey, len(epochKeys.GetNodeKeys()))
+	for i, k := range epochKeys.GetNodeKeys() {
+		if len(k.PublicKey) != KeySize || len(k.PrivateKey) != KeySize {
+			return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("invalid node key size"))
+		}
+
+		key := &nodeKey{}
+		copy(key.public[:], k.PublicKey)
+		copy(key.private[:], k.PrivateKey)
+		keys[i] = key
+	}
+
+	return keys, nil
+}
+
+// deriveSecret derives a secret from another one for the given purpose
+func deriveSecret(secret []byte, info string) ([]byte, error) {
+	derived := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte(info)), derived); err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(err)
+	}
+
+	return derived, nil
+}
+
+// nodeKeyFromPathSecret derives the key of a node from its path secret
+func nodeKeyFromPathSecret(pathSecret []byte) (*nodeKey, error) {
+	seed, err := deriveSecret(pathSecret, nodeKeyInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &nodeKey{}
+	copy(key.private[:], seed)
+
+	public, err := curve25519.X25519(key.private[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(err)
+	}
+
+	copy(key.public[:], public)
+
+	return key, nil
+}
+
+// encryptPathSecret encrypts a path secret for a node using an ephemeral key,
+// which is prepended to the ciphertext
+func encryptPathSecret(pathSecret []byte, recipientPublicKey []byte) ([]byte, error) {
+	recipient, err := cryptoutil.KeySliceToArray(recipientPublicKey)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(err)
+	}
+
+	ephemeralPublicKey, ephemeralPrivateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(err)
+	}
+
+	return box.Seal(ephemeralPublicKey[:], pathSecret, pathSecretNonce(ephemeralPublicKey, recipient), recipient, ephemeralPrivateKey), nil
+}
+
+// decryptPathSecret decrypts a path secret encrypted for a node
+func decryptPathSecret(encryptedPathSecret []byte, key NodeKey) ([]byte, error) {
+	if len(encryptedPathSecret) < KeySize+box.Overhead {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("encrypted path secret is too short"))
+	}
+
+	ephemeralPublicKey, err := cryptoutil.KeySliceToArray(encryptedPathSecret[:KeySize])
+	if err != nil {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(err)
+	}
+
+	sharedKey, err := key.BoxKey(ephemeralPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	pathSecret, ok := box.OpenAfterPrecomputation(nil, encryptedPathSecret[KeySize:], pathSecretNonce(ephemeralPublicKey, key.PublicKey()), sharedKey)
+	if !ok {
+		return nil, errcode.ErrCode_ErrCryptoDecrypt.Wrap(fmt.Errorf("unable to decrypt path secret"))
+	}
+
+	return pathSecret, nil
+}
+
+// pathSecretNonce returns the nonce of a path secret, the ephemeral key being
+// used once it is derived from the keys
+func pathSecretNonce(ephemeralPublicKey, recipientPublicKey *[KeySize]byte) *[cryptoutil.NonceSize]byte {
+	var nonce [cryptoutil.NonceSize]byte
+	copy(nonce[:], cryptoutil.ConcatAndHashSha256(ephemeralPublicKey[:], recipientPublicKey[:])[:])
+
+	return &nonce
+}
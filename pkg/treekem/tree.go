@@ -0,0 +1,227 @@
+package treekem
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+
+	"berty.tech/weshnet/v2/pkg/cryptoutil"
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+// Tree is the public state of a ratchet tree, its leaves are the devices of a
+// group, a nil node is blank
+type Tree struct {
+	nodes   []*protocoltypes.TreeKEMNode
+	removed map[string]struct{}
+}
+
+// NewTree returns an empty tree, the first commit of a group adds its
+// committer to it
+func NewTree() *Tree {
+	return &Tree{removed: map[string]struct{}{}}
+}
+
+// Clone returns a copy of the tree, the nodes being replaced and never
+// modified they are shared with the copy
+func (t *Tree) Clone() *Tree {
+	c := &Tree{
+		nodes:   make([]*protocoltypes.TreeKEMNode, len(t.nodes)),
+		removed: make(map[string]struct{}, len(t.removed)),
+	}
+
+	copy(c.nodes, t.nodes)
+	for device := range t.removed {
+		c.removed[device] = struct{}{}
+	}
+
+	return c
+}
+
+// Contains returns whether a device is a leaf of the tree
+func (t *Tree) Contains(devicePK []byte) bool {
+	_, ok := t.leafIndex(devicePK)
+	return ok
+}
+
+// IsRemoved returns whether a device has been removed from the tree
+func (t *Tree) IsRemoved(devicePK []byte) bool {
+	_, ok := t.removed[string(devicePK)]
+	return ok
+}
+
+// Devices returns the devices of the leaves of the tree
+func (t *Tree) Devices() [][]byte {
+	var devices [][]byte
+
+	for i := 0; i < len(t.nodes); i += 2 {
+		if t.nodes[i] != nil {
+			devices = append(devices, t.nodes[i].DevicePk)
+		}
+	}
+
+	return devices
+}
+
+// Apply returns the tree resulting from a commit created by another device,
+// the commit is checked against the tree
+func (t *Tree) Apply(commit *protocoltypes.TreeKEMCommit) (*Tree, error) {
+	next, leaf, err := t.applyProposals(commit.CommitterDevicePk, commit.AddedDevicePks, commit.RemovedDevicePks)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := next.applyPath(leaf, commit.Path); err != nil {
+		return nil, err
+	}
+
+	return next, nil
+}
+
+func (t *Tree) leafCount() uint32 {
+	return uint32((len(t.nodes) + 1) / 2)
+}
+
+func (t *Tree) leafIndex(devicePK []byte) (uint32, bool) {
+	for i := 0; i < len(t.nodes); i += 2 {
+		if t.nodes[i] != nil && bytes.Equal(t.nodes[i].DevicePk, devicePK) {
+			return uint32(i), true
+		}
+	}
+
+	return 0, false
+}
+
+// resolution returns the non blank nodes covering the subtree of a node
+func (t *Tree) resolution(x uint32) []uint32 {
+	if t.nodes[x] != nil {
+		return []uint32{x}
+	}
+
+	if level(x) == 0 {
+		return nil
+	}
+
+	return append(t.resolution(left(x)), t.resolution(right(x))...)
+}
+
+// blankPath blanks the ancestors of a leaf, their keys are known by the
+// device of the leaf which is removed or being unknown to the one added
+func (t *Tree) blankPath(leaf uint32) {
+	for _, x := range directPath(leaf, t.leafCount()) {
+		t.nodes[x] = nil
+	}
+}
+
+// addLeaf places a node on the leftmost blank leaf, the tree is doubled if
+// it is full
+func (t *Tree) addLeaf(node *protocoltypes.TreeKEMNode) {
+	for i := 0; i < len(t.nodes); i += 2 {
+		if t.nodes[i] == nil {
+			t.nodes[i] = node
+			t.blankPath(uint32(i))
+			return
+		}
+	}
+
+	if len(t.nodes) == 0 {
+		t.nodes = []*protocoltypes.TreeKEMNode{node}
+		return
+	}
+
+	leaf := len(t.nodes) + 1
+	t.nodes = append(t.nodes, make([]*protocoltypes.TreeKEMNode, len(t.nodes)+1)...)
+	t.nodes[leaf] = node
+	t.blankPath(uint32(leaf))
+}
+
+// applyProposals returns the tree once the devices of a commit have been
+// removed and added, along with the leaf of the committer
+func (t *Tree) applyProposals(committer []byte, added [][]byte, removed [][]byte) (*Tree, uint32, error) {
+	next := t.Clone()
+
+	for _, device := range removed {
+		if bytes.Equal(device, committer) {
+			return nil, 0, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("the committer can't remove itself from the tree"))
+		}
+
+		leaf, ok := next.leafIndex(device)
+		if !ok {
+			return nil, 0, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("the removed device is not part of the tree"))
+		}
+
+		next.nodes[leaf] = nil
+		next.blankPath(leaf)
+		next.removed[string(device)] = struct{}{}
+	}
+
+	for _, device := range added {
+		if next.Contains(device) || next.IsRemoved(device) {
+			return nil, 0, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("the added device is already part of the tree or has been removed from it"))
+		}
+
+		leafKey, err := deviceLeafKey(device)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		next.addLeaf(&protocoltypes.TreeKEMNode{PublicKey: leafKey[:], DevicePk: device})
+	}
+
+	leaf, ok := next.leafIndex(committer)
+	if !ok {
+		return nil, 0, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("the committer is not part of the tree"))
+	}
+
+	return next, leaf, nil
+}
+
+// applyPath replaces the keys of the path of a leaf by the ones of a commit
+func (t *Tree) applyPath(leaf uint32, path []*protocoltypes.TreeKEMPathNode) error {
+	ancestors := directPath(leaf, t.leafCount())
+	if len(path) != len(ancestors)+1 {
+		return errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("expected a path of %d nodes, got %d", len(ancestors)+1, len(path)))
+	}
+
+	child := leaf
+	for i, node := range path {
+		if len(node.PublicKey) != KeySize {
+			return errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("invalid public key size"))
+		}
+
+		expected := 0
+		if i > 0 {
+			expected = len(t.resolution(sibling(child)))
+			child = ancestors[i-1]
+		}
+
+		if len(node.EncryptedPathSecrets) != expected {
+			return errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("expected %d encrypted path secrets, got %d", expected, len(node.EncryptedPathSecrets)))
+		}
+	}
+
+	t.nodes[leaf] = &protocoltypes.TreeKEMNode{PublicKey: path[0].PublicKey, DevicePk: t.nodes[leaf].DevicePk}
+	for i, x := range ancestors {
+		t.nodes[x] = &protocoltypes.TreeKEMNode{PublicKey: path[i+1].PublicKey}
+	}
+
+	return nil
+}
+
+// deviceLeafKey returns the key of the leaf of a device added by another one,
+// it is used until the device commits its own path
+func deviceLeafKey(devicePK []byte) (*[KeySize]byte, error) {
+	pk, err := crypto.UnmarshalEd25519PublicKey(devicePK)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	leafKey, err := cryptoutil.EdwardsToMontgomeryPub(pk)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoKeyConversion.Wrap(err)
+	}
+
+	return leafKey, nil
+}
@@ -0,0 +1,64 @@
+package treekem
+
+// The nodes of a tree are stored in an array, the leaves at the even indexes
+// and the parents at the odd ones. The number of leaves is always a power of
+// two, the tree is doubled when it is full.
+
+// level returns the level of a node, the leaves being at level 0
+func level(x uint32) uint32 {
+	var k uint32
+	for (x>>k)&1 == 1 {
+		k++
+	}
+
+	return k
+}
+
+// root returns the root of a tree having the given number of leaves
+func root(leaves uint32) uint32 {
+	return leaves - 1
+}
+
+func left(x uint32) uint32 {
+	return x ^ (1 << (level(x) - 1))
+}
+
+func right(x uint32) uint32 {
+	return x ^ (3 << (level(x) - 1))
+}
+
+func parent(x uint32) uint32 {
+	k := level(x)
+	b := (x >> (k + 1)) & 1
+
+	return (x | (1 << k)) ^ (b << (k + 1))
+}
+
+func sibling(x uint32) uint32 {
+	p := parent(x)
+	if x < p {
+		return right(p)
+	}
+
+	return left(p)
+}
+
+// directPath returns the ancestors of a node, from its parent to the root
+func directPath(x uint32, leaves uint32) []uint32 {
+	var path []uint32
+
+	for r := root(leaves); x != r; {
+		x = parent(x)
+		path = append(path, x)
+	}
+
+	return path
+}
+
+// isInSubtree returns whether the node x is the node y or one of its
+// descendants
+func isInSubtree(x uint32, y uint32) bool {
+	span := uint32(1)<<level(y) - 1
+
+	return x >= y-span && x <= y+span
+}
@@ -0,0 +1,170 @@
+package treekem
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"berty.tech/weshnet/v2/pkg/errcode"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+)
+
+func TestTreeMath(t *testing.T) {
+	require.Equal(t, uint32(3), root(4))
+	require.Equal(t, uint32(1), parent(0))
+	require.Equal(t, uint32(1), parent(2))
+	require.Equal(t, uint32(3), parent(5))
+	require.Equal(t, uint32(2), sibling(0))
+	require.Equal(t, uint32(5), sibling(1))
+	require.Equal(t, []uint32{5, 3, 7}, directPath(6, 8))
+	require.True(t, isInSubtree(4, 5))
+	require.False(t, isInSubtree(2, 5))
+}
+
+type testDevice struct {
+	key   crypto.PrivKey
+	pk    []byte
+	epoch *Epoch
+}
+
+func newTestDevices(t *testing.T, count int) []*testDevice {
+	t.Helper()
+
+	devices := make([]*testDevice, count)
+	for i := range devices {
+		key, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+		require.NoError(t, err)
+
+		pk, err := pub.Raw()
+		require.NoError(t, err)
+
+		devices[i] = &testDevice{key: key, pk: pk}
+	}
+
+	return devices
+}
+
+// commitAndProcess creates a commit and processes it on the other devices of
+// the resulting tree, the devices outside of it are returned
+func commitAndProcess(t *testing.T, tree *Tree, committer *testDevice, devices []*testDevice, added, removed [][]byte) (*Tree, *protocoltypes.TreeKEMCommit) {
+	t.Helper()
+
+	commitBytes, epoch, err := Commit(tree, nil, committer.pk, added, removed)
+	require.NoError(t, err)
+
+	commit := &protocoltypes.TreeKEMCommit{}
+	require.NoError(t, proto.Unmarshal(commitBytes, commit))
+
+	next, err := tree.Apply(commit)
+	require.NoError(t, err)
+	require.ElementsMatch(t, epoch.Tree.Devices(), next.Devices())
+
+	committer.epoch = epoch
+
+	for _, d := range devices {
+		if d == committer || !next.Contains(d.pk) {
+			continue
+		}
+
+		deviceKey, err := NewDeviceKey(d.key)
+		require.NoError(t, err)
+
+		keys := []NodeKey{deviceKey}
+		if d.epoch != nil {
+			for _, key := range d.epoch.keys {
+				keys = append(keys, key)
+			}
+		}
+
+		d.epoch, err = Process(tree, commit, d.pk, keys)
+		require.NoError(t, err)
+		require.Equal(t, epoch.Secret, d.epoch.Secret)
+	}
+
+	return next, commit
+}
+
+func TestTreeKEM(t *testing.T) {
+	devices := newTestDevices(t, 8)
+	tree := NewTree()
+
+	// every device joins the tree by adding itself
+	for _, d := range devices {
+		var commit *protocoltypes.TreeKEMCommit
+		tree, commit = commitAndProcess(t, tree, d, devices, [][]byte{d.pk}, nil)
+		require.LessOrEqual(t, len(commit.Path), 4)
+	}
+
+	require.Len(t, tree.Devices(), 8)
+
+	// a full tree is refreshed with a path of log2(n) + 1 nodes
+	tree, commit := commitAndProcess(t, tree, devices[3], devices, nil, nil)
+	require.Len(t, commit.Path, 4)
+
+	// the removed device can't process the next commits nor be added again
+	removed := devices[5]
+	tree, _ = commitAndProcess(t, tree, devices[0], devices, nil, [][]byte{removed.pk})
+	require.False(t, tree.Contains(removed.pk))
+	require.True(t, tree.IsRemoved(removed.pk))
+
+	commitBytes, _, err := Commit(tree, nil, devices[1].pk, nil, nil)
+	require.NoError(t, err)
+
+	commit = &protocoltypes.TreeKEMCommit{}
+	require.NoError(t, proto.Unmarshal(commitBytes, commit))
+
+	_, err = Process(tree, commit, removed.pk, []NodeKey{})
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrNotFound))
+
+	_, _, err = Commit(tree, nil, removed.pk, [][]byte{removed.pk}, nil)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrInvalidInput))
+
+	// a device added by another one uses the key derived from its device key
+	newcomers := newTestDevices(t, 1)
+	tree, _ = commitAndProcess(t, tree, devices[2], append(devices, newcomers...), [][]byte{newcomers[0].pk}, nil)
+	require.True(t, tree.Contains(newcomers[0].pk))
+	require.NotNil(t, newcomers[0].epoch)
+
+	// the epoch keys can be restored from their serialized form
+	keys, err := NodeKeysFromEpochKeys(devices[4].epoch.Keys())
+	require.NoError(t, err)
+	devices[4].epoch.keys = map[[KeySize]byte]NodeKey{}
+	for _, key := range keys {
+		devices[4].epoch.keys[*key.PublicKey()] = key
+	}
+
+	_, _ = commitAndProcess(t, tree, newcomers[0], append(devices, newcomers...), nil, nil)
+}
+
+func TestTreeKEMInvalidCommit(t *testing.T) {
+	devices := newTestDevices(t, 2)
+
+	tree, _ := commitAndProcess(t, NewTree(), devices[0], devices, [][]byte{devices[0].pk}, nil)
+	commitBytes, _, err := Commit(tree, nil, devices[1].pk, [][]byte{devices[1].pk}, nil)
+	require.NoError(t, err)
+
+	commit := &protocoltypes.TreeKEMCommit{}
+	require.NoError(t, proto.Unmarshal(commitBytes, commit))
+
+	// the public keys of the path must match the encrypted path secrets
+	commit.Path[1].PublicKey = commit.Path[0].PublicKey
+
+	deviceKey, err := NewDeviceKey(devices[0].key)
+	require.NoError(t, err)
+
+	keys := []NodeKey{deviceKey}
+	for _, key := range devices[0].epoch.keys {
+		keys = append(keys, key)
+	}
+
+	_, err = Process(tree, commit, devices[0].pk, keys)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrCryptoDecrypt))
+
+	// the path must cover the ancestors of the committer
+	commit.Path = commit.Path[:1]
+	_, err = tree.Apply(commit)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrInvalidInput))
+}
@@ -0,0 +1,84 @@
+package weshnet
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	ipfs_p2p "github.com/ipfs/kubo/core/node/libp2p"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"berty.tech/weshnet/v2/pkg/ipfsutil"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/proximitysim"
+	proximity "berty.tech/weshnet/v2/pkg/proximitytransport"
+	"berty.tech/weshnet/v2/pkg/testutil"
+)
+
+func proximityHostOption(ctx context.Context, logger *zap.Logger, driver proximity.ProximityDriver) ipfs_p2p.HostOption {
+	return func(id peer.ID, ps peerstore.Peerstore, _ ...libp2p.Option) (host.Host, error) {
+		return libp2p.New(
+			libp2p.Identity(ps.PrivKey(id)),
+			libp2p.Peerstore(ps),
+			libp2p.NoTransports,
+			libp2p.NoListenAddrs,
+			libp2p.Transport(proximity.NewTransport(ctx, logger, driver)),
+		)
+	}
+}
+
+func TestGroupSyncOverProximity(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger, cleanup := testutil.Logger(t)
+	defer cleanup()
+
+	medium := proximitysim.NewMedium(logger, proximitysim.LinkConditions{
+		MTU:     512,
+		Latency: time.Millisecond * 5,
+	})
+
+	nodes := make([]*TestingProtocol, 2)
+	for i := range nodes {
+		node := ipfsutil.TestingCoreAPIUsingMockNet(ctx, t, &ipfsutil.TestingAPIOpts{
+			Logger:     logger,
+			HostOption: proximityHostOption(ctx, logger, proximitysim.NewDriver(logger, medium)),
+			SwarmAddrs: []string{proximitysim.DefaultAddr},
+		})
+
+		var cleanup func()
+		nodes[i], cleanup = NewTestingProtocol(ctx, t, &TestingOpts{Logger: logger, CoreAPIMock: node}, nil)
+		defer cleanup()
+	}
+
+	// the nodes are only connected through the simulated proximity link
+	require.Eventually(t, func() bool {
+		return nodes[0].Opts.Host.Network().Connectedness(nodes[1].Opts.Host.ID()) == network.Connected
+	}, time.Second*10, time.Millisecond*100)
+
+	g := CreateMultiMemberGroupInstance(ctx, t, nodes...)
+
+	reply, err := nodes[0].Client.AppMessageSend(ctx, &protocoltypes.AppMessageSend_Request{GroupPk: g.PublicKey, Payload: []byte("test")})
+	require.NoError(t, err)
+
+	id, err := cid.Cast(reply.Cid)
+	require.NoError(t, err)
+
+	gc, err := nodes[1].Service.(*service).GetContextGroupForID(g.PublicKey)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, ok := gc.messageStore.OpLog().Get(id)
+		return ok
+	}, time.Second*30, time.Millisecond*100)
+}
@@ -39,9 +39,12 @@ import (
 	"berty.tech/weshnet/v2/pkg/errcode"
 	"berty.tech/weshnet/v2/pkg/ipfsutil"
 	ipfs_mobile "berty.tech/weshnet/v2/pkg/ipfsutil/mobile"
+	"berty.tech/weshnet/v2/pkg/lifecycle"
+	"berty.tech/weshnet/v2/pkg/netmanager"
 	"berty.tech/weshnet/v2/pkg/protocoltypes"
 	"berty.tech/weshnet/v2/pkg/rendezvous"
 	"berty.tech/weshnet/v2/pkg/secretstore"
+	"berty.tech/weshnet/v2/pkg/storeforward"
 	tinder "berty.tech/weshnet/v2/pkg/tinder"
 	"berty.tech/weshnet/v2/pkg/tyber"
 )
@@ -79,6 +82,13 @@ type service struct {
 	contactRequestsManager *contactRequestsManager
 	vcClient               *bertyvcissuer.Client
 	secretStore            secretstore.SecretStore
+	storeForward           *storeForwardBridge
+	meteredPolicy          *meteredPolicyEngine
+	meteredPolicyDisabled  bool
+	tinder                 *tinder.Service
+	lifecycleManager       *lifecycle.Manager
+	groupBandwidth         *groupBandwidthAccounting
+	bandwidthTap           *ipfsutil.BandwidthTap
 
 	protocoltypes.UnimplementedProtocolServiceServer
 }
@@ -106,6 +116,34 @@ type Opts struct {
 	// These are used if OrbitDB is nil.
 	GroupMetadataStoreType string
 	GroupMessageStoreType  string
+
+	// StoreForward enables the store-and-forward relay mode when not nil,
+	// sealed entries of any group are carried and handed on to the peers met
+	// over proximity links.
+	StoreForward *storeforward.Options
+
+	// NetManager reports the connectivity of the device, it drives the
+	// metered network policy.
+	NetManager *netmanager.NetManager
+
+	// MeteredPolicy configures the reduced sync applied on metered networks,
+	// the default policy is used when nil.
+	MeteredPolicy *MeteredPolicy
+
+	// LifecycleManager is switched to lifecycle.StateSync while an inactive
+	// app syncs in the background.
+	LifecycleManager *lifecycle.Manager
+
+	// SecretStoreKeyProvider, used if SecretStore is nil, encrypts the key
+	// material stored in RootDatastore at rest.
+	SecretStoreKeyProvider secretstore.KeyProvider
+
+	// BandwidthTap, given to the host as its bandwidth reporter, lets the
+	// direct channel and block traffic be attributed to the groups. It is
+	// set up on the created host if IpfsCoreAPI is nil.
+	BandwidthTap *ipfsutil.BandwidthTap
+
+	groupBandwidth *groupBandwidthAccounting
 }
 
 func (opts *Opts) applyPushDefaults() {
@@ -164,9 +202,14 @@ func (opts *Opts) applyDefaults(ctx context.Context) error {
 
 	opts.applyPushDefaults()
 
+	if opts.groupBandwidth == nil {
+		opts.groupBandwidth = newGroupBandwidthAccounting(opts.Logger, opts.PrometheusRegister)
+	}
+
 	if opts.SecretStore == nil {
 		secretStore, err := secretstore.NewSecretStore(opts.RootDatastore, &secretstore.NewSecretStoreOptions{
-			Logger: opts.Logger,
+			Logger:      opts.Logger,
+			KeyProvider: opts.SecretStoreKeyProvider,
 		})
 		if err != nil {
 			return errcode.ErrCode_ErrInternal.Wrap(err)
@@ -191,12 +234,15 @@ func (opts *Opts) applyDefaults(ctx context.Context) error {
 			return err
 		}
 
+		opts.BandwidthTap = ipfsutil.NewBandwidthTap(nil)
+
 		mrepo := ipfs_mobile.NewRepoMobile(opts.DatastoreDir, repo)
 		// NewIPFSMobile will apply defaults for P2PStaticRelays
 		mnode, err = ipfsutil.NewIPFSMobile(ctx, mrepo, &ipfsutil.MobileOptions{
-			Logger:          opts.Logger,
-			P2PStaticRelays: opts.P2PStaticRelays,
-			PeerStorePeers:  opts.P2PRdvpMaddrs,
+			Logger:            opts.Logger,
+			P2PStaticRelays:   opts.P2PStaticRelays,
+			PeerStorePeers:    opts.P2PRdvpMaddrs,
+			BandwidthReporter: opts.BandwidthTap,
 		})
 		if err != nil {
 			return err
@@ -270,6 +316,7 @@ func (opts *Opts) applyDefaults(ctx context.Context) error {
 		popts := []pubsub.Option{
 			pubsub.WithMessageSigning(true),
 			pubsub.WithPeerExchange(true),
+			pubsub.WithRawTracer(&groupBandwidthTracer{accounting: opts.groupBandwidth}),
 		}
 
 		backoffstrat := backoff.NewExponentialBackoff(
@@ -414,6 +461,55 @@ func NewService(opts Opts) (_ Service, err error) {
 		peerStatusManager:      NewConnectednessManager(),
 		accountEventBus:        accountEventBus,
 		contactRequestsManager: contactRequestsManager,
+		tinder:                 opts.TinderService,
+		lifecycleManager:       opts.LifecycleManager,
+		groupBandwidth:         opts.groupBandwidth,
+		bandwidthTap:           opts.BandwidthTap,
+	}
+
+	if opts.StoreForward != nil {
+		sfDatastore := datastoreutil.NewNamespacedDatastore(opts.RootDatastore, ds.NewKey(NamespaceStoreForward))
+		sfOpts := *opts.StoreForward
+		if sfOpts.Logger == nil {
+			sfOpts.Logger = opts.Logger
+		}
+
+		sfStore, err := storeforward.NewStore(ctx, sfDatastore, sfOpts)
+		if err != nil {
+			cancel()
+			return nil, errcode.ErrCode_ErrInternal.Wrap(fmt.Errorf("unable to init store-and-forward store: %w", err))
+		}
+
+		if s.storeForward, err = newStoreForwardBridge(ctx, opts.Logger, opts.OrbitDB, opts.IpfsCoreAPI, sfStore); err != nil {
+			cancel()
+			return nil, errcode.ErrCode_ErrInternal.Wrap(fmt.Errorf("unable to start store-and-forward relay: %w", err))
+		}
+
+		opts.Logger.Debug("Store-and-forward relay is enabled", tyber.FormatStepLogFields(ctx, []tyber.Detail{})...)
+	}
+
+	bwDatastore := datastoreutil.NewNamespacedDatastore(opts.RootDatastore, ds.NewKey(NamespaceGroupBandwidth))
+	if err := s.groupBandwidth.start(ctx, opts.OrbitDB, bwDatastore); err != nil {
+		cancel()
+		return nil, errcode.ErrCode_ErrInternal.Wrap(fmt.Errorf("unable to start group bandwidth accounting: %w", err))
+	}
+	s.groupBandwidth.updateGroup(ctx, accountGroupCtx)
+
+	if s.bandwidthTap != nil {
+		s.bandwidthTap.SetTap(s.groupBandwidth.streamTraffic)
+	}
+
+	switch {
+	case opts.MeteredPolicy != nil && opts.MeteredPolicy.Disabled:
+		s.meteredPolicyDisabled = true
+	case opts.NetManager != nil:
+		policy := MeteredPolicy{}
+		if opts.MeteredPolicy != nil {
+			policy = *opts.MeteredPolicy
+		}
+
+		s.meteredPolicy = newMeteredPolicyEngine(ctx, opts.Logger, policy, opts.NetManager, s, opts.TinderService)
+		opts.Logger.Debug("Metered network policy is enabled", tyber.FormatStepLogFields(ctx, []tyber.Detail{})...)
 	}
 
 	s.startGroupDeviceMonitor()
@@ -458,6 +554,14 @@ func (s *service) Close() error {
 		}
 	}
 
+	if s.storeForward != nil {
+		err = multierr.Append(err, s.storeForward.close())
+	}
+
+	if s.bandwidthTap != nil {
+		s.bandwidthTap.SetTap(nil)
+	}
+
 	err = multierr.Append(err, s.odb.Close())
 
 	if s.close != nil {
@@ -493,9 +597,20 @@ func (s *service) startGroupDeviceMonitor() {
 		return
 	}
 
+	// monitor the peers leaving pubsub topics
+	subTopic, err := s.host.EventBus().Subscribe(new(ipfsutil.EvtPubSubTopic),
+		eventbus.Name("weshnet/service/monitor-pubsub-topic"))
+	if err != nil {
+		s.logger.Error("startGroupDeviceMonitor", zap.Error(errors.Wrap(err, "unable to subscribe pubsub topic event")))
+		subHead.Close()
+		subPeer.Close()
+		return
+	}
+
 	go func() {
 		defer subHead.Close()
 		defer subPeer.Close()
+		defer subTopic.Close()
 
 		for {
 			var evt any
@@ -503,6 +618,7 @@ func (s *service) startGroupDeviceMonitor() {
 			select {
 			case evt = <-subHead.Out():
 			case evt = <-subPeer.Out():
+			case evt = <-subTopic.Out():
 			case <-s.ctx.Done():
 				return
 			}
@@ -514,6 +630,11 @@ func (s *service) startGroupDeviceMonitor() {
 					s.peerStatusManager.UpdateState(e.Peer, ConnectednessTypeConnected)
 				case network.NotConnected:
 					s.peerStatusManager.UpdateState(e.Peer, ConnectednessTypeDisconnected)
+					s.odb.messageMarshaler.RemovePeer(e.Peer)
+				}
+			case ipfsutil.EvtPubSubTopic:
+				if e.EventType == ipfsutil.TypeEventMonitorPeerLeft {
+					s.odb.messageMarshaler.RemoveTopicPeer(e.Topic, e.PeerID)
 				}
 			case baseorbitdb.EventExchangeHeads:
 				if dpk, ok := s.odb.GetDevicePKForPeerID(e.Peer); ok {
@@ -71,6 +71,14 @@ func (s *service) deactivateGroup(pk crypto.PubKey) error {
 
 	delete(s.openedGroups, string(id))
 
+	s.odb.clearGroupReplication(cg)
+
+	if s.meteredPolicy != nil {
+		s.meteredPolicy.groupClosed(cg)
+	}
+
+	s.groupBandwidth.groupClosed(cg)
+
 	if cg.group.GroupType == protocoltypes.GroupType_GroupTypeAccount {
 		s.accountGroupCtx = nil
 	}
@@ -148,6 +156,20 @@ func (s *service) activateGroup(ctx context.Context, pk crypto.PubKey, localOnly
 
 	s.openedGroups[string(id)] = gc
 
+	if s.storeForward != nil {
+		s.storeForward.watchGroup(gc)
+	}
+
+	if g.GroupType == protocoltypes.GroupType_GroupTypeContact {
+		s.watchContactAccountKeyRotation(gc)
+	}
+
+	if s.meteredPolicy != nil {
+		s.meteredPolicy.updateGroup(s.ctx, gc)
+	}
+
+	s.groupBandwidth.updateGroup(s.ctx, gc)
+
 	gc.TagGroupContextPeers(s.ipfsCoreAPI, 42)
 	return nil
 }
@@ -206,6 +228,95 @@ func reindexGroupDatastore(ctx context.Context, secretStore secretstore.SecretSt
 	return nil
 }
 
+// joinedGroupPublicKeys returns the public keys of the account group, of the
+// joined multi member groups and of the groups of the contacts, including the
+// ones derived from replaced account keys, blocking a contact can be
+// reverted so the keys of its group are kept
+func joinedGroupPublicKeys(secretStore secretstore.SecretStore, accountGroup *GroupContext) ([]crypto.PubKey, error) {
+	m := accountGroup.MetadataStore()
+
+	accountGroupPK, err := accountGroup.Group().GetPubKey()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	joined := []crypto.PubKey{accountGroupPK}
+
+	for _, g := range m.ListMultiMemberGroups() {
+		pk, err := g.GetPubKey()
+		if err != nil {
+			return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+		}
+
+		joined = append(joined, pk)
+	}
+
+	for _, contact := range m.ListContactsByStatus(
+		protocoltypes.ContactState_ContactStateToRequest,
+		protocoltypes.ContactState_ContactStateReceived,
+		protocoltypes.ContactState_ContactStateAdded,
+		protocoltypes.ContactState_ContactStateRemoved,
+		protocoltypes.ContactState_ContactStateDiscarded,
+		protocoltypes.ContactState_ContactStateBlocked,
+	) {
+		cPK, err := contact.GetPubKey()
+		if err != nil {
+			return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+		}
+
+		group, err := secretStore.GetGroupForContact(cPK)
+		if err != nil {
+			return nil, errcode.ErrCode_ErrInternal.Wrap(err)
+		}
+
+		pk, err := group.GetPubKey()
+		if err != nil {
+			return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+		}
+
+		joined = append(joined, pk)
+	}
+
+	// the contact groups derived from replaced account keys hold the
+	// history preceding the rotation
+	for _, groupPK := range m.ListPreviousContactGroupPKs() {
+		pk, err := crypto.UnmarshalEd25519PublicKey(groupPK)
+		if err != nil {
+			return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+		}
+
+		joined = append(joined, pk)
+	}
+
+	return joined, nil
+}
+
+// garbageCollectSecretStore removes the key material of the groups which are
+// not joined anymore from the secret store
+func (s *service) garbageCollectSecretStore(ctx context.Context) (*secretstore.GarbageCollectReport, error) {
+	accountGroup := s.getAccountGroup()
+	if accountGroup == nil {
+		return nil, errcode.ErrCode_ErrGroupMissing
+	}
+
+	joined, err := joinedGroupPublicKeys(s.secretStore, accountGroup)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.secretStore.GarbageCollect(ctx, joined)
+}
+
+// garbageCollectSecretStoreAsync runs garbageCollectSecretStore in the
+// background, errors are only logged
+func (s *service) garbageCollectSecretStoreAsync() {
+	go func() {
+		if _, err := s.garbageCollectSecretStore(s.ctx); err != nil {
+			s.logger.Error("unable to garbage collect secret store", zap.Error(err))
+		}
+	}()
+}
+
 func (s *service) getAccountGroup() *GroupContext {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -0,0 +1,172 @@
+package weshnet
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"sync"
+
+	"github.com/ipfs/go-cid"
+	cbornode "github.com/ipfs/go-ipld-cbor"
+	"github.com/libp2p/go-libp2p/p2p/host/eventbus"
+	"go.uber.org/zap"
+
+	ipfslog "berty.tech/go-ipfs-log"
+	"berty.tech/go-ipfs-log/entry"
+	"berty.tech/go-orbit-db/iface"
+	"berty.tech/go-orbit-db/stores"
+	"berty.tech/weshnet/v2/pkg/ipfsutil"
+	"berty.tech/weshnet/v2/pkg/logutil"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/storeforward"
+)
+
+// storeForwardBridge connects the store-and-forward relay to the group
+// stores: entries written locally are published to the relay, and envelopes
+// received for an opened group are loaded into its store.
+type storeForwardBridge struct {
+	ctx    context.Context
+	logger *zap.Logger
+	odb    *WeshOrbitDB
+	ipfs   ipfsutil.ExtendedCoreAPI
+	relay  *storeforward.Relay
+	topics sync.Map // string(topic) -> iface.Store
+}
+
+// storeForwardTopic returns the topic of the envelopes of a group store, a
+// hash of its address keyed with the group link key so relays can't tell
+// which group an envelope belongs to
+func storeForwardTopic(g *protocoltypes.Group, address string) ([]byte, error) {
+	linkKey, err := g.GetLinkKeyArray()
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, linkKey[:])
+	mac.Write([]byte("weshnet/storeforward/topic"))
+	mac.Write([]byte(address))
+	return mac.Sum(nil), nil
+}
+
+func newStoreForwardBridge(ctx context.Context, logger *zap.Logger, odb *WeshOrbitDB, ipfs ipfsutil.ExtendedCoreAPI, store *storeforward.Store) (*storeForwardBridge, error) {
+	b := &storeForwardBridge{
+		ctx:    ctx,
+		logger: logger.Named("storeforward"),
+		odb:    odb,
+		ipfs:   ipfs,
+	}
+
+	relay, err := storeforward.NewRelay(ipfs, store, b.deliver)
+	if err != nil {
+		return nil, err
+	}
+	b.relay = relay
+
+	return b, nil
+}
+
+// watchGroup publishes the entries written on the group stores until the
+// group context is closed
+func (b *storeForwardBridge) watchGroup(gc *GroupContext) {
+	for _, store := range []iface.Store{gc.metadataStore, gc.messageStore} {
+		address := store.Address().String()
+		topic, err := storeForwardTopic(gc.group, address)
+		if err != nil {
+			b.logger.Error("unable to compute store topic", zap.Error(err))
+			continue
+		}
+
+		sub, err := store.EventBus().Subscribe(new(stores.EventWrite),
+			eventbus.Name("weshnet/storeforward/watch-group"), eventbus.BufSize(128))
+		if err != nil {
+			b.logger.Error("unable to subscribe to store events", zap.Error(err))
+			continue
+		}
+
+		b.topics.Store(string(topic), store)
+		go func() {
+			defer sub.Close()
+			defer b.topics.Delete(string(topic))
+
+			for {
+				var e any
+				select {
+				case e = <-sub.Out():
+				case <-gc.ctx.Done():
+					return
+				case <-b.ctx.Done():
+					return
+				}
+
+				evt := e.(stores.EventWrite)
+				if err := b.publish(gc.ctx, topic, evt.Entry.GetHash()); err != nil {
+					b.logger.Warn("unable to publish entry", logutil.PrivateString("address", address), zap.Error(err))
+				}
+			}
+		}()
+	}
+}
+
+func (b *storeForwardBridge) publish(ctx context.Context, topic []byte, c cid.Cid) error {
+	node, err := b.ipfs.Dag().Get(ctx, c)
+	if err != nil {
+		return fmt.Errorf("unable to get entry block: %w", err)
+	}
+
+	return b.relay.Publish(ctx, topic, c, node.RawData())
+}
+
+// deliver loads the envelopes of the opened groups into their stores, the
+// other ones are only carried
+func (b *storeForwardBridge) deliver(env *protocoltypes.StoreForwardEnvelope) {
+	value, ok := b.topics.Load(string(env.Topic))
+	if !ok {
+		return
+	}
+	store := value.(iface.Store)
+	address := store.Address().String()
+
+	c, err := cid.Cast(env.Cid)
+	if err != nil {
+		return
+	}
+
+	if _, ok := store.OpLog().Get(c); ok {
+		return
+	}
+
+	// entries are dag-cbor blocks, the cid has been checked by the relay
+	prefix := c.Prefix()
+	if prefix.Codec != cid.DagCBOR {
+		b.logger.Warn("unsupported envelope codec", zap.Uint64("codec", prefix.Codec))
+		return
+	}
+
+	node, err := cbornode.Decode(env.Payload, prefix.MhType, prefix.MhLength)
+	if err != nil || !node.Cid().Equals(c) {
+		b.logger.Warn("unable to decode envelope payload", zap.Error(err))
+		return
+	}
+
+	if err := b.ipfs.Dag().Add(b.ctx, node); err != nil {
+		b.logger.Error("unable to add envelope block", zap.Error(err))
+		return
+	}
+
+	head := &entry.Entry{Hash: c}
+
+	// the replication of inactive groups may be paused on metered networks
+	if b.odb.messageMarshaler.DeferHeads(address, []*entry.Entry{head}) {
+		return
+	}
+
+	// missing parents are fetched by the replicator, they may come with
+	// other envelopes
+	store.Replicator().Load(b.ctx, []ipfslog.Entry{head})
+	b.logger.Debug("envelope delivered", logutil.PrivateString("address", address), logutil.PrivateString("cid", c.String()))
+}
+
+func (b *storeForwardBridge) close() error {
+	return b.relay.Close()
+}
@@ -51,6 +51,10 @@ type MessageStore struct {
 
 	messagesQueue *simpleMessageQueue
 
+	// metadataIndex returns the index of the metadata store of the group,
+	// available once the group is opened
+	metadataIndex func() (*metadataStoreIndex, error)
+
 	ctx    context.Context
 	cancel context.CancelFunc
 }
@@ -134,6 +138,85 @@ func (m *MessageStore) ProcessMessageQueueForDevicePK(ctx context.Context, devic
 	m.muDeviceCaches.Unlock()
 }
 
+// checkSenderPermission returns an error if a device was not allowed to send
+// messages on the group when the given metadata event was the most recent
+// one, the current roles are used if it is unset
+func (m *MessageStore) checkSenderPermission(devicePK []byte, metadataHead []byte) error {
+	if m.group.GroupType != protocoltypes.GroupType_GroupTypeMultiMember {
+		return nil
+	}
+
+	index, err := m.metadataIndex()
+	if err != nil {
+		// the message is processed again once the group is activated
+		return errcode.ErrCode_ErrGroupMissing.Wrap(err)
+	}
+
+	return index.checkMessagePermission(devicePK, metadataHead)
+}
+
+// metadataHead returns the CID of the most recent metadata event of the
+// group, messages are checked against the roles at this event
+func (m *MessageStore) metadataHead() []byte {
+	if m.group.GroupType != protocoltypes.GroupType_GroupTypeMultiMember {
+		return nil
+	}
+
+	index, err := m.metadataIndex()
+	if err != nil {
+		return nil
+	}
+
+	return index.getHeadEvent()
+}
+
+// isMessageHidden returns whether a message has been hidden by an admin of
+// the group
+func (m *MessageStore) isMessageHidden(id cid.Cid) bool {
+	if m.group.GroupType != protocoltypes.GroupType_GroupTypeMultiMember {
+		return false
+	}
+
+	index, err := m.metadataIndex()
+	if err != nil {
+		return false
+	}
+
+	return index.isMessageHidden(id)
+}
+
+// emitHiddenMessage emits again a message already delivered once it has been
+// hidden by an admin, without its payload. The messages not received or not
+// opened yet are hidden once processed.
+func (m *MessageStore) emitHiddenMessage(ctx context.Context, id cid.Cid) {
+	op, err := m.GetMessageByCID(id)
+	if err != nil {
+		return
+	}
+
+	_, headers, err := m.secretStore.OpenEnvelopeHeaders(op.GetValue(), m.group)
+	if err != nil {
+		m.logger.Error("unable to open message headers", zap.Error(err))
+		return
+	}
+
+	devicePublicKey, err := crypto.UnmarshalEd25519PublicKey(headers.DevicePk)
+	if err != nil || !m.secretStore.IsChainKeyKnownForDevice(ctx, m.groupPublicKey, devicePublicKey) {
+		return
+	}
+
+	entry := op.GetEntry()
+	evt := &protocoltypes.GroupMessageEvent{
+		EventContext: newEventContext(entry.GetHash(), entry.GetNext(), m.group),
+		Headers:      headers,
+		Hidden:       true,
+	}
+
+	if err := m.emitters.groupMessage.Emit(evt); err != nil {
+		m.logger.Warn("unable to emit hidden message event", zap.Error(err))
+	}
+}
+
 func (m *MessageStore) processMessage(ctx context.Context, message *messageItem) (*protocoltypes.GroupMessageEvent, error) {
 	// process message
 	msg, err := m.secretStore.OpenEnvelopePayload(ctx, message.env, message.headers, m.groupPublicKey, m.currentDevicePublicKey, message.hash)
@@ -141,6 +224,12 @@ func (m *MessageStore) processMessage(ctx context.Context, message *messageItem)
 		return nil, fmt.Errorf("unable to open the envelope: %w", err)
 	}
 
+	// the metadata event referenced by the message is authenticated along
+	// with its payload
+	if err := m.checkSenderPermission(message.headers.DevicePk, msg.GetMetadataHead()); err != nil {
+		return nil, err
+	}
+
 	err = m.secretStore.UpdateOutOfStoreGroupReferences(ctx, message.headers.DevicePk, message.headers.Counter, m.group)
 	if err != nil {
 		m.logger.Error("unable to update push group references", zap.Error(err))
@@ -148,11 +237,17 @@ func (m *MessageStore) processMessage(ctx context.Context, message *messageItem)
 
 	entry := message.op.GetEntry()
 	eventContext := newEventContext(entry.GetHash(), entry.GetNext(), m.group)
-	return &protocoltypes.GroupMessageEvent{
+	evt := &protocoltypes.GroupMessageEvent{
 		EventContext: eventContext,
 		Headers:      message.headers,
 		Message:      msg.GetPlaintext(),
-	}, nil
+	}
+
+	if m.isMessageHidden(entry.GetHash()) {
+		evt.Hidden, evt.Message = true, nil
+	}
+
+	return evt, nil
 }
 
 func (m *MessageStore) processMessageLoop(ctx context.Context, tracer *messageMetricsTracer) {
@@ -178,7 +273,15 @@ func (m *MessageStore) processMessageLoop(ctx context.Context, tracer *messageMe
 
 		// actually process the message
 		evt, err := m.processMessage(ctx, message)
-		if err != nil {
+		if errcode.Is(err, errcode.ErrCode_ErrGroupPermissionDenied) {
+			// the metadata allowing the message may not have been received
+			// yet, it is processed again once the metadata changes
+			m.logger.Warn("unable to process message from unauthorized device", zap.Error(err))
+
+			device.queue.Add(message)
+			_ = m.emitters.groupCacheMessage.Emit(*message)
+			continue
+		} else if err != nil {
 			m.logger.Error("unable to process message", zap.Error(err))
 
 			// if we got any error here, put (back) the message into the device queue
@@ -225,6 +328,19 @@ func (m *MessageStore) getOrCreateDeviceCache(ctx context.Context, message *mess
 	return device, device.hasKnownChainKey
 }
 
+// ProcessMessageQueues puts back the queued messages of the devices whose
+// chain key is known for processing, the messages denied by the roles of the
+// group are checked again once its metadata changes
+func (m *MessageStore) ProcessMessageQueues() {
+	m.muDeviceCaches.Lock()
+	for _, device := range m.deviceCaches {
+		if device.hasKnownChainKey {
+			m.processDeviceMessagesInQueue(device)
+		}
+	}
+	m.muDeviceCaches.Unlock()
+}
+
 // process the whole device queue (if any) into to the message queue
 func (m *MessageStore) processDeviceMessagesInQueue(device *groupCache) {
 	_ = device.queue.NextAll(func(next *messageItem) error {
@@ -307,6 +423,10 @@ func (m *MessageStore) AddMessage(ctx context.Context, payload []byte) (operatio
 		)...,
 	)
 
+	if err := m.checkSenderPermission(m.currentDevicePublicKeyRaw, nil); errcode.Is(err, errcode.ErrCode_ErrGroupPermissionDenied) {
+		return nil, err
+	}
+
 	return messageStoreAddMessage(ctx, m.group, m, payload)
 }
 
@@ -314,6 +434,7 @@ func messageStoreAddMessage(ctx context.Context, g *protocoltypes.Group, m *Mess
 	msg := &protocoltypes.EncryptedMessage{
 		Plaintext:        payload,
 		ProtocolMetadata: &protocoltypes.ProtocolMetadata{},
+		MetadataHead:     m.metadataHead(),
 	}
 	msgBytes, err := proto.Marshal(msg)
 	if err != nil {
@@ -388,6 +509,16 @@ func constructorFactoryGroupMessage(s *WeshOrbitDB, logger *zap.Logger) iface.St
 			deviceCaches:   make(map[string]*groupCache),
 		}
 
+		groupID := g.GroupIDAsString()
+		store.metadataIndex = func() (*metadataStoreIndex, error) {
+			gc, err := s.getGroupContext(groupID)
+			if err != nil {
+				return nil, err
+			}
+
+			return gc.MetadataStore().Index().(*metadataStoreIndex), nil
+		}
+
 		if s.replicationMode {
 			replication = true
 		} else {
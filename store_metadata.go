@@ -8,7 +8,9 @@ import (
 	"io"
 	"slices"
 	"strings"
+	"time"
 
+	"github.com/ipfs/go-cid"
 	coreiface "github.com/ipfs/kubo/core/coreiface"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/event"
@@ -26,6 +28,7 @@ import (
 	"berty.tech/go-orbit-db/stores/operation"
 	"berty.tech/weshnet/v2/pkg/errcode"
 	"berty.tech/weshnet/v2/pkg/protocoltypes"
+	"berty.tech/weshnet/v2/pkg/rendezvous"
 	"berty.tech/weshnet/v2/pkg/secretstore"
 	"berty.tech/weshnet/v2/pkg/tyber"
 )
@@ -42,6 +45,7 @@ type MetadataStore struct {
 	memberDevice       secretstore.OwnMemberDevice
 	devicePublicKeyRaw []byte
 	secretStore        secretstore.SecretStore
+	rotationInterval   *rendezvous.RotationInterval
 	logger             *zap.Logger
 
 	ctx    context.Context
@@ -124,6 +128,8 @@ func (m *MetadataStore) ListEvents(_ context.Context, since, until []byte, rever
 				event, _, err := openMetadataEntry(m.OpLog(), entry, m.group)
 				if err != nil {
 					m.logger.Error("unable to open metadata event", zap.Error(err))
+				} else if m.Index().(*metadataStoreIndex).isEventDenied(entry.GetHash().String()) {
+					m.logger.Warn("ignoring unauthorized metadata event", zap.String("event-type", event.Metadata.EventType.String()))
 				} else {
 					out <- event
 					m.logger.Info("metadata store - sent 1 event from log history")
@@ -235,8 +241,13 @@ func (m *MetadataStore) ClaimGroupOwnership(ctx context.Context, groupSK crypto.
 		return nil, errcode.ErrCode_ErrGroupInvalidType
 	}
 
+	memberPKRaw, err := m.memberDevice.Member().Raw()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
 	event := &protocoltypes.MultiMemberGroupInitialMemberAnnounced{
-		MemberPk: m.devicePublicKeyRaw,
+		MemberPk: memberPKRaw,
 	}
 
 	sig, err := signProtoWithPrivateKey(event, groupSK)
@@ -283,6 +294,11 @@ func metadataStoreAddEvent(ctx context.Context, m *MetadataStore, g *protocoltyp
 		tyberLogError = tyber.LogFatalError
 	}
 
+	// the event would be ignored by the index of every peer
+	if err := m.Index().(*metadataStoreIndex).checkEventPermission(eventType, event); err != nil {
+		return nil, tyberLogError(ctx, m.logger, "Not allowed to send event", err)
+	}
+
 	env, err := sealGroupEnvelope(g, eventType, event, sig)
 	if err != nil {
 		return nil, tyberLogError(ctx, m.logger, "Failed to seal group envelope", errcode.ErrCode_ErrCryptoSignature.Wrap(err))
@@ -325,6 +341,33 @@ func (m *MetadataStore) GetDevicesForMember(pk crypto.PubKey) ([]crypto.PubKey,
 	return m.Index().(*metadataStoreIndex).getDevicesForMember(pk)
 }
 
+// GetRendezvousRotationInterval returns the rendezvous rotation interval
+// agreed on by the group members, 0 if none has been set
+func (m *MetadataStore) GetRendezvousRotationInterval() time.Duration {
+	return m.Index().(*metadataStoreIndex).getRendezvousRotationInterval()
+}
+
+// IsHistorySharingEnabled returns whether the members of the group are
+// allowed to share the message history with the new members
+func (m *MetadataStore) IsHistorySharingEnabled() bool {
+	return m.Index().(*metadataStoreIndex).isHistorySharingEnabled()
+}
+
+// GetPermissions returns the roles required to send entries on the group
+func (m *MetadataStore) GetPermissions() *protocoltypes.GroupPermissions {
+	return m.Index().(*metadataStoreIndex).getPermissions()
+}
+
+// GetMemberRole returns the role of a member of the group, every member is an
+// admin of the contact and account groups
+func (m *MetadataStore) GetMemberRole(pk crypto.PubKey) (protocoltypes.GroupRole, error) {
+	if m.typeChecker(isContactGroup, isAccountGroup) {
+		return protocoltypes.GroupRole_GroupRoleAdmin, nil
+	}
+
+	return m.Index().(*metadataStoreIndex).getMemberRole(pk)
+}
+
 func (m *MetadataStore) ListAdmins() []crypto.PubKey {
 	if m.typeChecker(isContactGroup, isAccountGroup) {
 		return m.ListMembers()
@@ -350,6 +393,8 @@ func (m *MetadataStore) GetIncomingContactRequestsStatus() (bool, *protocoltypes
 	contactRef := &protocoltypes.ShareableContact{
 		Pk:                   rawMemberDevice,
 		PublicRendezvousSeed: seed,
+
+		RendezvousRotationIntervalSeconds: int64(m.GetRendezvousRotationInterval() / time.Second),
 	}
 
 	return enabled, contactRef
@@ -458,6 +503,29 @@ func (m *MetadataStore) GetContactFromGroupPK(groupPK []byte) *protocoltypes.Sha
 	return contact.contact
 }
 
+// ListPreviousContactGroupPKs returns the public keys of the contact groups
+// derived from account keys that have since been replaced, ours or the ones
+// of our contacts
+func (m *MetadataStore) ListPreviousContactGroupPKs() [][]byte {
+	if !m.typeChecker(isAccountGroup) {
+		return nil
+	}
+
+	idx, ok := m.Index().(*metadataStoreIndex)
+	if !ok {
+		return nil
+	}
+	idx.lock.RLock()
+	defer idx.lock.RUnlock()
+
+	groupPKs := make([][]byte, 0, len(idx.previousContactGroups))
+	for groupPK := range idx.previousContactGroups {
+		groupPKs = append(groupPKs, []byte(groupPK))
+	}
+
+	return groupPKs
+}
+
 func (m *MetadataStore) checkIfInGroup(pk []byte) bool {
 	idx, ok := m.Index().(*metadataStoreIndex)
 	if !ok {
@@ -748,6 +816,38 @@ func (m *MetadataStore) ContactSendAliasKey(ctx context.Context) (operation.Oper
 	}, protocoltypes.EventType_EventTypeContactAliasKeyAdded)
 }
 
+// AccountKeyRotated records on the account group the replacement of an
+// account key, either ours or the one of a contact, previousContactGroupPKs
+// lists the contact groups derived from our replaced key so they are kept
+func (m *MetadataStore) AccountKeyRotated(ctx context.Context, succession *protocoltypes.AccountKeySuccession, previousContactGroupPKs [][]byte) (operation.Operation, error) {
+	if !m.typeChecker(isAccountGroup) {
+		return nil, errcode.ErrCode_ErrGroupInvalidType
+	}
+
+	return m.accountKeyRotatedAction(ctx, succession, previousContactGroupPKs, protocoltypes.EventType_EventTypeAccountKeyRotated)
+}
+
+// ContactAccountKeyRotated announces to a contact the replacement of our
+// account key
+func (m *MetadataStore) ContactAccountKeyRotated(ctx context.Context, succession *protocoltypes.AccountKeySuccession) (operation.Operation, error) {
+	if !m.typeChecker(isContactGroup) {
+		return nil, errcode.ErrCode_ErrGroupInvalidType
+	}
+
+	return m.accountKeyRotatedAction(ctx, succession, nil, protocoltypes.EventType_EventTypeContactAccountKeyRotated)
+}
+
+func (m *MetadataStore) accountKeyRotatedAction(ctx context.Context, succession *protocoltypes.AccountKeySuccession, previousContactGroupPKs [][]byte, evtType protocoltypes.EventType) (operation.Operation, error) {
+	if _, _, err := secretstore.VerifyAccountKeySuccession(succession); err != nil {
+		return nil, err
+	}
+
+	return m.attributeSignAndAddEvent(ctx, &protocoltypes.AccountKeyRotated{
+		Succession:              succession,
+		PreviousContactGroupPks: previousContactGroupPKs,
+	}, evtType)
+}
+
 func (m *MetadataStore) SendAliasProof(ctx context.Context) (operation.Operation, error) {
 	if !m.typeChecker(isMultiMemberGroup) {
 		return nil, errcode.ErrCode_ErrGroupInvalidType
@@ -783,6 +883,187 @@ func (m *MetadataStore) SendGroupReplicating(ctx context.Context, authentication
 	}, protocoltypes.EventType_EventTypeGroupReplicating)
 }
 
+func (m *MetadataStore) SendRendezvousRotationInterval(ctx context.Context, interval time.Duration) (operation.Operation, error) {
+	if err := checkRendezvousRotationInterval(interval); err != nil {
+		return nil, err
+	}
+
+	return m.attributeSignAndAddEvent(ctx, &protocoltypes.GroupRendezvousRotationIntervalUpdated{
+		IntervalSeconds: int64(interval / time.Second),
+	}, protocoltypes.EventType_EventTypeGroupRendezvousRotationIntervalUpdated)
+}
+
+// checkRendezvousRotationInterval checks that a rotation interval can be used
+// on a rendezvous topic, 0 resets it to the default interval
+func checkRendezvousRotationInterval(interval time.Duration) error {
+	switch {
+	case interval < 0:
+		return errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("rotation interval can't be negative"))
+	case interval > 0 && interval < rendezvous.MinimumDelayRotation:
+		return errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("rotation interval can't be lower than %s", rendezvous.MinimumDelayRotation))
+	case interval > rendezvous.MaximumDelayRotation:
+		return errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("rotation interval can't be greater than %s", rendezvous.MaximumDelayRotation))
+	}
+
+	return nil
+}
+
+// rendezvousRotationIntervalFromSeconds converts a rotation interval received
+// from a peer, it is bounded before the conversion to avoid overflows
+func rendezvousRotationIntervalFromSeconds(seconds int64) (time.Duration, error) {
+	if seconds < 0 || seconds > int64(rendezvous.MaximumDelayRotation/time.Second) {
+		return 0, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("rotation interval out of bounds: %ds", seconds))
+	}
+
+	interval := time.Duration(seconds) * time.Second
+	if err := checkRendezvousRotationInterval(interval); err != nil {
+		return 0, err
+	}
+
+	return interval, nil
+}
+
+// SendHistorySharingUpdated allows or disallows the members of the group to
+// share the message history with the new members
+func (m *MetadataStore) SendHistorySharingUpdated(ctx context.Context, enabled bool) (operation.Operation, error) {
+	if !m.typeChecker(isMultiMemberGroup) {
+		return nil, errcode.ErrCode_ErrGroupInvalidType
+	}
+
+	return m.attributeSignAndAddEvent(ctx, &protocoltypes.MultiMemberGroupHistorySharingUpdated{
+		Enabled: enabled,
+	}, protocoltypes.EventType_EventTypeMultiMemberGroupHistorySharingUpdated)
+}
+
+// GrantAdminRole grants the admin role to a member of the group, the current
+// member must be an admin
+func (m *MetadataStore) GrantAdminRole(ctx context.Context, memberPK crypto.PubKey) (operation.Operation, error) {
+	if !m.typeChecker(isMultiMemberGroup) {
+		return nil, errcode.ErrCode_ErrGroupInvalidType
+	}
+
+	memberPKRaw, err := memberPK.Raw()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	return m.attributeSignAndAddEvent(ctx, &protocoltypes.MultiMemberGroupAdminRoleGranted{
+		GranteeMemberPk: memberPKRaw,
+	}, protocoltypes.EventType_EventTypeMultiMemberGroupAdminRoleGranted)
+}
+
+// SendPermissionsUpdated updates the roles required to send entries on the
+// group, the current member must be an admin
+func (m *MetadataStore) SendPermissionsUpdated(ctx context.Context, permissions *protocoltypes.GroupPermissions) (operation.Operation, error) {
+	if !m.typeChecker(isMultiMemberGroup) {
+		return nil, errcode.ErrCode_ErrGroupInvalidType
+	}
+
+	if permissions == nil {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("permissions are missing"))
+	}
+
+	return m.attributeSignAndAddEvent(ctx, &protocoltypes.MultiMemberGroupPermissionsUpdated{
+		Permissions: permissions,
+	}, protocoltypes.EventType_EventTypeMultiMemberGroupPermissionsUpdated)
+}
+
+// HideMessage hides a message for every member of the group, the current
+// member must be an admin
+func (m *MetadataStore) HideMessage(ctx context.Context, messageCID cid.Cid, reason string) (operation.Operation, error) {
+	if !m.typeChecker(isMultiMemberGroup) {
+		return nil, errcode.ErrCode_ErrGroupInvalidType
+	}
+
+	return m.attributeSignAndAddEvent(ctx, &protocoltypes.MultiMemberGroupMessageHidden{
+		MessageCid: messageCID.Bytes(),
+		Reason:     reason,
+	}, protocoltypes.EventType_EventTypeMultiMemberGroupMessageHidden)
+}
+
+// CommitTreeKEM replaces the keys of the path of the current device in the
+// ratchet tree of a group using the TreeKEM keying, the device joins the tree
+// if it is not part of it yet. Removing devices requires the admin role.
+func (m *MetadataStore) CommitTreeKEM(ctx context.Context, removedDevicePKs [][]byte) (operation.Operation, error) {
+	if !m.typeChecker(isMultiMemberGroup) || m.group.Keying != protocoltypes.GroupKeying_GroupKeyingTreeKEM {
+		return nil, errcode.ErrCode_ErrGroupInvalidType
+	}
+
+	index := m.Index().(*metadataStoreIndex)
+
+	if len(removedDevicePKs) > 0 {
+		if err := index.checkDeviceRole(m.devicePublicKeyRaw, protocoltypes.GroupRole_GroupRoleAdmin); err != nil {
+			return nil, err
+		}
+	}
+
+	tree, epochID := index.getTreeKEMState()
+	if tree.IsRemoved(m.devicePublicKeyRaw) {
+		return nil, errcode.ErrCode_ErrGroupPermissionDenied.Wrap(fmt.Errorf("the device has been removed from the ratchet tree"))
+	}
+
+	commit, err := m.secretStore.CommitTreeKEM(ctx, m.group, tree, epochID, removedDevicePKs)
+	if err != nil {
+		if errcode.Is(err, errcode.ErrCode_ErrInvalidInput) {
+			return nil, err
+		}
+
+		return nil, errcode.ErrCode_ErrCryptoKeyGeneration.Wrap(err)
+	}
+
+	return m.attributeSignAndAddEvent(ctx, &protocoltypes.MultiMemberGroupTreeKEMCommitted{
+		Commit: commit,
+	}, protocoltypes.EventType_EventTypeMultiMemberGroupTreeKEMCommitted)
+}
+
+// GetTreeKEMDevices returns the devices of the ratchet tree of a group using
+// the TreeKEM keying along with whether the current device has been removed
+// from it
+func (m *MetadataStore) GetTreeKEMDevices() (devices [][]byte, removed bool) {
+	tree, _ := m.Index().(*metadataStoreIndex).getTreeKEMState()
+
+	return tree.Devices(), tree.IsRemoved(m.devicePublicKeyRaw)
+}
+
+// ShareHistory sends to a member the chain keys needed to decrypt the
+// messages sent before they joined
+func (m *MetadataStore) ShareHistory(ctx context.Context, memberPK crypto.PubKey) (operation.Operation, error) {
+	if !m.typeChecker(isMultiMemberGroup) {
+		return nil, errcode.ErrCode_ErrGroupInvalidType
+	}
+
+	if !m.IsHistorySharingEnabled() {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("history sharing is not enabled on the group"))
+	}
+
+	if devs, err := m.GetDevicesForMember(memberPK); len(devs) == 0 || err != nil {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("member is not part of the group"))
+	}
+
+	memberPKRaw, err := memberPK.Raw()
+	if err != nil {
+		return nil, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	encryptedHistoryChainKeys, err := m.secretStore.GetShareableHistoryChainKeys(ctx, m.group, memberPK)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoEncrypt.Wrap(err)
+	}
+
+	event := &protocoltypes.MultiMemberGroupHistoryChainKeysShared{
+		DevicePk:     m.devicePublicKeyRaw,
+		DestMemberPk: memberPKRaw,
+		Payload:      encryptedHistoryChainKeys,
+	}
+
+	sig, err := signProtoWithDevice(event, m.memberDevice)
+	if err != nil {
+		return nil, errcode.ErrCode_ErrCryptoSignature.Wrap(err)
+	}
+
+	return metadataStoreAddEvent(ctx, m, m.group, protocoltypes.EventType_EventTypeMultiMemberGroupHistoryChainKeysShared, event, sig)
+}
+
 type accountSignableEvent interface {
 	proto.Message
 	SetDevicePK([]byte)
@@ -895,10 +1176,11 @@ func constructorFactoryGroupMetadata(s *WeshOrbitDB, logger *zap.Logger) iface.S
 		}
 
 		store := &MetadataStore{
-			eventBus:    options.EventBus,
-			group:       g,
-			logger:      logger,
-			secretStore: s.secretStore,
+			eventBus:         options.EventBus,
+			group:            g,
+			logger:           logger,
+			secretStore:      s.secretStore,
+			rotationInterval: s.rotationInterval,
 		}
 
 		if s.replicationMode {
@@ -980,6 +1262,11 @@ func constructorFactoryGroupMetadata(s *WeshOrbitDB, logger *zap.Logger) iface.S
 						continue
 					}
 
+					if store.Index().(*metadataStoreIndex).isEventDenied(entry.GetHash().String()) {
+						_ = tyber.LogFatalError(ctx, store.logger, "Unauthorized metadata event", errcode.ErrCode_ErrGroupPermissionDenied, tyber.ForceReopen)
+						continue
+					}
+
 					tyber.LogStep(ctx, store.logger, "Opened metadata store event",
 						tyber.ForceReopen,
 						tyber.EndTrace,
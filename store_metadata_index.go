@@ -1,10 +1,13 @@
 package weshnet
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
@@ -15,6 +18,7 @@ import (
 	"berty.tech/weshnet/v2/pkg/errcode"
 	"berty.tech/weshnet/v2/pkg/protocoltypes"
 	"berty.tech/weshnet/v2/pkg/secretstore"
+	"berty.tech/weshnet/v2/pkg/treekem"
 )
 
 // FIXME: replace members, devices, sentSecrets, contacts and groups by a circular buffer to avoid an attack by RAM saturation
@@ -23,17 +27,30 @@ type metadataStoreIndex struct {
 	devices                  map[string]secretstore.MemberDevice
 	handledEvents            map[string]struct{}
 	sentSecrets              map[string]struct{}
-	admins                   map[crypto.PubKey]struct{}
+	admins                   map[string]crypto.PubKey
 	contacts                 map[string]*AccountContact
 	contactsFromGroupPK      map[string]*AccountContact
+	previousContactGroups    map[string]struct{}
 	groups                   map[string]*accountGroup
 	contactRequestMetadata   map[string][]byte
 	verifiedCredentials      []*protocoltypes.AccountVerifiedCredentialRegistered
 	contactRequestSeed       []byte
 	contactRequestEnabled    *bool
+	rotationInterval         *time.Duration
+	historySharingEnabled    *bool
+	permissions              *protocoltypes.GroupPermissions
+	rolePositions            *rolePositions
+	headEventHash            string
+	hiddenMessages           map[string]struct{}
+	deniedEvents             map[string]struct{}
+	treeKEMTree              *treekem.Tree
+	treeKEMEpochID           []byte
+	treeKEMRegisteredEpochs  map[string]struct{}
 	eventHandlers            map[protocoltypes.EventType][]func(event proto.Message) error
 	postIndexActions         []func() error
 	eventsContactAddAliasKey []*protocoltypes.ContactAliasKeyAdded
+	eventsAccountKeyRotated  []*protocoltypes.AccountKeyRotated
+	accountKeySuccessors     map[string][]byte
 	ownAliasKeySent          bool
 	otherAliasKey            []byte
 	group                    *protocoltypes.Group
@@ -66,13 +83,23 @@ func (m *metadataStoreIndex) UpdateIndex(log ipfslog.Log, _ []ipfslog.Entry) err
 	// Resetting state
 	m.contacts = map[string]*AccountContact{}
 	m.contactsFromGroupPK = map[string]*AccountContact{}
+	m.previousContactGroups = map[string]struct{}{}
 	m.groups = map[string]*accountGroup{}
 	m.contactRequestMetadata = map[string][]byte{}
 	m.contactRequestEnabled = nil
 	m.contactRequestSeed = []byte(nil)
 	m.verifiedCredentials = nil
+	m.rotationInterval = nil
+	m.historySharingEnabled = nil
+	m.hiddenMessages = map[string]struct{}{}
+	m.deniedEvents = map[string]struct{}{}
 	m.handledEvents = map[string]struct{}{}
+	m.eventsAccountKeyRotated = nil
+	m.accountKeySuccessors = map[string][]byte{}
 
+	m.headEventHash = ""
+
+	indexedEvents := make([]*indexedMetadataEvent, 0, len(entries))
 	for i := len(entries) - 1; i >= 0; i-- {
 		e := entries[i]
 
@@ -89,9 +116,34 @@ func (m *metadataStoreIndex) UpdateIndex(log ipfslog.Log, _ []ipfslog.Entry) err
 			continue
 		}
 
+		indexedEvents = append(indexedEvents, &indexedMetadataEvent{
+			hash:      e.GetHash().String(),
+			metaEvent: metaEvent,
+			event:     event,
+		})
+
+		if m.headEventHash == "" {
+			m.headEventHash = e.GetHash().String()
+		}
+	}
+
+	// the other events are checked against the roles of their senders
+	m.indexRoles(indexedEvents)
+	m.indexTreeKEMCommits(indexedEvents)
+
+	for _, e := range indexedEvents {
+		metaEvent, event := e.metaEvent, e.event
+
+		if err := e.permissionErr; err != nil {
+			m.handledEvents[e.hash] = struct{}{}
+			m.deniedEvents[e.hash] = struct{}{}
+			m.logger.Warn("ignoring unauthorized event", zap.String("event-type", metaEvent.Metadata.EventType.String()), zap.Error(err))
+			continue
+		}
+
 		handlers, ok := m.eventHandlers[metaEvent.Metadata.EventType]
 		if !ok {
-			m.handledEvents[e.GetHash().String()] = struct{}{}
+			m.handledEvents[e.hash] = struct{}{}
 			m.logger.Error("handler for event type not found", zap.String("event-type", metaEvent.Metadata.EventType.String()))
 			continue
 		}
@@ -99,7 +151,7 @@ func (m *metadataStoreIndex) UpdateIndex(log ipfslog.Log, _ []ipfslog.Entry) err
 		var lastErr error
 
 		for _, h := range handlers {
-			err = h(event)
+			err := h(event)
 			if err != nil {
 				m.logger.Error("unable to handle event", zap.Error(err))
 				lastErr = err
@@ -107,11 +159,11 @@ func (m *metadataStoreIndex) UpdateIndex(log ipfslog.Log, _ []ipfslog.Entry) err
 		}
 
 		if lastErr != nil {
-			m.handledEvents[e.GetHash().String()] = struct{}{}
+			m.handledEvents[e.hash] = struct{}{}
 			continue
 		}
 
-		m.handledEvents[e.GetHash().String()] = struct{}{}
+		m.handledEvents[e.hash] = struct{}{}
 	}
 
 	for _, h := range m.postIndexActions {
@@ -622,30 +674,142 @@ func (m *metadataStoreIndex) handleContactAliasKeyAdded(event proto.Message) err
 	return nil
 }
 
-func (m *metadataStoreIndex) handleMultiMemberInitialMember(event proto.Message) error {
-	e, ok := event.(*protocoltypes.MultiMemberGroupInitialMemberAnnounced)
-	if !ok {
+// handleAccountKeyRotated only collects the successions, they are applied by
+// postHandlerAccountKeySuccessions once the contacts and the devices they
+// replace are indexed
+func (m *metadataStoreIndex) handleAccountKeyRotated(event proto.Message) error {
+	evt, ok := event.(*protocoltypes.AccountKeyRotated)
+	if !ok || evt.Succession == nil {
 		return errcode.ErrCode_ErrInvalidInput
 	}
 
-	pk, err := crypto.UnmarshalEd25519PublicKey(e.MemberPk)
+	m.eventsAccountKeyRotated = append(m.eventsAccountKeyRotated, evt)
+
+	return nil
+}
+
+// postHandlerAccountKeySuccessions replays the account key successions from
+// the oldest one, so a key rotated several times ends up on its latest value,
+// the contact groups derived from the replaced keys are kept aside as their
+// history remains readable
+func (m *metadataStoreIndex) postHandlerAccountKeySuccessions() error {
+	for i := len(m.eventsAccountKeyRotated) - 1; i >= 0; i-- {
+		evt := m.eventsAccountKeyRotated[i]
+		previous, next := evt.Succession.PreviousAccountPk, evt.Succession.AccountPk
+
+		if m.group.GroupType == protocoltypes.GroupType_GroupTypeAccount {
+			for _, groupPK := range evt.PreviousContactGroupPks {
+				m.previousContactGroups[string(groupPK)] = struct{}{}
+			}
+		}
+
+		var err error
+
+		// on the account group, the succession of a contact key is relayed
+		// by one of our devices
+		if ac, ok := m.contacts[string(previous)]; ok && m.group.GroupType == protocoltypes.GroupType_GroupTypeAccount {
+			err = m.replaceContactKey(ac, previous, next)
+		} else {
+			err = m.replaceMemberKey(evt.DevicePk, previous, next)
+		}
+
+		if err != nil {
+			m.logger.Warn("unable to apply account key succession", zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// replaceContactKey moves a contact to its new account key, the contact
+// group derived from the new key is registered alongside the previous one.
+// The events sent after the rotation, using the new key, take precedence.
+func (m *metadataStoreIndex) replaceContactKey(ac *AccountContact, previous, next []byte) error {
+	migrated, ok := m.contacts[string(next)]
+	if ok {
+		if migrated.contact.Metadata == nil {
+			migrated.contact.Metadata = ac.contact.Metadata
+		}
+
+		if migrated.contact.PublicRendezvousSeed == nil {
+			migrated.contact.PublicRendezvousSeed = ac.contact.PublicRendezvousSeed
+		}
+	} else {
+		contact := proto.Clone(ac.contact).(*protocoltypes.ShareableContact)
+		contact.Pk = next
+
+		migrated = &AccountContact{state: ac.state, contact: contact}
+		m.contacts[string(next)] = migrated
+	}
+
+	delete(m.contacts, string(previous))
+
+	if data, ok := m.contactRequestMetadata[string(previous)]; ok {
+		delete(m.contactRequestMetadata, string(previous))
+		if _, ok := m.contactRequestMetadata[string(next)]; !ok {
+			m.contactRequestMetadata[string(next)] = data
+		}
+	}
+
+	for groupPK, c := range m.contactsFromGroupPK {
+		if c == ac {
+			m.contactsFromGroupPK[groupPK] = migrated
+			m.previousContactGroups[groupPK] = struct{}{}
+		}
+	}
+
+	return m.registerContactFromGroupPK(migrated)
+}
+
+// replaceMemberKey moves the devices of a member to its new account key, the
+// event must have been sent by one of these devices. The devices are kept
+// between two indexings, they may already have been moved.
+func (m *metadataStoreIndex) replaceMemberKey(devicePK, previous, next []byte) error {
+	if _, ok := m.accountKeySuccessors[string(previous)]; ok {
+		return nil
+	}
+
+	nextPK, err := crypto.UnmarshalEd25519PublicKey(next)
+	if err != nil {
+		return errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	previousPK, err := crypto.UnmarshalEd25519PublicKey(previous)
 	if err != nil {
 		return errcode.ErrCode_ErrDeserialization.Wrap(err)
 	}
 
-	if _, ok := m.admins[pk]; ok {
-		return errcode.ErrCode_ErrInternal
+	md, ok := m.devices[string(devicePK)]
+	if ok && md.Member().Equals(nextPK) && len(m.members[string(previous)]) == 0 {
+		m.accountKeySuccessors[string(previous)] = next
+		return nil
+	}
+
+	if !ok || !md.Member().Equals(previousPK) {
+		return errcode.ErrCode_ErrGroupMemberLogEventSignature.Wrap(fmt.Errorf("the account key succession has not been sent by a device of the previous key"))
 	}
 
-	m.admins[pk] = struct{}{}
+	for _, md := range m.members[string(previous)] {
+		deviceBytes, err := md.Device().Raw()
+		if err != nil {
+			return errcode.ErrCode_ErrSerialization.Wrap(err)
+		}
+
+		memberDevice := secretstore.NewMemberDevice(nextPK, md.Device())
+
+		m.devices[string(deviceBytes)] = memberDevice
+		m.members[string(next)] = append(m.members[string(next)], memberDevice)
+	}
+
+	delete(m.members, string(previous))
+	m.accountKeySuccessors[string(previous)] = next
 
 	return nil
 }
 
-//nolint:revive
-func (m *metadataStoreIndex) handleMultiMemberGrantAdminRole(event proto.Message) error {
-	// TODO:
-
+// handleMultiMemberRoleEvent does nothing, the roles are indexed by
+// indexRoles before the other events
+func (m *metadataStoreIndex) handleMultiMemberRoleEvent(_ proto.Message) error {
 	return nil
 }
 
@@ -664,16 +828,571 @@ func (m *metadataStoreIndex) handleAccountVerifiedCredentialRegistered(event pro
 	return nil
 }
 
+func (m *metadataStoreIndex) handleGroupRendezvousRotationIntervalUpdated(event proto.Message) error {
+	e, ok := event.(*protocoltypes.GroupRendezvousRotationIntervalUpdated)
+	if !ok {
+		return errcode.ErrCode_ErrInvalidInput
+	}
+
+	// only the most recent value is kept
+	if m.rotationInterval != nil {
+		return nil
+	}
+
+	// invalid values are skipped so an older valid value can be used
+	interval, err := rendezvousRotationIntervalFromSeconds(e.IntervalSeconds)
+	if err != nil {
+		return err
+	}
+
+	m.rotationInterval = &interval
+
+	return nil
+}
+
+func (m *metadataStoreIndex) handleMultiMemberGroupHistorySharingUpdated(event proto.Message) error {
+	e, ok := event.(*protocoltypes.MultiMemberGroupHistorySharingUpdated)
+	if !ok {
+		return errcode.ErrCode_ErrInvalidInput
+	}
+
+	// only the most recent value is kept
+	if m.historySharingEnabled != nil {
+		return nil
+	}
+
+	enabled := e.Enabled
+	m.historySharingEnabled = &enabled
+
+	return nil
+}
+
+func (m *metadataStoreIndex) handleMultiMemberGroupMessageHidden(event proto.Message) error {
+	e, ok := event.(*protocoltypes.MultiMemberGroupMessageHidden)
+	if !ok {
+		return errcode.ErrCode_ErrInvalidInput
+	}
+
+	_, id, err := cid.CidFromBytes(e.MessageCid)
+	if err != nil {
+		return errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	m.hiddenMessages[id.KeyString()] = struct{}{}
+
+	return nil
+}
+
+// handleMultiMemberGroupHistoryChainKeysShared does nothing, the chain keys
+// are registered by the group context
+func (m *metadataStoreIndex) handleMultiMemberGroupHistoryChainKeysShared(_ proto.Message) error {
+	return nil
+}
+
+// handleMultiMemberGroupTreeKEMCommitted does nothing, the commits are
+// indexed by indexTreeKEMCommits before the other events
+func (m *metadataStoreIndex) handleMultiMemberGroupTreeKEMCommitted(_ proto.Message) error {
+	return nil
+}
+
+// indexedMetadataEvent is a metadata entry opened by UpdateIndex
+type indexedMetadataEvent struct {
+	hash      string
+	metaEvent *protocoltypes.GroupMetadataEvent
+	event     proto.Message
+
+	// permissionErr is set by indexRoles if the sender of the event wasn't
+	// allowed to send it when it was written
+	permissionErr error
+}
+
+// rolePositions locates the role changes of a multi-member group in the replay
+// order of its metadata log, a message is checked against the roles at the
+// position of the most recent metadata event known by its sender
+type rolePositions struct {
+	events           map[string]int
+	admins           map[string]int
+	messageSendRoles []positionedRole
+	treeKEMRemovals  map[string]int
+}
+
+type positionedRole struct {
+	position int
+	role     protocoltypes.GroupRole
+}
+
+func newRolePositions() *rolePositions {
+	return &rolePositions{
+		events:          map[string]int{},
+		admins:          map[string]int{},
+		treeKEMRemovals: map[string]int{},
+	}
+}
+
+// messageSendRole returns the role required to send messages at the given
+// position
+func (p *rolePositions) messageSendRole(position int) protocoltypes.GroupRole {
+	role := protocoltypes.GroupRole_GroupRoleMember
+	for _, r := range p.messageSendRoles {
+		if r.position > position {
+			break
+		}
+
+		role = r.role
+	}
+
+	return role
+}
+
+// isAdmin returns whether the given member was an admin at the given position
+func (p *rolePositions) isAdmin(memberPublicKeyBytes []byte, position int) bool {
+	granted, ok := p.admins[string(memberPublicKeyBytes)]
+	return ok && granted <= position
+}
+
+// indexRoles resolves the admins and the permissions of a multi-member
+// group, the events are replayed from the oldest one so each event is
+// checked against the roles and the permissions preceding it in the log
+func (m *metadataStoreIndex) indexRoles(events []*indexedMetadataEvent) {
+	if m.group.GroupType != protocoltypes.GroupType_GroupTypeMultiMember {
+		return
+	}
+
+	m.admins = map[string]crypto.PubKey{}
+	m.permissions = nil
+	m.rolePositions = newRolePositions()
+	initialMemberAnnounced := false
+
+	for i := len(events) - 1; i >= 0; i-- {
+		position := len(events) - 1 - i
+		m.rolePositions.events[events[i].hash] = position
+
+		events[i].permissionErr = m.unsafeCheckEventPermission(events[i].metaEvent.Metadata.EventType, events[i].event)
+
+		var (
+			admin []byte
+			err   error
+		)
+
+		switch e := events[i].event.(type) {
+		case *protocoltypes.GroupMemberDeviceAdded:
+			err = m.handleGroupMemberDeviceAdded(e)
+		case *protocoltypes.MultiMemberGroupInitialMemberAnnounced:
+			// only the first announcement seeds the ownership of the group
+			if initialMemberAnnounced {
+				err = errcode.ErrCode_ErrGroupPermissionDenied.Wrap(fmt.Errorf("the initial member has already been announced"))
+				events[i].permissionErr = err
+				break
+			}
+
+			initialMemberAnnounced = true
+			admin, err = e.MemberPk, m.indexInitialMember(e)
+		case *protocoltypes.MultiMemberGroupAdminRoleGranted:
+			admin, err = e.GranteeMemberPk, m.indexAdminRoleGranted(e)
+		case *protocoltypes.MultiMemberGroupPermissionsUpdated:
+			if err = m.indexPermissionsUpdated(e); err == nil {
+				m.rolePositions.messageSendRoles = append(m.rolePositions.messageSendRoles, positionedRole{position, e.Permissions.GetMessageSend()})
+			}
+		}
+
+		if err != nil {
+			m.logger.Warn("unable to index role event", zap.Error(err))
+			continue
+		}
+
+		if _, ok := m.rolePositions.admins[string(admin)]; admin != nil && !ok {
+			m.rolePositions.admins[string(admin)] = position
+		}
+	}
+}
+
+func (m *metadataStoreIndex) indexInitialMember(e *protocoltypes.MultiMemberGroupInitialMemberAnnounced) error {
+	pk, err := crypto.UnmarshalEd25519PublicKey(e.MemberPk)
+	if err != nil {
+		return errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	m.admins[string(e.MemberPk)] = pk
+
+	return nil
+}
+
+func (m *metadataStoreIndex) indexAdminRoleGranted(e *protocoltypes.MultiMemberGroupAdminRoleGranted) error {
+	if err := m.unsafeCheckDeviceRole(e.DevicePk, protocoltypes.GroupRole_GroupRoleAdmin); err != nil {
+		return err
+	}
+
+	pk, err := crypto.UnmarshalEd25519PublicKey(e.GranteeMemberPk)
+	if err != nil {
+		return errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	m.admins[string(e.GranteeMemberPk)] = pk
+
+	return nil
+}
+
+func (m *metadataStoreIndex) indexPermissionsUpdated(e *protocoltypes.MultiMemberGroupPermissionsUpdated) error {
+	if err := m.unsafeCheckDeviceRole(e.DevicePk, protocoltypes.GroupRole_GroupRoleAdmin); err != nil {
+		return err
+	}
+
+	// only the most recent value is kept
+	m.permissions = e.Permissions
+
+	return nil
+}
+
+// indexTreeKEMCommits replays the commits of the ratchet tree of a group
+// using the TreeKEM keying from the oldest one, a commit is only accepted if
+// it applies to the latest accepted epoch so concurrent commits are resolved
+// using the order of the log
+func (m *metadataStoreIndex) indexTreeKEMCommits(events []*indexedMetadataEvent) {
+	if m.group.GroupType != protocoltypes.GroupType_GroupTypeMultiMember || m.group.Keying != protocoltypes.GroupKeying_GroupKeyingTreeKEM {
+		return
+	}
+
+	tree := treekem.NewTree()
+	epochID := []byte(nil)
+
+	for i := len(events) - 1; i >= 0; i-- {
+		e, ok := events[i].event.(*protocoltypes.MultiMemberGroupTreeKEMCommitted)
+		if !ok {
+			continue
+		}
+
+		next, err := m.indexTreeKEMCommit(tree, epochID, e)
+		if err != nil {
+			m.logger.Warn("ignoring ratchet tree commit", zap.Error(err))
+			continue
+		}
+
+		if m.rolePositions != nil {
+			for _, device := range tree.Devices() {
+				if next.IsRemoved(device) {
+					m.rolePositions.treeKEMRemovals[string(device)] = len(events) - 1 - i
+				}
+			}
+		}
+
+		tree, epochID = next, treekem.EpochID(e.Commit)
+	}
+
+	m.treeKEMTree = tree
+
+	if bytes.Equal(m.treeKEMEpochID, epochID) {
+		return
+	}
+
+	if err := m.secretStore.SetTreeKEMEpoch(m.ctx, m.group, epochID); err != nil {
+		m.logger.Error("unable to set ratchet tree epoch", zap.Error(err))
+		return
+	}
+
+	m.treeKEMEpochID = epochID
+}
+
+// indexTreeKEMCommit checks a commit against the tree of the current epoch and
+// returns the tree of the epoch it creates, the epoch secret is registered if
+// the current device is part of it
+func (m *metadataStoreIndex) indexTreeKEMCommit(tree *treekem.Tree, epochID []byte, e *protocoltypes.MultiMemberGroupTreeKEMCommitted) (*treekem.Tree, error) {
+	commit := &protocoltypes.TreeKEMCommit{}
+	if err := proto.Unmarshal(e.Commit, commit); err != nil {
+		return nil, errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	if !bytes.Equal(commit.ParentEpochId, epochID) {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("the commit doesn't apply to the current epoch"))
+	}
+
+	if !bytes.Equal(commit.CommitterDevicePk, e.DevicePk) {
+		return nil, errcode.ErrCode_ErrInvalidInput.Wrap(fmt.Errorf("the commit must be sent by its committer"))
+	}
+
+	for _, devicePublicKeyBytes := range append([][]byte{commit.CommitterDevicePk}, commit.AddedDevicePks...) {
+		if _, ok := m.devices[string(devicePublicKeyBytes)]; !ok {
+			return nil, errcode.ErrCode_ErrGroupPermissionDenied.Wrap(fmt.Errorf("unknown device"))
+		}
+	}
+
+	if len(commit.RemovedDevicePks) > 0 {
+		if err := m.unsafeCheckDeviceRole(e.DevicePk, protocoltypes.GroupRole_GroupRoleAdmin); err != nil {
+			return nil, err
+		}
+	}
+
+	next, err := tree.Apply(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	// the commits are processed once, the devices outside of the tree can't
+	// decrypt them
+	id := string(treekem.EpochID(e.Commit))
+	if _, ok := m.treeKEMRegisteredEpochs[id]; !ok {
+		if err := m.secretStore.RegisterTreeKEMCommit(m.ctx, m.group, tree, e.Commit); err != nil && !errcode.Is(err, errcode.ErrCode_ErrNotFound) {
+			m.logger.Error("unable to register ratchet tree commit", zap.Error(err))
+		}
+
+		m.treeKEMRegisteredEpochs[id] = struct{}{}
+	}
+
+	return next, nil
+}
+
+// requiredRoleForEvent returns the role needed to send an event on a
+// multi-member group having the given permissions
+func requiredRoleForEvent(permissions *protocoltypes.GroupPermissions, eventType protocoltypes.EventType) protocoltypes.GroupRole {
+	switch eventType {
+	case protocoltypes.EventType_EventTypeMultiMemberGroupAdminRoleGranted,
+		protocoltypes.EventType_EventTypeMultiMemberGroupPermissionsUpdated,
+		protocoltypes.EventType_EventTypeMultiMemberGroupMessageHidden:
+		return protocoltypes.GroupRole_GroupRoleAdmin
+
+	case protocoltypes.EventType_EventTypeGroupMetadataPayloadSent:
+		return permissions.GetMetadataSend()
+
+	case protocoltypes.EventType_EventTypeGroupRendezvousRotationIntervalUpdated,
+		protocoltypes.EventType_EventTypeMultiMemberGroupHistorySharingUpdated:
+		return permissions.GetSettingsUpdate()
+	}
+
+	return protocoltypes.GroupRole_GroupRoleMember
+}
+
+func (m *metadataStoreIndex) unsafeGetMemberRole(memberPublicKeyBytes []byte) protocoltypes.GroupRole {
+	for _, key := range m.unsafeAdminKeys(memberPublicKeyBytes) {
+		if _, ok := m.admins[string(key)]; ok {
+			return protocoltypes.GroupRole_GroupRoleAdmin
+		}
+	}
+
+	return protocoltypes.GroupRole_GroupRoleMember
+}
+
+// unsafeAdminKeys returns the keys under which a member may have been made an
+// admin, the initial member used to be announced with the key of its device
+func (m *metadataStoreIndex) unsafeAdminKeys(memberPublicKeyBytes []byte) [][]byte {
+	keys := [][]byte{memberPublicKeyBytes}
+	for _, md := range m.members[string(memberPublicKeyBytes)] {
+		if devicePublicKeyBytes, err := md.Device().Raw(); err == nil {
+			keys = append(keys, devicePublicKeyBytes)
+		}
+	}
+
+	return keys
+}
+
+// unsafeCheckDeviceRole returns an error if the member of the device doesn't
+// have the given role, roles are only enforced on multi-member groups
+func (m *metadataStoreIndex) unsafeCheckDeviceRole(devicePublicKeyBytes []byte, role protocoltypes.GroupRole) error {
+	if m.group.GroupType != protocoltypes.GroupType_GroupTypeMultiMember || role == protocoltypes.GroupRole_GroupRoleMember {
+		return nil
+	}
+
+	// the initial member used to be announced with the key of its device
+	if m.unsafeGetMemberRole(devicePublicKeyBytes) == role {
+		return nil
+	}
+
+	member, err := m.unsafeGetMemberByDevice(devicePublicKeyBytes)
+	if err != nil {
+		return errcode.ErrCode_ErrGroupPermissionDenied.Wrap(fmt.Errorf("unknown device"))
+	}
+
+	memberPublicKeyBytes, err := member.Raw()
+	if err != nil {
+		return errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	if m.unsafeGetMemberRole(memberPublicKeyBytes) != role {
+		return errcode.ErrCode_ErrGroupPermissionDenied.Wrap(fmt.Errorf("the member doesn't have the %s role", role))
+	}
+
+	return nil
+}
+
+func (m *metadataStoreIndex) unsafeCheckEventPermission(eventType protocoltypes.EventType, event proto.Message) error {
+	role := requiredRoleForEvent(m.permissions, eventType)
+	if m.group.GroupType != protocoltypes.GroupType_GroupTypeMultiMember || role == protocoltypes.GroupRole_GroupRoleMember {
+		return nil
+	}
+
+	e, ok := event.(eventDeviceSigned)
+	if !ok {
+		return errcode.ErrCode_ErrGroupPermissionDenied.Wrap(fmt.Errorf("the event is not signed by a device"))
+	}
+
+	return m.unsafeCheckDeviceRole(e.GetDevicePk(), role)
+}
+
+// checkEventPermission returns an error if the sender of the event is not
+// allowed to send it on the group
+func (m *metadataStoreIndex) checkEventPermission(eventType protocoltypes.EventType, event proto.Message) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.unsafeCheckEventPermission(eventType, event)
+}
+
+// checkDeviceRole returns an error if the member of the device doesn't have
+// the given role
+func (m *metadataStoreIndex) checkDeviceRole(devicePublicKeyBytes []byte, role protocoltypes.GroupRole) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.unsafeCheckDeviceRole(devicePublicKeyBytes, role)
+}
+
+// checkMessagePermission returns an error if the device is not allowed to
+// send messages on the group, the roles are the ones at the given metadata
+// event, or the current ones if unset
+func (m *metadataStoreIndex) checkMessagePermission(devicePublicKeyBytes []byte, metadataHead []byte) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if len(metadataHead) == 0 || m.rolePositions == nil {
+		if m.treeKEMTree != nil && m.treeKEMTree.IsRemoved(devicePublicKeyBytes) {
+			return errcode.ErrCode_ErrGroupPermissionDenied.Wrap(fmt.Errorf("the device has been removed from the ratchet tree"))
+		}
+
+		return m.unsafeCheckDeviceRole(devicePublicKeyBytes, m.permissions.GetMessageSend())
+	}
+
+	head, err := cid.Cast(metadataHead)
+	if err != nil {
+		return errcode.ErrCode_ErrDeserialization.Wrap(err)
+	}
+
+	// the message is checked again once the event is received
+	position, ok := m.rolePositions.events[head.String()]
+	if !ok {
+		return errcode.ErrCode_ErrGroupPermissionDenied.Wrap(fmt.Errorf("the metadata event preceding the message is unknown"))
+	}
+
+	if removed, ok := m.rolePositions.treeKEMRemovals[string(devicePublicKeyBytes)]; ok && removed <= position {
+		return errcode.ErrCode_ErrGroupPermissionDenied.Wrap(fmt.Errorf("the device had been removed from the ratchet tree"))
+	}
+
+	if m.rolePositions.messageSendRole(position) == protocoltypes.GroupRole_GroupRoleMember {
+		return nil
+	}
+
+	// the initial member used to be announced with the key of its device
+	if m.rolePositions.isAdmin(devicePublicKeyBytes, position) {
+		return nil
+	}
+
+	member, err := m.unsafeGetMemberByDevice(devicePublicKeyBytes)
+	if err != nil {
+		return errcode.ErrCode_ErrGroupPermissionDenied.Wrap(fmt.Errorf("unknown device"))
+	}
+
+	memberPublicKeyBytes, err := member.Raw()
+	if err != nil {
+		return errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	for _, key := range m.unsafeAdminKeys(memberPublicKeyBytes) {
+		if m.rolePositions.isAdmin(key, position) {
+			return nil
+		}
+	}
+
+	return errcode.ErrCode_ErrGroupPermissionDenied.Wrap(fmt.Errorf("the member wasn't an admin when sending the message"))
+}
+
+// getHeadEvent returns the CID of the most recent event of the metadata log
+func (m *metadataStoreIndex) getHeadEvent() []byte {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	head, err := cid.Decode(m.headEventHash)
+	if err != nil {
+		return nil
+	}
+
+	return head.Bytes()
+}
+
+// getTreeKEMState returns a copy of the ratchet tree of the group along with
+// the identifier of its current epoch
+func (m *metadataStoreIndex) getTreeKEMState() (*treekem.Tree, []byte) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.treeKEMTree == nil {
+		return treekem.NewTree(), nil
+	}
+
+	return m.treeKEMTree.Clone(), m.treeKEMEpochID
+}
+
+// isEventDenied returns whether the sender of the metadata entry with the
+// given hash wasn't allowed to send it when it was written
+func (m *metadataStoreIndex) isEventDenied(hash string) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	_, ok := m.deniedEvents[hash]
+	return ok
+}
+
+func (m *metadataStoreIndex) isMessageHidden(id cid.Cid) bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	_, ok := m.hiddenMessages[id.KeyString()]
+	return ok
+}
+
+func (m *metadataStoreIndex) getPermissions() *protocoltypes.GroupPermissions {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.permissions == nil {
+		return &protocoltypes.GroupPermissions{}
+	}
+
+	return proto.Clone(m.permissions).(*protocoltypes.GroupPermissions)
+}
+
+func (m *metadataStoreIndex) getMemberRole(memberPublicKey crypto.PubKey) (protocoltypes.GroupRole, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	memberPublicKeyBytes, err := memberPublicKey.Raw()
+	if err != nil {
+		return protocoltypes.GroupRole_GroupRoleMember, errcode.ErrCode_ErrSerialization.Wrap(err)
+	}
+
+	return m.unsafeGetMemberRole(memberPublicKeyBytes), nil
+}
+
 func (m *metadataStoreIndex) listAdmins() []crypto.PubKey {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
-	admins := make([]crypto.PubKey, len(m.admins))
-	i := 0
+	admins := make([]crypto.PubKey, 0, len(m.admins))
+	listed := map[string]struct{}{}
 
-	for admin := range m.admins {
-		admins[i] = admin
-		i++
+	for key, admin := range m.admins {
+		// the initial member used to be announced with the key of its device
+		if md, ok := m.devices[key]; ok {
+			admin = md.Member()
+		}
+
+		adminBytes, err := admin.Raw()
+		if err != nil {
+			continue
+		}
+
+		if _, ok := listed[string(adminBytes)]; ok {
+			continue
+		}
+
+		listed[string(adminBytes)] = struct{}{}
+		admins = append(admins, admin)
 	}
 
 	return admins
@@ -721,6 +1440,24 @@ func (m *metadataStoreIndex) contactRequestsSeed() []byte {
 	return m.contactRequestSeed
 }
 
+func (m *metadataStoreIndex) getRendezvousRotationInterval() time.Duration {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.rotationInterval == nil {
+		return 0
+	}
+
+	return *m.rotationInterval
+}
+
+func (m *metadataStoreIndex) isHistorySharingEnabled() bool {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.historySharingEnabled != nil && *m.historySharingEnabled
+}
+
 func (m *metadataStoreIndex) getContact(pk crypto.PubKey) (*AccountContact, error) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
@@ -767,20 +1504,24 @@ func (m *metadataStoreIndex) postHandlerSentAliases() error {
 func newMetadataIndex(ctx context.Context, g *protocoltypes.Group, md secretstore.MemberDevice, secretStore secretstore.SecretStore) iface.IndexConstructor {
 	return func(publicKey []byte) iface.StoreIndex {
 		m := &metadataStoreIndex{
-			members:                map[string][]secretstore.MemberDevice{},
-			devices:                map[string]secretstore.MemberDevice{},
-			admins:                 map[crypto.PubKey]struct{}{},
-			sentSecrets:            map[string]struct{}{},
-			handledEvents:          map[string]struct{}{},
-			contacts:               map[string]*AccountContact{},
-			contactsFromGroupPK:    map[string]*AccountContact{},
-			groups:                 map[string]*accountGroup{},
-			contactRequestMetadata: map[string][]byte{},
-			group:                  g,
-			ownMemberDevice:        md,
-			secretStore:            secretStore,
-			ctx:                    ctx,
-			logger:                 zap.NewNop(),
+			members:                 map[string][]secretstore.MemberDevice{},
+			devices:                 map[string]secretstore.MemberDevice{},
+			admins:                  map[string]crypto.PubKey{},
+			sentSecrets:             map[string]struct{}{},
+			handledEvents:           map[string]struct{}{},
+			contacts:                map[string]*AccountContact{},
+			contactsFromGroupPK:     map[string]*AccountContact{},
+			previousContactGroups:   map[string]struct{}{},
+			groups:                  map[string]*accountGroup{},
+			contactRequestMetadata:  map[string][]byte{},
+			accountKeySuccessors:    map[string][]byte{},
+			hiddenMessages:          map[string]struct{}{},
+			treeKEMRegisteredEpochs: map[string]struct{}{},
+			group:                   g,
+			ownMemberDevice:         md,
+			secretStore:             secretStore,
+			ctx:                     ctx,
+			logger:                  zap.NewNop(),
 		}
 
 		m.eventHandlers = map[protocoltypes.EventType][]func(event proto.Message) error{
@@ -795,17 +1536,26 @@ func newMetadataIndex(ctx context.Context, g *protocoltypes.Group, md secretstor
 			protocoltypes.EventType_EventTypeAccountContactRequestReferenceReset:    {m.handleContactRequestReferenceReset},
 			protocoltypes.EventType_EventTypeAccountContactUnblocked:                {m.handleContactUnblocked},
 			protocoltypes.EventType_EventTypeAccountGroupJoined:                     {m.handleGroupJoined},
+			protocoltypes.EventType_EventTypeAccountKeyRotated:                      {m.handleAccountKeyRotated},
 			protocoltypes.EventType_EventTypeAccountGroupLeft:                       {m.handleGroupLeft},
+			protocoltypes.EventType_EventTypeContactAccountKeyRotated:               {m.handleAccountKeyRotated},
 			protocoltypes.EventType_EventTypeContactAliasKeyAdded:                   {m.handleContactAliasKeyAdded},
 			protocoltypes.EventType_EventTypeGroupDeviceChainKeyAdded:               {m.handleGroupDeviceChainKeyAdded},
 			protocoltypes.EventType_EventTypeGroupMemberDeviceAdded:                 {m.handleGroupMemberDeviceAdded},
-			protocoltypes.EventType_EventTypeMultiMemberGroupAdminRoleGranted:       {m.handleMultiMemberGrantAdminRole},
-			protocoltypes.EventType_EventTypeMultiMemberGroupInitialMemberAnnounced: {m.handleMultiMemberInitialMember},
+			protocoltypes.EventType_EventTypeMultiMemberGroupAdminRoleGranted:       {m.handleMultiMemberRoleEvent},
+			protocoltypes.EventType_EventTypeMultiMemberGroupInitialMemberAnnounced: {m.handleMultiMemberRoleEvent},
+			protocoltypes.EventType_EventTypeMultiMemberGroupPermissionsUpdated:     {m.handleMultiMemberRoleEvent},
+			protocoltypes.EventType_EventTypeMultiMemberGroupMessageHidden:          {m.handleMultiMemberGroupMessageHidden},
+			protocoltypes.EventType_EventTypeMultiMemberGroupHistorySharingUpdated:  {m.handleMultiMemberGroupHistorySharingUpdated},
+			protocoltypes.EventType_EventTypeMultiMemberGroupHistoryChainKeysShared: {m.handleMultiMemberGroupHistoryChainKeysShared},
+			protocoltypes.EventType_EventTypeMultiMemberGroupTreeKEMCommitted:       {m.handleMultiMemberGroupTreeKEMCommitted},
 			protocoltypes.EventType_EventTypeGroupMetadataPayloadSent:               {m.handleGroupMetadataPayloadSent},
 			protocoltypes.EventType_EventTypeAccountVerifiedCredentialRegistered:    {m.handleAccountVerifiedCredentialRegistered},
+			protocoltypes.EventType_EventTypeGroupRendezvousRotationIntervalUpdated: {m.handleGroupRendezvousRotationIntervalUpdated},
 		}
 
 		m.postIndexActions = []func() error{
+			m.postHandlerAccountKeySuccessions,
 			m.postHandlerSentAliases,
 		}
 
@@ -16,7 +16,11 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/nacl/secretbox"
+	"google.golang.org/protobuf/proto"
 
+	"berty.tech/weshnet/v2/pkg/cryptoutil"
+	"berty.tech/weshnet/v2/pkg/errcode"
 	"berty.tech/weshnet/v2/pkg/ipfsutil"
 	"berty.tech/weshnet/v2/pkg/protocoltypes"
 	"berty.tech/weshnet/v2/pkg/testutil"
@@ -790,3 +794,470 @@ func TestFlappyMultiDevices_Basic(t *testing.T) {
 	groups = meta[pi[1][2]].ListMultiMemberGroups()
 	require.Len(t, groups, 1)
 }
+
+func TestMetadataGroupPermissions(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peers, groupSK, cleanup := CreatePeersWithGroupTest(ctx, t, "/tmp/member_test", 2, 1)
+	defer cleanup()
+
+	admin, member := peers[0].GC.MetadataStore(), peers[1].GC.MetadataStore()
+
+	for _, peer := range peers {
+		_, err := peer.GC.MetadataStore().AddDeviceToGroup(ctx)
+		require.NoError(t, err)
+	}
+
+	_, err := admin.ClaimGroupOwnership(ctx, groupSK)
+	require.NoError(t, err)
+
+	for _, ms := range []*MetadataStore{admin, member} {
+		require.Eventually(t, func() bool {
+			role, err := ms.GetMemberRole(peers[0].GC.MemberPubKey())
+			return err == nil && role == protocoltypes.GroupRole_GroupRoleAdmin && len(ms.ListDevices()) == len(peers)
+		}, 10*time.Second, 100*time.Millisecond)
+	}
+
+	// only the admins can update the permissions or grant roles
+	_, err = member.SendPermissionsUpdated(ctx, &protocoltypes.GroupPermissions{})
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrGroupPermissionDenied))
+
+	_, err = member.GrantAdminRole(ctx, peers[1].GC.MemberPubKey())
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrGroupPermissionDenied))
+
+	hasMessage := func(peer *mockedPeer, payload string) bool {
+		messages, err := peer.GC.MessageStore().ListEvents(ctx, nil, nil, false)
+		require.NoError(t, err)
+
+		found := false
+		for message := range messages {
+			found = found || bytes.Equal(message.Message, []byte(payload))
+		}
+
+		return found
+	}
+
+	_, err = peers[1].GC.MessageStore().AddMessage(ctx, []byte("before announcement"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool { return hasMessage(peers[0], "before announcement") }, 10*time.Second, 100*time.Millisecond)
+
+	// announcement-only group
+	_, err = admin.SendPermissionsUpdated(ctx, &protocoltypes.GroupPermissions{
+		MessageSend: protocoltypes.GroupRole_GroupRoleAdmin,
+	})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return member.GetPermissions().MessageSend == protocoltypes.GroupRole_GroupRoleAdmin
+	}, 10*time.Second, 100*time.Millisecond)
+
+	_, err = peers[1].GC.MessageStore().AddMessage(ctx, []byte("from member"))
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrGroupPermissionDenied))
+
+	_, err = peers[0].GC.MessageStore().AddMessage(ctx, []byte("from admin"))
+	require.NoError(t, err)
+
+	// the messages sent before the permissions changed are kept
+	require.True(t, hasMessage(peers[0], "before announcement"))
+	require.True(t, hasMessage(peers[1], "before announcement"))
+
+	// the app metadata is still allowed
+	_, err = member.SendAppMetadata(ctx, []byte("from member"))
+	require.NoError(t, err)
+
+	// the events sent before the permissions changed are kept
+	_, err = admin.SendPermissionsUpdated(ctx, &protocoltypes.GroupPermissions{
+		MessageSend:  protocoltypes.GroupRole_GroupRoleAdmin,
+		MetadataSend: protocoltypes.GroupRole_GroupRoleAdmin,
+	})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return member.GetPermissions().MetadataSend == protocoltypes.GroupRole_GroupRoleAdmin
+	}, 10*time.Second, 100*time.Millisecond)
+
+	events, err := member.ListEvents(ctx, nil, nil, false)
+	require.NoError(t, err)
+
+	appMetadata := 0
+	for evt := range events {
+		if evt.Metadata.EventType == protocoltypes.EventType_EventTypeGroupMetadataPayloadSent {
+			appMetadata++
+		}
+	}
+	require.Equal(t, 1, appMetadata)
+
+	_, err = member.SendAppMetadata(ctx, []byte("from member"))
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrGroupPermissionDenied))
+
+	_, err = admin.GrantAdminRole(ctx, peers[1].GC.MemberPubKey())
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		role, err := member.GetMemberRole(peers[1].GC.MemberPubKey())
+		return err == nil && role == protocoltypes.GroupRole_GroupRoleAdmin
+	}, 10*time.Second, 100*time.Millisecond)
+
+	_, err = peers[1].GC.MessageStore().AddMessage(ctx, []byte("from new admin"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool { return hasMessage(peers[0], "from new admin") }, 10*time.Second, 100*time.Millisecond)
+}
+
+func TestMetadataGroupLegacyOwnershipClaim(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peers, groupSK, cleanup := CreatePeersWithGroupTest(ctx, t, "/tmp/member_test", 2, 1)
+	defer cleanup()
+
+	admin, member := peers[0].GC.MetadataStore(), peers[1].GC.MetadataStore()
+
+	for _, peer := range peers {
+		_, err := peer.GC.MetadataStore().AddDeviceToGroup(ctx)
+		require.NoError(t, err)
+	}
+
+	announce := func(m *MetadataStore, pk crypto.PubKey) {
+		pkRaw, err := pk.Raw()
+		require.NoError(t, err)
+
+		event := &protocoltypes.MultiMemberGroupInitialMemberAnnounced{MemberPk: pkRaw}
+		sig, err := signProtoWithPrivateKey(event, groupSK)
+		require.NoError(t, err)
+
+		_, err = metadataStoreAddEvent(ctx, m, m.group, protocoltypes.EventType_EventTypeMultiMemberGroupInitialMemberAnnounced, event, sig)
+		require.NoError(t, err)
+	}
+
+	// the initial member used to be announced with the key of its device
+	announce(admin, peers[0].GC.DevicePubKey())
+
+	for _, ms := range []*MetadataStore{admin, member} {
+		require.Eventually(t, func() bool {
+			role, err := ms.GetMemberRole(peers[0].GC.MemberPubKey())
+			return err == nil && role == protocoltypes.GroupRole_GroupRoleAdmin && len(ms.ListDevices()) == len(peers)
+		}, 10*time.Second, 100*time.Millisecond)
+
+		admins := ms.ListAdmins()
+		require.Len(t, admins, 1)
+		require.True(t, admins[0].Equals(peers[0].GC.MemberPubKey()))
+	}
+
+	_, err := admin.SendPermissionsUpdated(ctx, &protocoltypes.GroupPermissions{
+		MessageSend: protocoltypes.GroupRole_GroupRoleAdmin,
+	})
+	require.NoError(t, err)
+
+	// only the first announcement seeds the ownership
+	announce(member, peers[1].GC.MemberPubKey())
+
+	require.Eventually(t, func() bool {
+		return member.GetPermissions().MessageSend == protocoltypes.GroupRole_GroupRoleAdmin
+	}, 10*time.Second, 100*time.Millisecond)
+
+	for _, ms := range []*MetadataStore{admin, member} {
+		role, err := ms.GetMemberRole(peers[1].GC.MemberPubKey())
+		require.NoError(t, err)
+		require.Equal(t, protocoltypes.GroupRole_GroupRoleMember, role)
+	}
+
+	_, err = member.GrantAdminRole(ctx, peers[1].GC.MemberPubKey())
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrGroupPermissionDenied))
+}
+
+func TestMetadataGroupMessageHidden(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peers, groupSK, cleanup := CreatePeersWithGroupTest(ctx, t, "/tmp/member_test", 2, 1)
+	defer cleanup()
+
+	admin, member := peers[0].GC.MetadataStore(), peers[1].GC.MetadataStore()
+
+	for _, peer := range peers {
+		_, err := peer.GC.MetadataStore().AddDeviceToGroup(ctx)
+		require.NoError(t, err)
+	}
+
+	_, err := admin.ClaimGroupOwnership(ctx, groupSK)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		role, err := admin.GetMemberRole(peers[0].GC.MemberPubKey())
+		return err == nil && role == protocoltypes.GroupRole_GroupRoleAdmin && len(admin.ListDevices()) == len(peers)
+	}, 10*time.Second, 100*time.Millisecond)
+
+	op, err := peers[1].GC.MessageStore().AddMessage(ctx, []byte("abusive message"))
+	require.NoError(t, err)
+
+	messageCID := op.GetEntry().GetHash()
+
+	// only the admins can hide messages
+	_, err = member.HideMessage(ctx, messageCID, "")
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrGroupPermissionDenied))
+
+	_, err = admin.HideMessage(ctx, messageCID, "abuse")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return member.Index().(*metadataStoreIndex).isMessageHidden(messageCID)
+	}, 10*time.Second, 100*time.Millisecond)
+
+	messages, err := peers[1].GC.MessageStore().ListEvents(ctx, nil, nil, false)
+	require.NoError(t, err)
+
+	count := 0
+	for message := range messages {
+		count++
+		require.True(t, message.Hidden)
+		require.Nil(t, message.Message)
+	}
+	require.Equal(t, 1, count)
+
+	// the moderation event is part of the metadata of the group
+	events, err := member.ListEvents(ctx, nil, nil, false)
+	require.NoError(t, err)
+
+	found := false
+	for event := range events {
+		if event.Metadata.EventType != protocoltypes.EventType_EventTypeMultiMemberGroupMessageHidden {
+			continue
+		}
+
+		hidden := &protocoltypes.MultiMemberGroupMessageHidden{}
+		require.NoError(t, proto.Unmarshal(event.Event, hidden))
+		require.Equal(t, messageCID.Bytes(), hidden.MessageCid)
+		require.Equal(t, "abuse", hidden.Reason)
+		found = true
+	}
+	require.True(t, found)
+}
+
+func TestMetadataGroupMessageHiddenAfterDelivery(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*60)
+	defer cancel()
+
+	nodes, cleanup := NewTestingProtocolWithMockedPeers(ctx, t, &TestingOpts{}, nil, 2)
+	defer cleanup()
+
+	created, err := nodes[0].Client.MultiMemberGroupCreate(ctx, &protocoltypes.MultiMemberGroupCreate_Request{})
+	require.NoError(t, err)
+
+	invitation, err := nodes[0].Client.MultiMemberGroupInvitationCreate(ctx, &protocoltypes.MultiMemberGroupInvitationCreate_Request{GroupPk: created.GroupPk})
+	require.NoError(t, err)
+
+	_, err = nodes[1].Client.MultiMemberGroupJoin(ctx, &protocoltypes.MultiMemberGroupJoin_Request{Group: invitation.Group})
+	require.NoError(t, err)
+
+	_, err = nodes[1].Client.ActivateGroup(ctx, &protocoltypes.ActivateGroup_Request{GroupPk: created.GroupPk})
+	require.NoError(t, err)
+
+	subCtx, subCancel := context.WithCancel(ctx)
+	defer subCancel()
+
+	sub, err := nodes[0].Client.GroupMessageList(subCtx, &protocoltypes.GroupMessageList_Request{GroupPk: created.GroupPk, SinceNow: true})
+	require.NoError(t, err)
+
+	sent, err := nodes[1].Client.AppMessageSend(ctx, &protocoltypes.AppMessageSend_Request{GroupPk: created.GroupPk, Payload: []byte("abusive message")})
+	require.NoError(t, err)
+
+	nextMessage := func() *protocoltypes.GroupMessageEvent {
+		for {
+			evt, err := sub.Recv()
+			require.NoError(t, err)
+
+			if bytes.Equal(evt.EventContext.Id, sent.Cid) {
+				return evt
+			}
+		}
+	}
+
+	delivered := nextMessage()
+	require.False(t, delivered.Hidden)
+	require.Equal(t, []byte("abusive message"), delivered.Message)
+
+	_, err = nodes[0].Client.MultiMemberGroupMessageHide(ctx, &protocoltypes.MultiMemberGroupMessageHide_Request{
+		GroupPk:    created.GroupPk,
+		MessageCid: sent.Cid,
+		Reason:     "abuse",
+	})
+	require.NoError(t, err)
+
+	// the live subscribers are notified of the hidden message
+	hidden := nextMessage()
+	require.True(t, hidden.Hidden)
+	require.Nil(t, hidden.Message)
+}
+
+func TestMetadataGroupTreeKEM(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	nodes, cleanup := NewTestingProtocolWithMockedPeers(ctx, t, &TestingOpts{}, nil, 3)
+	defer cleanup()
+
+	created, err := nodes[0].Client.MultiMemberGroupCreate(ctx, &protocoltypes.MultiMemberGroupCreate_Request{Keying: protocoltypes.GroupKeying_GroupKeyingTreeKEM})
+	require.NoError(t, err)
+
+	invitation, err := nodes[0].Client.MultiMemberGroupInvitationCreate(ctx, &protocoltypes.MultiMemberGroupInvitationCreate_Request{GroupPk: created.GroupPk})
+	require.NoError(t, err)
+	require.Equal(t, protocoltypes.GroupKeying_GroupKeyingTreeKEM, invitation.Group.Keying)
+
+	for _, node := range nodes[1:] {
+		_, err := node.Client.MultiMemberGroupJoin(ctx, &protocoltypes.MultiMemberGroupJoin_Request{Group: invitation.Group})
+		require.NoError(t, err)
+
+		_, err = node.Client.ActivateGroup(ctx, &protocoltypes.ActivateGroup_Request{GroupPk: created.GroupPk})
+		require.NoError(t, err)
+	}
+
+	gcs := make([]*GroupContext, len(nodes))
+	for i, node := range nodes {
+		gcs[i], err = node.Service.(*service).GetContextGroupForID(created.GroupPk)
+		require.NoError(t, err)
+	}
+
+	// every device joins the ratchet tree instead of sending its chain key
+	require.Eventually(t, func() bool {
+		for _, gc := range gcs {
+			if devices, _ := gc.MetadataStore().GetTreeKEMDevices(); len(devices) != len(nodes) {
+				return false
+			}
+		}
+
+		return true
+	}, 30*time.Second, 100*time.Millisecond)
+
+	_, err = nodes[1].Client.AppMessageSend(ctx, &protocoltypes.AppMessageSend_Request{GroupPk: created.GroupPk, Payload: []byte("test")})
+	require.NoError(t, err)
+
+	hasMessage := func(gc *GroupContext) bool {
+		messages, err := gc.MessageStore().ListEvents(ctx, nil, nil, false)
+		require.NoError(t, err)
+
+		found := false
+		for message := range messages {
+			if bytes.Equal(message.Message, []byte("test")) {
+				found = true
+			}
+		}
+
+		return found
+	}
+
+	require.Eventually(t, func() bool { return hasMessage(gcs[0]) && hasMessage(gcs[2]) }, 30*time.Second, 100*time.Millisecond)
+
+	removedDevicePK, err := gcs[2].DevicePubKey().Raw()
+	require.NoError(t, err)
+
+	// only the admins can remove devices from the tree
+	_, err = nodes[1].Client.MultiMemberGroupKeyUpdate(ctx, &protocoltypes.MultiMemberGroupKeyUpdate_Request{GroupPk: created.GroupPk, RemovedDevicePks: [][]byte{removedDevicePK}})
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrGroupPermissionDenied))
+
+	_, err = nodes[0].Client.MultiMemberGroupKeyUpdate(ctx, &protocoltypes.MultiMemberGroupKeyUpdate_Request{GroupPk: created.GroupPk, RemovedDevicePks: [][]byte{removedDevicePK}})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		devices, removed := gcs[2].MetadataStore().GetTreeKEMDevices()
+		return removed && len(devices) == len(nodes)-1
+	}, 30*time.Second, 100*time.Millisecond)
+
+	// the removed device can't send messages anymore
+	_, err = nodes[2].Client.AppMessageSend(ctx, &protocoltypes.AppMessageSend_Request{GroupPk: created.GroupPk, Payload: []byte("removed")})
+	require.Error(t, err)
+}
+
+func TestMetadataEnvelopeHidesEventType(t *testing.T) {
+	group, groupSK, err := NewGroupMultiMember()
+	require.NoError(t, err)
+
+	_, memberPK, err := crypto.GenerateEd25519Key(crand.Reader)
+	require.NoError(t, err)
+
+	memberPKRaw, err := memberPK.Raw()
+	require.NoError(t, err)
+
+	announced := &protocoltypes.MultiMemberGroupInitialMemberAnnounced{MemberPk: memberPKRaw}
+	announcedSig, err := signProtoWithPrivateKey(announced, groupSK)
+	require.NoError(t, err)
+
+	announcedEnvelope, err := sealGroupEnvelope(group, protocoltypes.EventType_EventTypeMultiMemberGroupInitialMemberAnnounced, announced, announcedSig)
+	require.NoError(t, err)
+
+	permissions := &protocoltypes.MultiMemberGroupPermissionsUpdated{DevicePk: memberPKRaw}
+	permissionsEnvelope, err := sealGroupEnvelope(group, protocoltypes.EventType_EventTypeMultiMemberGroupPermissionsUpdated, permissions, nil)
+	require.NoError(t, err)
+
+	// the envelope only exposes its version, its nonce and the encrypted
+	// event, whose size doesn't depend on the event type
+	announcedEnv := &protocoltypes.GroupEnvelope{}
+	require.NoError(t, proto.Unmarshal(announcedEnvelope, announcedEnv))
+	require.Empty(t, announcedEnv.ProtoReflect().GetUnknown())
+	require.Equal(t, groupEnvelopeVersionPadded, announcedEnv.Version)
+
+	permissionsEnv := &protocoltypes.GroupEnvelope{}
+	require.NoError(t, proto.Unmarshal(permissionsEnvelope, permissionsEnv))
+	require.Len(t, permissionsEnv.Event, len(announcedEnv.Event))
+
+	metadata, _, err := openGroupEnvelope(group, announcedEnvelope)
+	require.NoError(t, err)
+	require.Equal(t, protocoltypes.EventType_EventTypeMultiMemberGroupInitialMemberAnnounced, metadata.EventType)
+	require.Empty(t, metadata.Padding)
+
+	// a replica knows the public keys of the group but not its secret
+	replica, err := FilterGroupForReplication(group)
+	require.NoError(t, err)
+
+	_, _, err = openGroupEnvelope(replica, announcedEnvelope)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrGroupMemberLogEventOpen))
+
+	// envelopes sealed before the padding was introduced can still be opened
+	announcedBytes, err := proto.Marshal(announced)
+	require.NoError(t, err)
+
+	legacyClear, err := proto.Marshal(&protocoltypes.GroupMetadata{
+		EventType:        protocoltypes.EventType_EventTypeMultiMemberGroupInitialMemberAnnounced,
+		Payload:          announcedBytes,
+		Sig:              announcedSig,
+		ProtocolMetadata: &protocoltypes.ProtocolMetadata{},
+	})
+	require.NoError(t, err)
+
+	nonce, err := cryptoutil.GenerateNonce()
+	require.NoError(t, err)
+
+	legacyEnvelope, err := proto.Marshal(&protocoltypes.GroupEnvelope{
+		Nonce: nonce[:],
+		Event: secretbox.Seal(nil, legacyClear, nonce, group.GetSharedSecret()),
+	})
+	require.NoError(t, err)
+
+	metadata, event, err := openGroupEnvelope(group, legacyEnvelope)
+	require.NoError(t, err)
+	require.Equal(t, protocoltypes.EventType_EventTypeMultiMemberGroupInitialMemberAnnounced, metadata.EventType)
+	require.Equal(t, memberPKRaw, event.(*protocoltypes.MultiMemberGroupInitialMemberAnnounced).MemberPk)
+
+	// unknown envelope versions are rejected
+	unknownEnvelope, err := proto.Marshal(&protocoltypes.GroupEnvelope{
+		Nonce:   nonce[:],
+		Event:   secretbox.Seal(nil, legacyClear, nonce, group.GetSharedSecret()),
+		Version: groupEnvelopeVersionPadded + 1,
+	})
+	require.NoError(t, err)
+
+	_, _, err = openGroupEnvelope(group, unknownEnvelope)
+	require.True(t, errcode.Is(err, errcode.ErrCode_ErrInvalidInput))
+}
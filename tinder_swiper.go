@@ -122,17 +122,18 @@ func (s *Swiper) WatchTopic(ctx context.Context, topic, seed []byte) <-chan peer
 		defer close(cpeers)
 
 		wgRefresh := sync.WaitGroup{}
+		wgAdjacent := sync.WaitGroup{}
 
 		for ctx.Err() == nil {
 			if point == nil || time.Now().After(point.Deadline()) {
-				point = s.rp.NewRendezvousPointForPeriod(time.Now(), base64.StdEncoding.EncodeToString(topic), seed)
+				point = s.pointForTopic(time.Now(), topic, seed)
 			}
 
 			bstrat := s.backoffFactory()
 
 			// store watch peers information to be later used by the refresh method to force a lookup
 			s.muRequest.Lock()
-			wctx, cancel := context.WithCancel(ctx)
+			wctx, cancel := context.WithDeadline(ctx, point.Deadline())
 			s.inprogressLookup[base64.StdEncoding.EncodeToString(topic)] = &swiperRequest{
 				bstrat:    bstrat,
 				wgRefresh: &wgRefresh,
@@ -142,6 +143,14 @@ func (s *Swiper) WatchTopic(ctx context.Context, topic, seed []byte) <-chan peer
 			}
 			s.muRequest.Unlock()
 
+			// near the rotation boundaries, also look for peers on the
+			// adjacent points to find peers with a skewed clock
+			wgAdjacent.Add(1)
+			go func(point *rendezvous.Point) {
+				defer wgAdjacent.Done()
+				s.watchAdjacentPoints(wctx, bstrat, cpeers, point)
+			}(point)
+
 			// start looking for peers for the given rotation topic
 			s.logger.Debug("looking for peers", logutil.PrivateString("topic", point.RotationTopic()))
 			if err := s.watchPeers(wctx, bstrat, cpeers, point.RotationTopic()); err != nil && err != context.DeadlineExceeded {
@@ -160,14 +169,50 @@ func (s *Swiper) WatchTopic(ctx context.Context, topic, seed []byte) <-chan peer
 		delete(s.inprogressLookup, base64.StdEncoding.EncodeToString(topic))
 		s.muRequest.Unlock()
 
-		// wait all refresh job are done before closing the channel
+		// wait all refresh and adjacent jobs are done before closing the channel
 		// we dont want to send peer on a closed channel
 		wgRefresh.Wait()
+		wgAdjacent.Wait()
 	}()
 
 	return cpeers
 }
 
+// watchAdjacentPoints looks for peers on the previous point at the beginning
+// of the period, and on the next point at the end of the period
+func (s *Swiper) watchAdjacentPoints(ctx context.Context, bstrat backoff.BackoffStrategy, out chan<- peer.AddrInfo, point *rendezvous.Point) {
+	tolerance := s.rp.ClockSkew().Tolerance()
+
+	var wg sync.WaitGroup
+	watch := func(adjacent *rendezvous.Point, start, end time.Time) {
+		defer wg.Done()
+
+		select {
+		case <-time.After(time.Until(start)):
+		case <-ctx.Done():
+			return
+		}
+
+		actx, cancel := context.WithDeadline(ctx, end)
+		defer cancel()
+
+		s.logger.Debug("looking for peers on adjacent point", logutil.PrivateString("topic", adjacent.RotationTopic()))
+		if err := s.watchPeers(actx, bstrat, out, adjacent.RotationTopic()); err != nil && err != context.DeadlineExceeded && err != context.Canceled {
+			s.logger.Debug("watch adjacent point ended", zap.Error(err))
+		}
+	}
+
+	if end := point.Start().Add(tolerance); time.Now().Before(end) {
+		wg.Add(1)
+		go watch(point.PrevPoint(), time.Now(), end)
+	}
+
+	wg.Add(1)
+	go watch(point.NextPoint(), point.Deadline().Add(-tolerance), point.Deadline())
+
+	wg.Wait()
+}
+
 func (s *Swiper) watchPeers(ctx context.Context, _ backoff.BackoffStrategy, out chan<- peer.AddrInfo, topic string) error {
 	sub := s.tinder.Subscribe(topic)
 	defer sub.Close()
@@ -208,7 +253,8 @@ func (s *Swiper) watchPeers(ctx context.Context, _ backoff.BackoffStrategy, out
 	}
 }
 
-// watch looks for peers providing a resource
+// Announce advertises ourself on the rendezvous point of the given topic,
+// the next point is announced ahead of each rotation boundary
 func (s *Swiper) Announce(ctx context.Context, topic, seed []byte) {
 	var point *rendezvous.Point
 
@@ -219,12 +265,14 @@ func (s *Swiper) Announce(ctx context.Context, topic, seed []byte) {
 	go func() {
 		for ctx.Err() == nil {
 			if point == nil || time.Now().After(point.Deadline()) {
-				point = s.rp.NewRendezvousPointForPeriod(time.Now(), base64.StdEncoding.EncodeToString(topic), seed)
+				point = s.pointForTopic(time.Now(), topic, seed)
 			}
 
 			s.logger.Debug("self announce topic for time", logutil.PrivateString("topic", point.RotationTopic()))
 
-			actx, cancel := context.WithDeadline(ctx, point.Deadline())
+			// keep advertising a bit after the deadline for peers with a late clock
+			deadline := point.Deadline().Add(s.rp.ClockSkew().Tolerance())
+			actx, cancel := context.WithDeadline(ctx, deadline)
 			if err := s.tinder.StartAdvertises(actx, point.RotationTopic()); err != nil && err != ctx.Err() {
 				cancel()
 				<-time.After(time.Second * 10) // retry after 10sc
@@ -232,13 +280,28 @@ func (s *Swiper) Announce(ctx context.Context, topic, seed []byte) {
 			}
 
 			select {
-			case <-actx.Done():
-				s.logger.Debug("rotation ended", logutil.PrivateString("topic", point.RotationTopic()))
+			case <-time.After(time.Until(point.AnnounceNextAt())):
+				// keep advertising the current point until its deadline while
+				// the next one is being announced
+				time.AfterFunc(time.Until(deadline), cancel)
+				point = point.NextPoint()
+				s.logger.Debug("rotation ending, announcing next point", logutil.PrivateString("topic", point.RotationTopic()))
 			case <-ctx.Done():
 				s.logger.Debug("announce advertise ended", logutil.PrivateString("topic", point.RotationTopic()), zap.Error(ctx.Err()))
+				cancel()
 			}
-
-			cancel()
 		}
 	}()
 }
+
+// SetTopicRotationInterval overrides the rendezvous rotation interval used
+// to watch and announce the given topic
+func (s *Swiper) SetTopicRotationInterval(topic []byte, interval time.Duration) {
+	s.rp.SetTopicInterval(rendezvous.TopicKey(topic), interval)
+}
+
+// pointForTopic returns the rendezvous point of the given topic for the
+// period including the given time
+func (s *Swiper) pointForTopic(at time.Time, topic, seed []byte) *rendezvous.Point {
+	return s.rp.NewRendezvousPointForPeriod(at, rendezvous.TopicKey(topic), seed)
+}
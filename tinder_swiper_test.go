@@ -11,6 +11,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"berty.tech/weshnet/v2/pkg/ipfsutil"
+	"berty.tech/weshnet/v2/pkg/protocoltypes"
 	"berty.tech/weshnet/v2/pkg/rendezvous"
 	"berty.tech/weshnet/v2/pkg/testutil"
 	"berty.tech/weshnet/v2/pkg/tinder"
@@ -95,3 +96,42 @@ func TestAnnounceWatchForPeriod(t *testing.T) {
 
 func TestAnnounceForPeriod(t *testing.T) {
 }
+
+func TestSwiperGroupRotationInterval(t *testing.T) {
+	testutil.FilterSpeed(t, testutil.Slow)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger, cleanup := testutil.Logger(t)
+	defer cleanup()
+
+	opts := TestingOpts{
+		Mocknet: mocknet.New(),
+		Logger:  logger,
+	}
+
+	pts, cleanup := NewTestingProtocolWithMockedPeers(ctx, t, &opts, nil, 1)
+	defer cleanup()
+
+	g := CreateMultiMemberGroupInstance(ctx, t, pts...)
+
+	gc, err := pts[0].Service.(*service).GetContextGroupForID(g.PublicKey)
+	require.NoError(t, err)
+
+	swiper := NewSwiper(logger, nil, gc.MetadataStore().rotationInterval)
+	topic := []byte(gc.MessageStore().Address().String())
+
+	require.NotEqual(t, 2*time.Hour, swiper.pointForTopic(time.Now(), topic, g.Secret).Interval())
+
+	_, err = pts[0].Client.GroupRendezvousRotationIntervalUpdate(ctx, &protocoltypes.GroupRendezvousRotationIntervalUpdate_Request{
+		GroupPk:         g.PublicKey,
+		IntervalSeconds: int64(2 * time.Hour / time.Second),
+	})
+	require.NoError(t, err)
+
+	// the interval of the group is used by the swiper for the group topics
+	require.Eventually(t, func() bool {
+		return swiper.pointForTopic(time.Now(), topic, g.Secret).Interval() == 2*time.Hour
+	}, 10*time.Second, 100*time.Millisecond)
+}